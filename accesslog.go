@@ -0,0 +1,195 @@
+package iidy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestIDKey is the key to find the request's ID
+// in the request's context, after we put it there.
+const RequestIDKey string = "request ID"
+
+// AccessLogEntry is what the access log records about each request.
+type AccessLogEntry struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"request_id"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	List         string    `json:"list,omitempty"`
+	BatchID      string    `json:"batch_id,omitempty"`
+	Status       int       `json:"status"`
+	Bytes        int64     `json:"bytes"`
+	Duration     float64   `json:"duration_ms"`
+	RequestBody  string    `json:"request_body,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+}
+
+// AccessLog is middleware that writes an AccessLogEntry, as a line of JSON,
+// for requests to the handler it wraps.
+//
+// Every request gets an ID, which is taken from the X-Request-ID header
+// if the client sent one, and which is sent back in the X-Request-ID
+// response header.
+type AccessLog struct {
+	Next http.Handler
+	// Out is where entries are written.
+	Out io.Writer
+	// SampleRate is the fraction of requests, from 0 to 1, that are
+	// logged. Requests that fail with a 5xx status are always logged.
+	SampleRate float64
+	// BodyBytes, if more than 0, is how many bytes of each request and
+	// response body are recorded in the log, for debugging.
+	BodyBytes int
+
+	mu sync.Mutex
+}
+
+// NewAccessLog constructs a new AccessLog that logs every request
+// to next, without bodies, to out.
+func NewAccessLog(next http.Handler, out io.Writer) *AccessLog {
+	return &AccessLog{
+		Next:       next,
+		Out:        out,
+		SampleRate: 1,
+	}
+}
+
+func (a *AccessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	r = r.WithContext(context.WithValue(r.Context(), RequestIDKey, requestID))
+
+	var requestBody *limitedBuffer
+	if a.BodyBytes > 0 && r.Body != nil {
+		requestBody = &limitedBuffer{limit: a.BodyBytes}
+		r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, requestBody), Closer: r.Body}
+	}
+	lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	if a.BodyBytes > 0 {
+		lw.body = &limitedBuffer{limit: a.BodyBytes}
+	}
+
+	a.Next.ServeHTTP(lw, r)
+
+	if lw.status < http.StatusInternalServerError && mathrand.Float64() >= a.SampleRate {
+		return
+	}
+	e := AccessLogEntry{
+		Time:      start.UTC(),
+		RequestID: requestID,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		List:      listFromPath(r.URL.Path),
+		BatchID:   lw.Header().Get("X-IIDY-Batch-ID"),
+		Status:    lw.status,
+		Bytes:     lw.bytes,
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if requestBody != nil {
+		e.RequestBody = requestBody.String()
+	}
+	if lw.body != nil {
+		e.ResponseBody = lw.body.String()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Out.Write(append(line, '\n'))
+}
+
+// RequestID gives the ID that the access log gave the request,
+// or "" if the request did not come through the access log.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(RequestIDKey).(string)
+	return id
+}
+
+// newID makes up a random ID, for a request or a batch.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// listFromPath gives the list named in an IIDY URL path, which looks like
+// /iidy/v1/lists/<listname>/<itemname> or /iidy/v1/<op>/lists/<listname>.
+func listFromPath(path string) string {
+	urlParts := strings.Split(path, "/")
+	if len(urlParts) >= 5 && urlParts[3] == "lists" {
+		return urlParts[4]
+	}
+	if len(urlParts) >= 6 && urlParts[4] == "lists" {
+		return urlParts[5]
+	}
+	return ""
+}
+
+// loggingResponseWriter remembers the status and size of a response,
+// and (if body is not nil) the start of the response body.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	body        *limitedBuffer
+	wroteHeader bool
+}
+
+func (lw *loggingResponseWriter) WriteHeader(code int) {
+	if !lw.wroteHeader {
+		lw.wroteHeader = true
+		lw.status = code
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggingResponseWriter) Write(p []byte) (int, error) {
+	lw.wroteHeader = true
+	n, err := lw.ResponseWriter.Write(p)
+	lw.bytes += int64(n)
+	if lw.body != nil {
+		lw.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// limitedBuffer keeps the first limit bytes written to it,
+// and quietly drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// teeReadCloser reads from a TeeReader, but closes the original body.
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,77 @@
+package iidy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	mockStore := StoreTestingStub{
+		insertBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return int64(len(items)), nil
+		},
+	}
+	var out bytes.Buffer
+	a := NewAccessLog(&Handler{Store: mockStore}, &out)
+	a.BodyBytes = 4
+	req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads", strings.NewReader("a\nb\nc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Request-ID", "abc123")
+	rr := httptest.NewRecorder()
+	a.ServeHTTP(rr, req)
+	if got := rr.Header().Get("X-Request-ID"); got != "abc123" {
+		t.Errorf("got X-Request-ID %q want %q", got, "abc123")
+	}
+
+	var e AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatalf("access log is not JSON: %v: %s", err, out.String())
+	}
+	if e.RequestID != "abc123" || e.Method != http.MethodPost || e.Path != "/iidy/v1/batch/lists/downloads" ||
+		e.List != "downloads" || e.Status != http.StatusCreated || e.Bytes != int64(len("ADDED 3\n")) {
+		t.Errorf("unexpected access log entry: %+v", e)
+	}
+	if e.BatchID == "" || e.BatchID != rr.Header().Get("X-IIDY-Batch-ID") {
+		t.Errorf("got batch ID %q in log, %q in response", e.BatchID, rr.Header().Get("X-IIDY-Batch-ID"))
+	}
+	if e.RequestBody != "a\nb\n" || e.ResponseBody != "ADDE" {
+		t.Errorf("bodies not captured as expected: %q %q", e.RequestBody, e.ResponseBody)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 0, false, nil
+		},
+		deleteOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 0, fmt.Errorf("connection refused")
+		},
+	}
+	var out bytes.Buffer
+	a := NewAccessLog(&Handler{Store: mockStore}, &out)
+	a.SampleRate = 0
+	req := httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a", nil)
+	a.ServeHTTP(httptest.NewRecorder(), req)
+	if out.Len() != 0 {
+		t.Errorf("request logged despite sample rate of 0: %s", out.String())
+	}
+	req = httptest.NewRequest(http.MethodDelete, "/iidy/v1/lists/downloads/a", nil)
+	a.ServeHTTP(httptest.NewRecorder(), req)
+	var e AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatalf("failed request not logged: %v: %s", err, out.String())
+	}
+	if e.Status != http.StatusInternalServerError || e.RequestID == "" {
+		t.Errorf("unexpected access log entry: %+v", e)
+	}
+}
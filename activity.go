@@ -0,0 +1,115 @@
+package iidy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// MaxActivityWindow is the longest window of time that Activity
+// remembers, and so can summarize.
+const MaxActivityWindow time.Duration = 24 * time.Hour
+
+// activityBucketSize is how finely Activity divides time. A summary
+// "since" some time may include up to a bucket's worth of older activity.
+const activityBucketSize time.Duration = time.Minute
+
+// activityBucket counts what happened to a list in one bucket of time.
+type activityBucket struct {
+	start  time.Time
+	counts pgstore.ActionCounts
+}
+
+// Activity remembers how many items were added to, incremented in,
+// deleted from (that is, completed in), and moved to a new status in
+// (see Transitioner) each list, over the last
+// MaxActivityWindow, so that "is anything happening?" can be answered
+// without a trip to the data store. Only lists that have had activity in
+// the window take up any memory.
+//
+// Activity only knows about the requests that this process handled:
+// it starts out empty, and, with more than one iidy server, each has
+// its own. A nil *Activity records nothing.
+type Activity struct {
+	// Clock tells Activity the time, so that tests can
+	// simulate the passing of time with a clock.Fake.
+	Clock clock.Clock
+
+	mu    sync.Mutex
+	lists map[string][]activityBucket
+	// pruned is the start of the bucket in which a last pruned lists.
+	pruned time.Time
+}
+
+// NewActivity constructs a new, empty, Activity,
+// which uses the system clock.
+func NewActivity() *Activity {
+	return &Activity{Clock: clock.System, lists: make(map[string][]activityBucket)}
+}
+
+// Record counts what a request did to list.
+func (a *Activity) Record(list string, counts pgstore.ActionCounts) {
+	if a == nil || counts == (pgstore.ActionCounts{}) {
+		return
+	}
+	now := clock.OrSystem(a.Clock).Now()
+	start := now.Truncate(activityBucketSize)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.pruned.Equal(start) {
+		a.prune(now)
+		a.pruned = start
+	}
+	buckets := a.lists[list]
+	if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(start) {
+		buckets = append(buckets, activityBucket{start: start})
+	}
+	b := &buckets[len(buckets)-1]
+	b.counts.Added += counts.Added
+	b.counts.Incremented += counts.Incremented
+	b.counts.Deleted += counts.Deleted
+	b.counts.Transitioned += counts.Transitioned
+	a.lists[list] = buckets
+}
+
+// Summarize totals what has happened to list since since, which is
+// (at most MaxActivityWindow) before now.
+func (a *Activity) Summarize(list string, since time.Duration) pgstore.ActionCounts {
+	var total pgstore.ActionCounts
+	if a == nil {
+		return total
+	}
+	from := clock.OrSystem(a.Clock).Now().Add(-since).Truncate(activityBucketSize)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range a.lists[list] {
+		if b.start.Before(from) {
+			continue
+		}
+		total.Added += b.counts.Added
+		total.Incremented += b.counts.Incremented
+		total.Deleted += b.counts.Deleted
+		total.Transitioned += b.counts.Transitioned
+	}
+	return total
+}
+
+// prune forgets buckets that are older than MaxActivityWindow, and
+// lists that have no buckets left. Since buckets are appended in order,
+// the old ones are always at the front. The caller must hold a.mu.
+func (a *Activity) prune(now time.Time) {
+	oldest := now.Add(-MaxActivityWindow).Truncate(activityBucketSize)
+	for list, buckets := range a.lists {
+		i := 0
+		for i < len(buckets) && buckets[i].start.Before(oldest) {
+			i++
+		}
+		if i == len(buckets) {
+			delete(a.lists, list)
+		} else if i > 0 {
+			a.lists[list] = append([]activityBucket(nil), buckets[i:]...)
+		}
+	}
+}
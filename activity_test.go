@@ -0,0 +1,89 @@
+package iidy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestActivity(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 11, 1, 12, 0, 30, 0, time.UTC))
+	a := NewActivity()
+	a.Clock = c
+
+	a.Record("downloads", pgstore.ActionCounts{Added: 10})
+	c.Advance(30 * time.Minute)
+	a.Record("downloads", pgstore.ActionCounts{Incremented: 2, Deleted: 3})
+	a.Record("downloads", pgstore.ActionCounts{Deleted: 1})
+	a.Record("uploads", pgstore.ActionCounts{Added: 1})
+
+	if got, want := a.Summarize("downloads", time.Hour), (pgstore.ActionCounts{Added: 10, Incremented: 2, Deleted: 4}); got != want {
+		t.Errorf("last hour: got %+v want %+v", got, want)
+	}
+	if got, want := a.Summarize("downloads", 10*time.Minute), (pgstore.ActionCounts{Incremented: 2, Deleted: 4}); got != want {
+		t.Errorf("last 10 minutes: got %+v want %+v", got, want)
+	}
+	if got, want := a.Summarize("nosuch", time.Hour), (pgstore.ActionCounts{}); got != want {
+		t.Errorf("missing list: got %+v want %+v", got, want)
+	}
+
+	// A day later, the old activity is forgotten.
+	c.Advance(MaxActivityWindow + activityBucketSize)
+	a.Record("uploads", pgstore.ActionCounts{Added: 1})
+	if got, want := a.Summarize("downloads", MaxActivityWindow), (pgstore.ActionCounts{}); got != want {
+		t.Errorf("after a day: got %+v want %+v", got, want)
+	}
+	if n := len(a.lists); n != 1 {
+		t.Errorf("got %d lists remembered, want 1", n)
+	}
+
+	var nilActivity *Activity
+	nilActivity.Record("downloads", pgstore.ActionCounts{Added: 1})
+}
+
+func TestActivityHandler(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC))
+	a := NewActivity()
+	a.Clock = c
+	mockStore := StoreTestingStub{
+		deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return int64(len(items)), nil
+		},
+	}
+	h := &Handler{Store: mockStore, Activity: a}
+
+	req, err := http.NewRequest(http.MethodDelete, "/iidy/v1/batch/lists/downloads?items=a,b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		url      string
+		wantCode int
+		wantBody string
+	}{
+		{url: "/iidy/v1/activity/lists/downloads", wantCode: http.StatusOK, wantBody: "SINCE 2021-11-01T11:00:00Z\nADDED 0\nINCREMENTED 0\nDELETED 2\nTRANSITIONED 0\n"},
+		{url: "/iidy/v1/activity/lists/downloads?since=5m", wantCode: http.StatusOK, wantBody: "SINCE 2021-11-01T11:55:00Z\nADDED 0\nINCREMENTED 0\nDELETED 2\nTRANSITIONED 0\n"},
+		{url: "/iidy/v1/activity/lists/downloads?since=48h", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != test.wantCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.url, rr.Code, test.wantCode)
+		}
+		if test.wantBody != "" && rr.Body.String() != test.wantBody {
+			t.Errorf("%s: got body %q want %q", test.url, rr.Body.String(), test.wantBody)
+		}
+	}
+}
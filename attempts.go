@@ -0,0 +1,126 @@
+package iidy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// DecrementedMessage informs the user how many items had their
+// attempts decremented.
+// The message can be formatted either as plain text or JSON.
+type DecrementedMessage struct {
+	Decremented int64 `json:"decremented"`
+}
+
+// AttemptsSetMessage informs the user how many items had their
+// attempts set.
+// The message can be formatted either as plain text or JSON.
+type AttemptsSetMessage struct {
+	Set int64 `json:"set"`
+}
+
+// decrementOne takes one off the attempts of an item in a list.
+// The response reports how many items were decremented (1 or 0).
+func (h *Handler) decrementOne(w http.ResponseWriter, r *http.Request, list string, item string) {
+	h.adjustAttempts(w, r, list, []string{item}, "decrement_one")
+}
+
+// setAttemptsOne sets the attempts of an item in a list to the
+// "value" query arg. The response reports how many items were set
+// (1 or 0).
+func (h *Handler) setAttemptsOne(w http.ResponseWriter, r *http.Request, list string, item string) {
+	h.adjustAttempts(w, r, list, []string{item}, "set_attempts_one")
+}
+
+// decrementBatch takes one off the attempts of each of the items in the
+// request body (or the "items" query arg) in the specified list. Items
+// with no attempts are left alone. The response reports how many items
+// were decremented.
+func (h *Handler) decrementBatch(w http.ResponseWriter, r *http.Request, list string) {
+	h.adjustBatch(w, r, list, "decrement_batch")
+}
+
+// setAttemptsBatch sets the attempts of each of the items in the request
+// body (or the "items" query arg) in the specified list to the "value"
+// query arg. The response reports how many items were set.
+func (h *Handler) setAttemptsBatch(w http.ResponseWriter, r *http.Request, list string) {
+	h.adjustBatch(w, r, list, "set_attempts_batch")
+}
+
+// adjustBatch gets the items of a decrementBatch or setAttemptsBatch
+// (route names the one), and adjusts their attempts.
+func (h *Handler) adjustBatch(w http.ResponseWriter, r *http.Request, list string, route string) {
+	r = startBatch(w, r)
+	query := r.Context().Value(QueryKey).(url.Values)
+	var items []string
+	var err error
+	if query.Get("items") != "" {
+		items, err = getItemsFromQuery(query)
+		if err != nil {
+			printError(w, r, &ErrorMessage{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	} else if hasBody(r) {
+		items, err = getItemsFromRequest(r)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
+			printBodyError(w, r, errStr, err)
+			return
+		}
+	}
+	h.adjustAttempts(w, r, list, items, route)
+}
+
+// adjustAttempts decrements, or sets, the attempts of items in list,
+// as route (which names the route) says.
+func (h *Handler) adjustAttempts(w http.ResponseWriter, r *http.Request, list string, items []string, route string) {
+	if h.Attempts == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list, items...) {
+		return
+	}
+	h.Metrics.CountRequest(list, route)
+	decrement := route == "decrement_one" || route == "decrement_batch"
+	var attempts int
+	if !decrement {
+		query := r.Context().Value(QueryKey).(url.Values)
+		if query.Get("value") == "" {
+			printError(w, r, &ErrorMessage{Error: "Query arg value, the attempts to set, is required"}, http.StatusBadRequest)
+			return
+		}
+		var err error
+		attempts, err = parseAttemptsArg("value", query.Get("value"))
+		if err != nil {
+			printError(w, r, &ErrorMessage{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	var count int64
+	var err error
+	switch route {
+	case "decrement_one":
+		count, err = h.Attempts.DecrementOne(r.Context(), list, items[0])
+	case "decrement_batch":
+		count, err = h.Attempts.DecrementBatch(r.Context(), list, items)
+	case "set_attempts_one":
+		count, err = h.Attempts.SetAttemptsOne(r.Context(), list, items[0], attempts)
+	default:
+		count, err = h.Attempts.SetAttemptsBatch(r.Context(), list, items, attempts)
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to change attempts of list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, route, count)
+	if decrement {
+		h.mutated(MutationDecrement, list, items, count)
+		printSuccess(w, r, &DecrementedMessage{Decremented: count}, http.StatusOK)
+		return
+	}
+	h.mutated(MutationSetAttempts, list, items, count)
+	printSuccess(w, r, &AttemptsSetMessage{Set: count}, http.StatusOK)
+}
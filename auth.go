@@ -0,0 +1,132 @@
+package iidy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// APIKeyKey is the key to find the *APIKey that a request was
+// authenticated with in its context.
+const APIKeyKey string = "API key"
+
+// APIKey is a key that clients authenticate with, sent as
+// "Authorization: Bearer <key>".
+type APIKey struct {
+	Key string
+	// Prefixes, if not empty, restrict the key to lists whose names begin
+	// with one of them, so that teams sharing a server can each be kept
+	// to lists of their own. A restricted key cannot use the routes that
+	// are not about a list of its own, such as /iidy/v1/admin/db.
+	Prefixes []string
+}
+
+// Allows tells whether k may use list.
+func (k *APIKey) Allows(list string) bool {
+	if len(k.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range k.Prefixes {
+		if strings.HasPrefix(list, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireAPIKey gives middleware that turns away, with a 401, requests
+// that do not carry one of keys. A request that does carries its key in
+// its context (see APIKeyKey), for the handler to check each list that
+// it uses against.
+func RequireAPIKey(keys []APIKey) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := findAPIKey(keys, r.Header.Get("Authorization"))
+			if key == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="iidy"`)
+				printError(w, r, &ErrorMessage{Error: "A valid API key is required."}, http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), APIKeyKey, key)))
+		})
+	}
+}
+
+// findAPIKey gives the one of keys that header, an Authorization header,
+// carries, or nil if it carries none of them. Every key is compared, in
+// constant time, so that how long this takes gives nothing away.
+func findAPIKey(keys []APIKey, header string) *APIKey {
+	const scheme = "Bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return nil
+	}
+	given := []byte(header[len(scheme):])
+	var found *APIKey
+	for i := range keys {
+		if subtle.ConstantTimeCompare(given, []byte(keys[i].Key)) == 1 {
+			found = &keys[i]
+		}
+	}
+	return found
+}
+
+// requestAPIKey gives the key that r was authenticated with, or nil if
+// API keys are not required.
+func requestAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(APIKeyKey).(*APIKey)
+	return key
+}
+
+// listAllowed tells whether r may use list.
+func listAllowed(r *http.Request, list string) bool {
+	key := requestAPIKey(r)
+	return key == nil || key.Allows(list)
+}
+
+// unrestricted tells whether r may use every list, and so also the
+// routes that are not about any one list.
+func unrestricted(r *http.Request) bool {
+	key := requestAPIKey(r)
+	return key == nil || len(key.Prefixes) == 0
+}
+
+// printForbidden tells the client that its API key does not allow what
+// it asked for.
+func printForbidden(w http.ResponseWriter, r *http.Request, errStr string) {
+	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusForbidden)
+}
+
+// ReadAPIKeys reads API keys, one per line, each followed by the list
+// prefixes that it is restricted to, if any, separated by spaces:
+//     # The downloads team's key.
+//     4f1c9a0e8b7d downloads- thumbnails-
+//     # An admin key, for every list.
+//     e3b0c44298fc
+// Blank lines and lines starting with # are ignored.
+func ReadAPIKeys(in io.Reader) ([]APIKey, error) {
+	var keys []APIKey
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if seen[fields[0]] {
+			return nil, fmt.Errorf("line %d: key is given more than once", n)
+		}
+		seen[fields[0]] = true
+		keys = append(keys, APIKey{Key: fields[0], Prefixes: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+	return keys, nil
+}
@@ -0,0 +1,66 @@
+package iidy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	s := memstore.NewMemStore()
+	s.InsertBatch(context.Background(), "downloads-eu", []string{"a"})
+	s.InsertBatch(context.Background(), "uploads", []string{"b"})
+	keys := []APIKey{{Key: "admin"}, {Key: "team", Prefixes: []string{"downloads-"}}}
+	h := Chain(&Handler{Store: s, Lister: s, Merger: s, DB: dbStatterStub{stats: &pgstore.DBStats{}}}, RequireAPIKey(keys))
+	tests := []struct {
+		method   string
+		path     string
+		auth     string
+		code     int
+		expected string
+	}{
+		{http.MethodGet, "/iidy/v1/lists/downloads-eu/a", "", http.StatusUnauthorized, "A valid API key is required.\n"},
+		{http.MethodGet, "/iidy/v1/lists/downloads-eu/a", "Bearer nope", http.StatusUnauthorized, "A valid API key is required.\n"},
+		{http.MethodGet, "/iidy/v1/lists/downloads-eu/a", "Bearer team", http.StatusOK, "0\n"},
+		{http.MethodGet, "/iidy/v1/lists/uploads/b", "Bearer team", http.StatusForbidden, "This API key does not allow list \"uploads\".\n"},
+		{http.MethodGet, "/iidy/v1/lists/uploads/b", "bearer admin", http.StatusOK, "0\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads-eu?action=merge&from=uploads&conflict=sum", "Bearer team", http.StatusForbidden, "This API key does not allow list \"uploads\".\n"},
+		{http.MethodGet, "/iidy/v1/lists", "Bearer team", http.StatusOK, "downloads-eu 1\n"},
+		{http.MethodGet, "/iidy/v1/lists", "Bearer admin", http.StatusOK, "downloads-eu 1\nuploads 1\n"},
+		{http.MethodGet, "/iidy/v2/stats/db", "Bearer team", http.StatusForbidden, "{\"error\":\"This API key is restricted to some lists, so it cannot see database stats.\",\"code\":\"forbidden\"}\n"},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s as %q: got status %d want %d", tc.method, tc.path, tc.auth, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s as %q: got body %q want %q", tc.method, tc.path, tc.auth, rr.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestReadAPIKeys(t *testing.T) {
+	keys, err := ReadAPIKeys(strings.NewReader("# The downloads team.\n4f1c downloads- thumbnails-\n\ne3b0\n"))
+	want := []APIKey{{Key: "4f1c", Prefixes: []string{"downloads-", "thumbnails-"}}, {Key: "e3b0", Prefixes: []string{}}}
+	if err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %v, %v want %v", keys, err, want)
+	}
+	if _, err := ReadAPIKeys(strings.NewReader("4f1c\n4f1c downloads-\n")); err == nil || err.Error() != "line 2: key is given more than once" {
+		t.Errorf("got %v want a duplicate key error", err)
+	}
+	if _, err := ReadAPIKeys(strings.NewReader("# none\n")); err == nil {
+		t.Errorf("got no error for a file of no keys")
+	}
+}
@@ -0,0 +1,502 @@
+/*
+Package client is a typed Go client for iidy's REST API.
+
+    c := client.New("http://localhost:8080")
+    added, err := c.InsertBatch(context.Background(), "downloads", []string{"a.txt", "b.txt"})
+    entries, err := c.GetBatch(context.Background(), "downloads", "", 10)
+
+Its methods mirror those of pgstore.Store, and talk to iidy in JSON.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manniwood/iidy"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// Error is an error response from iidy.
+type Error struct {
+	StatusCode int
+	Message    string
+	// Code is the error's code, if it has one, such as
+	// iidy.CodeInvalidItem.
+	Code iidy.ErrorCode
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("iidy: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is makes errors.Is(err, target), where target is an *Error with a Code,
+// such as ErrDuplicateItem, true if err is an *Error with the same Code,
+// whatever its status and message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Errors to check for with errors.Is, one for each kind of error that
+// callers are likely to want to handle for themselves. Any other code can
+// be checked for with an *Error of its own, such as
+// &Error{Code: iidy.CodeInvalidAction}.
+var (
+	ErrBadRequest         = &Error{Code: iidy.CodeBadRequest}
+	ErrInvalidList        = &Error{Code: iidy.CodeInvalidList}
+	ErrInvalidItem        = &Error{Code: iidy.CodeInvalidItem}
+	ErrItemNotFound       = &Error{Code: iidy.CodeItemNotFound}
+	ErrPreconditionFailed = &Error{Code: iidy.CodePreconditionFailed}
+	ErrDuplicateItem      = &Error{Code: iidy.CodeDuplicateItem}
+	ErrReadOnly           = &Error{Code: iidy.CodeReadOnly}
+	ErrPoolTimeout        = &Error{Code: iidy.CodePoolTimeout}
+	ErrUnavailable        = &Error{Code: iidy.CodeUnavailable}
+	ErrOverloaded         = &Error{Code: iidy.CodeOverloaded}
+	ErrUnauthorized       = &Error{Code: iidy.CodeUnauthorized}
+	ErrForbidden          = &Error{Code: iidy.CodeForbidden}
+	ErrTooLarge           = &Error{Code: iidy.CodeTooLarge}
+	ErrInternal           = &Error{Code: iidy.CodeInternal}
+)
+
+// Client talks to the iidy server at BaseURL (such as
+// "http://localhost:8080"), using HTTPClient, and, if APIKey is set,
+// authenticating with it (see iidy.RequireAPIKey).
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	APIKey     string
+}
+
+// New returns a new Client for the iidy server at baseURL,
+// using http.DefaultClient.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// itemURL gives the URL of an item in a list.
+func (c *Client) itemURL(list string, item string) string {
+	return c.BaseURL + "/iidy/v1/lists/" + url.PathEscape(list) + "/" + url.PathEscape(item)
+}
+
+// batchURL gives the URL of a list for the op ("batch", "multiget", or
+// "snapshots").
+func (c *Client) batchURL(op string, list string) string {
+	return c.BaseURL + "/iidy/v1/" + op + "/lists/" + url.PathEscape(list)
+}
+
+// do sends a request with body (if not nil) encoded as JSON, and decodes
+// the JSON response into v (if not nil, and if there is a response body).
+// It returns the response's status code. Any status of 400 or above is
+// returned as an *Error, except for those in okStatuses.
+func (c *Client) do(ctx context.Context, method string, u string, body interface{}, v interface{}, okStatuses ...int) (int, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	c.authorize(req)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	for _, ok := range okStatuses {
+		if resp.StatusCode == ok {
+			return resp.StatusCode, nil
+		}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.StatusCode, responseError(resp.StatusCode, respBody)
+	}
+	if v != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, v); err != nil {
+			return resp.StatusCode, fmt.Errorf("iidy: could not decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// authorize adds c's API key, if it has one, to req.
+func (c *Client) authorize(req *http.Request) {
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+}
+
+// responseError gives the *Error for a response with an error status,
+// whose body is respBody.
+func responseError(status int, respBody []byte) *Error {
+	var e iidy.ErrorMessage
+	if err := json.Unmarshal(respBody, &e); err != nil || e.Error == "" {
+		e.Error = strings.TrimSpace(string(respBody))
+	}
+	return &Error{StatusCode: status, Message: e.Error, Code: e.Code}
+}
+
+// InsertOne adds item to list.
+func (c *Client) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	var m iidy.AddedMessage
+	_, err := c.do(ctx, http.MethodPost, c.itemURL(list, item), nil, &m)
+	return m.Added, err
+}
+
+// PutOne makes sure that item is in list, adding it if it is not. Unlike
+// InsertOne, it is not an error for item to be in list already, so it is
+// safe to retry; it returns 1 if item was added, or 0 if it was already
+// there.
+func (c *Client) PutOne(ctx context.Context, list string, item string) (int64, error) {
+	var m iidy.AddedMessage
+	_, err := c.do(ctx, http.MethodPut, c.itemURL(list, item), nil, &m)
+	return m.Added, err
+}
+
+// GetOne gets the number of attempts made on item in list. The second
+// return value is false if the item is not in the list.
+func (c *Client) GetOne(ctx context.Context, list string, item string) (int, bool, error) {
+	var e pgstore.ListEntry
+	status, err := c.do(ctx, http.MethodGet, c.itemURL(list, item), nil, &e, http.StatusNotFound)
+	if err != nil || status == http.StatusNotFound {
+		return 0, false, err
+	}
+	return e.Attempts, true, nil
+}
+
+// DeleteOne deletes item from list.
+func (c *Client) DeleteOne(ctx context.Context, list string, item string) (int64, error) {
+	var m iidy.DeletedMessage
+	_, err := c.do(ctx, http.MethodDelete, c.itemURL(list, item), nil, &m)
+	return m.Deleted, err
+}
+
+// IncrementOne increments the attempts made on item in list.
+func (c *Client) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
+	var m iidy.IncrementedMessage
+	_, err := c.do(ctx, http.MethodPost, c.itemURL(list, item)+"?action=increment", nil, &m)
+	return m.Incremented, err
+}
+
+// Transition does action (pgstore.ActionComplete, pgstore.ActionFail,
+// or pgstore.ActionReset) to item in list, and gives its new status.
+// The second return value is false if the item is not in the list.
+func (c *Client) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	var m iidy.StatusMessage
+	status, err := c.do(ctx, http.MethodPost, c.itemURL(list, item)+"?action="+url.QueryEscape(action), nil, &m, http.StatusNotFound)
+	if err != nil || status == http.StatusNotFound {
+		return "", false, err
+	}
+	return m.Status, true, nil
+}
+
+// InsertBatch adds items to list.
+func (c *Client) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
+	var m iidy.AddedMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("batch", list), &iidy.ItemListMessage{Items: items}, &m)
+	return m.Added, err
+}
+
+// InsertBatchIgnoreDuplicates adds entries, with their attempts, to list,
+// skipping any that are already in it, rather than failing. It returns
+// the number added, and the items that were skipped.
+func (c *Client) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, []string, error) {
+	body := &iidy.BatchItemListMessage{Items: make([]iidy.BatchItem, 0, len(entries))}
+	for _, e := range entries {
+		body.Items = append(body.Items, iidy.BatchItem(e))
+	}
+	var m iidy.AddedMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("batch", list)+"?on_conflict=ignore&detail=full", body, &m)
+	return m.Added, m.Skipped, err
+}
+
+// InsertBatchEntries adds entries, with their attempts, to list.
+func (c *Client) InsertBatchEntries(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error) {
+	body := &iidy.BatchItemListMessage{Items: make([]iidy.BatchItem, 0, len(entries))}
+	for _, e := range entries {
+		body.Items = append(body.Items, iidy.BatchItem(e))
+	}
+	var m iidy.AddedMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("batch", list), body, &m)
+	return m.Added, err
+}
+
+// GetBatch gets up to count entries from list, starting after afterID,
+// or from the start of the list if afterID is "". If count is less
+// than 1, the server's default page size is used.
+func (c *Client) GetBatch(ctx context.Context, list string, afterID string, count int) ([]pgstore.ListEntry, error) {
+	return c.QueryBatch(ctx, list, pgstore.BatchQuery{StartID: afterID, Count: count})
+}
+
+// QueryBatch is like GetBatch, with the options of a pgstore.BatchQuery.
+func (c *Client) QueryBatch(ctx context.Context, list string, q pgstore.BatchQuery) ([]pgstore.ListEntry, error) {
+	var m iidy.ListEntryMessage
+	_, err := c.do(ctx, http.MethodGet, c.batchURL("batch", list)+"?"+batchQueryArgs(q).Encode(), nil, &m)
+	if m.ListEntries == nil {
+		m.ListEntries = []pgstore.ListEntry{}
+	}
+	return m.ListEntries, err
+}
+
+// QueryPage is like QueryBatch, but gives the whole page, including
+// NextAfterID, the after_id of the page after it, which is "" once there
+// are no more pages (or, with q.OldestFirst or q.MostAttemptsFirst, when
+// there is no cursor to give).
+func (c *Client) QueryPage(ctx context.Context, list string, q pgstore.BatchQuery) (*iidy.ListEntryMessage, error) {
+	m := &iidy.ListEntryMessage{}
+	status, err := c.do(ctx, http.MethodGet, c.batchURL("batch", list)+"?"+batchQueryArgs(q).Encode(), nil, m)
+	if m.ListEntries == nil {
+		m.ListEntries = []pgstore.ListEntry{}
+	}
+	if status == http.StatusNoContent {
+		m.Exhausted = true
+	}
+	return m, err
+}
+
+// StreamBatch is like QueryBatch, but gets the entries as newline-delimited
+// JSON, and calls f with each one as it arrives, so that however many there
+// are, they are never all in memory at once. A q.Count of 0 means the rest
+// of the list, if the server can stream it (see iidy.Handler.Streamer), or
+// else the server's default page size. If f returns an error, StreamBatch
+// stops, and returns it. StreamBatch returns the number of entries that f
+// was called with.
+func (c *Client) StreamBatch(ctx context.Context, list string, q pgstore.BatchQuery, f func(pgstore.ListEntry) error) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.batchURL("batch", list)+"?"+batchQueryArgs(q).Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	c.authorize(req)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		return 0, responseError(resp.StatusCode, respBody)
+	}
+	var count int64
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e pgstore.ListEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("iidy: could not decode response: %v", err)
+		}
+		if err := f(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// batchQueryArgs gives the query args of a batch get for q.
+func batchQueryArgs(q pgstore.BatchQuery) url.Values {
+	query := url.Values{}
+	if q.Count > 0 {
+		query.Set("count", strconv.Itoa(q.Count))
+	}
+	if q.StartID != "" && q.Inclusive {
+		query.Set("from_id", q.StartID)
+	} else if q.StartID != "" {
+		query.Set("after_id", q.StartID)
+	}
+	if !q.UpdatedBefore.IsZero() {
+		query.Set("updated_before", q.UpdatedBefore.Format(time.RFC3339Nano))
+	}
+	if !q.UpdatedAfter.IsZero() {
+		query.Set("updated_after", q.UpdatedAfter.Format(time.RFC3339Nano))
+	}
+	if q.OldestFirst {
+		query.Set("order", "oldest_first")
+	} else if q.MostAttemptsFirst {
+		query.Set("order", "most_attempts")
+	}
+	if q.Ready {
+		query.Set("ready", "true")
+	}
+	if q.Status != "" {
+		query.Set("status", q.Status)
+	}
+	if q.MinAttempts > 0 {
+		query.Set("min_attempts", strconv.Itoa(q.MinAttempts))
+	}
+	if q.MaxAttempts != nil {
+		query.Set("max_attempts", strconv.Itoa(*q.MaxAttempts))
+	}
+	return query
+}
+
+// Top gets the n entries in list with the most attempts, highest first.
+func (c *Client) Top(ctx context.Context, list string, n int) ([]pgstore.ListEntry, error) {
+	var m iidy.ListEntryMessage
+	u := c.BaseURL + "/iidy/v1/stats/lists/" + url.PathEscape(list) + "/top?n=" + strconv.Itoa(n)
+	_, err := c.do(ctx, http.MethodGet, u, nil, &m)
+	if m.ListEntries == nil {
+		m.ListEntries = []pgstore.ListEntry{}
+	}
+	return m.ListEntries, err
+}
+
+// Lists gets the name of every list that has any items in it,
+// with how many items it has, sorted by list.
+func (c *Client) Lists(ctx context.Context) ([]pgstore.ListCount, error) {
+	var m iidy.ListsMessage
+	_, err := c.do(ctx, http.MethodGet, c.BaseURL+"/iidy/v1/lists", nil, &m)
+	if m.Lists == nil {
+		m.Lists = []pgstore.ListCount{}
+	}
+	return m.Lists, err
+}
+
+// Count tells how many items are in list.
+func (c *Client) Count(ctx context.Context, list string) (int64, error) {
+	var m iidy.CountMessage
+	_, err := c.do(ctx, http.MethodGet, c.batchURL("stats", list)+"/count", nil, &m)
+	return m.Items, err
+}
+
+// CountByAttempts counts the items of list by their attempts, sorted
+// by attempts.
+func (c *Client) CountByAttempts(ctx context.Context, list string) ([]pgstore.AttemptsCount, error) {
+	var m iidy.CountMessage
+	_, err := c.do(ctx, http.MethodGet, c.batchURL("stats", list)+"/count?by=attempts", nil, &m)
+	if m.ByAttempts == nil {
+		m.ByAttempts = []pgstore.AttemptsCount{}
+	}
+	return m.ByAttempts, err
+}
+
+// GetMulti gets the entries for items in list. Items that are
+// not in the list are left out.
+func (c *Client) GetMulti(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+	var m iidy.ListEntryMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("multiget", list), &iidy.ItemListMessage{Items: items}, &m)
+	if m.ListEntries == nil {
+		m.ListEntries = []pgstore.ListEntry{}
+	}
+	return m.ListEntries, err
+}
+
+// DeleteBatch deletes items from list.
+func (c *Client) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
+	var m iidy.DeletedMessage
+	_, err := c.do(ctx, http.MethodDelete, c.batchURL("batch", list), &iidy.ItemListMessage{Items: items}, &m)
+	return m.Deleted, err
+}
+
+// IncrementBatch increments the attempts made on items in list.
+func (c *Client) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	var m iidy.IncrementedMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("batch", list)+"?action=increment", &iidy.ItemListMessage{Items: items}, &m)
+	return m.Incremented, err
+}
+
+// DecrementBatch takes one off the attempts of each of items in list.
+// Items with no attempts are left alone.
+func (c *Client) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	var m iidy.DecrementedMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("batch", list)+"?action=decrement", &iidy.ItemListMessage{Items: items}, &m)
+	return m.Decremented, err
+}
+
+// SetAttemptsBatch sets the attempts of each of items in list to
+// attempts.
+func (c *Client) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	var m iidy.AttemptsSetMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("batch", list)+"?action=set&value="+strconv.Itoa(attempts), &iidy.ItemListMessage{Items: items}, &m)
+	return m.Set, err
+}
+
+// Merge merges list src into list dest, settling items that are in both
+// as conflict (one of pgstore.MergeKeepMax, pgstore.MergeKeepDest, or
+// pgstore.MergeSum) says.
+func (c *Client) Merge(ctx context.Context, dest string, src string, conflict string) (pgstore.MergeCounts, error) {
+	query := url.Values{"action": {"merge"}, "from": {src}, "conflict": {conflict}}
+	var m iidy.MergedMessage
+	_, err := c.do(ctx, http.MethodPost, c.BaseURL+"/iidy/v1/lists/"+url.PathEscape(dest)+"?"+query.Encode(), nil, &m)
+	return pgstore.MergeCounts(m), err
+}
+
+// snapshotURL gives the URL of a snapshot of a list, or, if label is
+// "", of the list's snapshots.
+func (c *Client) snapshotURL(list string, label string) string {
+	u := c.batchURL("snapshots", list)
+	if label != "" {
+		u += "/" + url.PathEscape(label)
+	}
+	return u
+}
+
+// TakeSnapshot snapshots list under label.
+func (c *Client) TakeSnapshot(ctx context.Context, list string, label string) (*pgstore.Snapshot, error) {
+	var m pgstore.Snapshot
+	_, err := c.do(ctx, http.MethodPost, c.snapshotURL(list, label), nil, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Snapshots gets the snapshots of list, oldest first.
+func (c *Client) Snapshots(ctx context.Context, list string) ([]pgstore.Snapshot, error) {
+	var m iidy.SnapshotsMessage
+	_, err := c.do(ctx, http.MethodGet, c.snapshotURL(list, ""), nil, &m)
+	if m.Snapshots == nil {
+		m.Snapshots = []pgstore.Snapshot{}
+	}
+	return m.Snapshots, err
+}
+
+// RestoreSnapshot puts list back the way it was when its snapshot
+// under label was taken.
+func (c *Client) RestoreSnapshot(ctx context.Context, list string, label string) (pgstore.RestoreCounts, error) {
+	var m iidy.RestoredMessage
+	_, err := c.do(ctx, http.MethodPost, c.snapshotURL(list, label)+"?action=restore", nil, &m)
+	return pgstore.RestoreCounts(m), err
+}
+
+// DeleteSnapshot deletes the snapshot of list under label.
+func (c *Client) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	var m iidy.DeletedMessage
+	_, err := c.do(ctx, http.MethodDelete, c.snapshotURL(list, label), nil, &m)
+	return m.Deleted, err
+}
+
+// ApplyBatch does actions to the items of list, all in one transaction.
+func (c *Client) ApplyBatch(ctx context.Context, list string, actions []pgstore.ItemAction) (pgstore.ActionCounts, error) {
+	var m iidy.ActionsMessage
+	_, err := c.do(ctx, http.MethodPost, c.batchURL("actions", list), &iidy.ActionListMessage{Items: actions}, &m)
+	return pgstore.ActionCounts{Added: m.Added, Incremented: m.Incremented, Deleted: m.Deleted}, err
+}
@@ -0,0 +1,133 @@
+/*
+Package clock lets the parts of iidy that depend on the time (how long
+queries take, when samples were taken, and, later, leases, delays, TTLs,
+and retention) be handed a clock, instead of calling time.Now directly,
+so that they can be tested deterministically.
+
+In production, use System. In tests, use a Fake, and move time along
+with Advance:
+
+    c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+    s := memstore.NewMemStore()
+    s.Clock = c
+    ...
+    c.Advance(5 * time.Minute)
+*/
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	// Now gives the current time.
+	Now() time.Time
+	// Since gives the time elapsed since t.
+	Since(t time.Time) time.Duration
+	// After waits for d to elapse, and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the real clock, which uses package time.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// OrSystem gives c, or System if c is nil, so that a nil Clock field
+// can mean "use the real clock".
+func OrSystem(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+	return c
+}
+
+// Fake is a Clock whose time only changes when it is told to.
+// It is safe for concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a channel returned by After, waiting for the time at.
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFake constructs a new Fake, set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now gives the fake current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since gives the fake time elapsed since t.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After gives a channel that gets the fake time once the clock
+// has been moved forward by d or more.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// Advance moves the clock forward by d, firing any
+// channels from After whose time has come.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(f.now.Add(d))
+}
+
+// Set sets the clock to t, firing any channels from After
+// whose time has come. Setting the clock back is allowed.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(t)
+}
+
+// set does the work of Set; f.mu must be held.
+func (f *Fake) set(t time.Time) {
+	f.now = t
+	waiting := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(t) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- t
+	}
+	f.waiters = waiting
+}
@@ -0,0 +1,64 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Errorf("Now() = %v, want %v", got, start)
+	}
+	soon := c.After(time.Minute)
+	later := c.After(time.Hour)
+	now := c.After(0)
+	select {
+	case <-now:
+	default:
+		t.Errorf("After(0) did not fire right away")
+	}
+
+	c.Advance(30 * time.Second)
+	select {
+	case <-soon:
+		t.Errorf("After(time.Minute) fired after only 30s")
+	default:
+	}
+	if got := c.Since(start); got != 30*time.Second {
+		t.Errorf("Since(start) = %v, want 30s", got)
+	}
+
+	c.Advance(30 * time.Second)
+	select {
+	case got := <-soon:
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("After(time.Minute) sent %v, want %v", got, want)
+		}
+	default:
+		t.Errorf("After(time.Minute) did not fire after a minute")
+	}
+	select {
+	case <-later:
+		t.Errorf("After(time.Hour) fired after only a minute")
+	default:
+	}
+
+	c.Set(start.Add(2 * time.Hour))
+	select {
+	case <-later:
+	default:
+		t.Errorf("After(time.Hour) did not fire after two hours")
+	}
+}
+
+func TestOrSystem(t *testing.T) {
+	if OrSystem(nil) != System {
+		t.Errorf("OrSystem(nil) is not System")
+	}
+	c := NewFake(time.Time{})
+	if OrSystem(c) != c {
+		t.Errorf("OrSystem(c) is not c")
+	}
+}
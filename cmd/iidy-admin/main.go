@@ -0,0 +1,197 @@
+// Command iidy-admin does administrative tasks against iidy's database,
+// which it connects to with IIDY_PG_CONN_URL, just as iidy does.
+//
+//     iidy-admin verify [-output plain|json|table]
+//     iidy-admin rewrite-prefix -list <list> [-dry-run] [-output plain|json|table] <from> <to>
+//
+// verify checks the invariants that iidy relies on, which is worth doing
+// after a crash, or after manual surgery on the database, and reports on
+// each of them. It exits with a status of 1 if any check fails.
+//
+// rewrite-prefix renames every item in a list that starts with one prefix
+// so that it starts with another, such as when the files a list tracks
+// move from one bucket to another:
+//
+//     iidy-admin rewrite-prefix -list downloads -dry-run s3://old-bucket/ s3://new-bucket/
+//
+// Nothing is renamed if any new name is already taken, or too long,
+// in which case it exits with a status of 1.
+//
+// Both commands print their reports as plain text by default; -output json
+// (or just -json) and -output table are for scripts and people,
+// respectively. So that scripts need not scrape the output to find out
+// what went wrong, the exit status is 2 for bad usage, 3 if nothing was
+// found (no item in the list starts with the prefix), 4 for a name that
+// is not allowed, and 5 if the data store could not be reached or
+// failed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+const usage = `Usage: iidy-admin <command> [flags]
+
+Commands:
+  verify            check the data store's invariants, and report on them
+  rewrite-prefix    rename the items in a list that start with a prefix
+`
+
+// The exit statuses, besides 0 for success.
+const (
+	// exitFailed is for a check that failed, or a rewrite that was blocked.
+	exitFailed = 1
+	exitUsage  = 2
+	// exitNotFound is for a command that found nothing to work on.
+	exitNotFound = 3
+	// exitInvalid is for a list or item name that is not allowed.
+	exitInvalid = 4
+	// exitError is for a data store that could not be reached or failed.
+	exitError = 5
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+	switch os.Args[1] {
+	case "verify":
+		verify(os.Args[2:])
+	case "rewrite-prefix":
+		rewritePrefix(os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(exitUsage)
+	}
+}
+
+// connect connects to the data store at IIDY_PG_CONN_URL.
+func connect() *pgstore.PgStore {
+	s, err := pgstore.NewPgStore(os.Getenv("IIDY_PG_CONN_URL"))
+	if err != nil {
+		fail(err, "Could not connect to data store: %v\n", err)
+	}
+	return s
+}
+
+// fail logs an error, and exits with the status for it: exitInvalid for
+// a name that is not allowed, or else exitError.
+func fail(err error, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	var verr *pgstore.ValidationError
+	if errors.As(err, &verr) {
+		os.Exit(exitInvalid)
+	}
+	os.Exit(exitError)
+}
+
+// outputFlags adds the -output flag, and its -json shorthand, to flags.
+// Once flags are parsed, the returned function gives the output format,
+// or exits if it is not one of "plain", "json", or "table".
+func outputFlags(flags *flag.FlagSet) func() string {
+	output := flags.String("output", "plain", "print the report as plain text, json, or a table")
+	asJSON := flags.Bool("json", false, "print the report as JSON (same as -output json)")
+	return func() string {
+		if *asJSON {
+			return "json"
+		}
+		switch *output {
+		case "plain", "json", "table":
+			return *output
+		}
+		fmt.Fprintf(os.Stderr, "Unknown output %q: must be plain, json, or table\n", *output)
+		os.Exit(exitUsage)
+		return ""
+	}
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func verify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	output := outputFlags(flags)
+	flags.Parse(args)
+	format := output()
+
+	report, err := connect().Verify(context.Background())
+	if err != nil {
+		fail(err, "Could not verify data store: %v\n", err)
+	}
+	switch format {
+	case "json":
+		printJSON(report)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "STATUS\tCHECK\tDETAIL")
+		for _, c := range report.Checks {
+			status := "OK"
+			if !c.OK {
+				status = "FAIL"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", status, c.Name, c.Detail)
+		}
+		tw.Flush()
+	default:
+		fmt.Printf("Checked at %s\n", report.CheckedAt.Format("2006-01-02T15:04:05Z"))
+		for _, c := range report.Checks {
+			status := "OK  "
+			if !c.OK {
+				status = "FAIL"
+			}
+			fmt.Printf("%s %s: %s\n", status, c.Name, c.Detail)
+		}
+	}
+	if !report.OK() {
+		os.Exit(exitFailed)
+	}
+}
+
+func rewritePrefix(args []string) {
+	flags := flag.NewFlagSet("rewrite-prefix", flag.ExitOnError)
+	list := flags.String("list", "", "the list whose items to rename")
+	dryRun := flags.Bool("dry-run", false, "report on what would be renamed, without renaming anything")
+	output := outputFlags(flags)
+	flags.Parse(args)
+	format := output()
+	if *list == "" || flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: iidy-admin rewrite-prefix -list <list> [-dry-run] [-output plain|json|table] <from> <to>")
+		os.Exit(exitUsage)
+	}
+
+	r, err := connect().RewritePrefix(context.Background(), *list, flags.Arg(0), flags.Arg(1), *dryRun)
+	if err != nil && !errors.Is(err, pgstore.ErrRewriteBlocked) {
+		fail(err, "Could not rewrite prefix: %v\n", err)
+	}
+	switch format {
+	case "json":
+		printJSON(r)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "MATCHED\tCONFLICTS\tTOO_LONG\tREWRITTEN")
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\n", r.Matched, r.Conflicts, r.TooLong, r.Rewritten)
+		tw.Flush()
+	default:
+		fmt.Printf("matched %d\nconflicts %d\ntoo_long %d\nrewritten %d\n", r.Matched, r.Conflicts, r.TooLong, r.Rewritten)
+	}
+	if !r.OK() {
+		os.Exit(exitFailed)
+	}
+	if r.Matched == 0 {
+		os.Exit(exitNotFound)
+	}
+}
@@ -0,0 +1,475 @@
+// Command iidy-client works with the lists of an iidy server, over its
+// HTTP API, from the command line.
+//
+//     iidy-client add [-file <path>] [-batch <n>] [-ignore-duplicates] <list> [<item>...]
+//     iidy-client get <list> <item>
+//     iidy-client del [-file <path>] [-batch <n>] <list> [<item>...]
+//     iidy-client inc [-file <path>] [-batch <n>] <list> [<item>...]
+//     iidy-client list [-after <item>] [-count <n>] [-all] [<list>]
+//     iidy-client count [-by-attempts] <list>
+//
+// add, del, and inc take their items from the command line, or, if there
+// are none, one per line from -file, or from stdin if -file is "-" (as it
+// is by default), and send them -batch at a time:
+//
+//     find /data -name '*.tar.gz' | iidy-client add -ignore-duplicates downloads
+//
+// list, without a list, names the lists that have items in them, with how
+// many items each has; with a list, it gives a page of its items, with
+// their attempts, or, with -all, every item.
+//
+// The server is at IIDY_URL (http://localhost:8080 if it is not set), and
+// requests are made with the API key IIDY_API_KEY, if it is set. Either
+// can come from the config file at IIDY_CLIENT_CONFIG instead (see package
+// config), or from the -url and -api-key flags, which override them.
+//
+// Like iidy-admin, every command prints plain text by default, and takes
+// -output json (or just -json) and -output table; and the exit status is
+// 2 for bad usage, 3 if nothing was found (get of an item that is not in
+// the list, or del or inc of items none of which are), 4 for a name that
+// is not allowed, and 5 if the server could not be reached or failed.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/manniwood/iidy"
+	"github.com/manniwood/iidy/client"
+	"github.com/manniwood/iidy/config"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+const usage = `Usage: iidy-client <command> [flags] <list> [<item>...]
+
+Commands:
+  add      add items to a list
+  get      get an item's attempts
+  del      delete items from a list
+  inc      increment the attempts of items in a list
+  list     name the lists, or get the items of one
+  count    count the items in a list
+`
+
+// The exit statuses, besides 0 for success.
+const (
+	exitUsage = 2
+	// exitNotFound is for a command that found nothing to work on.
+	exitNotFound = 3
+	// exitInvalid is for a list or item name that is not allowed.
+	exitInvalid = 4
+	// exitError is for a server that could not be reached or failed.
+	exitError = 5
+)
+
+// DefaultBatch is how many items add, del, and inc send at a time,
+// unless -batch says otherwise.
+const DefaultBatch = 1000
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+	switch os.Args[1] {
+	case "add":
+		add(os.Args[2:])
+	case "get":
+		get(os.Args[2:])
+	case "del":
+		del(os.Args[2:])
+	case "inc":
+		inc(os.Args[2:])
+	case "list":
+		list(os.Args[2:])
+	case "count":
+		count(os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(exitUsage)
+	}
+}
+
+// fail logs an error, and exits with the status for it: exitInvalid for
+// a name that is not allowed, or else exitError.
+func fail(err error, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	if errors.Is(err, client.ErrInvalidList) || errors.Is(err, client.ErrInvalidItem) {
+		os.Exit(exitInvalid)
+	}
+	os.Exit(exitError)
+}
+
+// usageFail prints a command's usage line, and exits with exitUsage.
+func usageFail(line string) {
+	fmt.Fprintln(os.Stderr, "Usage: iidy-client "+line)
+	os.Exit(exitUsage)
+}
+
+// commonFlags adds the flags that every command takes to flags: -url,
+// -api-key, and -output, with its -json shorthand. Once flags are parsed,
+// the returned function gives a Client for the server, and the output
+// format, or exits if either cannot be had.
+func commonFlags(flags *flag.FlagSet) func() (*client.Client, string) {
+	baseURL := flags.String("url", "", "base URL of the iidy server (default IIDY_URL, or http://localhost:8080)")
+	apiKey := flags.String("api-key", "", "API key to make requests with (default IIDY_API_KEY)")
+	output := flags.String("output", "plain", "print the results as plain text, json, or a table")
+	asJSON := flags.Bool("json", false, "print the results as JSON (same as -output json)")
+	return func() (*client.Client, string) {
+		format := *output
+		if *asJSON {
+			format = "json"
+		}
+		switch format {
+		case "plain", "json", "table":
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown output %q: must be plain, json, or table\n", *output)
+			os.Exit(exitUsage)
+		}
+		cfg, err := config.Load(os.Getenv("IIDY_CLIENT_CONFIG"))
+		if err != nil {
+			log.Printf("Could not load IIDY_CLIENT_CONFIG: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if *baseURL == "" {
+			*baseURL = cfg.Get("IIDY_URL")
+		}
+		if *baseURL == "" {
+			*baseURL = "http://localhost:8080"
+		}
+		c := client.New(*baseURL)
+		c.APIKey = *apiKey
+		if c.APIKey == "" {
+			c.APIKey = cfg.Get("IIDY_API_KEY")
+		}
+		return c, format
+	}
+}
+
+// itemFlags adds the flags of the commands that take any number of items,
+// -file and -batch, to flags. Once flags are parsed, the returned function
+// calls f with each batch of items, from the command line, after the list,
+// or else from -file, and gives the sum of what f returns.
+func itemFlags(flags *flag.FlagSet) func(f func(items []string) (int64, error)) (int64, error) {
+	file := flags.String("file", "-", "file to read items from, one per line, if none are given (\"-\" for stdin)")
+	batch := flags.Int("batch", DefaultBatch, "number of items to send at a time")
+	return func(f func(items []string) (int64, error)) (int64, error) {
+		if *batch < 1 {
+			fmt.Fprintf(os.Stderr, "-batch must be at least 1, not %d\n", *batch)
+			os.Exit(exitUsage)
+		}
+		if flags.NArg() > 1 {
+			return inBatches(flags.Args()[1:], *batch, f)
+		}
+		var r io.Reader = os.Stdin
+		if *file != "-" {
+			fh, err := os.Open(*file)
+			if err != nil {
+				log.Printf("Could not open -file: %v\n", err)
+				os.Exit(exitUsage)
+			}
+			defer fh.Close()
+			r = fh
+		}
+		var total int64
+		items := make([]string, 0, *batch)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				continue
+			}
+			items = append(items, scanner.Text())
+			if len(items) == *batch {
+				n, err := f(items)
+				total += n
+				if err != nil {
+					return total, err
+				}
+				items = items[:0]
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return total, err
+		}
+		if len(items) == 0 {
+			return total, nil
+		}
+		n, err := f(items)
+		return total + n, err
+	}
+}
+
+// inBatches calls f with items, batch at a time, and gives the sum of what
+// f returns.
+func inBatches(items []string, batch int, f func(items []string) (int64, error)) (int64, error) {
+	var total int64
+	for len(items) > 0 {
+		n := batch
+		if n > len(items) {
+			n = len(items)
+		}
+		count, err := f(items[:n])
+		total += count
+		if err != nil {
+			return total, err
+		}
+		items = items[n:]
+	}
+	return total, nil
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// printCount prints how many items a command did something to, as the
+// server does: "ADDED 3" in plain text, for a verb of "added", and
+// {"added": 3} in JSON.
+func printCount(format string, verb string, n int64) {
+	switch format {
+	case "json":
+		printJSON(map[string]int64{verb: n})
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\n%d\n", strings.ToUpper(verb), n)
+		tw.Flush()
+	default:
+		fmt.Printf("%s %d\n", strings.ToUpper(verb), n)
+	}
+}
+
+// printEntries prints entries, an item and its attempts to a line.
+func printEntries(format string, entries []pgstore.ListEntry) {
+	switch format {
+	case "json":
+		if entries == nil {
+			entries = []pgstore.ListEntry{}
+		}
+		printJSON(&iidy.ListEntryMessage{ListEntries: entries})
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ITEM\tATTEMPTS")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%d\n", e.Item, e.Attempts)
+		}
+		tw.Flush()
+	default:
+		for _, e := range entries {
+			fmt.Printf("%s %d\n", e.Item, e.Attempts)
+		}
+	}
+}
+
+func add(args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	ignore := flags.Bool("ignore-duplicates", false, "skip items that are already in the list, rather than failing")
+	common := commonFlags(flags)
+	items := itemFlags(flags)
+	flags.Parse(args)
+	c, format := common()
+	if flags.NArg() < 1 {
+		usageFail("add [-file <path>] [-batch <n>] [-ignore-duplicates] <list> [<item>...]")
+	}
+	list := flags.Arg(0)
+
+	ctx := context.Background()
+	added, err := items(func(items []string) (int64, error) {
+		if !*ignore {
+			return c.InsertBatch(ctx, list, items)
+		}
+		entries := make([]pgstore.ListEntry, len(items))
+		for i, item := range items {
+			entries[i].Item = item
+		}
+		n, _, err := c.InsertBatchIgnoreDuplicates(ctx, list, entries)
+		return n, err
+	})
+	if err != nil {
+		fail(err, "Could not add items to list %s (after adding %d): %v\n", list, added, err)
+	}
+	printCount(format, "added", added)
+}
+
+func get(args []string) {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	common := commonFlags(flags)
+	flags.Parse(args)
+	c, format := common()
+	if flags.NArg() != 2 {
+		usageFail("get <list> <item>")
+	}
+	list, item := flags.Arg(0), flags.Arg(1)
+
+	attempts, ok, err := c.GetOne(context.Background(), list, item)
+	if err != nil {
+		fail(err, "Could not get item %s of list %s: %v\n", item, list, err)
+	}
+	if !ok {
+		log.Printf("Item %s is not in list %s\n", item, list)
+		os.Exit(exitNotFound)
+	}
+	if format == "json" {
+		printJSON(&pgstore.ListEntry{Item: item, Attempts: attempts})
+		return
+	}
+	printEntries(format, []pgstore.ListEntry{{Item: item, Attempts: attempts}})
+}
+
+func del(args []string) {
+	flags := flag.NewFlagSet("del", flag.ExitOnError)
+	common := commonFlags(flags)
+	items := itemFlags(flags)
+	flags.Parse(args)
+	c, format := common()
+	if flags.NArg() < 1 {
+		usageFail("del [-file <path>] [-batch <n>] <list> [<item>...]")
+	}
+	list := flags.Arg(0)
+
+	ctx := context.Background()
+	deleted, err := items(func(items []string) (int64, error) {
+		return c.DeleteBatch(ctx, list, items)
+	})
+	if err != nil {
+		fail(err, "Could not delete items from list %s (after deleting %d): %v\n", list, deleted, err)
+	}
+	printCount(format, "deleted", deleted)
+	if deleted == 0 {
+		os.Exit(exitNotFound)
+	}
+}
+
+func inc(args []string) {
+	flags := flag.NewFlagSet("inc", flag.ExitOnError)
+	common := commonFlags(flags)
+	items := itemFlags(flags)
+	flags.Parse(args)
+	c, format := common()
+	if flags.NArg() < 1 {
+		usageFail("inc [-file <path>] [-batch <n>] <list> [<item>...]")
+	}
+	list := flags.Arg(0)
+
+	ctx := context.Background()
+	incremented, err := items(func(items []string) (int64, error) {
+		return c.IncrementBatch(ctx, list, items)
+	})
+	if err != nil {
+		fail(err, "Could not increment items in list %s (after incrementing %d): %v\n", list, incremented, err)
+	}
+	printCount(format, "incremented", incremented)
+	if incremented == 0 {
+		os.Exit(exitNotFound)
+	}
+}
+
+func list(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	after := flags.String("after", "", "get the items after this one")
+	n := flags.Int("count", 0, "number of items to get (default the server's page size)")
+	all := flags.Bool("all", false, "get every item in the list, a page at a time")
+	common := commonFlags(flags)
+	flags.Parse(args)
+	c, format := common()
+	if flags.NArg() > 1 {
+		usageFail("list [-after <item>] [-count <n>] [-all] [<list>]")
+	}
+
+	ctx := context.Background()
+	if flags.NArg() == 0 {
+		lists, err := c.Lists(ctx)
+		if err != nil {
+			fail(err, "Could not get lists: %v\n", err)
+		}
+		switch format {
+		case "json":
+			printJSON(&iidy.ListsMessage{Lists: lists})
+		case "table":
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "LIST\tITEMS")
+			for _, l := range lists {
+				fmt.Fprintf(tw, "%s\t%d\n", l.List, l.Items)
+			}
+			tw.Flush()
+		default:
+			for _, l := range lists {
+				fmt.Printf("%s %d\n", l.List, l.Items)
+			}
+		}
+		return
+	}
+
+	list := flags.Arg(0)
+	var entries []pgstore.ListEntry
+	for {
+		page, err := c.QueryPage(ctx, list, pgstore.BatchQuery{StartID: *after, Count: *n})
+		if err != nil {
+			fail(err, "Could not get items of list %s: %v\n", list, err)
+		}
+		entries = append(entries, page.ListEntries...)
+		if !*all || page.NextAfterID == "" {
+			break
+		}
+		*after = page.NextAfterID
+	}
+	printEntries(format, entries)
+}
+
+func count(args []string) {
+	flags := flag.NewFlagSet("count", flag.ExitOnError)
+	byAttempts := flags.Bool("by-attempts", false, "count the items by their attempts, too")
+	common := commonFlags(flags)
+	flags.Parse(args)
+	c, format := common()
+	if flags.NArg() != 1 {
+		usageFail("count [-by-attempts] <list>")
+	}
+	list := flags.Arg(0)
+
+	ctx := context.Background()
+	m := &iidy.CountMessage{}
+	var err error
+	if *byAttempts {
+		m.ByAttempts, err = c.CountByAttempts(ctx, list)
+		for _, b := range m.ByAttempts {
+			m.Items += b.Items
+		}
+	} else {
+		m.Items, err = c.Count(ctx, list)
+	}
+	if err != nil {
+		fail(err, "Could not count list %s: %v\n", list, err)
+	}
+	switch format {
+	case "json":
+		printJSON(m)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if *byAttempts {
+			fmt.Fprintln(tw, "ATTEMPTS\tITEMS")
+			for _, b := range m.ByAttempts {
+				fmt.Fprintf(tw, "%d\t%d\n", b.Attempts, b.Items)
+			}
+		} else {
+			fmt.Fprintf(tw, "ITEMS\n%d\n", m.Items)
+		}
+		tw.Flush()
+	default:
+		fmt.Printf("COUNT %d\n", m.Items)
+		for _, b := range m.ByAttempts {
+			fmt.Printf("%d %d\n", b.Attempts, b.Items)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Command iidy-replay replays recorded request/response fixtures (see
+// iidy.Recorder) against a running iidy server, and reports every response
+// that does not match, to catch accidental changes to the wire format.
+//
+//     iidy-replay -url http://localhost:8080 testdata/contract.jsonl
+//
+// It exits with a status of 1 if any response does not match.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/manniwood/iidy"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "base URL of the iidy server to replay against")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-url url] fixtures.jsonl...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalf("Could not open fixtures: %v\n", err)
+		}
+		fixtures, err := iidy.ReadFixtures(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Could not read fixtures from %s: %v\n", name, err)
+		}
+		mismatches, err := iidy.ReplayFixtures(nil, *baseURL, fixtures)
+		if err != nil {
+			log.Fatalf("Could not replay fixtures from %s: %v\n", name, err)
+		}
+		for _, m := range mismatches {
+			fmt.Printf("%s: %v\n", name, m)
+		}
+		fmt.Printf("%s: %d fixtures, %d mismatches\n", name, len(fixtures), len(mismatches))
+		if len(mismatches) > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+// Command iidy-seed adds synthetic items to a list, straight into iidy's
+// database (with COPY), for demos, benchmarks, and migration rehearsals.
+// It connects with IIDY_PG_CONN_URL, just as iidy does.
+//
+//     iidy-seed -list downloads -n 1000000 -pattern 's3://bucket/%08d.tar.gz' -attempts 0=90,1=8,5=2 -seed 42
+//
+// The same flags always generate the same items, with the same attempts.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/manniwood/iidy/pgstore"
+	"github.com/manniwood/iidy/seed"
+)
+
+func main() {
+	list := flag.String("list", "seeded", "list to add items to")
+	n := flag.Int("n", 1000, "number of items to add")
+	pattern := flag.String("pattern", seed.DefaultPattern, "fmt pattern for item names, given each item's number")
+	attempts := flag.String("attempts", "", "weights of attempts, such as 0=90,1=8,5=2 (default all 0)")
+	seedValue := flag.Int64("seed", 1, "random seed for the choice of attempts")
+	batch := flag.Int("batch", seed.DefaultBatchSize, "number of items to add at a time")
+	flag.Parse()
+
+	weights, err := seed.ParseAttempts(*attempts)
+	if err != nil {
+		log.Fatalf("Bad -attempts: %v\n", err)
+	}
+	s, err := pgstore.NewPgStore(os.Getenv("IIDY_PG_CONN_URL"))
+	if err != nil {
+		log.Fatalf("Could not connect to data store: %v\n", err)
+	}
+	cfg := seed.Config{
+		Count:     *n,
+		Pattern:   *pattern,
+		Attempts:  weights,
+		Seed:      *seedValue,
+		BatchSize: *batch,
+	}
+	start := time.Now()
+	added, err := seed.Load(context.Background(), s, *list, cfg, func(added int64) {
+		log.Printf("Added %d of %d items\n", added, *n)
+	})
+	if err != nil {
+		log.Fatalf("Could not add items to %s after adding %d: %v\n", *list, added, err)
+	}
+	log.Printf("Added %d items to %s in %v\n", added, *list, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,170 @@
+// Command iidy-worker-example is a pool of workers that works through the
+// items of a list, the way a real iidy client would, so that it serves
+// both as an example of that flow, and as a smoke test of a new
+// deployment.
+//
+//     iidy-worker-example -url http://localhost:8080 -list downloads -seed 1000 -workers 8 -fail-rate 0.1
+//
+// The flow is the one that iidy is built for. Page through the list with
+// GET /iidy/v1/batch/lists/<list>; for each item, increment its attempts
+// before working on it (so that a worker that crashes mid-item still
+// counts as an attempt); delete the item when the work is done; leave it
+// in the list when the work fails, to be retried on the next pass; and
+// give up on items that have been attempted -max-attempts times.
+//
+// iidy does not yet hand out claims on items, with heartbeats to keep them,
+// so two copies of this command working on the same list would work on the
+// same items. Run one copy per list, with as many -workers as needed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manniwood/iidy/client"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// config is how the workers behave.
+type config struct {
+	list        string
+	workers     int
+	batch       int
+	maxAttempts int
+	workTime    time.Duration
+	failRate    float64
+}
+
+// stats counts what the workers did. Use atomic to update it.
+type stats struct {
+	attempts  int64
+	done      int64
+	failed    int64
+	exhausted int64
+}
+
+func main() {
+	var cfg config
+	baseURL := flag.String("url", "http://localhost:8080", "base URL of the iidy server")
+	flag.StringVar(&cfg.list, "list", "worker-example", "list to work through")
+	seed := flag.Int("seed", 0, "number of items to add to the list before starting")
+	flag.IntVar(&cfg.workers, "workers", 4, "number of concurrent workers")
+	flag.IntVar(&cfg.batch, "batch", 100, "number of items to get from iidy at a time")
+	flag.IntVar(&cfg.maxAttempts, "max-attempts", 3, "attempts after which an item is given up on")
+	flag.DurationVar(&cfg.workTime, "work-time", 10*time.Millisecond, "longest time that simulated work takes")
+	flag.Float64Var(&cfg.failRate, "fail-rate", 0, "fraction of simulated work, from 0 to 1, that fails")
+	flag.Parse()
+
+	ctx := context.Background()
+	c := client.New(*baseURL)
+	if *seed > 0 {
+		items := make([]string, *seed)
+		for i := range items {
+			items[i] = fmt.Sprintf("item-%08d", i)
+		}
+		added, err := c.InsertBatch(ctx, cfg.list, items)
+		if err != nil {
+			log.Fatalf("Could not seed list %s: %v\n", cfg.list, err)
+		}
+		log.Printf("Added %d items to list %s\n", added, cfg.list)
+	}
+
+	start := time.Now()
+	s, err := run(ctx, c, cfg)
+	if err != nil {
+		log.Fatalf("Could not work through list %s: %v\n", cfg.list, err)
+	}
+	log.Printf("Finished in %v: %d attempts, %d done, %d failed, %d given up on\n",
+		time.Since(start).Round(time.Millisecond), s.attempts, s.done, s.failed, s.exhausted)
+}
+
+// run works through the list, a pass at a time, until every item
+// is either done or given up on.
+func run(ctx context.Context, c *client.Client, cfg config) (*stats, error) {
+	s := &stats{}
+	for pass := 1; ; pass++ {
+		items := make(chan string)
+		var wg sync.WaitGroup
+		for i := 0; i < cfg.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for item := range items {
+					work(ctx, c, cfg, s, item)
+				}
+			}()
+		}
+
+		queued, exhausted, err := queue(ctx, c, cfg, items)
+		close(items)
+		wg.Wait()
+		if err != nil {
+			return s, err
+		}
+		log.Printf("Pass %d: worked on %d items\n", pass, queued)
+		if queued == 0 {
+			s.exhausted = exhausted
+			return s, nil
+		}
+	}
+}
+
+// queue pages through the list, handing items that have attempts left
+// to the workers, and gives the number of items handed out, and the
+// number that have no attempts left.
+func queue(ctx context.Context, c *client.Client, cfg config, items chan<- string) (int64, int64, error) {
+	var queued, exhausted int64
+	afterID := ""
+	for {
+		page, err := c.QueryPage(ctx, cfg.list, pgstore.BatchQuery{StartID: afterID, Count: cfg.batch})
+		if err != nil {
+			return queued, exhausted, err
+		}
+		for _, e := range page.ListEntries {
+			if e.Attempts >= cfg.maxAttempts {
+				exhausted++
+				continue
+			}
+			items <- e.Item
+			queued++
+		}
+		if page.NextAfterID == "" {
+			return queued, exhausted, nil
+		}
+		afterID = page.NextAfterID
+	}
+}
+
+// work makes one attempt at an item.
+func work(ctx context.Context, c *client.Client, cfg config, s *stats, item string) {
+	// Count the attempt before starting, so that an item that
+	// crashes its worker every time is eventually given up on.
+	if _, err := c.IncrementOne(ctx, cfg.list, item); err != nil {
+		log.Printf("Could not count attempt at %s: %v\n", item, err)
+		return
+	}
+	atomic.AddInt64(&s.attempts, 1)
+
+	if cfg.workTime > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(cfg.workTime))))
+	}
+	if rand.Float64() < cfg.failRate {
+		// Leave the item in the list to be retried.
+		atomic.AddInt64(&s.failed, 1)
+		return
+	}
+
+	if _, err := c.DeleteOne(ctx, cfg.list, item); err != nil {
+		// The work is done, but iidy does not know it, so the item will be
+		// worked on again. Work must be idempotent for this reason.
+		log.Printf("Could not mark %s done: %v\n", item, err)
+		return
+	}
+	atomic.AddInt64(&s.done, 1)
+}
@@ -1,27 +1,762 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/manniwood/iidy"
+	"github.com/manniwood/iidy/config"
 	"github.com/manniwood/iidy/pgstore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func main() {
-	port := 8080
+// cfg holds iidy's settings, from the config file named by IIDY_CONFIG,
+// if any, and the environment, which overrides it.
+var cfg *config.Config
 
-	s, err := pgstore.NewPgStore(os.Getenv("IIDY_PG_CONN_URL"))
+func main() {
+	var err error
+	cfg, err = config.Load(os.Getenv("IIDY_CONFIG"))
 	if err != nil {
-		log.Fatalf("Could not connect to data store: %v\n", err)
+		log.Fatalf("Could not load IIDY_CONFIG: %v\n", err)
 	}
+	port := intSetting("IIDY_PORT", 8080)
+	adminPort := intSetting("IIDY_ADMIN_PORT", 8081)
+	// The admin port has the debugging endpoints, which should not be
+	// exposed to the clients of the lists.
+	admin := http.NewServeMux()
+	admin.Handle("/debug/vars", expvar.Handler())
+
+	// Query tracing is off unless IIDY_QUERY_TRACING is true; it can be
+	// turned on and off while running at /debug/iidy/tracing on the
+	// admin port.
+	tracer := pgstore.NewQueryTracer(nil)
+	tracing, _ := strconv.ParseBool(cfg.Get("IIDY_QUERY_TRACING"))
+	tracer.SetEnabled(tracing)
+	spans := newTracer()
+	tracer.Spans = spans
+	expvar.Publish("iidy_queries", tracer)
+	admin.Handle("/debug/iidy/tracing", tracer)
+
+	// The admin port is up while iidy waits for the database, so that
+	// /readyz can say what it is waiting for.
+	ready := iidy.NewReadiness()
+	expvar.Publish("iidy_ready", ready)
+	admin.Handle("/readyz", ready)
+	admin.HandleFunc("/healthz", iidy.Healthz)
+	adminHandler := iidy.Chain(admin, middleware("IIDY_ADMIN_MIDDLEWARE", "recover,no_sniff", map[string]iidy.Middleware{
+		"recover":    iidy.Recover,
+		"access_log": accessLog,
+		"no_sniff":   iidy.NoSniff,
+	})...)
+	go func() {
+		log.Printf("Admin server starting on port %d\n", adminPort)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", adminPort), adminHandler))
+	}()
+
+	s := connect(tracer, ready)
 	log.Printf("Connecting to data store with following config:\n%s\n", s)
-	h := &iidy.Handler{Store: s}
+	validator := newValidator()
+	s.Validator = validator
+	s.RetryDelays = newRetryDelays()
+	// Waiting for a connection from the pool is bounded by
+	// IIDY_ACQUIRE_TIMEOUT (default 5s; 0 means wait as long as it takes),
+	// apart from however long the query itself takes.
+	s.AcquireTimeout = 5 * time.Second
+	if timeout := cfg.Get("IIDY_ACQUIRE_TIMEOUT"); timeout != "" {
+		var err error
+		s.AcquireTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("IIDY_ACQUIRE_TIMEOUT is not a duration: %v\n", err)
+		}
+	}
+	// Connections that have sat idle in the pool for longer than
+	// IIDY_PRE_PING_IDLE (default 0, never) are pinged before use.
+	if idle := cfg.Get("IIDY_PRE_PING_IDLE"); idle != "" {
+		var err error
+		s.PrePingIdle, err = time.ParseDuration(idle)
+		if err != nil {
+			log.Fatalf("IIDY_PRE_PING_IDLE is not a duration: %v\n", err)
+		}
+	}
+	// With IIDY_WARM_UP, the pool's minimum connections (pool_min_conns
+	// in IIDY_PG_CONN_URL) are opened and checked before iidy is ready.
+	if warm, _ := strconv.ParseBool(cfg.Get("IIDY_WARM_UP")); warm {
+		n, err := s.Warm(context.Background())
+		if err != nil {
+			log.Fatalf("Could not warm up connections: %v\n", err)
+		}
+		log.Printf("Warmed up %d connections\n", n)
+	}
+	// Sample the shape of the data every IIDY_SHAPE_INTERVAL
+	// (default 5m) for capacity planning.
+	shapeInterval := 5 * time.Minute
+	if interval := cfg.Get("IIDY_SHAPE_INTERVAL"); interval != "" {
+		var err error
+		shapeInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("IIDY_SHAPE_INTERVAL is not a duration: %v\n", err)
+		}
+	}
+	shape := pgstore.NewShapeSampler(s.Shape)
+	expvar.Publish("iidy_shape", shape)
+	go shape.Run(context.Background(), shapeInterval)
+
+	metrics := newMetrics()
+	expvar.Publish("iidy", metrics)
+	store := withChaos(s)
+	if threshold := slowQueryThreshold(); threshold > 0 {
+		store = pgstore.NewSlowLogStore(store, threshold, func(q pgstore.SlowQuery) {
+			metrics.CountSlowQuery(q.List, q.Op)
+		})
+	}
+	if breaker := newBreaker(store, s.Ping); breaker != nil {
+		expvar.Publish("iidy_breaker", breaker)
+		store = breaker
+	}
+	readOnly := newReadOnly(store, s.IsReadOnly, ready)
+	expvar.Publish("iidy_read_only", readOnly)
+	go readOnly.Run(context.Background(), readOnlyCheckInterval())
+	store = readOnly
+	// Items whose TTL (see the list settings) has run out are expired
+	// every IIDY_EXPIRY_INTERVAL (default 1m; 0 turns expiry off),
+	// IIDY_EXPIRY_BATCH_SIZE (default 1000) at a time, except while
+	// the database is read-only.
+	if interval := durationSetting("IIDY_EXPIRY_INTERVAL", time.Minute); interval > 0 {
+		janitor := pgstore.NewJanitor(func(ctx context.Context, limit int) (pgstore.ExpireCounts, error) {
+			if readOnly.ReadOnly() {
+				return pgstore.ExpireCounts{}, nil
+			}
+			return s.Expire(ctx, limit)
+		})
+		janitor.BatchSize = intSetting("IIDY_EXPIRY_BATCH_SIZE", pgstore.DefaultExpireBatchSize)
+		expvar.Publish("iidy_expiry", janitor)
+		go janitor.Run(context.Background(), interval)
+	}
+	slo := iidy.NewSLOMetrics()
+	expvar.Publish("iidy_slo", slo)
+	latency := iidy.NewRouteLatency()
+	expvar.Publish("iidy_latency", latency)
+	h := &iidy.Handler{Store: store, Metrics: metrics, SLO: slo, Latency: latency, DB: readOnly, Exporter: readOnly, Differ: readOnly, Merger: readOnly, Snapshots: readOnly, Chains: readOnly, Settings: readOnly, Lister: readOnly, Transitioner: readOnly, Attempts: readOnly, Counter: readOnly, Streamer: readOnly, Inserter: readOnly, Validator: validator}
+	h.DefaultCount, h.MaxCount = pageSizes()
+	h.MaxBodyBytes, h.MaxBatchItems = bodyLimits()
+	h.MinCompressBytes = intSetting("IIDY_MIN_COMPRESS_BYTES", 1024)
+	h.Activity = iidy.NewActivity()
+	h.V1Deprecation = v1Deprecation()
+	h.Strict, _ = strconv.ParseBool(cfg.Get("IIDY_STRICT"))
+	h.Versions = iidy.NewVersionMetrics()
+	h.Tracer = spans
+	expvar.Publish("iidy_versions", h.Versions)
 
-	http.Handle("/", h)
+	// Importing expvar registers /debug/vars with http.DefaultServeMux,
+	// so lists are served from a mux of their own.
+	mux := http.NewServeMux()
+	// To record fixtures for iidy-replay, set IIDY_RECORD_FIXTURES
+	// to the file to append them to.
+	var record iidy.Middleware
+	if fixtures := cfg.Get("IIDY_RECORD_FIXTURES"); fixtures != "" {
+		f, err := os.OpenFile(fixtures, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Could not open IIDY_RECORD_FIXTURES: %v\n", err)
+		}
+		defer f.Close()
+		log.Printf("Recording fixtures to %s\n", fixtures)
+		record = func(next http.Handler) http.Handler {
+			return iidy.NewRecorder(next, f)
+		}
+	}
+	loadShed := func(next http.Handler) http.Handler {
+		shedder := newLoadShedder(next, s.MaxConns())
+		if shedder == nil {
+			return next
+		}
+		expvar.Publish("iidy_load", shedder)
+		return shedder
+	}
+	mux.Handle("/", iidy.Chain(h, middleware("IIDY_MIDDLEWARE", "recover,access_log,auth,load_shed,record,no_sniff", map[string]iidy.Middleware{
+		"recover":    iidy.Recover,
+		"access_log": accessLog,
+		"auth":       newAuth(),
+		"load_shed":  loadShed,
+		"record":     record,
+		"no_sniff":   iidy.NoSniff,
+	})...))
 
+	// IIDY_READ_TIMEOUT and IIDY_WRITE_TIMEOUT (default 0, no timeout)
+	// bound how long reading a request, and writing its response, can
+	// take.
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      mux,
+		ReadTimeout:  durationSetting("IIDY_READ_TIMEOUT", 0),
+		WriteTimeout: durationSetting("IIDY_WRITE_TIMEOUT", 0),
+		TLSConfig:    newTLSConfig(),
+	}
+	// Once ready, iidy is only ready while the database can be reached,
+	// and is up to the latest migration.
+	ready.SetCheck(s.CheckReady)
+	ready.SetReady()
+	if server.TLSConfig != nil {
+		log.Printf("Server starting on port %d, with TLS\n", port)
+		log.Fatal(server.ListenAndServeTLS(cfg.Get("IIDY_TLS_CERT_FILE"), cfg.Get("IIDY_TLS_KEY_FILE")))
+	}
 	log.Printf("Server starting on port %d\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	log.Fatal(server.ListenAndServe())
+}
+
+// newTLSConfig sets up TLS for the lists port, if IIDY_TLS_CERT_FILE and
+// IIDY_TLS_KEY_FILE name the server's certificate (with any intermediates
+// after it) and key, in PEM format; otherwise, nil is returned, and lists
+// are served in the clear. If IIDY_TLS_CLIENT_CA_FILE also names the PEM
+// certificates of one or more CAs, clients must present a certificate
+// signed by one of them (mutual TLS). HTTP/2 is offered along with
+// HTTP/1.1.
+func newTLSConfig() *tls.Config {
+	cert, key := cfg.Get("IIDY_TLS_CERT_FILE"), cfg.Get("IIDY_TLS_KEY_FILE")
+	clientCA := cfg.Get("IIDY_TLS_CLIENT_CA_FILE")
+	if cert == "" && key == "" {
+		if clientCA != "" {
+			log.Fatalf("IIDY_TLS_CLIENT_CA_FILE is set, but IIDY_TLS_CERT_FILE and IIDY_TLS_KEY_FILE are not\n")
+		}
+		return nil
+	}
+	if cert == "" || key == "" {
+		log.Fatalf("IIDY_TLS_CERT_FILE and IIDY_TLS_KEY_FILE must be set together\n")
+	}
+	// Load the pair now, so that a bad one stops iidy from starting,
+	// rather than failing every handshake.
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		log.Fatalf("Could not load IIDY_TLS_CERT_FILE and IIDY_TLS_KEY_FILE: %v\n", err)
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			log.Fatalf("Could not read IIDY_TLS_CLIENT_CA_FILE: %v\n", err)
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(pem) {
+			log.Fatalf("IIDY_TLS_CLIENT_CA_FILE has no PEM certificates\n")
+		}
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c
+}
+
+// newTracer sets up OpenTelemetry tracing, if OTEL_TRACES_EXPORTER is
+// "otlp", and gives the tracer of requests and queries, or nil if
+// tracing is off. Spans are sent over OTLP/HTTP, as configured by the
+// usual OpenTelemetry environment variables, such as
+// OTEL_EXPORTER_OTLP_ENDPOINT (default http://localhost:4318),
+// OTEL_TRACES_SAMPLER, and OTEL_SERVICE_NAME (default iidy). These are
+// read by OpenTelemetry itself, from the environment only.
+func newTracer() trace.Tracer {
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "", "none":
+		return nil
+	case "otlp":
+	default:
+		log.Fatalf("OTEL_TRACES_EXPORTER %q is not otlp or none\n", exporter)
+	}
+	ctx := context.Background()
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		log.Fatalf("Could not set up the OTLP exporter: %v\n", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String("iidy")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		log.Fatalf("Could not describe iidy to OpenTelemetry: %v\n", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	log.Printf("Tracing is on; spans are sent over OTLP\n")
+	return provider.Tracer("github.com/manniwood/iidy")
+}
+
+// intSetting gives the setting called name as an integer, or def if it
+// is not set.
+func intSetting(name string, def int) int {
+	v := cfg.Get(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s is not an integer: %v\n", name, err)
+	}
+	return n
+}
+
+// durationSetting gives the setting called name as a duration, or def
+// if it is not set.
+func durationSetting(name string, def time.Duration) time.Duration {
+	v := cfg.Get(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s is not a duration: %v\n", name, err)
+	}
+	return d
+}
+
+// connect connects to the data store at IIDY_PG_CONN_URL and, if
+// IIDY_MIGRATE is true, brings it up to the latest migration. When iidy is
+// started alongside PostgreSQL, the database may not be up yet, so failures
+// are retried, with backoff, for up to IIDY_DB_WAIT (default 1m; 0 means
+// give up right away). Until then, ready says what iidy is waiting for.
+func connect(tracer *pgstore.QueryTracer, ready *iidy.Readiness) *pgstore.PgStore {
+	b := pgstore.Backoff{Initial: 500 * time.Millisecond, Max: 10 * time.Second, Limit: time.Minute}
+	if wait := cfg.Get("IIDY_DB_WAIT"); wait != "" {
+		var err error
+		b.Limit, err = time.ParseDuration(wait)
+		if err != nil {
+			log.Fatalf("IIDY_DB_WAIT is not a duration: %v\n", err)
+		}
+	}
+	migrate, _ := strconv.ParseBool(cfg.Get("IIDY_MIGRATE"))
+	var s *pgstore.PgStore
+	err := b.Retry(context.Background(), func(ctx context.Context) error {
+		if s == nil {
+			var err error
+			s, err = pgstore.NewPgStoreWithTracer(cfg.Get("IIDY_PG_CONN_URL"), tracer)
+			if err != nil {
+				return err
+			}
+		}
+		if migrate {
+			return s.Migrate(ctx)
+		}
+		return nil
+	}, func(attempt int, err error, wait time.Duration) {
+		log.Printf("Data store is not ready (attempt %d): %v; trying again in %v\n", attempt, err, wait)
+		ready.SetNotReady(fmt.Sprintf("waiting for database: %v", err))
+	})
+	if err != nil {
+		log.Fatalf("Could not connect to data store: %v\n", err)
+	}
+	return s
+}
+
+// newMetrics sets up per-list metrics. IIDY_METRICS_LISTS is a
+// comma-separated list of lists that always get metrics of their own;
+// IIDY_METRICS_MAX_LISTS (default 100) is how many other lists get metrics
+// of their own before the rest are lumped together.
+func newMetrics() *iidy.Metrics {
+	var allow []string
+	if lists := cfg.Get("IIDY_METRICS_LISTS"); lists != "" {
+		allow = strings.Split(lists, ",")
+	}
+	limit := 100
+	if max := cfg.Get("IIDY_METRICS_MAX_LISTS"); max != "" {
+		var err error
+		limit, err = strconv.Atoi(max)
+		if err != nil {
+			log.Fatalf("IIDY_METRICS_MAX_LISTS is not an integer: %v\n", err)
+		}
+	}
+	return iidy.NewMetrics(allow, limit)
+}
+
+// newValidator sets up the rules for list and item names.
+// IIDY_MAX_LIST_LENGTH and IIDY_MAX_ITEM_LENGTH (in bytes) override the
+// defaults, and IIDY_NAME_CHARSET, if set, is a regular expression that
+// every name must match, such as "^[A-Za-z0-9_.-]+$". The two lengths
+// must add up to no more than pgstore.MaxKeyLength.
+func newValidator() *pgstore.Validator {
+	v := *pgstore.DefaultValidator
+	if n := cfg.Get("IIDY_MAX_LIST_LENGTH"); n != "" {
+		var err error
+		v.MaxListLength, err = strconv.Atoi(n)
+		if err != nil {
+			log.Fatalf("IIDY_MAX_LIST_LENGTH is not an integer: %v\n", err)
+		}
+	}
+	if n := cfg.Get("IIDY_MAX_ITEM_LENGTH"); n != "" {
+		var err error
+		v.MaxItemLength, err = strconv.Atoi(n)
+		if err != nil {
+			log.Fatalf("IIDY_MAX_ITEM_LENGTH is not an integer: %v\n", err)
+		}
+	}
+	if charset := cfg.Get("IIDY_NAME_CHARSET"); charset != "" {
+		var err error
+		v.Charset, err = regexp.Compile(charset)
+		if err != nil {
+			log.Fatalf("IIDY_NAME_CHARSET is not a regular expression: %v\n", err)
+		}
+	}
+	if err := v.Check(); err != nil {
+		log.Fatalf("IIDY_MAX_LIST_LENGTH and IIDY_MAX_ITEM_LENGTH: %v\n", err)
+	}
+	return &v
+}
+
+// newRetryDelays sets up how long items whose attempts are incremented
+// wait before they are ready again. IIDY_RETRY_DELAY (such as "10s") is
+// the delay after the first attempt, which doubles with each attempt after
+// that, up to IIDY_RETRY_DELAY_CAP (such as "1h"), if set. Lists can have
+// delays of their own in IIDY_RETRY_DELAY_LISTS, a comma-separated list of
+// list=delay or list=delay/cap, such as "api-scrape=1m/6h,thumbnails=0".
+// If none of these are set, nil is returned, and items are ready again
+// right away.
+func newRetryDelays() *pgstore.RetryDelays {
+	delay := cfg.Get("IIDY_RETRY_DELAY")
+	limit := cfg.Get("IIDY_RETRY_DELAY_CAP")
+	lists := cfg.Get("IIDY_RETRY_DELAY_LISTS")
+	if delay == "" && limit == "" && lists == "" {
+		return nil
+	}
+	var d pgstore.RetryDelays
+	var err error
+	if delay != "" {
+		d.Default.Base, err = time.ParseDuration(delay)
+		if err != nil {
+			log.Fatalf("IIDY_RETRY_DELAY is not a duration: %v\n", err)
+		}
+	}
+	if limit != "" {
+		d.Default.Cap, err = time.ParseDuration(limit)
+		if err != nil {
+			log.Fatalf("IIDY_RETRY_DELAY_CAP is not a duration: %v\n", err)
+		}
+	}
+	if lists != "" {
+		d.Lists = make(map[string]pgstore.RetryDelay)
+		for _, l := range strings.Split(lists, ",") {
+			parts := strings.SplitN(l, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("IIDY_RETRY_DELAY_LISTS: %q is not list=delay or list=delay/cap\n", l)
+			}
+			var listDelay pgstore.RetryDelay
+			durations := strings.SplitN(parts[1], "/", 2)
+			listDelay.Base, err = time.ParseDuration(durations[0])
+			if err == nil && len(durations) == 2 {
+				listDelay.Cap, err = time.ParseDuration(durations[1])
+			}
+			if err != nil {
+				log.Fatalf("IIDY_RETRY_DELAY_LISTS: for list %q, %v\n", parts[0], err)
+			}
+			d.Lists[parts[0]] = listDelay
+		}
+	}
+	return &d
+}
+
+// pageSizes gives how many items a batch get returns when the count
+// query arg is left out, IIDY_DEFAULT_PAGE_SIZE, and the most it can ask
+// for, IIDY_MAX_PAGE_SIZE. Either is 0 (meaning iidy's default) if not set.
+func pageSizes() (int, int) {
+	var sizes [2]int
+	for i, name := range []string{"IIDY_DEFAULT_PAGE_SIZE", "IIDY_MAX_PAGE_SIZE"} {
+		if n := cfg.Get(name); n != "" {
+			var err error
+			sizes[i], err = strconv.Atoi(n)
+			if err != nil || sizes[i] < 1 {
+				log.Fatalf("%s is not a positive integer: %v\n", name, n)
+			}
+		}
+	}
+	if sizes[0] > 0 && sizes[1] > 0 && sizes[0] > sizes[1] {
+		log.Fatalf("IIDY_DEFAULT_PAGE_SIZE (%d) is more than IIDY_MAX_PAGE_SIZE (%d)\n", sizes[0], sizes[1])
+	}
+	return sizes[0], sizes[1]
+}
+
+// bodyLimits gives the most bytes a request body can hold,
+// IIDY_MAX_BODY_BYTES, and the most items one request can name,
+// IIDY_MAX_BATCH_ITEMS. Either, if not set, is 0, for iidy's default.
+func bodyLimits() (int64, int) {
+	var limits [2]int
+	for i, name := range []string{"IIDY_MAX_BODY_BYTES", "IIDY_MAX_BATCH_ITEMS"} {
+		if n := cfg.Get(name); n != "" {
+			var err error
+			limits[i], err = strconv.Atoi(n)
+			if err != nil || limits[i] < 1 {
+				log.Fatalf("%s is not a positive integer: %v\n", name, n)
+			}
+		}
+	}
+	return int64(limits[0]), limits[1]
+}
+
+// v1Deprecation says when the v1 API was deprecated, IIDY_V1_DEPRECATED,
+// and when it will stop working, IIDY_V1_SUNSET, each as an RFC 3339 time
+// (such as "2026-12-31T00:00:00Z"). If IIDY_V1_DEPRECATED is not set, v1
+// is not deprecated, and nil is returned.
+func v1Deprecation() *iidy.Deprecation {
+	since := cfg.Get("IIDY_V1_DEPRECATED")
+	if since == "" {
+		if cfg.Get("IIDY_V1_SUNSET") != "" {
+			log.Fatalf("IIDY_V1_SUNSET is set, but IIDY_V1_DEPRECATED is not\n")
+		}
+		return nil
+	}
+	var d iidy.Deprecation
+	var err error
+	d.Since, err = time.Parse(time.RFC3339, since)
+	if err != nil {
+		log.Fatalf("IIDY_V1_DEPRECATED is not an RFC 3339 time: %v\n", err)
+	}
+	if sunset := cfg.Get("IIDY_V1_SUNSET"); sunset != "" {
+		d.Sunset, err = time.Parse(time.RFC3339, sunset)
+		if err != nil {
+			log.Fatalf("IIDY_V1_SUNSET is not an RFC 3339 time: %v\n", err)
+		}
+	}
+	return &d
+}
+
+// withChaos wraps s in a pgstore.ChaosStore, if any of IIDY_CHAOS_LATENCY
+// (such as "500ms"), IIDY_CHAOS_LATENCY_RATE (default 1),
+// IIDY_CHAOS_ERROR_RATE, or IIDY_CHAOS_ERROR_AFTER_RATE are set,
+// so that clients can be tested against a misbehaving iidy.
+// Never set these in production!
+func withChaos(s pgstore.Store) pgstore.Store {
+	c := pgstore.NewChaosStore(s)
+	c.LatencyRate = 1
+	enabled := false
+	if latency := cfg.Get("IIDY_CHAOS_LATENCY"); latency != "" {
+		var err error
+		c.Latency, err = time.ParseDuration(latency)
+		if err != nil {
+			log.Fatalf("IIDY_CHAOS_LATENCY is not a duration: %v\n", err)
+		}
+		enabled = true
+	}
+	rates := []struct {
+		name string
+		rate *float64
+	}{
+		{"IIDY_CHAOS_LATENCY_RATE", &c.LatencyRate},
+		{"IIDY_CHAOS_ERROR_RATE", &c.ErrorRate},
+		{"IIDY_CHAOS_ERROR_AFTER_RATE", &c.ErrorAfterRate},
+	}
+	for _, r := range rates {
+		if rate := cfg.Get(r.name); rate != "" {
+			var err error
+			*r.rate, err = strconv.ParseFloat(rate, 64)
+			if err != nil {
+				log.Fatalf("%s is not a number: %v\n", r.name, err)
+			}
+			enabled = true
+		}
+	}
+	if !enabled {
+		return s
+	}
+	log.Printf("WARNING: chaos mode is on; the data store will misbehave on purpose: %s\n", c)
+	return c
+}
+
+// slowQueryThreshold gives how long a data store call can take before
+// it gets logged as slow: IIDY_SLOW_QUERY_THRESHOLD (such as "250ms"),
+// or one second by default. A threshold of 0 turns slow-query logging off.
+func slowQueryThreshold() time.Duration {
+	threshold := cfg.Get("IIDY_SLOW_QUERY_THRESHOLD")
+	if threshold == "" {
+		return time.Second
+	}
+	d, err := time.ParseDuration(threshold)
+	if err != nil {
+		log.Fatalf("IIDY_SLOW_QUERY_THRESHOLD is not a duration: %v\n", err)
+	}
+	return d
+}
+
+// newBreaker wraps s in a pgstore.BreakerStore, so that requests fail
+// fast with a 503 while the database is down. IIDY_BREAKER_THRESHOLD
+// (default 5) is how many failures in a row open the circuit, and 0 turns
+// the breaker off; IIDY_BREAKER_COOLDOWN (default 5s) is how long the circuit
+// stays open before probing the database again. If the breaker is off,
+// nil is returned.
+func newBreaker(s pgstore.Store, probe func(ctx context.Context) error) *pgstore.BreakerStore {
+	b := pgstore.NewBreakerStore(s, probe)
+	if n := cfg.Get("IIDY_BREAKER_THRESHOLD"); n != "" {
+		var err error
+		b.Threshold, err = strconv.Atoi(n)
+		if err != nil {
+			log.Fatalf("IIDY_BREAKER_THRESHOLD is not an integer: %v\n", err)
+		}
+	}
+	if b.Threshold <= 0 {
+		return nil
+	}
+	if cooldown := cfg.Get("IIDY_BREAKER_COOLDOWN"); cooldown != "" {
+		var err error
+		b.Cooldown, err = time.ParseDuration(cooldown)
+		if err != nil {
+			log.Fatalf("IIDY_BREAKER_COOLDOWN is not a duration: %v\n", err)
+		}
+	}
+	return b
+}
+
+// newReadOnly wraps s in a pgstore.ReadOnlyStore, which turns away
+// changes with a 503 while the database is read-only (as checked by check),
+// or while IIDY_READ_ONLY is true, and marks ready as degraded
+// in the meantime.
+func newReadOnly(s pgstore.Store, check func(ctx context.Context) (bool, error), ready *iidy.Readiness) *pgstore.ReadOnlyStore {
+	r := pgstore.NewReadOnlyStore(s, check)
+	r.Forced, _ = strconv.ParseBool(cfg.Get("IIDY_READ_ONLY"))
+	r.OnChange = func(readOnly bool) {
+		if readOnly {
+			ready.SetDegraded("read_only: " + r.Reason())
+		} else {
+			ready.SetDegraded("")
+		}
+	}
+	if r.Forced {
+		log.Printf("Read-only mode is on; changes will be turned away\n")
+		r.OnChange(true)
+	}
+	return r
+}
+
+// readOnlyCheckInterval gives how often to check whether the database
+// is read-only: IIDY_READ_ONLY_CHECK_INTERVAL, or 10 seconds by default.
+func readOnlyCheckInterval() time.Duration {
+	interval := cfg.Get("IIDY_READ_ONLY_CHECK_INTERVAL")
+	if interval == "" {
+		return 10 * time.Second
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		log.Fatalf("IIDY_READ_ONLY_CHECK_INTERVAL is not a positive duration: %v\n", interval)
+	}
+	return d
+}
+
+// newLoadShedder wraps h in an iidy.LoadShedder, so that requests queue up
+// for a bounded time, and are then turned away with a 503, when the
+// database is busy. IIDY_MAX_IN_FLIGHT (by default, poolSize) is how many
+// requests h handles at once, and 0 turns load shedding off;
+// IIDY_MAX_QUEUE (default 100) is how many more can wait, and
+// IIDY_QUEUE_TIMEOUT (default 1s) is how long they can wait. If load
+// shedding is off, nil is returned.
+func newLoadShedder(h http.Handler, poolSize int) *iidy.LoadShedder {
+	inFlight, queue := poolSize, 100
+	for _, v := range []struct {
+		name string
+		n    *int
+	}{
+		{"IIDY_MAX_IN_FLIGHT", &inFlight},
+		{"IIDY_MAX_QUEUE", &queue},
+	} {
+		if n := cfg.Get(v.name); n != "" {
+			var err error
+			*v.n, err = strconv.Atoi(n)
+			if err != nil || *v.n < 0 {
+				log.Fatalf("%s is not a non-negative integer: %v\n", v.name, n)
+			}
+		}
+	}
+	if inFlight == 0 {
+		return nil
+	}
+	timeout := time.Second
+	if t := cfg.Get("IIDY_QUEUE_TIMEOUT"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil {
+			log.Fatalf("IIDY_QUEUE_TIMEOUT is not a duration: %v\n", err)
+		}
+	}
+	return iidy.NewLoadShedder(h, inFlight, queue, timeout)
+}
+
+// newAuth gives middleware that requires an API key of every request, if
+// IIDY_API_KEYS_FILE names a file of them (see iidy.ReadAPIKeys), or else
+// nil, and every request is let through.
+func newAuth() iidy.Middleware {
+	path := cfg.Get("IIDY_API_KEYS_FILE")
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Could not open IIDY_API_KEYS_FILE: %v\n", err)
+	}
+	defer f.Close()
+	keys, err := iidy.ReadAPIKeys(f)
+	if err != nil {
+		log.Fatalf("Could not read IIDY_API_KEYS_FILE: %v\n", err)
+	}
+	log.Printf("API keys are required; %d keys were read\n", len(keys))
+	return iidy.RequireAPIKey(keys)
+}
+
+// middleware gives the middleware named in the environment variable name,
+// a comma-separated list such as "recover,access_log,no_sniff", or in def
+// if it is not set, from those available. Each request passes through
+// them in the order they are named. Middleware that is available but
+// turned off (such as record, when IIDY_RECORD_FIXTURES is not set) is
+// nil, and is skipped.
+func middleware(name string, def string, available map[string]iidy.Middleware) []iidy.Middleware {
+	names := def
+	if v, ok := cfg.Lookup(name); ok {
+		names = v
+	}
+	var mws []iidy.Middleware
+	for _, n := range strings.Split(names, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		mw, ok := available[n]
+		if !ok {
+			log.Fatalf("%s names unknown middleware %q\n", name, n)
+		}
+		mws = append(mws, mw)
+	}
+	return mws
+}
+
+// accessLog is middleware that wraps next in newAccessLog.
+func accessLog(next http.Handler) http.Handler {
+	return newAccessLog(next)
+}
+
+// newAccessLog wraps h in an access log written to stdout.
+// IIDY_ACCESS_LOG_SAMPLE_RATE (from 0 to 1, default 1) is the fraction of
+// requests logged, and IIDY_ACCESS_LOG_BODY_BYTES (default 0) is how much of
+// each request and response body to record.
+func newAccessLog(h http.Handler) *iidy.AccessLog {
+	a := iidy.NewAccessLog(h, os.Stdout)
+	if rate := cfg.Get("IIDY_ACCESS_LOG_SAMPLE_RATE"); rate != "" {
+		var err error
+		a.SampleRate, err = strconv.ParseFloat(rate, 64)
+		if err != nil {
+			log.Fatalf("IIDY_ACCESS_LOG_SAMPLE_RATE is not a number: %v\n", err)
+		}
+	}
+	if n := cfg.Get("IIDY_ACCESS_LOG_BODY_BYTES"); n != "" {
+		var err error
+		a.BodyBytes, err = strconv.Atoi(n)
+		if err != nil {
+			log.Fatalf("IIDY_ACCESS_LOG_BODY_BYTES is not an integer: %v\n", err)
+		}
+	}
+	return a
 }
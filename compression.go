@@ -0,0 +1,194 @@
+package iidy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressRequestBody replaces the body of r with one that undoes the
+// request's Content-Encoding, which can be gzip or zstd. Any other
+// content coding is an error. The caller must close the new body.
+func decompressRequestBody(r *http.Request) (*http.Request, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || r.Body == nil {
+		return r, nil
+	}
+	var body io.ReadCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = gz
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		body = zr.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("Content-Encoding %q is not one of gzip or zstd", encoding)
+	}
+	r2 := r.Clone(r.Context())
+	r2.Body = body
+	r2.Header.Del("Content-Encoding")
+	r2.Header.Del("Content-Length")
+	r2.ContentLength = -1
+	return r2, nil
+}
+
+// responseEncoding picks the content coding to compress the response with,
+// given the value of the request's Accept-Encoding header. zstd is preferred
+// over gzip when the client likes both equally; "" means no compression.
+func responseEncoding(acceptEncoding string) string {
+	best := ""
+	bestQ := 0.0
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		if name != "zstd" && name != "gzip" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "zstd") {
+			best = name
+			bestQ = q
+		}
+	}
+	return best
+}
+
+// compressingResponseWriter compresses everything written to it with
+// the given content coding, once at least min bytes of the body have been
+// written; until then, the status and body are held back, so that a
+// response that turns out to be smaller goes out as it is. Close must be
+// called once the handler is done writing, to send anything still held
+// back, or to flush out the end of the compressed stream.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	min      int
+	status   int
+	decided  bool
+	compress bool
+	held     []byte
+	w        io.WriteCloser
+}
+
+func newCompressingResponseWriter(w http.ResponseWriter, encoding string, min int) *compressingResponseWriter {
+	w.Header().Add("Vary", "Accept-Encoding")
+	return &compressingResponseWriter{ResponseWriter: w, encoding: encoding, min: min}
+}
+
+// WriteHeader holds back the status until it is known whether the body
+// is big enough to compress, unless the response is one that never has
+// a body, or min is 0, in which case the body is always compressed.
+func (c *compressingResponseWriter) WriteHeader(code int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < http.StatusOK {
+		c.decide(false)
+	} else if c.min <= 0 {
+		c.decide(true)
+	}
+}
+
+// decide sends the held-back status, with a Content-Encoding header
+// if compress, followed by any of the body that was held back.
+func (c *compressingResponseWriter) decide(compress bool) error {
+	c.decided = true
+	c.compress = compress
+	if compress {
+		c.Header().Set("Content-Encoding", c.encoding)
+		c.Header().Del("Content-Length")
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if compress {
+		if err := c.startCompressing(); err != nil {
+			return err
+		}
+	}
+	held := c.held
+	c.held = nil
+	if len(held) == 0 {
+		return nil
+	}
+	_, err := c.write(held)
+	return err
+}
+
+func (c *compressingResponseWriter) write(p []byte) (int, error) {
+	if c.compress {
+		return c.w.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *compressingResponseWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.decided {
+		return c.write(p)
+	}
+	c.held = append(c.held, p...)
+	if len(c.held) >= c.min {
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *compressingResponseWriter) startCompressing() error {
+	switch c.encoding {
+	case "zstd":
+		zw, err := zstd.NewWriter(c.ResponseWriter, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		c.w = zw
+	default:
+		c.w = gzip.NewWriter(c.ResponseWriter)
+	}
+	return nil
+}
+
+// Close sends a response that was too small to compress as it is, or
+// finishes the compressed stream. A compressed response that the handler
+// wrote nothing to still gets a valid (empty) compressed stream.
+func (c *compressingResponseWriter) Close() error {
+	if c.status == 0 {
+		return nil
+	}
+	if !c.decided {
+		return c.decide(false)
+	}
+	if !c.compress {
+		return nil
+	}
+	return c.w.Close()
+}
@@ -0,0 +1,196 @@
+package iidy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestResponseEncoding(t *testing.T) {
+	tests := map[string]struct {
+		acceptEncoding string
+		want           string
+	}{
+		"None":          {acceptEncoding: "", want: ""},
+		"Gzip":          {acceptEncoding: "gzip, deflate", want: "gzip"},
+		"Zstd":          {acceptEncoding: "zstd", want: "zstd"},
+		"PreferZstd":    {acceptEncoding: "gzip, zstd", want: "zstd"},
+		"HigherQ":       {acceptEncoding: "zstd;q=0.5, gzip", want: "gzip"},
+		"Refused":       {acceptEncoding: "zstd;q=0, br", want: ""},
+		"UnknownOnly":   {acceptEncoding: "br, deflate", want: ""},
+		"CaseAndSpaces": {acceptEncoding: " ZSTD ;q=0.8 , gzip;q=0.2", want: "zstd"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			if got := responseEncoding(tt.acceptEncoding); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressedBatchPost(t *testing.T) {
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte("a\nb\nc\n"))
+	gw.Close()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := zw.EncodeAll([]byte("a\nb\nc\n"), nil)
+
+	mockStore := StoreTestingStub{
+		insertBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return int64(len(items)), nil
+		},
+	}
+	tests := map[string]struct {
+		contentEncoding string
+		body            []byte
+		code            int
+		expected        string
+	}{
+		"gzip":    {contentEncoding: "gzip", body: gzipped.Bytes(), code: http.StatusCreated, expected: "ADDED 3\n"},
+		"zstd":    {contentEncoding: "zstd", body: zstded, code: http.StatusCreated, expected: "ADDED 3\n"},
+		"unknown": {contentEncoding: "br", body: []byte("a"), code: http.StatusUnsupportedMediaType, expected: "Error decompressing body: Content-Encoding \"br\" is not one of gzip or zstd\n"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads", bytes.NewBuffer(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Content-Encoding", tt.contentEncoding)
+			rr := httptest.NewRecorder()
+			h := &Handler{Store: mockStore}
+			h.ServeHTTP(rr, req)
+			if status := rr.Code; status != tt.code {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.code)
+			}
+			if rr.Body.String() != tt.expected {
+				t.Errorf("handler returned unexpected body: got %q want %q", rr.Body.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompressedResponse(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 2, true, nil
+		},
+	}
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/kernel.tar.gz", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept-Encoding", encoding)
+			rr := httptest.NewRecorder()
+			h := &Handler{Store: mockStore}
+			h.ServeHTTP(rr, req)
+			if got := rr.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("got Content-Encoding %q want %q", got, encoding)
+			}
+			var body []byte
+			if encoding == "gzip" {
+				gr, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err = ioutil.ReadAll(gr)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				zr, err := zstd.NewReader(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err = zr.DecodeAll(rr.Body.Bytes(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(body) != "2\n" {
+				t.Errorf("got body %q want %q", body, "2\n")
+			}
+		})
+	}
+}
+
+func TestMinCompressBytes(t *testing.T) {
+	var entries []pgstore.ListEntry
+	for i := 0; i < 200; i++ {
+		entries = append(entries, pgstore.ListEntry{Item: fmt.Sprintf("kernel-%03d.tar.gz", i)})
+	}
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 2, true, nil
+		},
+		getBatch: func(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error) {
+			return entries, nil
+		},
+	}
+	tests := map[string]struct {
+		url        string
+		compressed bool
+	}{
+		"Small": {url: "/iidy/v1/lists/downloads/kernel.tar.gz", compressed: false},
+		"Large": {url: "/iidy/v1/batch/lists/downloads?count=200", compressed: true},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+			h := &Handler{Store: mockStore, MinCompressBytes: 1024}
+			h.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got Vary %q want %q", got, "Accept-Encoding")
+			}
+			body := rr.Body.Bytes()
+			if !tt.compressed {
+				if got := rr.Header().Get("Content-Encoding"); got != "" {
+					t.Fatalf("got Content-Encoding %q want none", got)
+				}
+				if string(body) != "2\n" {
+					t.Errorf("got body %q want %q", body, "2\n")
+				}
+				return
+			}
+			if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+				t.Fatalf("got Content-Encoding %q want %q", got, "gzip")
+			}
+			gr, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err = ioutil.ReadAll(gr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(body), "kernel-000.tar.gz 0\n") || !strings.HasSuffix(string(body), "kernel-199.tar.gz 0\n") {
+				t.Errorf("got unexpected body %q", body)
+			}
+		})
+	}
+}
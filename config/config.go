@@ -0,0 +1,95 @@
+/*
+Package config loads iidy's settings from a file, with the environment
+overriding it, so that a deployment's settings can live in one file that
+is reviewed like any other change.
+
+A config file has one setting per line, named as its environment
+variable is, with blank lines and lines starting with # ignored:
+
+    # Production settings for iidy.
+    IIDY_PG_CONN_URL=postgresql://iidy@db:5432/iidy?pool_max_conns=20
+    IIDY_PORT=8080
+    IIDY_READ_TIMEOUT=10s
+
+Values are taken as they are, up to the end of the line, with no quoting
+or expansion. Settings are read back with Get and Lookup:
+
+    c, err := config.Load("/etc/iidy/iidy.conf")
+    ...
+    url := c.Get("IIDY_PG_CONN_URL")
+*/
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefix begins the name of every setting.
+const Prefix string = "IIDY_"
+
+// Config holds settings by name. A setting in the environment overrides
+// the same setting in the file that the Config was loaded from.
+type Config struct {
+	file   map[string]string
+	lookup func(name string) (string, bool)
+}
+
+// Load loads the config file at path. If path is "", there is no file,
+// and every setting comes from the environment.
+func Load(path string) (*Config, error) {
+	c := &Config{file: map[string]string{}, lookup: os.LookupEnv}
+	if path == "" {
+		return c, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := c.parse(f.Name(), bufio.NewScanner(f)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parse reads the settings of the file called name from scanner.
+func (c *Config) parse(name string, scanner *bufio.Scanner) error {
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: %q is not NAME=value", name, n, line)
+		}
+		setting := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(setting, Prefix) {
+			return fmt.Errorf("%s:%d: %q does not begin with %s", name, n, setting, Prefix)
+		}
+		if _, ok := c.file[setting]; ok {
+			return fmt.Errorf("%s:%d: %s is set more than once", name, n, setting)
+		}
+		c.file[setting] = strings.TrimSpace(parts[1])
+	}
+	return scanner.Err()
+}
+
+// Lookup gives the setting called name, from the environment if it is
+// set there, or else from the file, and whether it was set at all.
+func (c *Config) Lookup(name string) (string, bool) {
+	if v, ok := c.lookup(name); ok {
+		return v, true
+	}
+	v, ok := c.file[name]
+	return v, ok
+}
+
+// Get gives the setting called name, or "" if it is not set.
+func (c *Config) Get(name string) string {
+	v, _ := c.Lookup(name)
+	return v
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	env := map[string]string{"IIDY_PORT": "9090", "IIDY_STRICT": ""}
+	c := &Config{file: map[string]string{}, lookup: func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}}
+	file := `
+# Settings for iidy.
+IIDY_PG_CONN_URL = postgresql://iidy@db/iidy?pool_max_conns=20
+IIDY_PORT=8080
+IIDY_STRICT=true
+`
+	if err := c.parse("iidy.conf", bufio.NewScanner(strings.NewReader(file))); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"IIDY_PG_CONN_URL", "postgresql://iidy@db/iidy?pool_max_conns=20", true},
+		// The environment wins, even when it sets a setting to "".
+		{"IIDY_PORT", "9090", true},
+		{"IIDY_STRICT", "", true},
+		{"IIDY_MIGRATE", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := c.Lookup(tt.name); got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%q) = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		file string
+		want string
+	}{
+		"NoEquals":  {"IIDY_PORT 8080\n", `iidy.conf:1: "IIDY_PORT 8080" is not NAME=value`},
+		"NoPrefix":  {"# port\nPORT=8080\n", `iidy.conf:2: "PORT" does not begin with IIDY_`},
+		"Duplicate": {"IIDY_PORT=8080\nIIDY_PORT=9090\n", `iidy.conf:2: IIDY_PORT is set more than once`},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			c := &Config{file: map[string]string{}}
+			err := c.parse("iidy.conf", bufio.NewScanner(strings.NewReader(tt.file)))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package iidy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// csvEntryColumns are the columns of a CSV of list entries, in the order
+// that batch gets write them. A CSV request body must have an item column,
+// and can have an attempts column, in any order.
+var csvEntryColumns = []string{"item", "attempts"}
+
+// csvActionColumns are the columns of a CSV of actions to take on items,
+// both of which a CSV request body must have, in any order.
+var csvActionColumns = []string{"item", "action"}
+
+// csvHeader reads the header line of a CSV body, and gives the index of
+// each of the columns that it names that are also in known. It is an error
+// for it to leave out any of required. Any other column is ignored, unless
+// strict, in which case it is an error too. An empty body gives io.EOF.
+func csvHeader(cr *csv.Reader, strict bool, known []string, required []string) (map[string]int, error) {
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("line 1: column %q is given more than once", name)
+		}
+		if !contains(known, name) {
+			if strict {
+				return nil, fmt.Errorf("line 1: column %q is not one of %q", name, known)
+			}
+			continue
+		}
+		columns[name] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("line 1: column %q is missing", name)
+		}
+	}
+	return columns, nil
+}
+
+// getEntriesFromCSV gets a slice of list entries from body, which is CSV
+// with a header line naming its columns: an item column, and, optionally,
+// an attempts column, which, if empty, means 0. The body is parsed as it
+// is read, so it never has to be held in memory all at once. If strict,
+// a column that a ListEntry does not have is an error.
+func getEntriesFromCSV(body io.Reader, strict bool) ([]pgstore.ListEntry, error) {
+	cr := csv.NewReader(body)
+	columns, err := csvHeader(cr, strict, csvEntryColumns, csvEntryColumns[:1])
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	attemptsColumn, hasAttempts := columns["attempts"]
+	var entries []pgstore.ListEntry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			// A *csv.ParseError says which line it is on.
+			return nil, err
+		}
+		e := pgstore.ListEntry{Item: record[columns["item"]]}
+		if hasAttempts && record[attemptsColumn] != "" {
+			e.Attempts, err = strconv.Atoi(record[attemptsColumn])
+			if err != nil {
+				line, _ := cr.FieldPos(attemptsColumn)
+				return nil, fmt.Errorf("line %d: attempts %q is not a number", line, record[attemptsColumn])
+			}
+		}
+		entries = append(entries, e)
+	}
+}
+
+// getActionsFromCSV gets ItemActions from body, which is CSV with a header
+// line naming its columns, which must include item and action. If strict,
+// any other column is an error.
+func getActionsFromCSV(body io.Reader, strict bool) ([]pgstore.ItemAction, error) {
+	cr := csv.NewReader(body)
+	columns, err := csvHeader(cr, strict, csvActionColumns, csvActionColumns)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var actions []pgstore.ItemAction
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return actions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, pgstore.ItemAction{Item: record[columns["item"]], Action: record[columns["action"]]})
+	}
+}
+
+// entryEncoder writes ListEntries to a response, as they come,
+// in one of streamedContentTypes.
+type entryEncoder interface {
+	Encode(e pgstore.ListEntry) error
+	// Flush writes anything still held back.
+	Flush() error
+}
+
+// newEntryEncoder gives an entryEncoder that writes to w in contentType.
+func newEntryEncoder(w io.Writer, contentType string) entryEncoder {
+	if contentType == "text/csv" {
+		return &csvEntryEncoder{w: csv.NewWriter(w)}
+	}
+	return &ndjsonEntryEncoder{enc: json.NewEncoder(w)}
+}
+
+// ndjsonEntryEncoder writes one ListEntry per line, as JSON.
+type ndjsonEntryEncoder struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonEntryEncoder) Encode(e pgstore.ListEntry) error {
+	return n.enc.Encode(e)
+}
+
+func (n *ndjsonEntryEncoder) Flush() error {
+	return nil
+}
+
+// csvEntryEncoder writes ListEntries as CSV, with a header line of
+// csvEntryColumns, which, so that nothing is written until there is
+// something to write, comes with the first entry, or, failing that, on
+// Flush.
+type csvEntryEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvEntryEncoder) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(csvEntryColumns)
+}
+
+func (c *csvEntryEncoder) Encode(e pgstore.ListEntry) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write([]string{e.Item, strconv.Itoa(e.Attempts)})
+}
+
+func (c *csvEntryEncoder) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
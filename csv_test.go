@@ -0,0 +1,57 @@
+package iidy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestCSVHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Streamer: s}
+	strict := &Handler{Store: s, Streamer: s, Strict: true}
+	s.InsertBatchEntries(context.Background(), "uploads", []pgstore.ListEntry{{Item: "x, y", Attempts: 1}, {Item: "z"}})
+	tests := []struct {
+		h           *Handler
+		method      string
+		path        string
+		body        string
+		code        int
+		expected    string
+		disposition string
+	}{
+		{h, http.MethodPost, "/iidy/v1/batch/lists/downloads", "attempts,item,notes\n2,a,first\n,b,\n", http.StatusCreated, "ADDED 2\n", ""},
+		{h, http.MethodGet, "/iidy/v1/batch/lists/downloads", "", http.StatusOK, "item,attempts\na,2\nb,0\n", `attachment; filename="downloads.csv"`},
+		{h, http.MethodGet, "/iidy/v1/batch/lists/uploads?count=1", "", http.StatusOK, "item,attempts\n\"x, y\",1\n", `attachment; filename="uploads.csv"`},
+		{h, http.MethodGet, "/iidy/v1/batch/lists/empty", "", http.StatusNoContent, "", ""},
+		{h, http.MethodGet, "/iidy/v2/lists/empty/items", "", http.StatusOK, "item,attempts\n", `attachment; filename="empty.csv"`},
+		{h, http.MethodDelete, "/iidy/v1/batch/lists/downloads", "item\na\n", http.StatusOK, "DELETED 1\n", ""},
+		{h, http.MethodPost, "/iidy/v1/batch/lists/downloads", "name\nc\n", http.StatusBadRequest, "Error trying to parse list of items from request body: line 1: column \"item\" is missing\n", ""},
+		{h, http.MethodPost, "/iidy/v1/batch/lists/downloads", "item,attempts\nc,many\n", http.StatusBadRequest, "Error trying to parse list of items from request body: line 2: attempts \"many\" is not a number\n", ""},
+		{strict, http.MethodPost, "/iidy/v1/batch/lists/downloads", "item,notes\nc,first\n", http.StatusBadRequest, "Error trying to parse list of items from request body: line 1: column \"notes\" is not one of [\"item\" \"attempts\"]\n", ""},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.body != "" {
+			req.Header.Set("Content-Type", "text/csv")
+		} else {
+			req.Header.Set("Accept", "text/csv")
+		}
+		tc.h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.path, rr.Body.String(), tc.expected)
+		}
+		if d := rr.Header().Get("Content-Disposition"); d != tc.disposition {
+			t.Errorf("%s %s: got Content-Disposition %q want %q", tc.method, tc.path, d, tc.disposition)
+		}
+	}
+}
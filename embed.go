@@ -0,0 +1,132 @@
+package iidy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/manniwood/iidy/pgstore"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultPrefix is the path that the API is served under, unless
+// a Handler's Prefix says otherwise.
+const DefaultPrefix string = "/iidy"
+
+// Options configure a Handler made by NewHandler. The zero value
+// serves the API under DefaultPrefix, with iidy's defaults.
+type Options struct {
+	// Prefix, if set, is the path that the API is served under,
+	// such as "/queue"; see Handler.Prefix.
+	Prefix string
+	// Validator checks list and item names. If nil,
+	// pgstore.DefaultValidator is used.
+	Validator *pgstore.Validator
+	// DefaultCount and MaxCount bound batch gets; see Handler.
+	DefaultCount int
+	MaxCount     int
+	// MaxBodyBytes and MaxBatchItems bound request bodies; see Handler.
+	MaxBodyBytes  int64
+	MaxBatchItems int
+	// MinCompressBytes is the smallest response that is compressed;
+	// see Handler.
+	MinCompressBytes int
+	// Metrics, Activity, and Versions, if not nil, are kept up to date,
+	// for the application to publish as it likes.
+	Metrics  *Metrics
+	Activity *Activity
+	Versions *VersionMetrics
+	// Tracer, if not nil, traces requests; see Handler.Tracer.
+	Tracer trace.Tracer
+	// V1Deprecation, if not nil, marks v1 as deprecated.
+	V1Deprecation *Deprecation
+	// Strict turns on strict mode; see Handler.Strict.
+	Strict bool
+	// OnMutation are registered with Handler.OnMutation.
+	OnMutation []func(MutationEvent)
+	// Middleware wrap the Handler, between Recover, which comes first,
+	// and NoSniff, which comes last.
+	Middleware []Middleware
+}
+
+// NewHandler makes a handler that serves the iidy API from store, for
+// applications that run iidy inside a service of their own, rather than
+// as cmd/iidy. It can be mounted on the application's mux at its prefix:
+//     mux.Handle("/queue/", iidy.NewHandler(store, &iidy.Options{Prefix: "/queue"}))
+// If store is also a pgstore.DBStatter, pgstore.Exporter, pgstore.Differ,
+// pgstore.Merger, pgstore.Snapshotter, pgstore.Chainer, pgstore.Configurer,
+// pgstore.Lister, pgstore.Transitioner, pgstore.AttemptsSetter,
+// pgstore.AttemptsCounter, pgstore.Streamer, or pgstore.StreamInserter (as
+// a *pgstore.PgStore is), the routes that need it are served too.
+// opts may be nil.
+func NewHandler(store pgstore.Store, opts *Options) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	h := &Handler{
+		Store:            store,
+		Metrics:          opts.Metrics,
+		Validator:        opts.Validator,
+		DefaultCount:     opts.DefaultCount,
+		MaxCount:         opts.MaxCount,
+		MaxBodyBytes:     opts.MaxBodyBytes,
+		MaxBatchItems:    opts.MaxBatchItems,
+		MinCompressBytes: opts.MinCompressBytes,
+		Activity:         opts.Activity,
+		V1Deprecation:    opts.V1Deprecation,
+		Versions:         opts.Versions,
+		Prefix:           opts.Prefix,
+		Strict:           opts.Strict,
+		Tracer:           opts.Tracer,
+	}
+	h.DB, _ = store.(pgstore.DBStatter)
+	h.Exporter, _ = store.(pgstore.Exporter)
+	h.Differ, _ = store.(pgstore.Differ)
+	h.Merger, _ = store.(pgstore.Merger)
+	h.Snapshots, _ = store.(pgstore.Snapshotter)
+	h.Chains, _ = store.(pgstore.Chainer)
+	h.Settings, _ = store.(pgstore.Configurer)
+	h.Lister, _ = store.(pgstore.Lister)
+	h.Transitioner, _ = store.(pgstore.Transitioner)
+	h.Attempts, _ = store.(pgstore.AttemptsSetter)
+	h.Counter, _ = store.(pgstore.AttemptsCounter)
+	h.Streamer, _ = store.(pgstore.Streamer)
+	h.Inserter, _ = store.(pgstore.StreamInserter)
+	for _, f := range opts.OnMutation {
+		h.OnMutation(f)
+	}
+	mws := append([]Middleware{Recover}, opts.Middleware...)
+	return Chain(h, append(mws, NoSniff)...)
+}
+
+// prefix gives the path that h serves the API under.
+func (h *Handler) prefix() string {
+	if h.Prefix == "" {
+		return DefaultPrefix
+	}
+	return strings.TrimSuffix(h.Prefix, "/")
+}
+
+// unprefix gives r with h's prefix in its path replaced by DefaultPrefix,
+// which is what the routes are matched against, or false if r's path is
+// not under h's prefix.
+func (h *Handler) unprefix(r *http.Request) (*http.Request, bool) {
+	prefix := h.prefix()
+	if prefix == DefaultPrefix {
+		return r, true
+	}
+	rest := strings.TrimPrefix(r.URL.Path, prefix)
+	if len(rest) == len(r.URL.Path) || (rest != "" && rest[0] != '/') {
+		return r, false
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = DefaultPrefix + rest
+	r2.URL.RawPath = ""
+	if r.URL.RawPath != "" {
+		r2.URL.RawPath = DefaultPrefix + strings.TrimPrefix(r.URL.RawPath, prefix)
+	}
+	return r2, true
+}
@@ -0,0 +1,67 @@
+package iidy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+func TestNewHandler(t *testing.T) {
+	var events []MutationEvent
+	mux := http.NewServeMux()
+	mux.Handle("/queue/", NewHandler(memstore.NewMemStore(), &Options{
+		Prefix:     "/queue",
+		OnMutation: []func(MutationEvent){func(e MutationEvent) { events = append(events, e) }},
+	}))
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/queue/v1/lists/downloads/a.txt")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("insert gave status %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Location"); got != "/queue/v1/lists/downloads/a.txt" {
+		t.Errorf("got Location %q", got)
+	}
+	if got := rr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("got X-Content-Type-Options %q", got)
+	}
+	if len(events) != 1 || events[0].Items[0] != "a.txt" {
+		t.Errorf("got events %+v", events)
+	}
+	if rr = do(http.MethodGet, "/queue/v2/lists/downloads/items/a.txt"); rr.Code != http.StatusOK {
+		t.Errorf("v2 get gave status %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr = do(http.MethodGet, "/queue/"); rr.Code != http.StatusOK {
+		t.Errorf("discovery gave status %d: %s", rr.Code, rr.Body.String())
+	}
+	// A memstore cannot describe its database.
+	if rr = do(http.MethodGet, "/queue/v1/admin/db"); rr.Code != http.StatusNotFound {
+		t.Errorf("db stats gave status %d", rr.Code)
+	}
+}
+
+func TestUnprefix(t *testing.T) {
+	h := &Handler{Prefix: "/queue/"}
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"/queue/v1/lists/a/b", "/iidy/v1/lists/a/b", true},
+		{"/queue", "/iidy", true},
+		{"/queuex/v1/lists/a/b", "", false},
+		{"/iidy/v1/lists/a/b", "", false},
+	}
+	for _, tt := range tests {
+		r, ok := h.unprefix(httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if ok != tt.ok || (ok && r.URL.Path != tt.want) {
+			t.Errorf("%s: got %s, %v want %s, %v", tt.path, r.URL.Path, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package iidy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/manniwood/iidy/pgstore"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// FinalAcceptKey is the key to find the content type of the response
+// in the request's context, after we put it there.
+const FinalAcceptKey string = "final Accept"
+
+// streamedContentTypes are the content types of request bodies that are
+// parsed as they stream in, rather than read in their entirety first, and
+// that batch gets can stream their responses in. Each goes with the
+// content type that our other responses, which are single messages, are
+// written in instead.
+var streamedContentTypes = map[string]string{
+	"application/x-ndjson": "application/json",
+	"text/csv":             "text/plain",
+}
+
+// isStreamed tells us if contentType is one of streamedContentTypes.
+func isStreamed(contentType string) bool {
+	_, ok := streamedContentTypes[contentType]
+	return ok
+}
+
+// acceptHeaderToContext puts the content type that the response should
+// be written in into the request's context. This is the first media type in
+// the Accept header that we handle (with JSON for NDJSON, and plain text for
+// CSV; see streamedContentTypes); failing that, it is the same content type
+// as the request (so that, as has always been the case, a client that
+// sends JSON gets JSON back). contentTypeHeaderToContext must already have
+// been called on r.
+func acceptHeaderToContext(r *http.Request) *http.Request {
+	accept := fmt.Sprintf("%s", r.Context().Value(FinalContentTypeKey))
+	if single, ok := streamedContentTypes[accept]; ok {
+		// Batch gets, which can stream, look for
+		// these themselves; see streamedAccept.
+		accept = single
+	}
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		if single, ok := streamedContentTypes[mediaType]; ok {
+			accept = single
+			break
+		}
+		if _, ok := HandledContentTypes[mediaType]; ok {
+			accept = mediaType
+			break
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), FinalAcceptKey, accept))
+}
+
+// streamedAccept gives the first media type in r's Accept header that we
+// handle, if it is one of streamedContentTypes, or else "". Only batch
+// gets, which can stream their responses, look for it.
+func streamedAccept(r *http.Request) string {
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		if isStreamed(mediaType) {
+			return mediaType
+		}
+		if _, ok := HandledContentTypes[mediaType]; ok {
+			return ""
+		}
+	}
+	return ""
+}
+
+// getEntriesFromNDJSON gets a slice of list entries from body, which is
+// newline-delimited JSON: one BatchItem (an item name or an object) per line.
+// The body is decoded as it is read, so it never has to be held in memory
+// all at once. If strict, a field that a BatchItem does not have is
+// an error.
+func getEntriesFromNDJSON(body io.Reader, strict bool) ([]pgstore.ListEntry, error) {
+	var entries []pgstore.ListEntry
+	dec := json.NewDecoder(body)
+	for {
+		var line json.RawMessage
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err == nil && strict {
+			err = checkStrictNDJSON(line, reflect.TypeOf(BatchItem{}))
+		}
+		var b BatchItem
+		if err == nil {
+			err = json.Unmarshal(line, &b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, pgstore.ListEntry(b))
+	}
+}
+
+// getActionsFromNDJSON reads ItemActions from a body of newline-delimited
+// JSON, one {"item": ..., "action": ...} object per line. If strict, a
+// field that an ItemAction does not have is an error.
+func getActionsFromNDJSON(body io.Reader, strict bool) ([]pgstore.ItemAction, error) {
+	var actions []pgstore.ItemAction
+	if body == nil {
+		return nil, nil
+	}
+	dec := json.NewDecoder(body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	for {
+		var a pgstore.ItemAction
+		err := dec.Decode(&a)
+		if err == io.EOF {
+			return actions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(actions)+1, err)
+		}
+		actions = append(actions, a)
+	}
+}
+
+// DecodeMsgpack satisfies the msgpack.CustomDecoder interface, accepting
+// either of the two forms of ActionListMessage.
+func (m *ActionListMessage) DecodeMsgpack(dec *msgpack.Decoder) error {
+	c, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+	if msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32 {
+		return dec.Decode(&m.Items)
+	}
+	// Decode into a type without this method, to avoid recursion.
+	type wrapped ActionListMessage
+	return dec.Decode((*wrapped)(m))
+}
+
+// requestContentType returns the content type of the request body.
+func requestContentType(r *http.Request) string {
+	return fmt.Sprintf("%s", r.Context().Value(FinalContentTypeKey))
+}
+
+// responseContentType returns the content type that the response
+// should be written in.
+func responseContentType(r *http.Request) string {
+	return fmt.Sprintf("%s", r.Context().Value(FinalAcceptKey))
+}
+
+// isStructured tells us if contentType is one of the content types
+// (JSON or MessagePack) that our messages are encoded to and decoded
+// from as a whole, as opposed to text/plain, which has its own
+// line-oriented format for each message.
+func isStructured(contentType string) bool {
+	return contentType == "application/json" || contentType == "application/msgpack"
+}
+
+// contentTypeHeader gives the value of the Content-Type header of a
+// response written in contentType.
+func contentTypeHeader(contentType string) string {
+	if contentType == "application/msgpack" {
+		return contentType
+	}
+	return contentType + "; charset=utf-8"
+}
+
+// encodeBody writes v to w in the given structured content type.
+// Our message types are only tagged for JSON, so MessagePack uses
+// the JSON tags, which keeps the field names the same for both.
+func encodeBody(w io.Writer, contentType string, v interface{}) error {
+	if contentType == "application/msgpack" {
+		enc := msgpack.NewEncoder(w)
+		enc.SetCustomStructTag("json")
+		return enc.Encode(v)
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// decodeBody decodes bodyBytes, which are in the given structured
+// content type, into v.
+func decodeBody(contentType string, bodyBytes []byte, v interface{}) error {
+	if contentType == "application/msgpack" {
+		dec := msgpack.NewDecoder(bytes.NewReader(bodyBytes))
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	}
+	return json.Unmarshal(bodyBytes, v)
+}
+
+// DecodeMsgpack satisfies the msgpack.CustomDecoder interface, accepting
+// either of the two forms of BatchItem.
+func (b *BatchItem) DecodeMsgpack(dec *msgpack.Decoder) error {
+	v, err := dec.DecodeInterfaceLoose()
+	if err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case string:
+		*b = BatchItem{Item: t}
+		return nil
+	case map[string]interface{}:
+		item, ok := t["item"].(string)
+		if !ok {
+			return fmt.Errorf("item is not a string: %v", t["item"])
+		}
+		*b = BatchItem{Item: item}
+		if t["attempts"] == nil {
+			return nil
+		}
+		// MessagePack encoders use the smallest integer type that
+		// fits, and nested values are not loosened to int64 for us.
+		attempts := reflect.ValueOf(t["attempts"])
+		switch attempts.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			b.Attempts = int(attempts.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			b.Attempts = int(attempts.Uint())
+		default:
+			return fmt.Errorf("attempts for item %q is not an integer: %v", item, t["attempts"])
+		}
+		return nil
+	default:
+		return fmt.Errorf("expected an item name or an object, got %v", v)
+	}
+}
@@ -0,0 +1,165 @@
+package iidy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/pgstore"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestAcceptHeaderToContext(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		accept      string
+		want        string
+	}{
+		"NoAccept":         {contentType: "application/json", accept: "", want: "application/json"},
+		"AnyAccept":        {contentType: "application/json", accept: "*/*", want: "application/json"},
+		"AcceptMsgpack":    {contentType: "text/plain", accept: "application/msgpack", want: "application/msgpack"},
+		"AcceptFirstKnown": {contentType: "", accept: "text/html, application/json;q=0.9, text/plain;q=0.8", want: "application/json"},
+		"AcceptUnknown":    {contentType: "application/msgpack", accept: "text/html", want: "application/msgpack"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Accept", tt.accept)
+			req = acceptHeaderToContext(contentTypeHeaderToContext(req))
+			if got := responseContentType(req); got != tt.want {
+				t.Errorf("got response content type %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackBatchPost(t *testing.T) {
+	mockStore := StoreTestingStub{
+		insertEntries: func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error) {
+			want := []pgstore.ListEntry{
+				{Item: "kernel.tar.gz", Attempts: 2},
+				{Item: "vim.tar.gz", Attempts: 0},
+			}
+			if !reflect.DeepEqual(entries, want) {
+				return 0, fmt.Errorf("got entries %v want %v", entries, want)
+			}
+			return 2, nil
+		},
+	}
+	body, err := msgpack.Marshal(map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"item": "kernel.tar.gz", "attempts": 2},
+			"vim.tar.gz",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/msgpack")
+	rr := httptest.NewRecorder()
+	h := &Handler{Store: mockStore}
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusCreated, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/msgpack" {
+		t.Errorf("handler returned wrong content type: got %v", got)
+	}
+	var msg AddedMessage
+	dec := msgpack.NewDecoder(rr.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&msg); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if msg.Added != 2 {
+		t.Errorf("unexpected response: %v", msg)
+	}
+}
+
+func TestMsgpackBatchGet(t *testing.T) {
+	want := []pgstore.ListEntry{
+		{Item: "a", Attempts: 0},
+		{Item: "b", Attempts: 3},
+	}
+	mockStore := StoreTestingStub{
+		getBatch: func(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error) {
+			return want, nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "/iidy/v1/batch/lists/downloads?count=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/msgpack")
+	rr := httptest.NewRecorder()
+	h := &Handler{Store: mockStore}
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var msg ListEntryMessage
+	dec := msgpack.NewDecoder(rr.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&msg); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if !reflect.DeepEqual(msg.ListEntries, want) {
+		t.Errorf("got %v want %v", msg.ListEntries, want)
+	}
+}
+
+func TestNDJSONBatchPost(t *testing.T) {
+	mockStore := StoreTestingStub{
+		insertEntries: func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error) {
+			want := []pgstore.ListEntry{
+				{Item: "kernel.tar.gz", Attempts: 2},
+				{Item: "vim.tar.gz", Attempts: 0},
+			}
+			if !reflect.DeepEqual(entries, want) {
+				return 0, fmt.Errorf("got entries %v want %v", entries, want)
+			}
+			return 2, nil
+		},
+	}
+	body := []byte(`{"item": "kernel.tar.gz", "attempts": 2}
+"vim.tar.gz"
+`)
+	req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	h := &Handler{Store: mockStore}
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusCreated, rr.Body.String())
+	}
+	expected := `{"added":2}
+`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+
+func TestGetEntriesFromNDJSONError(t *testing.T) {
+	body := strings.NewReader(`"a"
+{"item": 5}
+`)
+	if _, err := getEntriesFromNDJSON(body, false); err == nil {
+		t.Errorf("expected an error for a malformed second line")
+	}
+}
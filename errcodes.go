@@ -0,0 +1,100 @@
+package iidy
+
+import "net/http"
+
+// ErrorCode says what kind of error an ErrorMessage is, so that clients
+// can branch on it without parsing the message, which is meant for
+// people, and can change. Codes, once given out, do not change.
+type ErrorCode string
+
+// The codes that errors are given.
+const (
+	// CodeBadRequest is for a request that could not be understood,
+	// such as one with a body or query arg that could not be parsed.
+	CodeBadRequest ErrorCode = "bad_request"
+	// CodeUnknownQueryArg and CodeUnknownField are for a query arg, or a
+	// field of a request body, that the route does not take; only strict
+	// mode (see Handler.Strict) checks for these.
+	CodeUnknownQueryArg ErrorCode = "unknown_query_arg"
+	CodeUnknownField    ErrorCode = "unknown_field"
+	// CodeInvalidList, CodeInvalidItem, CodeInvalidAction,
+	// CodeInvalidPrefix, CodeInvalidTemplate, CodeInvalidConflict,
+	// CodeInvalidLabel, CodeInvalidStatus, CodeInvalidAttempts, and, for
+	// list settings, CodeInvalidMaxAttempts, CodeInvalidRetryBase,
+	// CodeInvalidRetryCap, CodeInvalidTTL, and CodeInvalidArchiveList are
+	// for a pgstore.ValidationError of that field.
+	CodeInvalidList        ErrorCode = "invalid_list"
+	CodeInvalidItem        ErrorCode = "invalid_item"
+	CodeInvalidAction      ErrorCode = "invalid_action"
+	CodeInvalidPrefix      ErrorCode = "invalid_prefix"
+	CodeInvalidTemplate    ErrorCode = "invalid_template"
+	CodeInvalidConflict    ErrorCode = "invalid_conflict"
+	CodeInvalidLabel       ErrorCode = "invalid_label"
+	CodeInvalidStatus      ErrorCode = "invalid_status"
+	CodeInvalidAttempts    ErrorCode = "invalid_attempts"
+	CodeInvalidMaxAttempts ErrorCode = "invalid_max_attempts"
+	CodeInvalidRetryBase   ErrorCode = "invalid_retry_base"
+	CodeInvalidRetryCap    ErrorCode = "invalid_retry_cap"
+	CodeInvalidTTL         ErrorCode = "invalid_ttl"
+	CodeInvalidArchiveList ErrorCode = "invalid_archive_list"
+	// CodeUnauthorized is for a request without a valid API key, when
+	// one is required (see RequireAPIKey).
+	CodeUnauthorized ErrorCode = "unauthorized"
+	// CodeForbidden is for a list, or a route, that the request's API
+	// key does not allow (see APIKey.Prefixes).
+	CodeForbidden ErrorCode = "forbidden"
+	// CodeNotFound is for a path that is not a route, or a route
+	// that the server does not serve.
+	CodeNotFound ErrorCode = "not_found"
+	// CodeItemNotFound is for an item that is not in its list.
+	CodeItemNotFound ErrorCode = "item_not_found"
+	// CodeSnapshotNotFound is for a snapshot label that a list does
+	// not have (see pgstore.ErrSnapshotNotFound).
+	CodeSnapshotNotFound ErrorCode = "snapshot_not_found"
+	// CodeSnapshotExists is for taking a snapshot under a label that
+	// the list already has (see pgstore.ErrSnapshotExists).
+	CodeSnapshotExists ErrorCode = "snapshot_exists"
+	// CodeInvalidTransition is for an action that an item's status
+	// does not allow (see pgstore.ErrInvalidTransition).
+	CodeInvalidTransition ErrorCode = "invalid_transition"
+	// CodeMethodNotAllowed is for a method that a path does not take.
+	CodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	// CodeUnsupportedMediaType is for a body that could not be
+	// decompressed, or, in strict mode, a Content-Type that is not
+	// handled.
+	CodeUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	// CodeTooLarge is for a request body, or a batch of items, that is
+	// bigger than the Handler allows.
+	CodeTooLarge ErrorCode = "too_large"
+	// CodePreconditionFailed is for an If-Match that did not match.
+	CodePreconditionFailed ErrorCode = "precondition_failed"
+	// CodeDuplicateItem is for inserting an item that is already in
+	// its list (see pgstore.ErrDuplicate).
+	CodeDuplicateItem ErrorCode = "duplicate_item"
+	// CodeReadOnly is for a change while the data store is read-only.
+	CodeReadOnly ErrorCode = "read_only"
+	// CodePoolTimeout is for a request that waited too long for a
+	// database connection, and is worth trying again soon.
+	CodePoolTimeout ErrorCode = "pool_timeout"
+	// CodeUnavailable is for a request made while the data store is down.
+	CodeUnavailable ErrorCode = "unavailable"
+	// CodeOverloaded is for a request turned away by load shedding.
+	CodeOverloaded ErrorCode = "overloaded"
+	// CodeInternal is for anything else that went wrong on the server.
+	CodeInternal ErrorCode = "internal"
+)
+
+// statusCodes are the ErrorCodes of errors that do not have one of
+// their own, by status.
+var statusCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
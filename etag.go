@@ -0,0 +1,62 @@
+package iidy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// entryETag gives the entity tag of a list entry. The number of attempts
+// is the only thing about a list entry that can change, so it serves as the
+// entry's version. (Deleting an entry and adding it again gives it back
+// its old entity tag, but then it really is the same as it was before.)
+func entryETag(attempts int) string {
+	return `"` + strconv.Itoa(attempts) + `"`
+}
+
+// etagMatches tells us if etag is one of the entity tags in header,
+// which holds the value of an If-None-Match or If-Match header. "*" matches
+// any etag. When weak is true, weak entity tags (W/"...") are compared as
+// though they were strong, as RFC 7232 prescribes for If-None-Match;
+// otherwise weak entity tags never match, as prescribed for If-Match.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchAttempts gets the numbers of attempts named by the entity tags in
+// header, which holds the value of an If-Match header. The second return
+// value is true if header is "*", meaning any number of attempts will do.
+// Weak entity tags, and entity tags that we could not have handed out,
+// never match, so they are left out.
+func ifMatchAttempts(header string) ([]int, bool) {
+	var attempts []int
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil, true
+		}
+		if len(candidate) < 2 || candidate[0] != '"' || candidate[len(candidate)-1] != '"' {
+			continue
+		}
+		n, err := strconv.Atoi(candidate[1 : len(candidate)-1])
+		if err != nil {
+			continue
+		}
+		attempts = append(attempts, n)
+	}
+	return attempts, false
+}
@@ -0,0 +1,171 @@
+package iidy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := map[string]struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		"Exact":           {header: `"3"`, weak: false, want: true},
+		"Other":           {header: `"4"`, weak: false, want: false},
+		"InList":          {header: `"1", "3"`, weak: false, want: true},
+		"Star":            {header: `*`, weak: false, want: true},
+		"WeakComparison":  {header: `W/"3"`, weak: true, want: true},
+		"StrongNoWeakTag": {header: `W/"3"`, weak: false, want: false},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			if got := etagMatches(tt.header, entryETag(3), tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%v) got %v want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOneIfNoneMatch(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 3, true, nil
+		},
+	}
+	tests := map[string]struct {
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		"NoHeader": {ifNoneMatch: "", wantStatus: http.StatusOK, wantBody: "3\n"},
+		"Current":  {ifNoneMatch: `"3"`, wantStatus: http.StatusNotModified, wantBody: ""},
+		"Stale":    {ifNoneMatch: `"2"`, wantStatus: http.StatusOK, wantBody: "3\n"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/kernel.tar.gz", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			h := &Handler{Store: mockStore}
+			h.ServeHTTP(rr, req)
+			if gotStatus := rr.Code; gotStatus != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", gotStatus, tt.wantStatus)
+			}
+			if gotBody := rr.Body.String(); gotBody != tt.wantBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", gotBody, tt.wantBody)
+			}
+			if gotETag := rr.Header().Get("ETag"); gotETag != `"3"` {
+				t.Errorf("handler returned wrong ETag: got %v", gotETag)
+			}
+		})
+	}
+}
+
+func TestIfMatchAttempts(t *testing.T) {
+	attempts, matchAny := ifMatchAttempts(`"3", W/"4", "bogus", "5"`)
+	if matchAny {
+		t.Error("list of ETags should not match any attempts")
+	}
+	if want := []int{3, 5}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("got attempts %v want %v", attempts, want)
+	}
+	if _, matchAny := ifMatchAttempts("*"); !matchAny {
+		t.Error("* should match any attempts")
+	}
+}
+
+func TestIfMatchMutations(t *testing.T) {
+	// The item in the mock store always has 3 attempts.
+	matches := func(attempts []int) int64 {
+		for _, a := range attempts {
+			if a == 3 {
+				return 1
+			}
+		}
+		return 0
+	}
+	mockStore := StoreTestingStub{
+		deleteOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 1, nil
+		},
+		deleteOneIf: func(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+			return matches(attempts), nil
+		},
+		incrementOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 1, nil
+		},
+		incrementOneIf: func(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+			return matches(attempts), nil
+		},
+	}
+	tests := map[string]struct {
+		httpMethod string
+		endpoint   string
+		ifMatch    string
+		wantStatus int
+		wantBody   string
+	}{
+		"DeleteMatch": {
+			httpMethod: http.MethodDelete,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz",
+			ifMatch:    `"3"`,
+			wantStatus: http.StatusOK,
+			wantBody:   "DELETED 1\n",
+		},
+		"DeleteChanged": {
+			httpMethod: http.MethodDelete,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz",
+			ifMatch:    `"2"`,
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   "Precondition failed: list item is missing or has changed.\n",
+		},
+		"DeleteAny": {
+			httpMethod: http.MethodDelete,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz",
+			ifMatch:    `*`,
+			wantStatus: http.StatusOK,
+			wantBody:   "DELETED 1\n",
+		},
+		"IncrementMatch": {
+			httpMethod: http.MethodPost,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz?action=increment",
+			ifMatch:    `"1", "3"`,
+			wantStatus: http.StatusOK,
+			wantBody:   "INCREMENTED 1\n",
+		},
+		"IncrementChanged": {
+			httpMethod: http.MethodPost,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz?action=increment",
+			ifMatch:    `"2"`,
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   "Precondition failed: list item is missing or has changed.\n",
+		},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req, err := http.NewRequest(tt.httpMethod, tt.endpoint, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("If-Match", tt.ifMatch)
+			rr := httptest.NewRecorder()
+			h := &Handler{Store: mockStore}
+			h.ServeHTTP(rr, req)
+			if gotStatus := rr.Code; gotStatus != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", gotStatus, tt.wantStatus)
+			}
+			if gotBody := rr.Body.String(); gotBody != tt.wantBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", gotBody, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package iidy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MaxFixtureBodyBytes is the most of each request and response body
+// that a Recorder keeps.
+const MaxFixtureBodyBytes int = 1 << 20
+
+// FixtureRequestHeaders are the request headers that are recorded
+// in fixtures, and sent again on replay.
+var FixtureRequestHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Content-Encoding",
+	"Content-Type",
+	"If-Match",
+	"If-None-Match",
+}
+
+// FixtureResponseHeaders are the response headers that are recorded in
+// fixtures, and checked on replay. Headers that change from one request
+// to the next, such as X-Request-ID and X-IIDY-Batch-ID, are left out.
+var FixtureResponseHeaders = []string{
+	"Content-Encoding",
+	"Content-Type",
+	"ETag",
+	"Link",
+	"Location",
+	"X-IIDY-Exhausted",
+	"X-IIDY-Last-Item",
+	"X-IIDY-Remaining",
+}
+
+// Fixture is a recorded request, and the response that iidy gave to it.
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is the recorded part of a request. A body that is
+// valid UTF-8 is kept in Body; any other body (MessagePack, or gzip)
+// is kept in BodyBase64.
+type FixtureRequest struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Header     map[string]string `json:"header,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+}
+
+// FixtureResponse is the recorded part of a response. Its body
+// is kept the same way as a FixtureRequest's.
+type FixtureResponse struct {
+	Status     int               `json:"status"`
+	Header     map[string]string `json:"header,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+}
+
+// Recorder is middleware that writes a Fixture, as a line of JSON,
+// for every request to the handler it wraps. The fixtures can later be
+// replayed against another build of iidy with ReplayFixtures (see
+// cmd/iidy-replay), to catch accidental changes to the wire format.
+type Recorder struct {
+	Next http.Handler
+	// Out is where fixtures are written.
+	Out io.Writer
+
+	mu sync.Mutex
+}
+
+// NewRecorder constructs a new Recorder that records
+// requests to next in out.
+func NewRecorder(next http.Handler, out io.Writer) *Recorder {
+	return &Recorder{Next: next, Out: out}
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestBody := &limitedBuffer{limit: MaxFixtureBodyBytes}
+	if r.Body != nil {
+		r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, requestBody), Closer: r.Body}
+	}
+	lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK, body: &limitedBuffer{limit: MaxFixtureBodyBytes}}
+
+	rec.Next.ServeHTTP(lw, r)
+
+	f := Fixture{
+		Request: FixtureRequest{
+			Method: r.Method,
+			URL:    r.URL.RequestURI(),
+			Header: pickHeaders(r.Header, FixtureRequestHeaders),
+		},
+		Response: FixtureResponse{
+			Status: lw.status,
+			Header: pickHeaders(lw.Header(), FixtureResponseHeaders),
+		},
+	}
+	// When the handler sets no Content-Type, net/http sniffs one from the
+	// body, so that is what the client sees, and what is recorded.
+	if _, ok := f.Response.Header["Content-Type"]; !ok && lw.body.Len() > 0 && lw.Header().Get("Content-Encoding") == "" {
+		if f.Response.Header == nil {
+			f.Response.Header = make(map[string]string)
+		}
+		f.Response.Header["Content-Type"] = http.DetectContentType(lw.body.Bytes())
+	}
+	f.Request.Body, f.Request.BodyBase64 = fixtureBody(requestBody.Bytes())
+	f.Response.Body, f.Response.BodyBase64 = fixtureBody(lw.body.Bytes())
+	line, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.Out.Write(append(line, '\n'))
+}
+
+// pickHeaders gives the named headers that are set in h.
+func pickHeaders(h http.Header, names []string) map[string]string {
+	picked := make(map[string]string)
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			picked[name] = v
+		}
+	}
+	if len(picked) == 0 {
+		return nil
+	}
+	return picked
+}
+
+// fixtureBody gives b either as a string, if it is valid UTF-8,
+// or else as base64.
+func fixtureBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(b)
+}
+
+// bodyBytes gives back the bytes that fixtureBody was given.
+func bodyBytes(body string, bodyBase64 string) ([]byte, error) {
+	if bodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(bodyBase64)
+	}
+	return []byte(body), nil
+}
+
+// ReadFixtures reads fixtures, one JSON object per line,
+// as written by a Recorder.
+func ReadFixtures(r io.Reader) ([]Fixture, error) {
+	var fixtures []Fixture
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*MaxFixtureBodyBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var f Fixture
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return fixtures, nil
+}
+
+// Mismatch describes how a replayed response differs from its fixture.
+type Mismatch struct {
+	// Index is the fixture's index in the fixtures that were replayed.
+	Index   int
+	Request FixtureRequest
+	// Field is what differs: "status", "body", or the name of a header.
+	Field string
+	Want  string
+	Got   string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("fixture %d (%s %s): %s: want %q, got %q", m.Index, m.Request.Method, m.Request.URL, m.Field, m.Want, m.Got)
+}
+
+// ReplayFixtures sends the request of each fixture, in order, to the iidy
+// server at baseURL (such as "http://localhost:8080"), and compares each
+// response to the recorded one. The fixtures should leave the lists they
+// use as they found them, so that they can be replayed more than once
+// against the same server. c should not decompress responses
+// (see http.Transport's DisableCompression), or else compressed
+// responses will not match; if c is nil, such a client is used.
+func ReplayFixtures(c *http.Client, baseURL string, fixtures []Fixture) ([]Mismatch, error) {
+	if c == nil {
+		c = &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var mismatches []Mismatch
+	for i, f := range fixtures {
+		body, err := bodyBytes(f.Request.Body, f.Request.BodyBase64)
+		if err != nil {
+			return mismatches, fmt.Errorf("fixture %d: %v", i, err)
+		}
+		req, err := http.NewRequest(f.Request.Method, baseURL+f.Request.URL, bytes.NewReader(body))
+		if err != nil {
+			return mismatches, fmt.Errorf("fixture %d: %v", i, err)
+		}
+		for name, v := range f.Request.Header {
+			req.Header.Set(name, v)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return mismatches, fmt.Errorf("fixture %d: %v", i, err)
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return mismatches, fmt.Errorf("fixture %d: %v", i, err)
+		}
+
+		mismatch := func(field string, want string, got string) {
+			mismatches = append(mismatches, Mismatch{Index: i, Request: f.Request, Field: field, Want: want, Got: got})
+		}
+		if resp.StatusCode != f.Response.Status {
+			mismatch("status", fmt.Sprint(f.Response.Status), fmt.Sprint(resp.StatusCode))
+		}
+		got := pickHeaders(resp.Header, FixtureResponseHeaders)
+		for _, name := range FixtureResponseHeaders {
+			if got[name] != f.Response.Header[name] {
+				mismatch(name, f.Response.Header[name], got[name])
+			}
+		}
+		wantBody, err := bodyBytes(f.Response.Body, f.Response.BodyBase64)
+		if err != nil {
+			return mismatches, fmt.Errorf("fixture %d: %v", i, err)
+		}
+		if !bytes.Equal(respBody, wantBody) {
+			mismatch("body", string(wantBody), string(respBody))
+		}
+	}
+	return mismatches, nil
+}
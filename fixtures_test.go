@@ -0,0 +1,158 @@
+package iidy
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// contractRequest is a request that the contract fixtures are recorded from.
+type contractRequest struct {
+	method string
+	url    string
+	header map[string]string
+	body   string
+}
+
+// contractRequests exercise every endpoint, in every format. They end by
+// deleting everything they added, so that they can be replayed against
+// a server that has seen them before. (They also begin by deleting it,
+// in case a replay was interrupted, but then, the first response will
+// not match.)
+var contractRequests = []contractRequest{
+	{method: http.MethodDelete, url: "/iidy/v1/batch/lists/contract", header: map[string]string{"Content-Type": "text/plain"}, body: "a.txt\nb.txt\nc.txt\nd.txt\ne.txt\nf.txt\n"},
+	{method: http.MethodPost, url: "/iidy/v1/lists/contract/a.txt"},
+	{method: http.MethodPost, url: "/iidy/v1/lists/contract/a.txt?on_conflict=ignore"},
+	{method: http.MethodGet, url: "/iidy/v1/lists/contract/a.txt"},
+	{method: http.MethodGet, url: "/iidy/v1/lists/contract/a.txt", header: map[string]string{"Accept": "application/json"}},
+	{method: http.MethodGet, url: "/iidy/v1/lists/contract/a.txt", header: map[string]string{"Accept": "application/msgpack"}},
+	{method: http.MethodGet, url: "/iidy/v1/lists/contract/a.txt", header: map[string]string{"If-None-Match": `"0"`}},
+	{method: http.MethodPost, url: "/iidy/v1/lists/contract/a.txt?action=increment", header: map[string]string{"Accept": "application/json"}},
+	{method: http.MethodPost, url: "/iidy/v1/lists/contract/a.txt?action=increment", header: map[string]string{"If-Match": `"0"`}},
+	{method: http.MethodGet, url: "/iidy/v1/lists/contract/nosuch.txt"},
+	{method: http.MethodGet, url: "/iidy/v1/lists/contract/nosuch.txt", header: map[string]string{"Accept": "application/json"}},
+	{method: http.MethodPost, url: "/iidy/v1/batch/lists/contract", header: map[string]string{"Content-Type": "text/plain"}, body: "b.txt\nc.txt\n"},
+	{method: http.MethodPost, url: "/iidy/v1/batch/lists/contract", header: map[string]string{"Content-Type": "application/json"}, body: `{"items":[{"item":"d.txt","attempts":2}]}`},
+	{method: http.MethodPost, url: "/iidy/v1/batch/lists/contract?on_conflict=ignore&detail=full", header: map[string]string{"Content-Type": "application/json"}, body: `{"items":["d.txt","e.txt"]}`},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=2"},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=10&after_id=b.txt", header: map[string]string{"Accept": "application/json"}},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=2&from_id=b.txt"},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=2&remaining=exact"},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=10", header: map[string]string{"Accept": "application/msgpack"}},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=10", header: map[string]string{"Accept-Encoding": "gzip"}},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract"},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=10001"},
+	{method: http.MethodGet, url: "/iidy/v1/batch/lists/contract?count=10&after_id=e.txt"},
+	{method: http.MethodPost, url: "/iidy/v1/multiget/lists/contract", header: map[string]string{"Content-Type": "application/json", "Accept": "application/json"}, body: `{"items":["a.txt","nosuch.txt","d.txt"]}`},
+	{method: http.MethodPost, url: "/iidy/v1/batch/lists/contract?action=increment", header: map[string]string{"Content-Type": "text/plain"}, body: "b.txt\nc.txt\n"},
+	{method: http.MethodPost, url: "/iidy/v1/batch/lists/contract?action=increment&detail=full&items=b.txt,nosuch.txt", header: map[string]string{"Accept": "application/json"}},
+	{method: http.MethodDelete, url: "/iidy/v1/batch/lists/contract?detail=full", header: map[string]string{"Content-Type": "application/json", "Accept": "application/json"}, body: `{"items":["c.txt","nosuch.txt"]}`},
+	{method: http.MethodDelete, url: "/iidy/v1/lists/contract/e.txt"},
+	{method: http.MethodDelete, url: "/iidy/v1/lists/contract/a.txt", header: map[string]string{"If-Match": `"0"`}},
+	{method: http.MethodPost, url: "/iidy/v1/lists/contract/bad%01item", header: map[string]string{"Accept": "application/json"}},
+	{method: http.MethodPatch, url: "/iidy/v1/lists/contract/a.txt"},
+	{method: http.MethodPut, url: "/iidy/v1/lists/contract/a.txt"},
+	{method: http.MethodPost, url: "/iidy/v1/actions/lists/contract", header: map[string]string{"Content-Type": "application/json", "Accept": "application/json"}, body: `[{"item":"b.txt","action":"increment"},{"item":"f.txt","action":"insert"}]`},
+	{method: http.MethodPost, url: "/iidy/v1/actions/lists/contract", header: map[string]string{"Content-Type": "text/plain"}, body: "delete f.txt\nincrement nosuch.txt\n"},
+	{method: http.MethodPost, url: "/iidy/v1/actions/lists/contract", header: map[string]string{"Content-Type": "application/json", "Accept": "application/json"}, body: `[{"item":"b.txt","action":"frobnicate"}]`},
+	{method: http.MethodDelete, url: "/iidy/v1/batch/lists/contract?items=a.txt,b.txt,d.txt"},
+}
+
+// recordContract sends the contract requests to a new server,
+// and gives the fixtures recorded from them.
+func recordContract(t *testing.T) []byte {
+	var out bytes.Buffer
+	ts := httptest.NewServer(NewRecorder(&Handler{Store: memstore.NewMemStore()}, &out))
+	defer ts.Close()
+	c := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	for _, cr := range contractRequests {
+		req, err := http.NewRequest(cr.method, ts.URL+cr.url, strings.NewReader(cr.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, v := range cr.header {
+			req.Header.Set(name, v)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	return out.Bytes()
+}
+
+// TestContractFixtures catches accidental changes to the wire format.
+// When a change is on purpose, rewrite the fixtures with
+//
+//     go test -run TestContractFixtures -update
+//
+// and check the diff of testdata/contract.jsonl.
+func TestContractFixtures(t *testing.T) {
+	golden := filepath.Join("testdata", "contract.jsonl")
+	got := recordContract(t)
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		gotLines := strings.Split(string(got), "\n")
+		wantLines := strings.Split(string(want), "\n")
+		for i := 0; i < len(gotLines) && i < len(wantLines); i++ {
+			if gotLines[i] != wantLines[i] {
+				t.Fatalf("fixture %d changed (run with -update if this is on purpose):\ngot  %s\nwant %s", i, gotLines[i], wantLines[i])
+			}
+		}
+		t.Fatalf("got %d fixtures want %d (run with -update if this is on purpose)", len(gotLines)-1, len(wantLines)-1)
+	}
+}
+
+func TestReplayFixtures(t *testing.T) {
+	f, err := ioutil.ReadFile(filepath.Join("testdata", "contract.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures, err := ReadFixtures(bytes.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != len(contractRequests) {
+		t.Fatalf("read %d fixtures want %d", len(fixtures), len(contractRequests))
+	}
+	ts := httptest.NewServer(&Handler{Store: memstore.NewMemStore()})
+	defer ts.Close()
+	// The second time around, the lists are already there.
+	for i := 0; i < 2; i++ {
+		mismatches, err := ReplayFixtures(nil, ts.URL, fixtures)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range mismatches {
+			t.Errorf("replay %d: %v", i, m)
+		}
+	}
+
+	fixtures[1].Response.Body = "ADDED 2\n"
+	mismatches, err := ReplayFixtures(nil, ts.URL, fixtures[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Field != "body" || mismatches[0].Index != 1 {
+		t.Errorf("got mismatches %v want one for the body of fixture 1", mismatches)
+	}
+}
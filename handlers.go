@@ -1,16 +1,26 @@
 package iidy
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
+	"github.com/manniwood/iidy/clock"
 	"github.com/manniwood/iidy/pgstore"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // FinalContentTypeKey is the key to find the ContentType
@@ -25,35 +35,119 @@ const BodyBytesKey string = "bodyBytes"
 // in the request's context, after we put them there.
 const QueryKey string = "query"
 
+// MaxQueryItems is the most items that can be named in the items query
+// parameter of a batch increment or delete. Bigger batches belong
+// in the request body.
+const MaxQueryItems int = 100
+
+// DefaultPageSize is how many items a batch get returns when
+// the count query arg is left out, unless Handler.DefaultCount says otherwise.
+const DefaultPageSize int = 100
+
+// MaxPageSize is the most items that a batch get can ask for,
+// unless Handler.MaxCount says otherwise.
+const MaxPageSize int = 10000
+
+// DefaultTopCount is how many items a get of the most-attempted items
+// in a list returns when the n query arg is left out.
+const DefaultTopCount int = 10
+
 // HandledContentTypes are the content types handled
 // by this service.
 var HandledContentTypes = map[string]struct{}{
-	"text/plain":       struct{}{},
-	"application/json": struct{}{},
+	"text/plain":           struct{}{},
+	"application/json":     struct{}{},
+	"application/msgpack":  struct{}{},
+	"application/x-ndjson": struct{}{},
+	"text/csv":             struct{}{},
 }
 
 // ErrorMessage holds an error that can be sent to the client either as
-// plain text or JSON.
+// plain text, JSON, or MessagePack.
 type ErrorMessage struct {
 	Error string `json:"error"`
+	// Code tells clients what kind of error this is, without them
+	// having to parse Error. For instance, a list or item name that is
+	// not allowed gives a Code of CodeInvalidList or CodeInvalidItem.
+	// An error that has no code of its own gets the one for its status.
+	Code ErrorCode `json:"code,omitempty"`
 }
 
 // AddedMessage informs the user how many items were added to a list.
+// When duplicates are ignored and full detail is requested, it also
+// names the items that were skipped because they were already in the list.
+// When per-item detail is requested, Results gives the outcome for
+// each item instead.
 // The message can be formatted either as plain text or JSON.
 type AddedMessage struct {
-	Added int64 `json:"added"`
+	Added   int64        `json:"added"`
+	Skipped []string     `json:"skipped,omitempty"`
+	Results []ItemResult `json:"results,omitempty"`
 }
 
 // IncrementedMessage informs the user how many items were incremented in a list.
+// When full detail is requested, it also gives the new number of attempts
+// for each incremented item, and names the items that were not found in
+// the list. When per-item detail is requested, Results gives the outcome
+// for each item instead.
 // The message can be formatted either as plain text or JSON.
 type IncrementedMessage struct {
-	Incremented int64 `json:"incremented"`
+	Incremented int64               `json:"incremented"`
+	ListEntries []pgstore.ListEntry `json:"listentries,omitempty"`
+	NotFound    []string            `json:"not_found,omitempty"`
+	Results     []ItemResult        `json:"results,omitempty"`
 }
 
 // DeletedMessage informs the user how many items were deleted from a list.
+// When full detail is requested, it also names the items that were deleted
+// and the items that were not found in the list. When per-item detail
+// is requested, Results gives the outcome for each item instead.
 // The message can be formatted either as plain text or JSON.
 type DeletedMessage struct {
-	Deleted int64 `json:"deleted"`
+	Deleted  int64        `json:"deleted"`
+	Items    []string     `json:"items,omitempty"`
+	NotFound []string     `json:"not_found,omitempty"`
+	Results  []ItemResult `json:"results,omitempty"`
+}
+
+// The outcomes of an ItemResult.
+const (
+	// ResultOK is for an item that was added, incremented, or deleted.
+	ResultOK string = "ok"
+	// ResultDuplicate is for an item that was already in the list when
+	// adding, or that was given earlier in the same batch.
+	ResultDuplicate string = "duplicate"
+	// ResultNotFound is for an item that was not in the list when
+	// incrementing or deleting.
+	ResultNotFound string = "not_found"
+)
+
+// ItemResult is the outcome of a batch mutation for one of its items,
+// given, with the "detail=per_item" query arg, in the order the items
+// were requested.
+type ItemResult struct {
+	Item   string `json:"item"`
+	Result string `json:"result"`
+}
+
+// StatusMessage gives the status of an item (see pgstore.StatusPending).
+// The message can be formatted either as plain text or JSON.
+type StatusMessage struct {
+	Status string `json:"status"`
+}
+
+// CountMessage tells how many items are in a list, and, if asked for,
+// how many of them have each number of attempts.
+// The message can be formatted either as plain text or JSON.
+type CountMessage struct {
+	Items      int64                   `json:"items"`
+	ByAttempts []pgstore.AttemptsCount `json:"by_attempts,omitempty"`
+}
+
+// ListsMessage names the lists in the store, with how many items each has.
+// The message can be formatted either as plain text or JSON.
+type ListsMessage struct {
+	Lists []pgstore.ListCount `json:"lists"`
 }
 
 // ItemListMessage is a list of items that we serialize/deserialize
@@ -62,16 +156,204 @@ type ItemListMessage struct {
 	Items []string `json:"items"`
 }
 
+// BatchItem is one element of the "items" array of a JSON request body.
+// It may be given either as a bare item name,
+//
+//     "a.txt"
+//
+// or as an object that also carries the item's attempts,
+//
+//     {"item": "a.txt", "attempts": 2}
+//
+// so that a list that has already been partially worked on can be
+// loaded without losing its attempt counts.
+type BatchItem pgstore.ListEntry
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface, accepting
+// either of the two forms of BatchItem.
+func (b *BatchItem) UnmarshalJSON(data []byte) error {
+	var item string
+	if err := json.Unmarshal(data, &item); err == nil {
+		*b = BatchItem{Item: item}
+		return nil
+	}
+	var entry pgstore.ListEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	*b = BatchItem(entry)
+	return nil
+}
+
+// BatchItemListMessage is a list of BatchItems that we deserialize
+// from JSON or MessagePack when using application/json or application/msgpack
+type BatchItemListMessage struct {
+	Items []BatchItem `json:"items"`
+}
+
+// ActionListMessage is a list of ItemActions that we deserialize from
+// JSON or MessagePack. It may be given either wrapped in an object,
+//
+//     {"items": [{"item": "a.txt", "action": "delete"}]}
+//
+// like the other batch request bodies, or as a bare array.
+type ActionListMessage struct {
+	Items []pgstore.ItemAction `json:"items"`
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface, accepting
+// either of the two forms of ActionListMessage.
+func (m *ActionListMessage) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return json.Unmarshal(trimmed, &m.Items)
+	}
+	// Unmarshal into a type without this method, to avoid recursion.
+	type wrapped ActionListMessage
+	return json.Unmarshal(data, (*wrapped)(m))
+}
+
+// ActionsMessage informs the user how many items were added, incremented,
+// and deleted by a batch of actions.
+// The message can be formatted either as plain text or JSON.
+type ActionsMessage struct {
+	Added       int64 `json:"added"`
+	Incremented int64 `json:"incremented"`
+	Deleted     int64 `json:"deleted"`
+}
+
+// MergedMessage informs the user how many items a merge of one list into
+// another added, and how many it updated the attempts of.
+// The message can be formatted either as plain text or JSON.
+type MergedMessage struct {
+	Added   int64 `json:"added"`
+	Updated int64 `json:"updated"`
+}
+
+// ActivityMessage summarizes the activity in a list since a time:
+// how many items were added, incremented, deleted (that is, completed),
+// and moved to a new status.
+// The message can be formatted either as plain text or JSON.
+type ActivityMessage struct {
+	Since        time.Time `json:"since"`
+	Added        int64     `json:"added"`
+	Incremented  int64     `json:"incremented"`
+	Deleted      int64     `json:"deleted"`
+	Transitioned int64     `json:"transitioned"`
+}
+
 // ListEntryMessage is a list of entries and their attempts that we
-// serialize/deserialize to/from JSON when using application/json
+// serialize/deserialize to/from JSON or MessagePack when using
+// application/json or application/msgpack
+//
+// For a batch get in item order, NextAfterID is the after_id to get the
+// next page with, and is left out once there are no more pages, when
+// Exhausted is true instead. Other reads leave both out.
 type ListEntryMessage struct {
 	ListEntries []pgstore.ListEntry `json:"listentries"`
+	NextAfterID string              `json:"next_after_id,omitempty"`
+	Exhausted   bool                `json:"exhausted,omitempty"`
 }
 
 // Handler handles requests to "/lists/". It contains an instance of PgStore,
 // so that it has a place to store list data.
 type Handler struct {
 	Store pgstore.Store
+	// Metrics, if not nil, counts requests and rows per list.
+	Metrics *Metrics
+	// SLO, if not nil, counts successes and latencies per endpoint class.
+	SLO *SLOMetrics
+	// Latency, if not nil, keeps a latency histogram per route.
+	Latency *RouteLatency
+	// Tracer, if not nil, records a span per request, named for its
+	// route, whose context is handed to the store, so that queries
+	// (see pgstore.QueryTracer.Spans) can be traced as its children.
+	Tracer trace.Tracer
+	// DB, if not nil, describes the database for GET /iidy/v1/admin/db.
+	DB pgstore.DBStatter
+	// Exporter, if not nil, exports snapshots of lists for
+	// GET /iidy/v1/admin/export.
+	Exporter pgstore.Exporter
+	// Differ, if not nil, compares lists for
+	// GET /iidy/v1/diff/lists/<listname>?with=<listname>.
+	Differ pgstore.Differ
+	// Merger, if not nil, merges lists for
+	// POST /iidy/v1/lists/<listname>?action=merge&from=<listname>.
+	Merger pgstore.Merger
+	// Snapshots, if not nil, keeps snapshots of lists for
+	// /iidy/v1/snapshots/lists/<listname>.
+	Snapshots pgstore.Snapshotter
+	// Chains, if not nil, chains lists together for
+	// /iidy/v1/chains/lists/<listname>.
+	Chains pgstore.Chainer
+	// Settings, if not nil, keeps the settings of each list for
+	// /iidy/v1/settings/lists/<listname>.
+	Settings pgstore.Configurer
+	// Lister, if not nil, enumerates lists for GET /iidy/v1/lists.
+	Lister pgstore.Lister
+	// Counter, if not nil, breaks down the count of
+	// GET /iidy/v1/stats/lists/<listname>/count?by=attempts.
+	Counter pgstore.AttemptsCounter
+	// Transitioner, if not nil, moves items between statuses for
+	// POST /iidy/v1/lists/<listname>/<itemname>?action=complete.
+	Transitioner pgstore.Transitioner
+	// Attempts, if not nil, decrements and sets attempts for
+	// POST /iidy/v1/lists/<listname>/<itemname>?action=decrement
+	// (or action=set&value=<n>), and the batch equivalents.
+	Attempts pgstore.AttemptsSetter
+	// Streamer, if not nil, streams batch gets that accept
+	// application/x-ndjson or text/csv straight from the store; without
+	// one, they are gathered first, and limited to MaxCount, as other
+	// batch gets are.
+	Streamer pgstore.Streamer
+	// Inserter, if not nil, takes plain-text batch inserts a line at a
+	// time, as the body is read, rather than once the body has been read
+	// into memory in its entirety; see streamsInsert.
+	Inserter pgstore.StreamInserter
+	// Validator checks list and item names before they reach the store.
+	// If nil, pgstore.DefaultValidator is used.
+	Validator *pgstore.Validator
+	// DefaultCount is how many items a batch get returns when the count
+	// query arg is left out. If 0, DefaultPageSize is used.
+	DefaultCount int
+	// MaxCount is the most items a batch get can ask for.
+	// If 0, MaxPageSize is used.
+	MaxCount int
+	// MaxBodyBytes is the most bytes a request body can hold, after it
+	// is decompressed; a bigger one is turned away with a status of 413.
+	// If 0, DefaultMaxBodyBytes is used.
+	MaxBodyBytes int64
+	// MaxBatchItems is the most items that one request can name; more
+	// are turned away with a status of 413. If 0, DefaultMaxBatchItems
+	// is used.
+	MaxBatchItems int
+	// MinCompressBytes is how big a response body must be before it is
+	// compressed for a client that asks for it with Accept-Encoding;
+	// smaller ones gain little, and cost a header and a compressor. If 0,
+	// every response is compressed.
+	MinCompressBytes int
+	// Activity, if not nil, remembers recent activity per list
+	// for GET /iidy/v1/activity/lists/<listname>.
+	Activity *Activity
+	// Clock tells the handler the time, for query args given as a
+	// duration before now, such as updated_after=24h, so that tests can
+	// fix the time with a clock.Fake. If nil, clock.System is used.
+	Clock clock.Clock
+	// V1Deprecation, if not nil, marks every v1 response as deprecated,
+	// with Deprecation and Sunset headers, and says so at GET /iidy.
+	V1Deprecation *Deprecation
+	// Versions, if not nil, counts requests per API version and route.
+	Versions *VersionMetrics
+	// Strict, if true, rejects requests that would otherwise be quietly
+	// made sense of: ones with query args or body fields that their route
+	// does not take (with a status of 400), or with a Content-Type that is
+	// not handled (with a status of 415), rather than text/plain.
+	Strict bool
+	// Prefix, if set, is the path that the API is served under, in place
+	// of DefaultPrefix, such as "/queue" for /queue/v1/lists/<listname>,
+	// so that the Handler can share a mux with an application's own.
+	Prefix string
+
+	hooks mutationHooks
 }
 
 // contentTypeHeaderToContext puts the Content-Type header into
@@ -81,11 +363,19 @@ type Handler struct {
 // read this header?" conundrum.
 func contentTypeHeaderToContext(r *http.Request) *http.Request {
 	contentType := r.Header.Get("Content-Type")
+	if isStrict(r) {
+		// Strict mode turns away content types that are not handled,
+		// so it had better not turn away handled ones with parameters.
+		contentType = strictMediaType(contentType)
+	}
 	_, ok := HandledContentTypes[contentType]
 	if contentType == "" || !ok {
 		// If the client handed us a content type we do not understand,
-		// default to sending and receiving text/plain.
+		// default to sending and receiving text/plain (or, in v2, JSON).
 		contentType = "text/plain"
+		if apiVersion(r) >= 2 {
+			contentType = "application/json"
+		}
 	}
 	return r.WithContext(context.WithValue(r.Context(), FinalContentTypeKey, contentType))
 }
@@ -95,7 +385,15 @@ func contentTypeHeaderToContext(r *http.Request) *http.Request {
 // request. Reading the request can be a stateful matter, so reading
 // the request body and saving it for later circumvents the "have I already
 // read the request body?" conundrum.
-func requestBodyToContext(r *http.Request) (*http.Request, error) {
+//
+// The exception is a body of newline-delimited JSON or CSV, or one that
+// streamsInsert says can go straight to the store, which is left unread,
+// so that it can be parsed as it streams in rather than being buffered in
+// its entirety.
+func (h *Handler) requestBodyToContext(r *http.Request) (*http.Request, error) {
+	if isStreamed(requestContentType(r)) || (routeName(r) == "insert_batch" && h.streamsInsert(r)) {
+		return r, nil
+	}
 	// Fetch the body now, defensively. Things like r.FormValue
 	// can fetch the body, and then subsequent calls to get the body fail.
 	if r.Body != nil {
@@ -111,7 +409,7 @@ func requestBodyToContext(r *http.Request) (*http.Request, error) {
 // queryParamsToContext parses the query params and makes them available
 // in the request's context. Our API only supports query params in the URL,
 // not in the request body; the request body is for API payloads that are
-// either in text/plain or application/json. In other words, we never parse
+// in text/plain, application/json, or application/msgpack. In other words, we never parse
 // HTTP form vars from the request body.
 func queryParamsToContext(r *http.Request) *http.Request {
 	query := r.URL.Query()
@@ -123,19 +421,82 @@ func queryParamsToContext(r *http.Request) *http.Request {
 // specific handlers depending on the request method.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	r, ok := h.unprefix(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if h.Tracer != nil {
+		var end func()
+		w, r, end = h.startSpan(w, r)
+		defer end()
+	}
+
+	if h.SLO != nil || h.Latency != nil {
+		start := time.Now()
+		sw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			d := time.Since(start)
+			h.SLO.Observe(endpointClass(r), sw.status, d)
+			h.Latency.Observe(routeName(r), RequestID(r), d)
+		}()
+		w = sw
+	}
+
+	if encoding := responseEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+		cw := newCompressingResponseWriter(w, encoding, h.MinCompressBytes)
+		defer cw.Close()
+		w = cw
+	}
+
+	if h.Strict {
+		r = r.WithContext(context.WithValue(r.Context(), StrictKey, true))
+	}
 	r = contentTypeHeaderToContext(r)
+	r = acceptHeaderToContext(r)
 
-	r, err := requestBodyToContext(r)
+	rDecompressed, err := decompressRequestBody(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Error decompressing body: %v", err)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusUnsupportedMediaType)
+		return
+	}
+	if rDecompressed != r {
+		defer rDecompressed.Body.Close()
+	}
+	r = rDecompressed
+	if !h.limitBody(w, r) {
+		return
+	}
+
+	rWithBody, err := h.requestBodyToContext(r)
 	if err != nil {
 		errStr := fmt.Sprintf("Error reading body: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		printBodyError(w, r, errStr, err)
 		return
 	}
+	r = rWithBody
 
 	r = queryParamsToContext(r)
 
-	// Tell the client to take the "Content-Type header seriously.
-	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if h.Strict && !h.checkStrict(w, r) {
+		return
+	}
+
+	if isDiscovery(r) {
+		h.getVersions(w, r)
+		return
+	}
+
+	if apiVersion(r) >= 2 {
+		h.Versions.CountRequest("v2", routeName(r))
+		h.v2(w, r)
+		return
+	}
+
+	h.Versions.CountRequest("v1", routeName(r))
+	setDeprecationHeaders(w, h.V1Deprecation, h.prefix())
 
 	switch r.Method {
 	case http.MethodPost:
@@ -144,14 +505,35 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.get(w, r)
 	case http.MethodDelete:
 		h.delete(w, r)
+	case http.MethodPut:
+		h.put(w, r)
 	default:
 		printError(w, r, &ErrorMessage{Error: "Unknown method."}, http.StatusBadRequest)
 	}
 }
 
-// delete handles DELETEs to these two endpoints:
+// put handles PUTs to these endpoints:
+//     PUT /v1/lists/<listname>/<itemname>
+//     PUT /v1/settings/lists/<listname> [settings in body]
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	urlParts := strings.Split(r.URL.Path, "/")
+	if len(urlParts) == 6 && urlParts[3] == "settings" && urlParts[4] == "lists" {
+		h.setSettings(w, r, urlParts[5])
+		return
+	}
+	if len(urlParts) < 6 || urlParts[3] != "lists" {
+		errStr := fmt.Sprintf(`"%s" is not a valid %s url`, r.URL.Path, http.MethodPut)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	h.putOne(w, r, urlParts[4], urlParts[5])
+}
+
+// delete handles DELETEs to these endpoints:
 //     DELETE /v1/lists/<listname>/<itemname>
 //     DELETE /v1/batch/lists/<listname> [itemnames in body]
+//     DELETE /v1/chains/lists/<listname>
+//     DELETE /v1/snapshots/lists/<listname>/<label>
 func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
 	urlParts := strings.Split(r.URL.Path, "/")
 	if len(urlParts) < 6 {
@@ -170,16 +552,50 @@ func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
 		h.deleteBatch(w, r, list)
 		return
 	}
+	if len(urlParts) == 7 && urlParts[3] == "snapshots" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		label := urlParts[6]
+		h.deleteSnapshot(w, r, list, label)
+		return
+	}
+	if urlParts[3] == "chains" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.deleteChain(w, r, list)
+		return
+	}
 	errStr := fmt.Sprintf(`"%s" is not a valid %s url`, r.URL.Path, http.MethodDelete)
 	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
 	return
 }
 
-// get handles GETs to these two endpoints:
+// get handles GETs to these endpoints:
+//     GET /iidy/v1/lists
 //     GET /iidy/v1/lists/<listname>/<itemname>
 //     GET /iidy/v1/batch/lists/<listname>?count=ct&after_id=it
+//     GET /iidy/v1/activity/lists/<listname>?since=1h
+//     GET /iidy/v1/stats/lists/<listname>/top?n=50
+//     GET /iidy/v1/stats/lists/<listname>/count?by=attempts
+//     GET /iidy/v1/diff/lists/<listname>?with=<listname>
+//     GET /iidy/v1/diff/lists/<listname>?snapshot=<label>
+//     GET /iidy/v1/snapshots/lists/<listname>
+//     GET /iidy/v1/chains/lists/<listname>
+//     GET /iidy/v1/settings/lists/<listname>
+//     GET /iidy/v1/admin/db
+//     GET /iidy/v1/admin/export?list=<listname>
 func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
 	urlParts := strings.Split(r.URL.Path, "/")
+	if len(urlParts) == 5 && urlParts[3] == "admin" && urlParts[4] == "db" {
+		h.getDBStats(w, r)
+		return
+	}
+	if len(urlParts) == 4 && urlParts[3] == "lists" {
+		h.getLists(w, r)
+		return
+	}
+	if len(urlParts) == 5 && urlParts[3] == "admin" && urlParts[4] == "export" {
+		h.getExport(w, r)
+		return
+	}
 	if len(urlParts) < 6 {
 		errStr := fmt.Sprintf(`"%s" is not a valid %s url`, r.URL.Path, http.MethodGet)
 		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
@@ -196,202 +612,1491 @@ func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
 		h.getBatch(w, r, list)
 		return
 	}
+	if urlParts[3] == "activity" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.getActivity(w, r, list)
+		return
+	}
+	if urlParts[3] == "diff" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.getDiff(w, r, list)
+		return
+	}
+	if len(urlParts) == 6 && urlParts[3] == "snapshots" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.getSnapshots(w, r, list)
+		return
+	}
+	if len(urlParts) == 7 && urlParts[3] == "stats" && urlParts[4] == "lists" && urlParts[6] == "top" {
+		list := urlParts[5]
+		h.getTop(w, r, list)
+		return
+	}
+	if len(urlParts) == 7 && urlParts[3] == "stats" && urlParts[4] == "lists" && urlParts[6] == "count" {
+		list := urlParts[5]
+		h.getCount(w, r, list)
+		return
+	}
+	if urlParts[3] == "chains" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.getChain(w, r, list)
+		return
+	}
+	if len(urlParts) == 6 && urlParts[3] == "settings" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.getSettings(w, r, list)
+		return
+	}
 	errStr := fmt.Sprintf(`"%s" is not a valid %s url`, r.URL.Path, http.MethodPost)
 	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
 	return
 }
 
-// post handles POSTs to these three endpoints:
+// post handles POSTs to these endpoints:
 //     POST /iidy/v1/lists/<listname>/<itemname>
+//     POST /iidy/v1/lists/<listname>/<itemname>?action=complete|fail|reset
+//     POST /iidy/v1/lists/<listname>/<itemname>?action=decrement
+//     POST /iidy/v1/lists/<listname>/<itemname>?action=set&value=<n>
+//     POST /iidy/v1/lists/<listname>?action=merge&from=<listname>&conflict=keep_max
 //     POST /iidy/v1/batch/lists/<listname> [itemnames in body]
 //     POST /iidy/v1/batch/lists/<listname>?action=increment [itemnames in body]
+//     POST /iidy/v1/batch/lists/<listname>?action=decrement [itemnames in body]
+//     POST /iidy/v1/batch/lists/<listname>?action=set&value=<n> [itemnames in body]
+//     POST /iidy/v1/multiget/lists/<listname> [itemnames in body]
+//     POST /iidy/v1/actions/lists/<listname> [items and actions in body]
+//     POST /iidy/v1/chains/lists/<listname> [next list and template in body]
+//     POST /iidy/v1/snapshots/lists/<listname>/<label>
+//     POST /iidy/v1/snapshots/lists/<listname>/<label>?action=restore
 func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
 	urlParts := strings.Split(r.URL.Path, "/")
+	query := r.Context().Value(QueryKey).(url.Values)
+	if len(urlParts) == 5 && urlParts[3] == "lists" && query.Get("action") == "merge" {
+		list := urlParts[4]
+		h.mergeList(w, r, list)
+		return
+	}
 	if len(urlParts) < 6 {
 		errStr := fmt.Sprintf(`"%s" is not a valid %s url`, r.URL.Path, http.MethodPost)
 		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
 		return
 	}
 
-	query := r.Context().Value(QueryKey).(url.Values)
-
 	if urlParts[3] == "lists" {
 		list := urlParts[4]
 		item := urlParts[5]
-		if query.Get("action") == "increment" {
+		switch query.Get("action") {
+		case "increment":
 			h.incrementOne(w, r, list, item)
-		} else {
+		case "decrement":
+			h.decrementOne(w, r, list, item)
+		case "set":
+			h.setAttemptsOne(w, r, list, item)
+		case pgstore.ActionComplete, pgstore.ActionFail, pgstore.ActionReset:
+			h.transitionOne(w, r, list, item, query.Get("action"))
+		default:
 			h.insertOne(w, r, list, item)
 		}
 		return
 	}
 	if urlParts[3] == "batch" && urlParts[4] == "lists" {
 		list := urlParts[5]
-		if query.Get("action") == "increment" {
+		switch query.Get("action") {
+		case "increment":
 			h.incrementBatch(w, r, list)
-		} else {
+		case "decrement":
+			h.decrementBatch(w, r, list)
+		case "set":
+			h.setAttemptsBatch(w, r, list)
+		default:
 			h.insertBatch(w, r, list)
 		}
 		return
 	}
+	if urlParts[3] == "multiget" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.getMulti(w, r, list)
+		return
+	}
+	if urlParts[3] == "actions" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.applyBatch(w, r, list)
+		return
+	}
+	if urlParts[3] == "chains" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		h.setChain(w, r, list)
+		return
+	}
+	if len(urlParts) == 7 && urlParts[3] == "snapshots" && urlParts[4] == "lists" {
+		list := urlParts[5]
+		label := urlParts[6]
+		if query.Get("action") == "restore" {
+			h.restoreSnapshot(w, r, list, label)
+		} else {
+			h.takeSnapshot(w, r, list, label)
+		}
+		return
+	}
 	errStr := fmt.Sprintf(`"%s" is not a valid %s url`, r.URL.Path, http.MethodPost)
 	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
 	return
 }
 
 // insertOne adds an item to a list. If the list does not already exist,
-// the list will be created.
+// the list will be created. A status of 201 is given, with the item's URL
+// in the Location header.
+//
+// With the "on_conflict=ignore" query arg, inserting an item that is
+// already in the list is not an error: a status of 200 is given, and the
+// body reports that 0 items were added, so that clients can safely retry.
 func (h *Handler) insertOne(w http.ResponseWriter, r *http.Request, list string, item string) {
-	count, err := h.Store.InsertOne(r.Context(), list, item)
+	if !h.validate(w, r, list, item) {
+		return
+	}
+	h.Metrics.CountRequest(list, "insert_one")
+	query := r.Context().Value(QueryKey).(url.Values)
+	onConflict := query.Get("on_conflict")
+	if !validOnConflict(w, r, onConflict) {
+		return
+	}
+	var count int64
+	var err error
+	if onConflict == "ignore" {
+		count, _, err = h.Store.InsertBatchIgnoreDuplicates(r.Context(), list, []pgstore.ListEntry{{Item: item}})
+	} else {
+		count, err = h.Store.InsertOne(r.Context(), list, item)
+	}
 	if err != nil {
 		errStr := fmt.Sprintf("Error trying to add list item: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "insert_one", count)
+	h.Activity.Record(list, pgstore.ActionCounts{Added: count})
+	h.mutated(MutationInsert, list, []string{item}, count)
+	w.Header().Set("Location", h.itemPath(r, list, item))
+	printSuccess(w, r, &AddedMessage{Added: count}, addedStatus(count))
+}
+
+// validOnConflict checks the "on_conflict" query arg of an insert.
+// If it is not one of "", "error", or "ignore", a status of 400 is
+// given, and false is returned.
+func validOnConflict(w http.ResponseWriter, r *http.Request, onConflict string) bool {
+	if onConflict == "" || onConflict == "error" || onConflict == "ignore" {
+		return true
+	}
+	errStr := fmt.Sprintf(`For query arg on_conflict, "%s" is not one of "error" or "ignore"`, onConflict)
+	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+	return false
+}
+
+// putOne makes sure that an item is in a list, adding it, with 0
+// attempts, if it is not. An item that is already in the list is left as
+// it is, attempts and all, so that PUTting it again, as a client retrying
+// a PUT that timed out would, changes nothing. A status of 201 is given,
+// with the item's URL in the Location header, if the item was added, or
+// else a status of 200; either way, the body reports how many items
+// were added.
+func (h *Handler) putOne(w http.ResponseWriter, r *http.Request, list string, item string) {
+	if !h.validate(w, r, list, item) {
+		return
+	}
+	h.Metrics.CountRequest(list, "put_one")
+	count, _, err := h.Store.InsertBatchIgnoreDuplicates(r.Context(), list, []pgstore.ListEntry{{Item: item}})
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to add list item: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "put_one", count)
+	h.Activity.Record(list, pgstore.ActionCounts{Added: count})
+	h.mutated(MutationInsert, list, []string{item}, count)
+	if count > 0 {
+		w.Header().Set("Location", h.itemPath(r, list, item))
+	}
+	printSuccess(w, r, &AddedMessage{Added: count}, addedStatus(count))
+}
+
+// addedStatus gives the status of a response to an insert that added
+// count items: 201 if anything was created, or else 200.
+func addedStatus(count int64) int {
+	if count > 0 {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// itemPath gives the URL path of an item in a list, in the same
+// version of the API as r, for use in a Location header.
+func (h *Handler) itemPath(r *http.Request, list string, item string) string {
+	if apiVersion(r) >= 2 {
+		return h.prefix() + "/v2/lists/" + url.PathEscape(list) + "/items/" + url.PathEscape(item)
+	}
+	return h.prefix() + "/v1/lists/" + url.PathEscape(list) + "/" + url.PathEscape(item)
+}
+
+// listPath gives the URL path of a list's batch endpoint, in the same
+// version of the API as r, for use in a Location header.
+func (h *Handler) listPath(r *http.Request, list string) string {
+	if apiVersion(r) >= 2 {
+		return h.prefix() + "/v2/lists/" + url.PathEscape(list) + "/items"
+	}
+	return h.prefix() + "/v1/batch/lists/" + url.PathEscape(list)
+}
+
+// transitionOne does action (pgstore.ActionComplete, pgstore.ActionFail,
+// or pgstore.ActionReset) to the specified item, moving it to a new
+// status, which the response gives. If the item is not in the list, a
+// status of 404 is given; if its status does not allow the action, 409.
+func (h *Handler) transitionOne(w http.ResponseWriter, r *http.Request, list string, item string, action string) {
+	if h.Transitioner == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list, item) {
+		return
+	}
+	h.Metrics.CountRequest(list, "transition")
+	status, ok, err := h.Transitioner.Transition(r.Context(), list, item, action)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to %s list item: %v", action, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	if !ok {
+		printError(w, r, &ErrorMessage{Error: "Not found.", Code: CodeItemNotFound}, http.StatusNotFound)
 		return
 	}
-	printSuccess(w, r, &AddedMessage{Added: count}, http.StatusCreated)
+	h.Metrics.CountRows(list, "transition", 1)
+	h.transitioned(list, item, action, status)
+	h.Activity.Record(list, pgstore.ActionCounts{Transitioned: 1})
+	printSuccess(w, r, &StatusMessage{Status: status}, http.StatusOK)
 }
 
 // incrementOne increments an item in a list. The returned body text reports
 // the number of items found and incremented (1 or 0).
+//
+// When the request has an If-Match header, the item is only incremented
+// if its ETag (see getOne) still matches; otherwise, a status of 412 is given.
 func (h *Handler) incrementOne(w http.ResponseWriter, r *http.Request, list string, item string) {
-	count, err := h.Store.IncrementOne(r.Context(), list, item)
+	if !h.validate(w, r, list, item) {
+		return
+	}
+	h.Metrics.CountRequest(list, "increment_one")
+	var count int64
+	var err error
+	ifMatch := r.Header.Get("If-Match")
+	attempts, matchAny := ifMatchAttempts(ifMatch)
+	if ifMatch == "" || matchAny {
+		count, err = h.Store.IncrementOne(r.Context(), list, item)
+	} else {
+		count, err = h.Store.IncrementOneIfAttempts(r.Context(), list, item, attempts)
+	}
 	if err != nil {
 		errStr := fmt.Sprintf("Error trying to increment list item: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	if ifMatch != "" && count == 0 {
+		printError(w, r, &ErrorMessage{Error: "Precondition failed: list item is missing or has changed.", Code: CodePreconditionFailed}, http.StatusPreconditionFailed)
 		return
 	}
+	h.Metrics.CountRows(list, "increment_one", count)
+	h.Activity.Record(list, pgstore.ActionCounts{Incremented: count})
+	h.mutated(MutationIncrement, list, []string{item}, count)
 	printSuccess(w, r, &IncrementedMessage{Incremented: count}, http.StatusOK)
 }
 
 // deleteOne deletes an item from a list. The returned body text reports
 // the number of items found and deleted (1 or 0).
+//
+// When the request has an If-Match header, the item is only deleted
+// if its ETag (see getOne) still matches; otherwise, a status of 412 is given.
 func (h *Handler) deleteOne(w http.ResponseWriter, r *http.Request, list string, item string) {
-	count, err := h.Store.DeleteOne(r.Context(), list, item)
+	if !h.validate(w, r, list, item) {
+		return
+	}
+	h.Metrics.CountRequest(list, "delete_one")
+	var count int64
+	var err error
+	ifMatch := r.Header.Get("If-Match")
+	attempts, matchAny := ifMatchAttempts(ifMatch)
+	if ifMatch == "" || matchAny {
+		count, err = h.Store.DeleteOne(r.Context(), list, item)
+	} else {
+		count, err = h.Store.DeleteOneIfAttempts(r.Context(), list, item, attempts)
+	}
 	if err != nil {
 		errStr := fmt.Sprintf("Error trying to delete list item: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	if ifMatch != "" && count == 0 {
+		printError(w, r, &ErrorMessage{Error: "Precondition failed: list item is missing or has changed.", Code: CodePreconditionFailed}, http.StatusPreconditionFailed)
+		return
+	}
+	if count == 0 && apiVersion(r) >= 2 {
+		printError(w, r, &ErrorMessage{Error: "Not found.", Code: CodeItemNotFound}, http.StatusNotFound)
 		return
 	}
+	h.Metrics.CountRows(list, "delete_one", count)
+	h.Activity.Record(list, pgstore.ActionCounts{Deleted: count})
+	h.mutated(MutationDelete, list, []string{item}, count)
 	printSuccess(w, r, &DeletedMessage{Deleted: count}, http.StatusOK)
 }
 
 // getOne returns the number of attempts that were made to complete
 // an item in a list. When a list or list item is missing, no body will
 // be returned, and a status of 404 will be given.
+//
+// The response carries an ETag header. When the request's If-None-Match
+// header holds the entry's current ETag, a status of 304 is given
+// and no body is returned, which makes polling an item cheap.
 func (h *Handler) getOne(w http.ResponseWriter, r *http.Request, list string, item string) {
+	if !h.validate(w, r, list, item) {
+		return
+	}
+	h.Metrics.CountRequest(list, "get_one")
 	attempts, ok, err := h.Store.GetOne(r.Context(), list, item)
 	if err != nil {
 		errStr := fmt.Sprintf("Error trying to get list item: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+		printStoreError(w, r, errStr, err)
 		return
 	}
 	if !ok {
-		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		printError(w, r, &ErrorMessage{Error: "Not found.", Code: CodeItemNotFound}, http.StatusNotFound)
+		return
+	}
+	h.Metrics.CountRows(list, "get_one", 1)
+	etag := entryETag(attempts)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	printSuccess(w, r, &pgstore.ListEntry{Item: item, Attempts: attempts}, http.StatusOK)
 }
 
-// getItemsFromBody gets a slice of list items from the request body,
-// regardless if the request body is in JSON or plain text format.
-func getItemsFromBody(contentType string, bodyBytes []byte) ([]string, error) {
-	if bodyBytes == nil || len(bodyBytes) == 0 {
-		return nil, nil
+// getActivity summarizes what has happened to a list in the window given
+// by the optional "since" query arg, a duration (default 1h, and at most
+// MaxActivityWindow). Only this server's own requests are counted, and
+// only since it started. When the handler does not keep track of
+// activity, a status of 404 is given.
+func (h *Handler) getActivity(w http.ResponseWriter, r *http.Request, list string) {
+	if !h.validate(w, r, list) {
+		return
 	}
-	if contentType == "application/json" {
-		return getItemsFromJSON(bodyBytes)
+	if h.Activity == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
 	}
-	// default to text/plain
-	return getItemsFromPlainText(bodyBytes), nil
-}
-
-// getItemsFromJSON gets a slice of list item names from
-// the bytes of a request body that is in JSON format.
-func getItemsFromJSON(bodyBytes []byte) ([]string, error) {
-	if bodyBytes == nil || len(bodyBytes) == 0 {
-		return nil, nil
+	h.Metrics.CountRequest(list, "get_activity")
+	query := r.Context().Value(QueryKey).(url.Values)
+	since := time.Hour
+	if v := query.Get("since"); v != "" {
+		var err error
+		since, err = time.ParseDuration(v)
+		if err != nil || since <= 0 || since > MaxActivityWindow {
+			errStr := fmt.Sprintf("For query arg since, %q is not a duration greater than 0 and at most %v", v, MaxActivityWindow)
+			printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+			return
+		}
 	}
-	var msg *ItemListMessage
-	err := json.Unmarshal(bodyBytes, &msg)
-	if err != nil {
-		return nil, err
+	counts := h.Activity.Summarize(list, since)
+	msg := &ActivityMessage{
+		Since:        clock.OrSystem(h.Activity.Clock).Now().Add(-since).UTC(),
+		Added:        counts.Added,
+		Incremented:  counts.Incremented,
+		Deleted:      counts.Deleted,
+		Transitioned: counts.Transitioned,
 	}
-	return msg.Items, nil
+	printSuccess(w, r, msg, http.StatusOK)
 }
 
-// getItemsFromPlainText gets a slice of list item names from
-// the bytes of a request body that is in plain text format.
-func getItemsFromPlainText(bodyBytes []byte) []string {
-	if bodyBytes == nil || len(bodyBytes) == 0 {
-		return nil
+// mergeList merges the list given by the from query arg into list, as
+// the conflict query arg (by default, pgstore.MergeKeepMax) says to settle
+// items that are in both. The from list is left as it was.
+func (h *Handler) mergeList(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Merger == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
 	}
-	bodyString := string(bodyBytes[:])
-	// be nice and trim leading and trailing space from body first.
-	bodyString = strings.TrimSpace(bodyString)
-	return strings.Split(bodyString, "\n")
-}
-
-// insertBatch adds all of the items in the request body to the specified
-// list, and sets their completion attempt counts to 0. The response contains
-// the number of items successfully inserted, generally len(items) or 0.
-func (h *Handler) insertBatch(w http.ResponseWriter, r *http.Request, list string) {
-	v := r.Context().Value(BodyBytesKey)
-	if v == nil {
-		printSuccess(w, r, &AddedMessage{Added: 0}, http.StatusOK)
+	r = startBatch(w, r)
+	query := r.Context().Value(QueryKey).(url.Values)
+	from := query.Get("from")
+	if from == "" {
+		printError(w, r, &ErrorMessage{Error: "Query arg from, the list to merge, is required"}, http.StatusBadRequest)
 		return
 	}
-	bodyBytes := v.([]byte)
-	items, err := getItemsFromBody(fmt.Sprintf("%s", r.Context().Value(FinalContentTypeKey)), bodyBytes)
-	if err != nil {
-		errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+	conflict := query.Get("conflict")
+	if conflict == "" {
+		conflict = pgstore.MergeKeepMax
+	}
+	if !h.validate(w, r, list) || !h.validate(w, r, from) {
 		return
 	}
-
-	count, err := h.Store.InsertBatch(r.Context(), list, items)
+	h.Metrics.CountRequest(list, "merge")
+	counts, err := h.Merger.Merge(r.Context(), list, from, conflict)
 	if err != nil {
-		errStr := fmt.Sprintf("Error trying to add list items: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+		errStr := fmt.Sprintf("Error trying to merge %q into %q: %v", from, list, err)
+		printStoreError(w, r, errStr, err)
 		return
 	}
-	printSuccess(w, r, &AddedMessage{Added: count}, http.StatusCreated)
+	h.Metrics.CountRows(list, "merge", counts.Added+counts.Updated)
+	h.Activity.Record(list, pgstore.ActionCounts{Added: counts.Added})
+	h.mutated(MutationInsert, list, nil, counts.Added)
+	// Every way of settling a conflict leaves attempts the same or
+	// higher, so an update is, as far as hooks are concerned, an
+	// increment.
+	h.mutated(MutationIncrement, list, nil, counts.Updated)
+	if counts.Added > 0 {
+		w.Header().Set("Location", h.listPath(r, list))
+	}
+	printSuccess(w, r, &MergedMessage{Added: counts.Added, Updated: counts.Updated}, addedStatus(counts.Added))
 }
 
-// getBatch requires the "count" query arg, and takes an optional
-// "after_id" query arg. It returns a response body of list items;
-// each list item shows the number of attempts to
-// complete that list item. "count" determines how many items are
-// returned (from
-// the sorted list). "after_id" determines the offset in the list;
-// when set to the empty string, we start at the beginning of the list; when
-// set to an item (generally the last item from a previous call to this
-// handler) we start after that item in the list.
-func (h *Handler) getBatch(w http.ResponseWriter, r *http.Request, list string) {
-	query := r.Context().Value(QueryKey).(url.Values)
-	afterID := query.Get("after_id")
-	countStr := query.Get("count")
-	if countStr == "" {
-		printError(w, r, &ErrorMessage{Error: "Query arg not found: count"},
-			http.StatusBadRequest)
+// getTop returns the n items in list with the most attempts (highest
+// first, then alphabetically), which are most often the ones that an
+// operator wants to look at: the ones that keep failing.
+func (h *Handler) getTop(w http.ResponseWriter, r *http.Request, list string) {
+	if !h.validate(w, r, list) {
 		return
 	}
-	count, err := strconv.Atoi(countStr)
+	h.Metrics.CountRequest(list, "get_top")
+	query := r.Context().Value(QueryKey).(url.Values)
+	n := DefaultTopCount
+	if v := query.Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > h.maxCount() {
+			errStr := fmt.Sprintf("For query arg n, %q is not a number between 1 and the maximum of %d", v, h.maxCount())
+			printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+			return
+		}
+	}
+	listEntries, err := h.Store.QueryBatch(r.Context(), list, pgstore.BatchQuery{Count: n, MostAttemptsFirst: true})
 	if err != nil {
-		errStr := fmt.Sprintf("For query arg count, %v is not a number: %v", countStr, err)
+		errStr := fmt.Sprintf("Error trying to get list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "get_top", int64(len(listEntries)))
+	if len(listEntries) == 0 {
+		printNoEntries(w, r)
+		return
+	}
+	printListEntries(w, r, listEntries)
+}
+
+// getCount returns a response body of how many items are in the
+// specified list. With a query arg of by=attempts, the count is broken
+// down by attempts too; this needs h.Counter, without which a status of
+// 404 is given.
+func (h *Handler) getCount(w http.ResponseWriter, r *http.Request, list string) {
+	if !h.validate(w, r, list) {
+		return
+	}
+	h.Metrics.CountRequest(list, "get_count")
+	by := r.Context().Value(QueryKey).(url.Values).Get("by")
+	switch by {
+	case "":
+		n, err := h.Store.CountBatch(r.Context(), list, pgstore.BatchQuery{}, false)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to count list items: %v", err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		printSuccess(w, r, &CountMessage{Items: n}, http.StatusOK)
+	case "attempts":
+		if h.Counter == nil {
+			printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+			return
+		}
+		counts, err := h.Counter.CountByAttempts(r.Context(), list)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to count list items: %v", err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		// The total is summed from the breakdown, rather than counted
+		// apart, so that the two cannot disagree.
+		m := &CountMessage{ByAttempts: counts}
+		for _, c := range counts {
+			m.Items += c.Items
+		}
+		printSuccess(w, r, m, http.StatusOK)
+	default:
+		errStr := fmt.Sprintf(`For query arg by, %q is not "attempts"`, by)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+	}
+}
+
+// startBatch gives a batch mutation a new batch ID, which is sent to the
+// client in the X-IIDY-Batch-ID header, and carried by the returned
+// request's context to the store, which tags inserted items with it.
+func startBatch(w http.ResponseWriter, r *http.Request) *http.Request {
+	batchID := newID()
+	w.Header().Set("X-IIDY-Batch-ID", batchID)
+	return r.WithContext(pgstore.WithBatchID(r.Context(), batchID))
+}
+
+// getLists returns a response body of the name of every list that has
+// any items in it, with how many items it has, sorted by list.
+func (h *Handler) getLists(w http.ResponseWriter, r *http.Request) {
+	if h.Lister == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	lists, err := h.Lister.Lists(r.Context())
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get lists: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	if !unrestricted(r) {
+		allowed := []pgstore.ListCount{}
+		for _, l := range lists {
+			if listAllowed(r, l.List) {
+				allowed = append(allowed, l)
+			}
+		}
+		lists = allowed
+	}
+	printSuccess(w, r, &ListsMessage{Lists: lists}, http.StatusOK)
+}
+
+// getDBStats returns a response body describing the database connection
+// pool and server, for operational triage. When the handler has no
+// database to describe, a status of 404 is given.
+func (h *Handler) getDBStats(w http.ResponseWriter, r *http.Request) {
+	if h.DB == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !unrestricted(r) {
+		printForbidden(w, r, "This API key is restricted to some lists, so it cannot see database stats.")
+		return
+	}
+	stats, err := h.DB.DBStats(r.Context())
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get database stats: %v", err)
 		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
 		return
 	}
+	printSuccess(w, r, stats, http.StatusOK)
+}
+
+// getExport writes a consistent snapshot of the list given by the list
+// query arg (or, if there is none, of every list) as CSV; see
+// pgstore.PgStore.Export.
+//
+// Once the export has started, its status can no longer be changed, so if
+// it then fails, the connection is cut, so that the client cannot mistake
+// a partial export for a whole one.
+func (h *Handler) getExport(w http.ResponseWriter, r *http.Request) {
+	if h.Exporter == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !unrestricted(r) {
+		printForbidden(w, r, "This API key is restricted to some lists, so it cannot export.")
+		return
+	}
+	list := r.Context().Value(QueryKey).(url.Values).Get("list")
+	name := "all"
+	if list != "" {
+		name = list
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "iidy-"+name+".csv"))
+	cw := &countingWriter{w: w}
+	count, err := h.Exporter.Export(r.Context(), cw, list)
+	if err != nil {
+		if cw.n == 0 {
+			w.Header().Del("Content-Disposition")
+			errStr := fmt.Sprintf("Error trying to export %q: %v", name, err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		log.Printf("Export of %q failed after %d bytes: %v\n", name, cw.n, err)
+		panic(http.ErrAbortHandler)
+	}
+	log.Printf("Exported %d items of %q\n", count, name)
+}
+
+// getDiff compares list with the list given by the with query arg,
+// streaming the differences (see pgstore.Differ) as newline-delimited
+// JSON, whatever the client accepts, since a diff can be too big to
+// hold in memory as one JSON document.
+//
+// As with getExport, once the diff has started, its status can no longer
+// be changed, so a failure partway through aborts the response.
+func (h *Handler) getDiff(w http.ResponseWriter, r *http.Request, list string) {
+	query := r.Context().Value(QueryKey).(url.Values)
+	with := query.Get("with")
+	snapshot := query.Get("snapshot")
+	if (with == "") == (snapshot == "") {
+		printError(w, r, &ErrorMessage{Error: "One of query args with, the list to compare to, or snapshot, the snapshot to compare to, is required"}, http.StatusBadRequest)
+		return
+	}
+	if (snapshot == "" && h.Differ == nil) || (snapshot != "" && h.Snapshots == nil) {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) || (with != "" && !h.validate(w, r, with)) || (snapshot != "" && !h.validateLabel(w, r, snapshot)) {
+		return
+	}
+	h.Metrics.CountRequest(list, "get_diff")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	cw := &countingWriter{w: w}
+	enc := json.NewEncoder(cw)
+	encode := func(e pgstore.DiffEntry) error {
+		return enc.Encode(e)
+	}
+	var count int64
+	var err error
+	if snapshot != "" {
+		// The snapshot is list a, so that what was added
+		// since it was taken is only_b.
+		with = "snapshot " + snapshot
+		count, err = h.Snapshots.DiffSnapshot(r.Context(), list, snapshot, encode)
+	} else {
+		count, err = h.Differ.Diff(r.Context(), list, with, encode)
+	}
+	if err != nil {
+		if cw.n == 0 {
+			errStr := fmt.Sprintf("Error trying to compare %q with %q: %v", list, with, err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		log.Printf("Diff of %q with %q failed after %d bytes: %v\n", list, with, cw.n, err)
+		panic(http.ErrAbortHandler)
+	}
+	h.Metrics.CountRows(list, "get_diff", count)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// getChain gets the chain from a list to the next list (see
+// pgstore.Chain). If the list is not chained, a status of 404 is given.
+func (h *Handler) getChain(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Chains == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) {
+		return
+	}
+	c, err := h.Chains.GetChain(r.Context(), list)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get chain from list %s: %v", list, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	if c == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	printSuccess(w, r, c, http.StatusOK)
+}
+
+// setChain chains a list to the next list, so that items deleted from it
+// are inserted into the next list (see pgstore.PgStore.SetChain). The
+// body is {"next": "<listname>", "template": "<template>"} in JSON or
+// MessagePack, or, in plain text, the next list, optionally followed by a
+// space and the template. The template can be left out.
+func (h *Handler) setChain(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Chains == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	c, err := getChainFromRequest(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Error parsing chain from request body: %v", err)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	c.List = list
+	if !h.validate(w, r, list) || !h.validate(w, r, c.Next) {
+		return
+	}
+	err = h.Chains.SetChain(r.Context(), c)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to chain list %s to list %s: %v", list, c.Next, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	printSuccess(w, r, &c, http.StatusOK)
+}
+
+// deleteChain unchains a list from the next list. If the list was
+// not chained, a status of 404 is given.
+func (h *Handler) deleteChain(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Chains == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) {
+		return
+	}
+	count, err := h.Chains.DeleteChain(r.Context(), list)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to unchain list %s: %v", list, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
 	if count == 0 {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
 		return
 	}
-	listEntries, err := h.Store.GetBatch(r.Context(), list, afterID, count)
+	printSuccess(w, r, &DeletedMessage{Deleted: count}, http.StatusOK)
+}
+
+// getChainFromRequest gets a pgstore.Chain (less its List) from
+// the request body, regardless of the format it is in.
+func getChainFromRequest(r *http.Request) (pgstore.Chain, error) {
+	var c pgstore.Chain
+	bodyBytes, _ := r.Context().Value(BodyBytesKey).([]byte)
+	contentType := requestContentType(r)
+	if isStructured(contentType) {
+		if err := decodeBody(contentType, bodyBytes, &c); err != nil {
+			return c, err
+		}
+	} else {
+		lines := getItemsFromPlainText(bodyBytes)
+		if len(lines) != 1 {
+			return c, fmt.Errorf("expected one line, of the next list, optionally followed by a template; got %d lines", len(lines))
+		}
+		parts := strings.SplitN(lines[0], " ", 2)
+		c.Next = parts[0]
+		if len(parts) == 2 {
+			c.Template = parts[1]
+		}
+	}
+	if c.Next == "" {
+		return c, errors.New("the next list is missing")
+	}
+	return c, nil
+}
+
+// hasBody tells us if the request came with a body to get items from.
+func hasBody(r *http.Request) bool {
+	if isStreamed(requestContentType(r)) {
+		return r.Body != nil
+	}
+	return r.Context().Value(BodyBytesKey) != nil
+}
+
+// getItemsFromRequest gets a slice of list items from the request body,
+// regardless of the format it is in.
+func getItemsFromRequest(r *http.Request) ([]string, error) {
+	contentType := requestContentType(r)
+	if isStreamed(contentType) {
+		entries, err := getEntriesFromRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		return entryItems(entries), nil
+	}
+	v := r.Context().Value(BodyBytesKey)
+	if v == nil {
+		return nil, nil
+	}
+	return getItemsFromBody(contentType, v.([]byte))
+}
+
+// getEntriesFromRequest gets a slice of list entries from the request body,
+// regardless of the format it is in. Items given without attempts
+// (which is always the case for plain text) get 0 attempts.
+func getEntriesFromRequest(r *http.Request) ([]pgstore.ListEntry, error) {
+	contentType := requestContentType(r)
+	if contentType == "application/x-ndjson" {
+		if r.Body == nil {
+			return nil, nil
+		}
+		return getEntriesFromNDJSON(r.Body, isStrict(r))
+	}
+	if contentType == "text/csv" {
+		if r.Body == nil {
+			return nil, nil
+		}
+		return getEntriesFromCSV(r.Body, isStrict(r))
+	}
+	v := r.Context().Value(BodyBytesKey)
+	if v == nil {
+		return nil, nil
+	}
+	bodyBytes := v.([]byte)
+	if isStructured(contentType) {
+		return getEntriesFromStructured(contentType, bodyBytes)
+	}
+	var entries []pgstore.ListEntry
+	for _, item := range getItemsFromPlainText(bodyBytes) {
+		entries = append(entries, pgstore.ListEntry{Item: item})
+	}
+	return entries, nil
+}
+
+// getItemsFromBody gets a slice of list items from the request body,
+// regardless if the request body is in JSON, MessagePack, or plain text format.
+func getItemsFromBody(contentType string, bodyBytes []byte) ([]string, error) {
+	if bodyBytes == nil || len(bodyBytes) == 0 {
+		return nil, nil
+	}
+	if isStructured(contentType) {
+		return getItemsFromStructured(contentType, bodyBytes)
+	}
+	// default to text/plain
+	return getItemsFromPlainText(bodyBytes), nil
+}
+
+// getItemsFromStructured gets a slice of list item names from
+// the bytes of a request body that is in JSON or MessagePack format.
+// Any attempts given alongside the item names are ignored.
+func getItemsFromStructured(contentType string, bodyBytes []byte) ([]string, error) {
+	entries, err := getEntriesFromStructured(contentType, bodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return entryItems(entries), nil
+}
+
+// getEntriesFromStructured gets a slice of list entries from
+// the bytes of a request body that is in JSON or MessagePack format.
+// Items given as bare names get 0 attempts.
+func getEntriesFromStructured(contentType string, bodyBytes []byte) ([]pgstore.ListEntry, error) {
+	if bodyBytes == nil || len(bodyBytes) == 0 {
+		return nil, nil
+	}
+	var msg *BatchItemListMessage
+	err := decodeBody(contentType, bodyBytes, &msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil || msg.Items == nil {
+		return nil, nil
+	}
+	entries := make([]pgstore.ListEntry, 0, len(msg.Items))
+	for _, b := range msg.Items {
+		entries = append(entries, pgstore.ListEntry(b))
+	}
+	return entries, nil
+}
+
+// getItemsFromQuery gets a slice of list items from the comma-separated
+// items query parameter, which is handy for small batches where
+// building a request body is a nuisance. Item names containing commas
+// have to be sent in a request body instead.
+func getItemsFromQuery(query url.Values) ([]string, error) {
+	items := strings.Split(query.Get("items"), ",")
+	if len(items) > MaxQueryItems {
+		return nil, fmt.Errorf("For query arg items, %d items is more than the maximum of %d; send them in the request body instead", len(items), MaxQueryItems)
+	}
+	return items, nil
+}
+
+// getItemsFromPlainText gets a slice of list item names from
+// the bytes of a request body that is in plain text format.
+func getItemsFromPlainText(bodyBytes []byte) []string {
+	if bodyBytes == nil || len(bodyBytes) == 0 {
+		return nil
+	}
+	bodyString := string(bodyBytes[:])
+	// be nice and trim leading and trailing space from body first.
+	bodyString = strings.TrimSpace(bodyString)
+	return strings.Split(bodyString, "\n")
+}
+
+// plainTextReader reads the items of a plain-text body one line at a
+// time, giving the same items as getItemsFromPlainText would, without
+// holding the whole body in memory: leading and trailing blank lines are
+// skipped, as is space at the very start and end of the body.
+type plainTextReader struct {
+	r       *bufio.Reader
+	started bool     // whether the first item has been read
+	last    string   // the last item read, held back in case it is the last in the body
+	held    bool     // whether there is a last item held back
+	blanks  []string // blank lines since last, which count only if an item follows
+	ready   []string // items ready to be given out
+	eof     bool
+}
+
+func newPlainTextReader(body io.Reader) *plainTextReader {
+	return &plainTextReader{r: bufio.NewReader(body)}
+}
+
+// Read gives the next item, or io.EOF when there are no more.
+func (p *plainTextReader) Read() (string, error) {
+	for len(p.ready) == 0 {
+		if p.eof {
+			if !p.held {
+				return "", io.EOF
+			}
+			p.held = false
+			return strings.TrimRightFunc(p.last, unicode.IsSpace), nil
+		}
+		line, err := p.r.ReadString('\n')
+		if err == io.EOF {
+			p.eof = true
+		} else if err != nil {
+			return "", err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if strings.TrimSpace(line) == "" {
+			if p.held && !p.eof {
+				p.blanks = append(p.blanks, line)
+			}
+			continue
+		}
+		if !p.started {
+			p.started = true
+			line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		}
+		if p.held {
+			p.ready = append(append(p.ready, p.last), p.blanks...)
+		}
+		p.blanks = nil
+		p.last = line
+		p.held = true
+	}
+	item := p.ready[0]
+	p.ready = p.ready[1:]
+	return item, nil
+}
+
+// insertBatch adds all of the items in the request body to the specified
+// list, and sets their completion attempt counts to 0, unless the
+// (JSON, MessagePack, or NDJSON) request body specifies otherwise. The response contains
+// the number of items successfully inserted, generally len(items) or 0.
+// As with insertOne, the status is 201 (with the list's URL in the Location
+// header) if any items were added, or else 200.
+//
+// With the "detail=per_item" query arg, items already in the list are
+// skipped, as with "on_conflict=ignore", and the response gives the
+// outcome for each item (see ItemResult); incrementBatch and deleteBatch
+// take the same query arg.
+func (h *Handler) insertBatch(w http.ResponseWriter, r *http.Request, list string) {
+	r = startBatch(w, r)
+	if !h.validate(w, r, list) {
+		return
+	}
+	h.Metrics.CountRequest(list, "insert_batch")
+	query := r.Context().Value(QueryKey).(url.Values)
+	onConflict := query.Get("on_conflict")
+	if !validOnConflict(w, r, onConflict) {
+		return
+	}
+	if h.streamsInsert(r) && r.Context().Value(BodyBytesKey) == nil {
+		h.insertStream(w, r, list)
+		return
+	}
+	if !hasBody(r) {
+		printSuccess(w, r, &AddedMessage{Added: 0}, http.StatusOK)
+		return
+	}
+	entries, err := getEntriesFromRequest(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
+		printBodyError(w, r, errStr, err)
+		return
+	}
+	if !h.validate(w, r, list, entryItems(entries)...) {
+		return
+	}
+
+	msg := &AddedMessage{}
+	if onConflict == "ignore" || query.Get("detail") == "per_item" {
+		msg.Added, msg.Skipped, err = h.Store.InsertBatchIgnoreDuplicates(r.Context(), list, entries)
+		if query.Get("detail") == "per_item" {
+			msg.Results = itemResults(entryItems(entries), insertedItems(entryItems(entries), msg.Skipped), ResultDuplicate)
+		}
+		if query.Get("detail") != "full" {
+			msg.Skipped = nil
+		}
+	} else if hasAttempts(entries) {
+		msg.Added, err = h.Store.InsertBatchEntries(r.Context(), list, entries)
+	} else {
+		msg.Added, err = h.Store.InsertBatch(r.Context(), list, entryItems(entries))
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to add list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "insert_batch", msg.Added)
+	h.Activity.Record(list, pgstore.ActionCounts{Added: msg.Added})
+	h.mutated(MutationInsert, list, entryItems(entries), msg.Added)
+	if msg.Added > 0 {
+		w.Header().Set("Location", h.listPath(r, list))
+	}
+	printSuccess(w, r, msg, addedStatus(msg.Added))
+}
+
+// streamsInsert tells if r, a batch insert, has a body that can be fed to
+// h.Inserter as it is read: one of plain text, which is always 0 attempts
+// per item, that asks for no per-item detail, and that no functions
+// registered with OnMutation need the items of.
+func (h *Handler) streamsInsert(r *http.Request) bool {
+	if h.Inserter == nil || r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	if requestContentType(r) != "text/plain" {
+		return false
+	}
+	query := r.URL.Query()
+	if query.Get("on_conflict") == "ignore" || query.Get("detail") != "" {
+		return false
+	}
+	return !h.hasMutationHooks()
+}
+
+// insertStream is insertBatch for a body that streamsInsert says can be
+// fed to h.Inserter a line at a time, so that a batch of millions of items
+// never has to be held in memory. Each item is checked as it is read;
+// the first bad one, or one more than h allows in one request, stops the
+// insert, and nothing is added.
+func (h *Handler) insertStream(w http.ResponseWriter, r *http.Request, list string) {
+	lines := newPlainTextReader(r.Body)
+	var bodyErr, itemErr error
+	count := 0
+	next := func() (string, error) {
+		item, err := lines.Read()
+		if err == io.EOF {
+			return "", err
+		}
+		if err == nil {
+			count++
+			if count > h.maxBatchItems() {
+				err = fmt.Errorf("more than the %d items allowed in one request: %w", h.maxBatchItems(), ErrBodyTooLarge)
+			}
+		}
+		if err != nil {
+			bodyErr = err
+			return "", err
+		}
+		itemErr = h.validator().ValidateItem(item)
+		return item, itemErr
+	}
+	added, err := h.Inserter.InsertStream(r.Context(), list, next)
+	if bodyErr != nil {
+		errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", bodyErr)
+		printBodyError(w, r, errStr, bodyErr)
+		return
+	}
+	if itemErr != nil {
+		printStoreError(w, r, itemErr.Error(), itemErr)
+		return
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to add list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	msg := &AddedMessage{Added: added}
+	h.Metrics.CountRows(list, "insert_batch", msg.Added)
+	h.Activity.Record(list, pgstore.ActionCounts{Added: msg.Added})
+	if msg.Added > 0 {
+		w.Header().Set("Location", h.listPath(r, list))
+	}
+	printSuccess(w, r, msg, addedStatus(msg.Added))
+}
+
+// hasAttempts reports whether any of the entries has a non-zero
+// number of attempts.
+func hasAttempts(entries []pgstore.ListEntry) bool {
+	for _, e := range entries {
+		if e.Attempts != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// entryItems returns just the item names of the entries.
+func entryItems(entries []pgstore.ListEntry) []string {
+	if entries == nil {
+		return nil
+	}
+	items := make([]string, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, e.Item)
+	}
+	return items
+}
+
+// getBatch takes optional "count", and "after_id" or "from_id", query args. It returns
+// a response body of list items; each list item shows the number of
+// attempts to complete that list item. "count" determines how many items
+// are returned (from the sorted list); when it is left out, h's default
+// page size is used, and when it is more than h's maximum page size,
+// a status of 400 is given. "after_id" determines the offset in the list;
+// when set to the empty string, we start at the beginning of the list; when
+// set to an item (generally the last item from a previous call to this
+// handler) we start after that item in the list. "from_id" is like
+// "after_id", but includes the item it names, which is handy for a client
+// resuming from a checkpoint that it may not have finished. When there are no
+// (more) items, a status of 204 is given, with no body.
+//
+// To tell if there is another page, one more item than "count" is asked
+// of the store. If there is, a Link header with rel="next" gives the URL
+// of the next page, and a JSON or MessagePack body gives its after_id as
+// next_after_id; if not, the X-IIDY-Exhausted header is "true", and such
+// a body has "exhausted": true. Orders other than by item have no cursor
+// to carry on from, so they only ever say that the list is exhausted.
+//
+// With the "remaining=exact" or "remaining=estimate" query arg, the
+// X-IIDY-Remaining header tells how many items are left in the list after
+// the ones returned. An exact count reads all of them, so for big lists,
+// the query planner's estimate is a lot cheaper.
+//
+// The "updated_before" and "updated_after" query args leave out items that
+// were inserted, or had their attempts changed, after or before a time,
+// given either in RFC 3339 format, or as a duration ago, such as "24h".
+// With "order=oldest_first", the least recently updated items come first,
+// instead of being sorted alphabetically; "after_id" and "from_id"
+// cannot be used with it.
+//
+// With "ready=true", items still waiting out the delay after their attempts
+// were last incremented (see pgstore.RetryDelay) are left out. With
+// "status", only items with that status (see pgstore.StatusPending) are
+// given. With "min_attempts" or "max_attempts", only items with at least,
+// or at most, that many attempts are given.
+func (h *Handler) getBatch(w http.ResponseWriter, r *http.Request, list string) {
+	if !h.validate(w, r, list) {
+		return
+	}
+	h.Metrics.CountRequest(list, "get_batch")
+	query := r.Context().Value(QueryKey).(url.Values)
+	q := pgstore.BatchQuery{StartID: query.Get("after_id")}
+	if fromID := query.Get("from_id"); fromID != "" {
+		if q.StartID != "" {
+			printError(w, r, &ErrorMessage{Error: "Query args after_id and from_id cannot both be given"}, http.StatusBadRequest)
+			return
+		}
+		q.StartID = fromID
+		q.Inclusive = true
+	}
+	if q.StartID != "" && !h.validate(w, r, list, q.StartID) {
+		return
+	}
+	var err error
+	for _, arg := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"updated_before", &q.UpdatedBefore},
+		{"updated_after", &q.UpdatedAfter},
+	} {
+		if v := query.Get(arg.name); v != "" {
+			*arg.t, err = parseTimeArg(v, h.Clock)
+			if err != nil {
+				errStr := fmt.Sprintf("For query arg %s, %v", arg.name, err)
+				printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	switch query.Get("order") {
+	case "", "item":
+	case "oldest_first":
+		if q.StartID != "" {
+			printError(w, r, &ErrorMessage{Error: "Query arg order=oldest_first cannot be used with after_id or from_id"}, http.StatusBadRequest)
+			return
+		}
+		q.OldestFirst = true
+	case "most_attempts":
+		if q.StartID != "" {
+			printError(w, r, &ErrorMessage{Error: "Query arg order=most_attempts cannot be used with after_id or from_id"}, http.StatusBadRequest)
+			return
+		}
+		q.MostAttemptsFirst = true
+	default:
+		errStr := fmt.Sprintf(`For query arg order, "%s" is not one of "item", "oldest_first", or "most_attempts"`, query.Get("order"))
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	if ready := query.Get("ready"); ready != "" {
+		q.Ready, err = strconv.ParseBool(ready)
+		if err != nil {
+			errStr := fmt.Sprintf("For query arg ready, %v is not true or false", ready)
+			printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("min_attempts"); v != "" {
+		q.MinAttempts, err = parseAttemptsArg("min_attempts", v)
+		if err != nil {
+			printError(w, r, &ErrorMessage{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("max_attempts"); v != "" {
+		maxAttempts, err := parseAttemptsArg("max_attempts", v)
+		if err != nil {
+			printError(w, r, &ErrorMessage{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		q.MaxAttempts = &maxAttempts
+	}
+	q.Status = query.Get("status")
+	if err := pgstore.ValidateStatus(q.Status); err != nil {
+		printStoreError(w, r, err.Error(), err)
+		return
+	}
+	stream := streamedAccept(r)
+	// A stream is never held in memory, so it has no maximum,
+	// and, without a count, is the rest of the list.
+	streaming := stream != "" && h.Streamer != nil
+	count := h.defaultCount()
+	rest := false
+	if countStr := query.Get("count"); countStr != "" {
+		count, err = strconv.Atoi(countStr)
+		if err != nil {
+			errStr := fmt.Sprintf("For query arg count, %v is not a number: %v", countStr, err)
+			printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+			return
+		}
+	} else if streaming {
+		count, rest = 0, true
+	}
+	if streaming && count < 0 {
+		errStr := fmt.Sprintf("For query arg count, %d is less than 0", count)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	if !streaming && (count < 0 || count > h.maxCount()) {
+		errStr := fmt.Sprintf("For query arg count, %d is not between 0 and the maximum of %d", count, h.maxCount())
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	if count == 0 && !rest {
+		printNoEntries(w, r)
+		return
+	}
+	remaining := query.Get("remaining")
+	if remaining != "" && remaining != "exact" && remaining != "estimate" {
+		errStr := fmt.Sprintf(`For query arg remaining, "%s" is not one of "exact" or "estimate"`, remaining)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	q.Count = count
+	if stream != "" {
+		if remaining != "" {
+			printError(w, r, &ErrorMessage{Error: "Query arg remaining cannot be used with Accept: " + stream}, http.StatusBadRequest)
+			return
+		}
+		h.streamBatch(w, r, list, q, stream)
+		return
+	}
+	// Ask for one more than count, to tell if there is another page.
+	q.Count = count + 1
+	listEntries, err := h.Store.QueryBatch(r.Context(), list, q)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	q.Count = count
+	exhausted := len(listEntries) <= count
+	if !exhausted {
+		listEntries = listEntries[:count]
+	}
+	if remaining != "" {
+		rest := q
+		if len(listEntries) > 0 && !q.OldestFirst && !q.MostAttemptsFirst {
+			rest.StartID = listEntries[len(listEntries)-1].Item
+			rest.Inclusive = false
+		}
+		n, err := h.Store.CountBatch(r.Context(), list, rest, remaining == "estimate")
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to count remaining list items: %v", err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		if q.OldestFirst || q.MostAttemptsFirst {
+			// There is no cursor to count from, so count
+			// everything, less what was returned.
+			n -= int64(len(listEntries))
+			if n < 0 {
+				n = 0
+			}
+		}
+		w.Header().Set("X-IIDY-Remaining", strconv.FormatInt(n, 10))
+	}
+	h.Metrics.CountRows(list, "get_batch", int64(len(listEntries)))
 	if len(listEntries) == 0 {
 		// Nothing found, so we are done!
+		w.Header().Set("X-IIDY-Exhausted", "true")
+		printNoEntries(w, r)
 		return
 	}
 	// Although the client can parse out the last item from the body,
 	// as a convenience, also provide the last item in a header.
-	w.Header().Set("X-IIDY-Last-Item", listEntries[len(listEntries)-1].Item)
+	last := listEntries[len(listEntries)-1].Item
+	w.Header().Set("X-IIDY-Last-Item", last)
+	msg := &ListEntryMessage{ListEntries: listEntries}
+	if exhausted {
+		msg.Exhausted = true
+		w.Header().Set("X-IIDY-Exhausted", "true")
+	} else if !q.OldestFirst && !q.MostAttemptsFirst {
+		// Only item order has a cursor to carry on from.
+		msg.NextAfterID = last
+		w.Header().Add("Link", nextPageLink(r, last, count))
+	}
+	printListEntryMessage(w, r, msg)
+}
+
+// nextPageLink gives a Link header value pointing at the page of a
+// batch get that comes after last, with the same query args as r,
+// but for after_id (or from_id) and count.
+func nextPageLink(r *http.Request, last string, count int) string {
+	query := url.Values{}
+	for name, values := range r.Context().Value(QueryKey).(url.Values) {
+		query[name] = values
+	}
+	query.Del("from_id")
+	query.Set("after_id", last)
+	query.Set("count", strconv.Itoa(count))
+	return "<" + r.URL.Path + "?" + query.Encode() + `>; rel="next"`
+}
+
+// streamBatch writes the ListEntries that q picks out of list in
+// contentType, one of streamedContentTypes (one ListEntry per line of
+// newline-delimited JSON, or per record of CSV, after a header line), as
+// h.Streamer reads them, or, without one, once h.Store has got them all.
+// Since the last item is not known until the end, X-IIDY-Last-Item comes
+// as a trailer.
+//
+// As with getDiff, once the first line is written, the status can no
+// longer be changed, so a failure partway through aborts the response.
+func (h *Handler) streamBatch(w http.ResponseWriter, r *http.Request, list string, q pgstore.BatchQuery, contentType string) {
+	if contentType == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", list+".csv"))
+	} else {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Trailer", "X-IIDY-Last-Item")
+	cw := &countingWriter{w: w}
+	enc := newEntryEncoder(cw, contentType)
+	var last string
+	encode := func(e pgstore.ListEntry) error {
+		last = e.Item
+		return enc.Encode(e)
+	}
+	var count int64
+	var err error
+	if h.Streamer != nil {
+		count, err = h.Streamer.StreamBatch(r.Context(), list, q, encode)
+	} else {
+		var listEntries []pgstore.ListEntry
+		listEntries, err = h.Store.QueryBatch(r.Context(), list, q)
+		for i := 0; err == nil && i < len(listEntries); i++ {
+			err = encode(listEntries[i])
+			count++
+		}
+	}
+	if err == nil && (count > 0 || apiVersion(r) >= 2) {
+		err = enc.Flush()
+	}
+	if err != nil {
+		if cw.n == 0 {
+			w.Header().Del("Trailer")
+			w.Header().Del("Content-Disposition")
+			errStr := fmt.Sprintf("Error trying to get list items: %v", err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		log.Printf("Streaming %q failed after %d bytes: %v\n", list, cw.n, err)
+		panic(http.ErrAbortHandler)
+	}
+	h.Metrics.CountRows(list, "get_batch", count)
+	if count == 0 {
+		w.Header().Del("Trailer")
+		if apiVersion(r) < 2 {
+			w.Header().Del("Content-Disposition")
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	w.Header().Set("X-IIDY-Last-Item", last)
+}
+
+// parseAttemptsArg parses the query arg called name, which is a
+// number of attempts.
+func parseAttemptsArg(name string, v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("For query arg %s, %v is not a number of attempts", name, v)
+	}
+	return n, nil
+}
+
+// parseTimeArg parses a query arg that is a time, either in RFC 3339
+// format, or as a duration before now, by c, such as "90m".
+func parseTimeArg(v string, c clock.Clock) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return clock.OrSystem(c).Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`"%s" is neither an RFC 3339 time nor a duration`, v)
+	}
+	return t, nil
+}
+
+// getMulti returns a response body of the list entries for the items
+// in the request body, alphabetically sorted. It is a cheaper way of
+// checking on a known set of items than calling getOne for each of them.
+// Items that are not in the list are left out of the response; when none
+// of them are in the list, a status of 204 is given, with no body.
+func (h *Handler) getMulti(w http.ResponseWriter, r *http.Request, list string) {
+	if !h.validate(w, r, list) {
+		return
+	}
+	h.Metrics.CountRequest(list, "get_multi")
+	if !hasBody(r) {
+		printNoEntries(w, r)
+		return
+	}
+	items, err := getItemsFromRequest(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
+		printBodyError(w, r, errStr, err)
+		return
+	}
+	h.getEntries(w, r, list, items)
+}
+
+// getEntries gets the entries of the named items in the specified list,
+// for getMulti, or for a v2 get of the items given as "item" query args.
+func (h *Handler) getEntries(w http.ResponseWriter, r *http.Request, list string, items []string) {
+	if !h.validate(w, r, list, items...) {
+		return
+	}
+
+	listEntries, err := h.Store.GetMulti(r.Context(), list, items)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "get_multi", int64(len(listEntries)))
+	if len(listEntries) == 0 {
+		// Nothing found, so we are done!
+		printNoEntries(w, r)
+		return
+	}
 	printListEntries(w, r, listEntries)
 }
 
@@ -399,25 +2104,74 @@ func (h *Handler) getBatch(w http.ResponseWriter, r *http.Request, list string)
 // in the specified list. The response contains the
 // number of items successfully incremented, generally len(items) or 0.
 func (h *Handler) incrementBatch(w http.ResponseWriter, r *http.Request, list string) {
-	v := r.Context().Value(BodyBytesKey)
-	if v == nil {
-		printSuccess(w, r, &IncrementedMessage{Incremented: 0}, http.StatusOK)
+	r = startBatch(w, r)
+	if !h.validate(w, r, list) {
 		return
 	}
-	bodyBytes := v.([]byte)
-	items, err := getItemsFromBody(fmt.Sprintf("%s", r.Context().Value(FinalContentTypeKey)), bodyBytes)
-	if err != nil {
-		errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+	h.Metrics.CountRequest(list, "increment_batch")
+	query := r.Context().Value(QueryKey).(url.Values)
+	var items []string
+	var err error
+	if query.Get("items") != "" {
+		items, err = getItemsFromQuery(query)
+		if err != nil {
+			printError(w, r, &ErrorMessage{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	} else {
+		if !hasBody(r) {
+			printSuccess(w, r, &IncrementedMessage{Incremented: 0}, http.StatusOK)
+			return
+		}
+		items, err = getItemsFromRequest(r)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
+			printBodyError(w, r, errStr, err)
+			return
+		}
+	}
+	if !h.validate(w, r, list, items...) {
+		return
+	}
+
+	if detail := query.Get("detail"); detail == "full" || detail == "per_item" {
+		entries, err := h.Store.IncrementBatchReturning(r.Context(), list, items)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to increment list items: %v", err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		attempts := make(map[string]int, len(entries))
+		for _, e := range entries {
+			attempts[e.Item] = e.Attempts
+		}
+		found, notFound := partitionItems(items, entryItems(entries))
+		msg := &IncrementedMessage{Incremented: int64(len(entries))}
+		if detail == "per_item" {
+			msg.Results = itemResults(items, found, ResultNotFound)
+		} else {
+			msg.ListEntries = make([]pgstore.ListEntry, 0, len(found))
+			msg.NotFound = notFound
+			for _, item := range found {
+				msg.ListEntries = append(msg.ListEntries, pgstore.ListEntry{Item: item, Attempts: attempts[item]})
+			}
+		}
+		h.Metrics.CountRows(list, "increment_batch", msg.Incremented)
+		h.Activity.Record(list, pgstore.ActionCounts{Incremented: msg.Incremented})
+		h.mutated(MutationIncrement, list, items, msg.Incremented)
+		printSuccess(w, r, msg, http.StatusOK)
 		return
 	}
 
 	count, err := h.Store.IncrementBatch(r.Context(), list, items)
 	if err != nil {
 		errStr := fmt.Sprintf("Error trying to increment list items: %v", err)
-		http.Error(w, errStr, http.StatusInternalServerError)
+		printStoreError(w, r, errStr, err)
 		return
 	}
+	h.Metrics.CountRows(list, "increment_batch", count)
+	h.Activity.Record(list, pgstore.ActionCounts{Incremented: count})
+	h.mutated(MutationIncrement, list, items, count)
 	printSuccess(w, r, &IncrementedMessage{Incremented: count}, http.StatusOK)
 }
 
@@ -425,58 +2179,385 @@ func (h *Handler) incrementBatch(w http.ResponseWriter, r *http.Request, list st
 // from the specified list. The response contains the
 // number of items successfully deleted, generally len(items) or 0.
 func (h *Handler) deleteBatch(w http.ResponseWriter, r *http.Request, list string) {
-	v := r.Context().Value(BodyBytesKey)
-	if v == nil {
-		printSuccess(w, r, &DeletedMessage{Deleted: 0}, http.StatusOK)
+	r = startBatch(w, r)
+	if !h.validate(w, r, list) {
 		return
 	}
-	bodyBytes := v.([]byte)
-	items, err := getItemsFromBody(fmt.Sprintf("%s", r.Context().Value(FinalContentTypeKey)), bodyBytes)
-	if err != nil {
-		errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
-		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+	h.Metrics.CountRequest(list, "delete_batch")
+	query := r.Context().Value(QueryKey).(url.Values)
+	var items []string
+	var err error
+	if query.Get("items") != "" {
+		items, err = getItemsFromQuery(query)
+		if err != nil {
+			printError(w, r, &ErrorMessage{Error: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	} else {
+		if !hasBody(r) {
+			printSuccess(w, r, &DeletedMessage{Deleted: 0}, http.StatusOK)
+			return
+		}
+		items, err = getItemsFromRequest(r)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to parse list of items from request body: %v", err)
+			printBodyError(w, r, errStr, err)
+			return
+		}
+	}
+	if !h.validate(w, r, list, items...) {
+		return
+	}
+
+	if detail := query.Get("detail"); detail == "full" || detail == "per_item" {
+		deleted, err := h.Store.DeleteBatchReturning(r.Context(), list, items)
+		if err != nil {
+			errStr := fmt.Sprintf("Error trying to delete list items: %v", err)
+			printStoreError(w, r, errStr, err)
+			return
+		}
+		found, notFound := partitionItems(items, deleted)
+		h.Metrics.CountRows(list, "delete_batch", int64(len(deleted)))
+		h.Activity.Record(list, pgstore.ActionCounts{Deleted: int64(len(deleted))})
+		h.mutated(MutationDelete, list, items, int64(len(deleted)))
+		if detail == "per_item" {
+			printSuccess(w, r, &DeletedMessage{Deleted: int64(len(deleted)), Results: itemResults(items, found, ResultNotFound)}, http.StatusOK)
+			return
+		}
+		printSuccess(w, r, &DeletedMessage{Deleted: int64(len(deleted)), Items: found, NotFound: notFound}, http.StatusOK)
 		return
 	}
 
 	count, err := h.Store.DeleteBatch(r.Context(), list, items)
 	if err != nil {
 		errStr := fmt.Sprintf("Error trying to delete list items: %v", err)
-		http.Error(w, errStr, http.StatusInternalServerError)
+		printStoreError(w, r, errStr, err)
 		return
 	}
+	h.Metrics.CountRows(list, "delete_batch", count)
+	h.Activity.Record(list, pgstore.ActionCounts{Deleted: count})
+	h.mutated(MutationDelete, list, items, count)
 	printSuccess(w, r, &DeletedMessage{Deleted: count}, http.StatusOK)
 }
 
+// applyBatch does the actions in the request body to the items of the
+// specified list, all in one transaction, so that either all of them are
+// done, or (if any of them fails) none of them are. The response contains
+// the numbers of items added, incremented, and deleted.
+func (h *Handler) applyBatch(w http.ResponseWriter, r *http.Request, list string) {
+	r = startBatch(w, r)
+	if !h.validate(w, r, list) {
+		return
+	}
+	h.Metrics.CountRequest(list, "apply_batch")
+	if !hasBody(r) {
+		printSuccess(w, r, &ActionsMessage{}, http.StatusOK)
+		return
+	}
+	actions, err := getActionsFromRequest(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to parse list of actions from request body: %v", err)
+		printBodyError(w, r, errStr, err)
+		return
+	}
+	items := make([]string, 0, len(actions))
+	for _, a := range actions {
+		items = append(items, a.Item)
+	}
+	if !h.validate(w, r, list, items...) {
+		return
+	}
+	if err := pgstore.ValidateActions(actions); err != nil {
+		printStoreError(w, r, err.Error(), err)
+		return
+	}
+
+	counts, err := h.Store.ApplyBatch(r.Context(), list, actions)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to apply actions to list items: %v", err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "apply_batch", counts.Added+counts.Incremented+counts.Deleted)
+	h.Activity.Record(list, counts)
+	h.mutatedByActions(list, actions, counts)
+	printSuccess(w, r, &ActionsMessage{Added: counts.Added, Incremented: counts.Incremented, Deleted: counts.Deleted}, http.StatusOK)
+}
+
+// getActionsFromRequest gets a slice of ItemActions from the request body,
+// regardless of the format it is in. In plain text, each line is an
+// action, a space, and an item, such as "delete a.txt".
+func getActionsFromRequest(r *http.Request) ([]pgstore.ItemAction, error) {
+	contentType := requestContentType(r)
+	if contentType == "application/x-ndjson" {
+		return getActionsFromNDJSON(r.Body, isStrict(r))
+	}
+	if contentType == "text/csv" {
+		return getActionsFromCSV(r.Body, isStrict(r))
+	}
+	bodyBytes, _ := r.Context().Value(BodyBytesKey).([]byte)
+	if len(bodyBytes) == 0 {
+		return nil, nil
+	}
+	if isStructured(contentType) {
+		var msg ActionListMessage
+		if err := decodeBody(contentType, bodyBytes, &msg); err != nil {
+			return nil, err
+		}
+		return msg.Items, nil
+	}
+	var actions []pgstore.ItemAction
+	for i, line := range getItemsFromPlainText(bodyBytes) {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(`line %d: "%s" is not of the form "<action> <item>"`, i+1, line)
+		}
+		actions = append(actions, pgstore.ItemAction{Action: parts[0], Item: parts[1]})
+	}
+	return actions, nil
+}
+
+// partitionItems splits the requested items into those that the
+// data store reported as affected and those that it did not, preserving
+// the order in which the items were requested. An item requested more
+// than once is only counted as affected the first time.
+func partitionItems(requested []string, affected []string) ([]string, []string) {
+	affectedSet := make(map[string]struct{}, len(affected))
+	for _, item := range affected {
+		affectedSet[item] = struct{}{}
+	}
+	found := make([]string, 0, len(affected))
+	var notFound []string
+	for _, item := range requested {
+		if _, ok := affectedSet[item]; ok {
+			delete(affectedSet, item)
+			found = append(found, item)
+			continue
+		}
+		notFound = append(notFound, item)
+	}
+	return found, notFound
+}
+
+// itemResults gives the outcome of a batch mutation for each of the
+// requested items, in order: ResultOK for an item that the data store
+// reported as affected, missing for one that it did not, and
+// ResultDuplicate for any repeat of an item requested earlier.
+func itemResults(requested []string, affected []string, missing string) []ItemResult {
+	affectedSet := make(map[string]struct{}, len(affected))
+	for _, item := range affected {
+		affectedSet[item] = struct{}{}
+	}
+	seen := make(map[string]struct{}, len(requested))
+	results := make([]ItemResult, 0, len(requested))
+	for _, item := range requested {
+		result := missing
+		if _, ok := seen[item]; ok {
+			result = ResultDuplicate
+		} else if _, ok := affectedSet[item]; ok {
+			result = ResultOK
+		}
+		seen[item] = struct{}{}
+		results = append(results, ItemResult{Item: item, Result: result})
+	}
+	return results
+}
+
+// insertedItems gives the requested items that an insert ignoring
+// duplicates did not report as skipped.
+func insertedItems(requested []string, skipped []string) []string {
+	skips := make(map[string]int, len(skipped))
+	for _, item := range skipped {
+		skips[item]++
+	}
+	inserted := make([]string, 0, len(requested)-len(skipped))
+	for _, item := range requested {
+		if skips[item] > 0 {
+			skips[item]--
+			continue
+		}
+		inserted = append(inserted, item)
+	}
+	return inserted
+}
+
 // printListEntries prints list entries to the w, the response writer.
-// This function correctly determines whether JSON or plain text is
+// This function correctly determines whether JSON, MessagePack, or plain text is
 // requested.
 func printListEntries(w http.ResponseWriter, r *http.Request, listEntries []pgstore.ListEntry) {
-	contentType := r.Context().Value(FinalContentTypeKey)
-	if contentType == "application/json" {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		err := json.NewEncoder(w).Encode(&ListEntryMessage{ListEntries: listEntries})
+	printListEntryMessage(w, r, &ListEntryMessage{ListEntries: listEntries})
+}
+
+// printListEntryMessage is printListEntries for a message that may also
+// say where the next page starts; plain text has room for only the
+// entries, so it leaves that to the response headers.
+func printListEntryMessage(w http.ResponseWriter, r *http.Request, msg *ListEntryMessage) {
+	contentType := responseContentType(r)
+	if isStructured(contentType) {
+		w.Header().Set("Content-Type", contentTypeHeader(contentType))
+		err := encodeBody(w, contentType, msg)
 		if err != nil {
-			fmt.Printf("Could not encode list entries to JSON: %v", err)
+			fmt.Printf("Could not encode list entries to %s: %v", contentType, err)
 		}
 	} else {
-		for _, listItem := range listEntries {
+		for _, listItem := range msg.ListEntries {
 			fmt.Fprintf(w, "%s %d\n", listItem.Item, listItem.Attempts)
 		}
 	}
 	return
 }
 
+// printItemResults prints the outcome of a batch mutation for each
+// item as plain text, one "item result" line per item.
+func printItemResults(w http.ResponseWriter, results []ItemResult) {
+	for _, res := range results {
+		fmt.Fprintf(w, "%s %s\n", res.Item, res.Result)
+	}
+}
+
+// printFields prints the fields of the struct that v points to as plain
+// text, one "name value" line per field, using the fields' JSON names.
+// Nil pointer fields are left out.
+func printFields(w http.ResponseWriter, v interface{}) {
+	rv := reflect.ValueOf(v).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		name := strings.Split(rv.Type().Field(i).Tag.Get("json"), ",")[0]
+		f := rv.Field(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+		fmt.Fprintf(w, "%s %v\n", name, f.Interface())
+	}
+}
+
+// defaultCount gives how many items a batch get returns
+// when the count query arg is left out, which is never
+// more than h's maximum.
+func (h *Handler) defaultCount() int {
+	count := h.DefaultCount
+	if count == 0 {
+		count = DefaultPageSize
+	}
+	if count > h.maxCount() {
+		return h.maxCount()
+	}
+	return count
+}
+
+// maxCount gives the most items a batch get can ask for.
+func (h *Handler) maxCount() int {
+	if h.MaxCount == 0 {
+		return MaxPageSize
+	}
+	return h.MaxCount
+}
+
+// validator gives the Validator that h uses.
+func (h *Handler) validator() *pgstore.Validator {
+	if h.Validator == nil {
+		return pgstore.DefaultValidator
+	}
+	return h.Validator
+}
+
+// validate checks a list name and any item names. If any of them is not
+// allowed, a status of 400 is given, and false is returned. So is false,
+// with a status of 413, if there are more items than h allows, and with
+// a status of 403, if the request's API key does not allow the list.
+func (h *Handler) validate(w http.ResponseWriter, r *http.Request, list string, items ...string) bool {
+	if len(items) > h.maxBatchItems() {
+		errStr := fmt.Sprintf("%d items are more than the %d allowed in one request.", len(items), h.maxBatchItems())
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusRequestEntityTooLarge)
+		return false
+	}
+	err := h.validator().Validate(list, items...)
+	if err != nil {
+		printStoreError(w, r, err.Error(), err)
+		return false
+	}
+	if !listAllowed(r, list) {
+		printForbidden(w, r, fmt.Sprintf("This API key does not allow list %q.", list))
+		return false
+	}
+	return true
+}
+
+// printStoreError prints an error from the data store. A
+// *pgstore.ValidationError is the client's fault, and gives a status of 400;
+// pgstore.ErrUnavailable (the database is down), pgstore.ErrReadOnly
+// (a change while the database is read-only), and pgstore.ErrAcquireTimeout
+// (no database connection came free in time) give a status of 503;
+// pgstore.ErrDuplicate gives a status of 409 in v2, and, as it always
+// has, 500 in v1; pgstore.ErrSnapshotNotFound and pgstore.ErrNotImplemented
+// give a status of 404, and pgstore.ErrSnapshotExists and
+// pgstore.ErrInvalidTransition 409; anything else gives a status of 500.
+func printStoreError(w http.ResponseWriter, r *http.Request, errStr string, err error) {
+	var ve *pgstore.ValidationError
+	if errors.As(err, &ve) {
+		printError(w, r, &ErrorMessage{Error: errStr, Code: ErrorCode("invalid_" + ve.Field)}, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, pgstore.ErrReadOnly) {
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeReadOnly}, http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, pgstore.ErrAcquireTimeout) {
+		// The database is busy, rather than down, so it is worth
+		// trying again soon.
+		w.Header().Set("Retry-After", "1")
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodePoolTimeout}, http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, pgstore.ErrUnavailable) {
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeUnavailable}, http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, pgstore.ErrNotImplemented) {
+		// A wrapper, such as a ReadOnlyStore, was handed to NewHandler
+		// over a store that cannot serve the route after all.
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, pgstore.ErrSnapshotNotFound) {
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeSnapshotNotFound}, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, pgstore.ErrSnapshotExists) {
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeSnapshotExists}, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, pgstore.ErrInvalidTransition) {
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeInvalidTransition}, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, pgstore.ErrDuplicate) {
+		status := http.StatusInternalServerError
+		if apiVersion(r) >= 2 {
+			status = http.StatusConflict
+		}
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeDuplicateItem}, status)
+		return
+	}
+	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusInternalServerError)
+}
+
 // printError prints an error to w, the response writer, in the requested
-// format, JSON or plain text. The response code is also set as specified.
+// format, JSON, MessagePack, or plain text. The response code is also set as specified.
 func printError(w http.ResponseWriter, r *http.Request, e *ErrorMessage, code int) {
-	contentType := r.Context().Value(FinalContentTypeKey)
-	if contentType == "application/json" {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if e.Code == "" {
+		e.Code = statusCodes[code]
+	}
+	contentType := responseContentType(r)
+	if isStructured(contentType) {
+		w.Header().Set("Content-Type", contentTypeHeader(contentType))
 		w.WriteHeader(code)
-		err := json.NewEncoder(w).Encode(e)
+		err := encodeBody(w, contentType, e)
 		if err != nil {
-			fmt.Printf("Encountered error %v and could not even encode to JSON: %v",
-				e, err)
+			fmt.Printf("Encountered error %v and could not even encode to %s: %v",
+				e, contentType, err)
 		}
 	} else {
 		http.Error(w, e.Error, code)
@@ -485,31 +2566,105 @@ func printError(w http.ResponseWriter, r *http.Request, e *ErrorMessage, code in
 }
 
 // printSuccess prints a success message to w, the response writer, in the requested
-// format, JSON or plain text. The response code is also set as specified.
+// format, JSON, MessagePack, or plain text. The response code is also set as specified.
 func printSuccess(w http.ResponseWriter, r *http.Request, v interface{}, code int) {
-	w.WriteHeader(code)
-	contentType := r.Context().Value(FinalContentTypeKey)
-	if contentType == "application/json" {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		err := json.NewEncoder(w).Encode(v)
+	contentType := responseContentType(r)
+	if isStructured(contentType) {
+		w.Header().Set("Content-Type", contentTypeHeader(contentType))
+		w.WriteHeader(code)
+		err := encodeBody(w, contentType, v)
 		if err != nil {
-			fmt.Printf("Could not even encode to JSON: %v", v)
+			fmt.Printf("Could not even encode to %s: %v", contentType, v)
 		}
 	} else {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
 		switch v.(type) {
 		case *AddedMessage:
 			m := v.(*AddedMessage)
 			fmt.Fprintf(w, "ADDED %d\n", m.Added)
+			for _, item := range m.Skipped {
+				fmt.Fprintf(w, "%s duplicate\n", item)
+			}
+			printItemResults(w, m.Results)
+		case *ActionsMessage:
+			m := v.(*ActionsMessage)
+			fmt.Fprintf(w, "ADDED %d\nINCREMENTED %d\nDELETED %d\n", m.Added, m.Incremented, m.Deleted)
+		case *MergedMessage:
+			m := v.(*MergedMessage)
+			fmt.Fprintf(w, "ADDED %d\nUPDATED %d\n", m.Added, m.Updated)
+		case *RestoredMessage:
+			m := v.(*RestoredMessage)
+			fmt.Fprintf(w, "ADDED %d\nUPDATED %d\nDELETED %d\n", m.Added, m.Updated, m.Deleted)
+		case *pgstore.Snapshot:
+			m := v.(*pgstore.Snapshot)
+			fmt.Fprintf(w, "%s %d %s\n", m.Label, m.Items, m.CreatedAt.UTC().Format(time.RFC3339))
+		case *DecrementedMessage:
+			m := v.(*DecrementedMessage)
+			fmt.Fprintf(w, "DECREMENTED %d\n", m.Decremented)
+		case *AttemptsSetMessage:
+			m := v.(*AttemptsSetMessage)
+			fmt.Fprintf(w, "SET %d\n", m.Set)
+		case *StatusMessage:
+			m := v.(*StatusMessage)
+			fmt.Fprintf(w, "STATUS %s\n", m.Status)
+		case *CountMessage:
+			m := v.(*CountMessage)
+			fmt.Fprintf(w, "COUNT %d\n", m.Items)
+			for _, c := range m.ByAttempts {
+				fmt.Fprintf(w, "%d %d\n", c.Attempts, c.Items)
+			}
+		case *ListsMessage:
+			m := v.(*ListsMessage)
+			for _, l := range m.Lists {
+				fmt.Fprintf(w, "%s %d\n", l.List, l.Items)
+			}
+		case *SnapshotsMessage:
+			m := v.(*SnapshotsMessage)
+			for _, s := range m.Snapshots {
+				fmt.Fprintf(w, "%s %d %s\n", s.Label, s.Items, s.CreatedAt.UTC().Format(time.RFC3339))
+			}
+		case *ActivityMessage:
+			m := v.(*ActivityMessage)
+			fmt.Fprintf(w, "SINCE %s\nADDED %d\nINCREMENTED %d\nDELETED %d\nTRANSITIONED %d\n", m.Since.Format(time.RFC3339), m.Added, m.Incremented, m.Deleted, m.Transitioned)
 		case *IncrementedMessage:
 			m := v.(*IncrementedMessage)
 			fmt.Fprintf(w, "INCREMENTED %d\n", m.Incremented)
+			for _, e := range m.ListEntries {
+				fmt.Fprintf(w, "%s %d\n", e.Item, e.Attempts)
+			}
+			for _, item := range m.NotFound {
+				fmt.Fprintf(w, "%s not_found\n", item)
+			}
+			printItemResults(w, m.Results)
 		case *DeletedMessage:
 			m := v.(*DeletedMessage)
 			fmt.Fprintf(w, "DELETED %d\n", m.Deleted)
+			for _, item := range m.Items {
+				fmt.Fprintf(w, "%s deleted\n", item)
+			}
+			for _, item := range m.NotFound {
+				fmt.Fprintf(w, "%s not_found\n", item)
+			}
+			printItemResults(w, m.Results)
 		case *pgstore.ListEntry:
 			m := v.(*pgstore.ListEntry)
 			fmt.Fprintf(w, "%d\n", m.Attempts)
+		case *pgstore.DBStats, *pgstore.Chain, *ListSettingsMessage:
+			printFields(w, v)
+		case *VersionsMessage:
+			m := v.(*VersionsMessage)
+			fmt.Fprintf(w, "CURRENT %s\n", m.Current)
+			for _, v := range m.Versions {
+				fmt.Fprintf(w, "%s %s", v.Version, v.Prefix)
+				if v.Deprecated != nil {
+					fmt.Fprintf(w, " deprecated %s", v.Deprecated.Format(time.RFC3339))
+				}
+				if v.Sunset != nil {
+					fmt.Fprintf(w, " sunset %s", v.Sunset.Format(time.RFC3339))
+				}
+				fmt.Fprintln(w)
+			}
 		default:
 			fmt.Printf("Could not determine type of: %v", v)
 		}
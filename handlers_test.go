@@ -3,22 +3,42 @@ package iidy
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/manniwood/iidy/clock"
+	"github.com/manniwood/iidy/memstore"
 	"github.com/manniwood/iidy/pgstore"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 type StoreTestingStub struct {
 	insertOne      func(ctx context.Context, list string, item string) (int64, error)
 	getOne         func(ctx context.Context, list string, item string) (int, bool, error)
 	deleteOne      func(ctx context.Context, list string, item string) (int64, error)
+	deleteOneIf    func(ctx context.Context, list string, item string, attempts []int) (int64, error)
 	incrementOne   func(ctx context.Context, list string, item string) (int64, error)
+	incrementOneIf func(ctx context.Context, list string, item string, attempts []int) (int64, error)
 	insertBatch    func(ctx context.Context, list string, items []string) (int64, error)
+	insertEntries  func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error)
+	insertIgnore   func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, []string, error)
 	getBatch       func(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error)
+	queryBatch     func(ctx context.Context, list string, q pgstore.BatchQuery) ([]pgstore.ListEntry, error)
+	countBatch     func(ctx context.Context, list string, q pgstore.BatchQuery, estimate bool) (int64, error)
+	getMulti       func(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error)
 	deleteBatch    func(ctx context.Context, list string, items []string) (int64, error)
+	deleteBatchRet func(ctx context.Context, list string, items []string) ([]string, error)
 	incrementBatch func(ctx context.Context, list string, items []string) (int64, error)
+	incrementRet   func(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error)
+	applyBatch     func(ctx context.Context, list string, actions []pgstore.ItemAction) (pgstore.ActionCounts, error)
 }
 
 func (sts StoreTestingStub) InsertOne(ctx context.Context, list string, item string) (int64, error) {
@@ -33,26 +53,71 @@ func (sts StoreTestingStub) DeleteOne(ctx context.Context, list string, item str
 	return sts.deleteOne(ctx, list, item)
 }
 
+func (sts StoreTestingStub) DeleteOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	return sts.deleteOneIf(ctx, list, item, attempts)
+}
+
 func (sts StoreTestingStub) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
 	return sts.incrementOne(ctx, list, item)
 }
 
+func (sts StoreTestingStub) IncrementOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	return sts.incrementOneIf(ctx, list, item, attempts)
+}
+
 func (sts StoreTestingStub) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
 	return sts.insertBatch(ctx, list, items)
 }
 
+func (sts StoreTestingStub) InsertBatchEntries(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error) {
+	return sts.insertEntries(ctx, list, entries)
+}
+
+func (sts StoreTestingStub) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, []string, error) {
+	return sts.insertIgnore(ctx, list, entries)
+}
+
 func (sts StoreTestingStub) GetBatch(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error) {
 	return sts.getBatch(ctx, list, startID, count)
 }
 
+// QueryBatch falls back on getBatch, for the tests that
+// only care about plain pages of a list.
+func (sts StoreTestingStub) QueryBatch(ctx context.Context, list string, q pgstore.BatchQuery) ([]pgstore.ListEntry, error) {
+	if sts.queryBatch == nil {
+		return sts.getBatch(ctx, list, q.StartID, q.Count)
+	}
+	return sts.queryBatch(ctx, list, q)
+}
+
+func (sts StoreTestingStub) CountBatch(ctx context.Context, list string, q pgstore.BatchQuery, estimate bool) (int64, error) {
+	return sts.countBatch(ctx, list, q, estimate)
+}
+
+func (sts StoreTestingStub) GetMulti(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+	return sts.getMulti(ctx, list, items)
+}
+
 func (sts StoreTestingStub) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
 	return sts.deleteBatch(ctx, list, items)
 }
 
+func (sts StoreTestingStub) DeleteBatchReturning(ctx context.Context, list string, items []string) ([]string, error) {
+	return sts.deleteBatchRet(ctx, list, items)
+}
+
 func (sts StoreTestingStub) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
 	return sts.incrementBatch(ctx, list, items)
 }
 
+func (sts StoreTestingStub) IncrementBatchReturning(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+	return sts.incrementRet(ctx, list, items)
+}
+
+func (sts StoreTestingStub) ApplyBatch(ctx context.Context, list string, actions []pgstore.ItemAction) (pgstore.ActionCounts, error) {
+	return sts.applyBatch(ctx, list, actions)
+}
+
 func TestHandler(t *testing.T) {
 	tests := map[string]struct {
 		httpMethod string
@@ -72,6 +137,24 @@ func TestHandler(t *testing.T) {
 			wantStatus: http.StatusCreated,
 			wantBody:   "ADDED 1\n",
 		},
+		"InsertOneIgnoreDuplicate": {
+			httpMethod: http.MethodPost,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz?on_conflict=ignore",
+			mockStore: StoreTestingStub{
+				insertIgnore: func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, []string, error) {
+					return 0, []string{"kernel.tar.gz"}, nil
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "ADDED 0\n",
+		},
+		"InsertOneBadOnConflict": {
+			httpMethod: http.MethodPost,
+			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz?on_conflict=replace",
+			mockStore:  StoreTestingStub{},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "For query arg on_conflict, \"replace\" is not one of \"error\" or \"ignore\"\n",
+		},
 		"UnknownMethod": {
 			httpMethod: "BLARG",
 			endpoint:   "/iidy/v1/lists/downloads/kernel.tar.gz",
@@ -184,6 +267,7 @@ func TestBatchPostHandler(t *testing.T) {
 		mockStore      StoreTestingStub
 		body           []byte
 		expectAfterAdd string
+		expectedStatus int
 		expected       []pgstore.ListEntry
 	}{
 		{
@@ -197,11 +281,12 @@ func TestBatchPostHandler(t *testing.T) {
 vim.tar.gz
 robots.txt`),
 			expectAfterAdd: "ADDED 3\n",
+			expectedStatus: http.StatusCreated,
 			// remember, these come back in alphabetical order
 			expected: []pgstore.ListEntry{
-				{"kernel.tar.gz", 0},
-				{"robots.txt", 0},
-				{"vim.tar.gz", 0},
+				{Item: "kernel.tar.gz", Attempts: 0},
+				{Item: "robots.txt", Attempts: 0},
+				{Item: "vim.tar.gz", Attempts: 0},
 			},
 		},
 		{
@@ -214,11 +299,12 @@ robots.txt`),
 			body: []byte(`{ "items": ["kernel.tar.gz", "vim.tar.gz", "robots.txt"] }`),
 			expectAfterAdd: `{"added":3}
 `,
+			expectedStatus: http.StatusCreated,
 			// remember, these come back in alphabetical order
 			expected: []pgstore.ListEntry{
-				{"kernel.tar.gz", 0},
-				{"robots.txt", 0},
-				{"vim.tar.gz", 0},
+				{Item: "kernel.tar.gz", Attempts: 0},
+				{Item: "robots.txt", Attempts: 0},
+				{Item: "vim.tar.gz", Attempts: 0},
 			},
 		},
 		{
@@ -230,6 +316,7 @@ robots.txt`),
 			},
 			body:           nil,
 			expectAfterAdd: "ADDED 0\n",
+			expectedStatus: http.StatusOK,
 			// remember, these come back in alphabetical order
 			expected: []pgstore.ListEntry{},
 		},
@@ -243,9 +330,36 @@ robots.txt`),
 			body: nil,
 			expectAfterAdd: `{"added":0}
 `,
+			expectedStatus: http.StatusOK,
 			// remember, these come back in alphabetical order
 			expected: []pgstore.ListEntry{},
 		},
+		{
+			mime: "application/json",
+			mockStore: StoreTestingStub{
+				insertEntries: func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error) {
+					want := []pgstore.ListEntry{
+						{Item: "kernel.tar.gz", Attempts: 2},
+						{Item: "vim.tar.gz", Attempts: 0},
+						{Item: "robots.txt", Attempts: 0},
+					}
+					if !reflect.DeepEqual(entries, want) {
+						return 0, fmt.Errorf("got entries %v want %v", entries, want)
+					}
+					return 3, nil
+				},
+			},
+			body: []byte(`{ "items": [{"item": "kernel.tar.gz", "attempts": 2}, {"item": "vim.tar.gz"}, "robots.txt"] }`),
+			expectAfterAdd: `{"added":3}
+`,
+			expectedStatus: http.StatusCreated,
+			// remember, these come back in alphabetical order
+			expected: []pgstore.ListEntry{
+				{Item: "kernel.tar.gz", Attempts: 2},
+				{Item: "robots.txt", Attempts: 0},
+				{Item: "vim.tar.gz", Attempts: 0},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -259,8 +373,11 @@ robots.txt`),
 		rr := httptest.NewRecorder()
 		handler := http.Handler(h)
 		handler.ServeHTTP(rr, req)
-		if status := rr.Code; status != http.StatusCreated {
-			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		if status := rr.Code; status != test.expectedStatus {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, test.expectedStatus)
+		}
+		if status := rr.Code; status == http.StatusCreated && rr.Header().Get("Location") != "/iidy/v1/batch/lists/downloads" {
+			t.Errorf("handler returned wrong Location: got %q", rr.Header().Get("Location"))
 		}
 		if rr.Body.String() != test.expectAfterAdd {
 			t.Errorf(`Unexpected body: got "%v" want "%v"`, rr.Body.String(), test.expectAfterAdd)
@@ -268,6 +385,77 @@ robots.txt`),
 	}
 }
 
+func TestBatchPostIgnoreDuplicatesHandler(t *testing.T) {
+	mockStore := StoreTestingStub{
+		insertIgnore: func(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, []string, error) {
+			return 1, []string{"vim.tar.gz", "robots.txt"}, nil
+		},
+	}
+	var tests = []struct {
+		name     string
+		mime     string
+		query    string
+		body     []byte
+		expected string
+	}{
+		{
+			name:  "text",
+			mime:  "text/plain",
+			query: "?on_conflict=ignore",
+			body: []byte(`kernel.tar.gz
+vim.tar.gz
+robots.txt`),
+			expected: "ADDED 1\n",
+		},
+		{
+			name:  "text detail",
+			mime:  "text/plain",
+			query: "?on_conflict=ignore&detail=full",
+			body: []byte(`kernel.tar.gz
+vim.tar.gz
+robots.txt`),
+			expected: "ADDED 1\nvim.tar.gz duplicate\nrobots.txt duplicate\n",
+		},
+		{
+			name:  "JSON detail",
+			mime:  "application/json",
+			query: "?on_conflict=ignore&detail=full",
+			body:  []byte(`{ "items": ["kernel.tar.gz", "vim.tar.gz", "robots.txt"] }`),
+			expected: `{"added":1,"skipped":["vim.tar.gz","robots.txt"]}
+`,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads"+test.query, bytes.NewBuffer(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.mime)
+		rr := httptest.NewRecorder()
+		h := &Handler{Store: mockStore}
+		handler := http.Handler(h)
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, status, http.StatusCreated)
+		}
+		if rr.Body.String() != test.expected {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v", test.name, rr.Body.String(), test.expected)
+		}
+	}
+
+	// Only "error" and "ignore" are understood.
+	req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads?on_conflict=overwrite", bytes.NewBufferString("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h := &Handler{Store: mockStore}
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
 func TestBatchGetHandler(t *testing.T) {
 	// Order of these tests matters. We set up state and go through in order.
 	var tests = []struct {
@@ -280,7 +468,7 @@ func TestBatchGetHandler(t *testing.T) {
 		{
 			afterItem: "",
 			want:      "a 0\nb 0\n",
-			wantJSON: `{"listentries":[{"item":"a","attempts":0},{"item":"b","attempts":0}]}
+			wantJSON: `{"listentries":[{"item":"a","attempts":0},{"item":"b","attempts":0}],"exhausted":true}
 `,
 			lastItem: "b",
 			mockStore: StoreTestingStub{
@@ -295,7 +483,7 @@ func TestBatchGetHandler(t *testing.T) {
 		{
 			afterItem: "b",
 			want:      "c 0\nd 0\n",
-			wantJSON: `{"listentries":[{"item":"c","attempts":0},{"item":"d","attempts":0}]}
+			wantJSON: `{"listentries":[{"item":"c","attempts":0},{"item":"d","attempts":0}],"exhausted":true}
 `,
 			lastItem: "d",
 			mockStore: StoreTestingStub{
@@ -361,8 +549,237 @@ func TestBatchGetHandlerError(t *testing.T) {
 	h := &Handler{Store: mockStore}
 	handler := http.Handler(h)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+}
+
+func TestBatchGetPageSize(t *testing.T) {
+	var gotCount int
+	mockStore := StoreTestingStub{
+		getBatch: func(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error) {
+			gotCount = count
+			return []pgstore.ListEntry{}, nil
+		},
+	}
+	// The store is asked for one more than the page size,
+	// to tell if there is another page.
+	tests := []struct {
+		url       string
+		h         *Handler
+		wantCode  int
+		wantCount int
+	}{
+		{url: "/iidy/v1/batch/lists/downloads", h: &Handler{Store: mockStore}, wantCode: http.StatusNoContent, wantCount: DefaultPageSize + 1},
+		{url: "/iidy/v1/batch/lists/downloads", h: &Handler{Store: mockStore, DefaultCount: 7}, wantCode: http.StatusNoContent, wantCount: 7 + 1},
+		{url: "/iidy/v1/batch/lists/downloads", h: &Handler{Store: mockStore, MaxCount: 50}, wantCode: http.StatusNoContent, wantCount: 50 + 1},
+		{url: "/iidy/v1/batch/lists/downloads?count=50", h: &Handler{Store: mockStore, MaxCount: 50}, wantCode: http.StatusNoContent, wantCount: 50 + 1},
+		{url: "/iidy/v1/batch/lists/downloads?count=51", h: &Handler{Store: mockStore, MaxCount: 50}, wantCode: http.StatusBadRequest},
+		{url: "/iidy/v1/batch/lists/downloads?count=-1", h: &Handler{Store: mockStore}, wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		gotCount = 0
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		test.h.ServeHTTP(rr, req)
+		if status := rr.Code; status != test.wantCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.url, status, test.wantCode)
+		}
+		if gotCount != test.wantCount {
+			t.Errorf("%s: store was asked for %d items, want %d", test.url, gotCount, test.wantCount)
+		}
+	}
+}
+
+func TestBatchGetFromID(t *testing.T) {
+	var got pgstore.BatchQuery
+	mockStore := StoreTestingStub{
+		queryBatch: func(ctx context.Context, list string, q pgstore.BatchQuery) ([]pgstore.ListEntry, error) {
+			got = q
+			return []pgstore.ListEntry{{Item: "b"}}, nil
+		},
+	}
+	h := &Handler{Store: mockStore}
+
+	req, err := http.NewRequest("GET", "/iidy/v1/batch/lists/downloads?count=2&from_id=b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	// One more than count is asked for, to tell if there is another page.
+	want := pgstore.BatchQuery{StartID: "b", Inclusive: true, Count: 3}
+	if rr.Code != http.StatusOK || got != want {
+		t.Errorf("got status %d and query %+v; want 200 and %+v", rr.Code, got, want)
+	}
+
+	req, err = http.NewRequest("GET", "/iidy/v1/batch/lists/downloads?count=2&from_id=b&after_id=a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestBatchGetRemaining(t *testing.T) {
+	var gotQuery pgstore.BatchQuery
+	var gotEstimate bool
+	mockStore := StoreTestingStub{
+		getBatch: func(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error) {
+			return []pgstore.ListEntry{{Item: "a"}, {Item: "b"}}, nil
+		},
+		countBatch: func(ctx context.Context, list string, q pgstore.BatchQuery, estimate bool) (int64, error) {
+			gotQuery, gotEstimate = q, estimate
+			return 40, nil
+		},
+	}
+	h := &Handler{Store: mockStore}
+	req, err := http.NewRequest("GET", "/iidy/v1/batch/lists/downloads?count=2&remaining=estimate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Header().Get("X-IIDY-Remaining"); got != "40" {
+		t.Errorf("got X-IIDY-Remaining %q want %q", got, "40")
+	}
+	if want := (pgstore.BatchQuery{StartID: "b", Count: 2}); gotQuery != want || !gotEstimate {
+		t.Errorf("counted %+v (estimate %v); want %+v (estimate true)", gotQuery, gotEstimate, want)
+	}
+
+	req, err = http.NewRequest("GET", "/iidy/v1/batch/lists/downloads?count=2&remaining=lots", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestBatchGetUpdated(t *testing.T) {
+	var got pgstore.BatchQuery
+	mockStore := StoreTestingStub{
+		queryBatch: func(ctx context.Context, list string, q pgstore.BatchQuery) ([]pgstore.ListEntry, error) {
+			got = q
+			return []pgstore.ListEntry{}, nil
+		},
+	}
+	now := time.Date(2021, 11, 2, 12, 0, 0, 0, time.UTC)
+	h := &Handler{Store: mockStore, Clock: clock.NewFake(now)}
+	tests := []struct {
+		url      string
+		wantCode int
+		check    func(q pgstore.BatchQuery) bool
+	}{
+		{
+			url:      "/iidy/v1/batch/lists/downloads?order=oldest_first&updated_before=2021-11-01T00:00:00Z",
+			wantCode: http.StatusNoContent,
+			check: func(q pgstore.BatchQuery) bool {
+				return q.OldestFirst && q.UpdatedBefore.Equal(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC))
+			},
+		},
+		{
+			url:      "/iidy/v1/batch/lists/downloads?updated_after=24h",
+			wantCode: http.StatusNoContent,
+			check: func(q pgstore.BatchQuery) bool {
+				return !q.OldestFirst && q.UpdatedAfter.Equal(now.Add(-24*time.Hour))
+			},
+		},
+		{url: "/iidy/v1/batch/lists/downloads?updated_after=yesterday", wantCode: http.StatusBadRequest},
+		{url: "/iidy/v1/batch/lists/downloads?order=newest_first", wantCode: http.StatusBadRequest},
+		{url: "/iidy/v1/batch/lists/downloads?order=oldest_first&after_id=a", wantCode: http.StatusBadRequest},
+		{
+			url:      "/iidy/v1/batch/lists/downloads?min_attempts=3",
+			wantCode: http.StatusNoContent,
+			check: func(q pgstore.BatchQuery) bool {
+				return q.MinAttempts == 3 && q.MaxAttempts == nil
+			},
+		},
+		{
+			url:      "/iidy/v1/batch/lists/downloads?max_attempts=0",
+			wantCode: http.StatusNoContent,
+			check: func(q pgstore.BatchQuery) bool {
+				return q.MinAttempts == 0 && q.MaxAttempts != nil && *q.MaxAttempts == 0
+			},
+		},
+		{url: "/iidy/v1/batch/lists/downloads?min_attempts=-1", wantCode: http.StatusBadRequest},
+		{url: "/iidy/v1/batch/lists/downloads?max_attempts=many", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		got = pgstore.BatchQuery{}
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != test.wantCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.url, rr.Code, test.wantCode)
+		}
+		if test.check != nil && !test.check(got) {
+			t.Errorf("%s: unexpected query %+v", test.url, got)
+		}
+	}
+}
+
+func TestMultiGetHandler(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getMulti: func(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+			if !reflect.DeepEqual(items, []string{"c", "a", "typo"}) {
+				return nil, fmt.Errorf("unexpected items %v", items)
+			}
+			return []pgstore.ListEntry{
+				{Item: "a", Attempts: 2},
+				{Item: "c", Attempts: 0},
+			}, nil
+		},
+	}
+	var tests = []struct {
+		name     string
+		mime     string
+		body     []byte
+		expected string
+	}{
+		{
+			name: "text",
+			mime: "text/plain",
+			body: []byte(`c
+a
+typo`),
+			expected: "a 2\nc 0\n",
+		},
+		{
+			name: "JSON",
+			mime: "application/json",
+			body: []byte(`{ "items": ["c", "a", "typo"] }`),
+			expected: `{"listentries":[{"item":"a","attempts":2},{"item":"c","attempts":0}]}
+`,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, "/iidy/v1/multiget/lists/downloads", bytes.NewBuffer(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.mime)
+		rr := httptest.NewRecorder()
+		h := &Handler{Store: mockStore}
+		handler := http.Handler(h)
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, status, http.StatusOK)
+		}
+		if rr.Body.String() != test.expected {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v", test.name, rr.Body.String(), test.expected)
+		}
 	}
 }
 
@@ -423,6 +840,56 @@ e`),
 	}
 }
 
+func TestBatchIncDetailHandler(t *testing.T) {
+	mockStore := StoreTestingStub{
+		incrementRet: func(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+			return []pgstore.ListEntry{
+				{Item: "c", Attempts: 1},
+				{Item: "a", Attempts: 4},
+			}, nil
+		},
+	}
+	var tests = []struct {
+		name     string
+		mime     string
+		body     []byte
+		expected string
+	}{
+		{
+			name: "text",
+			mime: "text/plain",
+			body: []byte(`a
+b
+c`),
+			expected: "INCREMENTED 2\na 4\nc 1\nb not_found\n",
+		},
+		{
+			name: "JSON",
+			mime: "application/json",
+			body: []byte(`{ "items": ["a", "b", "c"] }`),
+			expected: `{"incremented":2,"listentries":[{"item":"a","attempts":4},{"item":"c","attempts":1}],"not_found":["b"]}
+`,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads?action=increment&detail=full", bytes.NewBuffer(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.mime)
+		rr := httptest.NewRecorder()
+		h := &Handler{Store: mockStore}
+		handler := http.Handler(h)
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, status, http.StatusOK)
+		}
+		if rr.Body.String() != test.expected {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v", test.name, rr.Body.String(), test.expected)
+		}
+	}
+}
+
 func TestBatchIncHandlerError(t *testing.T) {
 	var tests = []struct {
 		name      string
@@ -505,6 +972,24 @@ e`),
 				},
 			},
 			expected: `{"deleted":5}
+`,
+		},
+		{
+			name: "NDJSON",
+			mime: "application/x-ndjson",
+			body: []byte(`"a"
+"b"
+{"item": "c"}
+`),
+			mockStore: StoreTestingStub{
+				deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+					if !reflect.DeepEqual(items, []string{"a", "b", "c"}) {
+						return 0, fmt.Errorf("unexpected items %v", items)
+					}
+					return 3, nil
+				},
+			},
+			expected: `{"deleted":3}
 `,
 		},
 	}
@@ -527,19 +1012,66 @@ e`),
 	}
 }
 
-func TestBatchDelHandlerError(t *testing.T) {
+func TestBatchDelDetailHandler(t *testing.T) {
+	mockStore := StoreTestingStub{
+		deleteBatchRet: func(ctx context.Context, list string, items []string) ([]string, error) {
+			return []string{"c", "a"}, nil
+		},
+	}
 	var tests = []struct {
-		name      string
-		mime      string
-		mockStore StoreTestingStub
-		expected  string
+		name     string
+		mime     string
+		body     []byte
+		expected string
 	}{
 		{
 			name: "text",
 			mime: "text/plain",
-			mockStore: StoreTestingStub{
-				deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
-					return 0, nil
+			body: []byte(`a
+b
+c`),
+			expected: "DELETED 2\na deleted\nc deleted\nb not_found\n",
+		},
+		{
+			name: "JSON",
+			mime: "application/json",
+			body: []byte(`{ "items": ["a", "b", "c"] }`),
+			expected: `{"deleted":2,"items":["a","c"],"not_found":["b"]}
+`,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodDelete, "/iidy/v1/batch/lists/downloads?detail=full", bytes.NewBuffer(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.mime)
+		rr := httptest.NewRecorder()
+		h := &Handler{Store: mockStore}
+		handler := http.Handler(h)
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, status, http.StatusOK)
+		}
+		if rr.Body.String() != test.expected {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v", test.name, rr.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestBatchDelHandlerError(t *testing.T) {
+	var tests = []struct {
+		name      string
+		mime      string
+		mockStore StoreTestingStub
+		expected  string
+	}{
+		{
+			name: "text",
+			mime: "text/plain",
+			mockStore: StoreTestingStub{
+				deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+					return 0, nil
 				},
 			},
 			expected: "DELETED 0\n",
@@ -575,3 +1107,983 @@ func TestBatchDelHandlerError(t *testing.T) {
 		}
 	}
 }
+
+func TestBatchQueryItemsHandler(t *testing.T) {
+	var tests = []struct {
+		name     string
+		method   string
+		url      string
+		code     int
+		expected string
+	}{
+		{
+			name:     "increment",
+			method:   http.MethodPost,
+			url:      "/iidy/v1/batch/lists/downloads?action=increment&items=a.txt,b.txt,c.txt",
+			code:     http.StatusOK,
+			expected: "INCREMENTED 3\n",
+		},
+		{
+			name:     "delete",
+			method:   http.MethodDelete,
+			url:      "/iidy/v1/batch/lists/downloads?items=a.txt,b.txt,c.txt",
+			code:     http.StatusOK,
+			expected: "DELETED 3\n",
+		},
+		{
+			name:   "too many",
+			method: http.MethodDelete,
+			url:    "/iidy/v1/batch/lists/downloads?items=" + strings.Repeat("x,", MaxQueryItems) + "x",
+			code:   http.StatusBadRequest,
+			expected: fmt.Sprintf("For query arg items, %d items is more than the maximum of %d; send them in the request body instead\n",
+				MaxQueryItems+1, MaxQueryItems),
+		},
+	}
+	checkItems := func(items []string) (int64, error) {
+		if !reflect.DeepEqual(items, []string{"a.txt", "b.txt", "c.txt"}) {
+			return 0, fmt.Errorf("unexpected items %v", items)
+		}
+		return int64(len(items)), nil
+	}
+	mockStore := StoreTestingStub{
+		incrementBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return checkItems(items)
+		},
+		deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return checkItems(items)
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		rr := httptest.NewRecorder()
+		h := &Handler{Store: mockStore}
+		h.ServeHTTP(rr, req)
+		if status := rr.Code; status != test.code {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, status, test.code)
+		}
+		if rr.Body.String() != test.expected {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v", test.name, rr.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestBatchIDHandler(t *testing.T) {
+	var storeBatchID string
+	mockStore := StoreTestingStub{
+		insertBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			storeBatchID = pgstore.BatchIDFromContext(ctx)
+			return int64(len(items)), nil
+		},
+	}
+	h := &Handler{Store: mockStore}
+	var ids []string
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads", strings.NewReader("a\nb\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		batchID := rr.Header().Get("X-IIDY-Batch-ID")
+		if batchID == "" || batchID != storeBatchID {
+			t.Errorf("got batch ID %q in response, %q in store", batchID, storeBatchID)
+		}
+		ids = append(ids, batchID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("two batches got the same batch ID: %s", ids[0])
+	}
+}
+
+type dbStatterStub struct {
+	stats *pgstore.DBStats
+}
+
+func (d dbStatterStub) DBStats(ctx context.Context) (*pgstore.DBStats, error) {
+	return d.stats, nil
+}
+
+func TestDBStatsHandler(t *testing.T) {
+	lag := int64(1500)
+	db := dbStatterStub{stats: &pgstore.DBStats{
+		Host:             "localhost",
+		Port:             5432,
+		MaxConns:         5,
+		TotalConns:       2,
+		ServerVersion:    "14.1",
+		IsReplica:        true,
+		ReplicationLagMs: &lag,
+	}}
+	h := &Handler{Store: StoreTestingStub{}, DB: db}
+
+	req := httptest.NewRequest(http.MethodGet, "/iidy/v1/admin/db", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var got pgstore.DBStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode response: %v: %s", err, rr.Body.String())
+	}
+	if !reflect.DeepEqual(&got, db.stats) {
+		t.Errorf("got %+v want %+v", got, *db.stats)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/iidy/v1/admin/db", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	for _, line := range []string{"host localhost\n", "max_conns 5\n", "is_replica true\n", "replication_lag_ms 1500\n"} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("plain text response is missing %q: %s", line, rr.Body.String())
+		}
+	}
+
+	h = &Handler{Store: StoreTestingStub{}}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/admin/db", nil))
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+type exporterStub struct {
+	err error
+}
+
+func (e exporterStub) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	io.WriteString(w, "list,item,attempts,batch_id,updated_at\n")
+	io.WriteString(w, list+",a.txt,0,,2021-01-01 00:00:00+00\n")
+	return 1, nil
+}
+
+func TestExportHandler(t *testing.T) {
+	h := &Handler{Store: StoreTestingStub{}, Exporter: exporterStub{}}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/admin/export?list=downloads", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	if got, want := rr.Header().Get("Content-Disposition"), `attachment; filename="iidy-downloads.csv"`; got != want {
+		t.Errorf("got Content-Disposition %q want %q", got, want)
+	}
+	want := "list,item,attempts,batch_id,updated_at\ndownloads,a.txt,0,,2021-01-01 00:00:00+00\n"
+	if rr.Body.String() != want {
+		t.Errorf("handler returned unexpected body: got %q want %q", rr.Body.String(), want)
+	}
+
+	h = &Handler{Store: StoreTestingStub{}, Exporter: exporterStub{err: pgstore.ErrAcquireTimeout}}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/admin/export", nil))
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	h = &Handler{Store: StoreTestingStub{}}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/admin/export", nil))
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+type chainerStub struct {
+	chains map[string]pgstore.Chain
+}
+
+func (c chainerStub) SetChain(ctx context.Context, chain pgstore.Chain) error {
+	c.chains[chain.List] = chain
+	return nil
+}
+
+func (c chainerStub) GetChain(ctx context.Context, list string) (*pgstore.Chain, error) {
+	chain, ok := c.chains[list]
+	if !ok {
+		return nil, nil
+	}
+	return &chain, nil
+}
+
+func (c chainerStub) DeleteChain(ctx context.Context, list string) (int64, error) {
+	if _, ok := c.chains[list]; !ok {
+		return 0, nil
+	}
+	delete(c.chains, list)
+	return 1, nil
+}
+
+func TestChainHandler(t *testing.T) {
+	chains := chainerStub{chains: make(map[string]pgstore.Chain)}
+	h := &Handler{Store: StoreTestingStub{}, Chains: chains}
+
+	req := httptest.NewRequest(http.MethodPost, "/iidy/v1/chains/lists/downloads", strings.NewReader(`{"next": "verify", "template": "s3://verify/{item}"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+	want := pgstore.Chain{List: "downloads", Next: "verify", Template: "s3://verify/{item}"}
+	if got := chains.chains["downloads"]; got != want {
+		t.Errorf("got chain %+v want %+v", got, want)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/iidy/v1/chains/lists/verify", strings.NewReader("ingest\n"))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	want = pgstore.Chain{List: "verify", Next: "ingest"}
+	if got := chains.chains["verify"]; got != want {
+		t.Errorf("got chain %+v want %+v", got, want)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/chains/lists/verify", nil))
+	if body := rr.Body.String(); body != "list verify\nnext ingest\ntemplate \n" {
+		t.Errorf("handler returned unexpected body: %q", body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/iidy/v1/chains/lists/ingest", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/iidy/v1/chains/lists/verify", nil))
+		if status := rr.Code; status != want {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, want)
+		}
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/chains/lists/verify", nil))
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestValidationHandler(t *testing.T) {
+	mockStore := StoreTestingStub{
+		insertOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 1, nil
+		},
+		insertBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return int64(len(items)), nil
+		},
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 0, false, &pgstore.ValidationError{Field: "item", Value: item, Reason: "not allowed by the store"}
+		},
+	}
+	h := &Handler{
+		Store:     mockStore,
+		Validator: &pgstore.Validator{MaxListLength: 10, MaxItemLength: 8, Charset: regexp.MustCompile(`^[a-z.]+$`)},
+	}
+	tests := []struct {
+		method      string
+		url         string
+		body        string
+		contentType string
+		expected    int
+		code        ErrorCode
+	}{
+		{method: http.MethodPost, url: "/iidy/v1/lists/downloads/a.txt", expected: http.StatusCreated},
+		{method: http.MethodPost, url: "/iidy/v1/lists/moredownloads/a.txt", expected: http.StatusBadRequest, code: "invalid_list"},
+		{method: http.MethodPost, url: "/iidy/v1/lists/downloads/A.TXT", expected: http.StatusBadRequest, code: "invalid_item"},
+		{method: http.MethodPost, url: "/iidy/v1/batch/lists/downloads", body: `{"items": ["a.txt", "b.txt"]}`, contentType: "application/json", expected: http.StatusCreated},
+		{method: http.MethodPost, url: "/iidy/v1/batch/lists/downloads", body: `{"items": ["a.txt", "b\u0001txt"]}`, contentType: "application/json", expected: http.StatusBadRequest, code: "invalid_item"},
+		{method: http.MethodPost, url: "/iidy/v1/batch/lists/downloads", body: `{"items": ["a.txt", "abcdefghi"]}`, contentType: "application/json", expected: http.StatusBadRequest, code: "invalid_item"},
+		{method: http.MethodGet, url: "/iidy/v1/lists/downloads/a.txt", expected: http.StatusBadRequest, code: "invalid_item"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if status := rr.Code; status != test.expected {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v: %s", test.method, test.url, status, test.expected, rr.Body.String())
+			continue
+		}
+		if test.code == "" {
+			continue
+		}
+		var e ErrorMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+			t.Fatalf("could not decode response: %v: %s", err, rr.Body.String())
+		}
+		if e.Code != test.code {
+			t.Errorf("%s %s: got error code %q want %q: %s", test.method, test.url, e.Code, test.code, e.Error)
+		}
+	}
+}
+
+func TestUnavailableHandler(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 0, false, pgstore.ErrUnavailable
+		},
+		insertOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 0, pgstore.ErrReadOnly
+		},
+		deleteOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 0, pgstore.ErrAcquireTimeout
+		},
+	}
+	h := &Handler{Store: mockStore}
+	tests := []struct {
+		method string
+		code   ErrorCode
+	}{
+		{http.MethodGet, "unavailable"},
+		{http.MethodPost, "read_only"},
+		{http.MethodDelete, "pool_timeout"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/iidy/v1/lists/downloads/a.txt", nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.method, status, http.StatusServiceUnavailable)
+		}
+		var e ErrorMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+			t.Fatalf("could not decode response: %v: %s", err, rr.Body.String())
+		}
+		if e.Code != test.code {
+			t.Errorf("%s: got error code %q want %q", test.method, e.Code, test.code)
+		}
+	}
+}
+
+func TestApplyBatchHandler(t *testing.T) {
+	var got []pgstore.ItemAction
+	mockStore := StoreTestingStub{
+		applyBatch: func(ctx context.Context, list string, actions []pgstore.ItemAction) (pgstore.ActionCounts, error) {
+			got = actions
+			return pgstore.ActionCounts{Incremented: 1, Deleted: 1}, nil
+		},
+	}
+	h := &Handler{Store: mockStore}
+	want := []pgstore.ItemAction{{Item: "a", Action: "delete"}, {Item: "b", Action: "increment"}}
+	packed, err := msgpack.Marshal([]map[string]string{{"item": "a", "action": "delete"}, {"item": "b", "action": "increment"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		contentType string
+		accept      string
+		body        string
+		expected    int
+		response    string
+	}{
+		"JSONArray": {
+			contentType: "application/json",
+			accept:      "application/json",
+			body:        `[{"item":"a","action":"delete"},{"item":"b","action":"increment"}]`,
+			expected:    http.StatusOK,
+			response:    "{\"added\":0,\"incremented\":1,\"deleted\":1}\n",
+		},
+		"JSONObject": {
+			contentType: "application/json",
+			accept:      "application/json",
+			body:        `{"items":[{"item":"a","action":"delete"},{"item":"b","action":"increment"}]}`,
+			expected:    http.StatusOK,
+			response:    "{\"added\":0,\"incremented\":1,\"deleted\":1}\n",
+		},
+		"MessagePackArray": {
+			contentType: "application/msgpack",
+			accept:      "text/plain",
+			body:        string(packed),
+			expected:    http.StatusOK,
+			response:    "ADDED 0\nINCREMENTED 1\nDELETED 1\n",
+		},
+		"PlainText": {
+			contentType: "text/plain",
+			body:        "delete a\nincrement b\n",
+			expected:    http.StatusOK,
+			response:    "ADDED 0\nINCREMENTED 1\nDELETED 1\n",
+		},
+		"NDJSON": {
+			contentType: "application/x-ndjson",
+			accept:      "text/plain",
+			body:        "{\"item\":\"a\",\"action\":\"delete\"}\n{\"item\":\"b\",\"action\":\"increment\"}\n",
+			expected:    http.StatusOK,
+			response:    "ADDED 0\nINCREMENTED 1\nDELETED 1\n",
+		},
+		"CSV": {
+			contentType: "text/csv",
+			body:        "action,item\ndelete,a\nincrement,b\n",
+			expected:    http.StatusOK,
+			response:    "ADDED 0\nINCREMENTED 1\nDELETED 1\n",
+		},
+		"BadAction": {
+			contentType: "application/json",
+			accept:      "application/json",
+			body:        `[{"item":"a","action":"frobnicate"}]`,
+			expected:    http.StatusBadRequest,
+			response:    "{\"error\":\"invalid action \\\"frobnicate\\\": for item \\\"a\\\", must be one of \\\"insert\\\", \\\"increment\\\", or \\\"delete\\\"\",\"code\":\"invalid_action\"}\n",
+		},
+		"BadPlainText": {
+			contentType: "text/plain",
+			body:        "delete\n",
+			expected:    http.StatusBadRequest,
+			response:    "Error trying to parse list of actions from request body: line 1: \"delete\" is not of the form \"<action> <item>\"\n",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, "/iidy/v1/actions/lists/downloads", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if status := rr.Code; status != test.expected {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, test.expected, rr.Body.String())
+			}
+			if rr.Body.String() != test.response {
+				t.Errorf("handler returned unexpected body: got %q want %q", rr.Body.String(), test.response)
+			}
+			if test.expected == http.StatusOK && !reflect.DeepEqual(got, want) {
+				t.Errorf("store got actions %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPutOneHandler(t *testing.T) {
+	h := &Handler{Store: memstore.NewMemStore()}
+	for _, path := range []string{"/iidy/v1/lists/downloads/a.txt", "/iidy/v2/lists/downloads/items/b.txt"} {
+		// The second PUT of each item is a no-op.
+		for i, want := range []int{http.StatusCreated, http.StatusOK} {
+			req := httptest.NewRequest(http.MethodPut, path, nil)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != want {
+				t.Errorf("PUT %d of %s: got status %d want %d: %s", i+1, path, rr.Code, want, rr.Body.String())
+			}
+			if loc := rr.Header().Get("Location"); (want == http.StatusCreated) != (loc == path) {
+				t.Errorf("PUT %d of %s: got Location %q", i+1, path, loc)
+			}
+		}
+	}
+	// PUT leaves the attempts of an item that is already there alone.
+	if _, err := h.Store.IncrementOne(context.Background(), "downloads", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/iidy/v1/lists/downloads/a.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d want %d", rr.Code, http.StatusOK)
+	}
+	if attempts, _, _ := h.Store.GetOne(context.Background(), "downloads", "a.txt"); attempts != 1 {
+		t.Errorf("got %d attempts want 1", attempts)
+	}
+}
+
+func TestGetTopHandler(t *testing.T) {
+	h := &Handler{Store: memstore.NewMemStore()}
+	entries := []pgstore.ListEntry{{Item: "a.txt", Attempts: 1}, {Item: "b.txt", Attempts: 5}, {Item: "c.txt"}, {Item: "d.txt", Attempts: 5}}
+	if _, err := h.Store.InsertBatchEntries(context.Background(), "downloads", entries); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		path     string
+		code     int
+		expected string
+	}{
+		"Default":    {path: "/iidy/v1/stats/lists/downloads/top", code: http.StatusOK, expected: "b.txt 5\nd.txt 5\na.txt 1\nc.txt 0\n"},
+		"N":          {path: "/iidy/v1/stats/lists/downloads/top?n=2", code: http.StatusOK, expected: "b.txt 5\nd.txt 5\n"},
+		"V2":         {path: "/iidy/v2/lists/downloads/top?n=1", code: http.StatusOK, expected: "{\"listentries\":[{\"item\":\"b.txt\",\"attempts\":5}]}\n"},
+		"BatchOrder": {path: "/iidy/v1/batch/lists/downloads?order=most_attempts&count=3", code: http.StatusOK, expected: "b.txt 5\nd.txt 5\na.txt 1\n"},
+		"Empty":      {path: "/iidy/v1/stats/lists/uploads/top", code: http.StatusNoContent, expected: ""},
+		"BadN":       {path: "/iidy/v1/stats/lists/downloads/top?n=0", code: http.StatusBadRequest, expected: "For query arg n, \"0\" is not a number between 1 and the maximum of 10000\n"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rr.Code != tc.code {
+				t.Errorf("got status %d want %d: %s", rr.Code, tc.code, rr.Body.String())
+			}
+			if rr.Body.String() != tc.expected {
+				t.Errorf("got body %q want %q", rr.Body.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestGetDiffHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Differ: s}
+	s.InsertBatch(context.Background(), "last_week", []string{"a.txt", "b.txt"})
+	s.InsertBatchEntries(context.Background(), "this_week", []pgstore.ListEntry{{Item: "b.txt", Attempts: 2}, {Item: "c.txt"}})
+	want := `{"item":"a.txt","diff":"only_a","attempts_a":0}
+{"item":"b.txt","diff":"attempts","attempts_a":0,"attempts_b":2}
+{"item":"c.txt","diff":"only_b","attempts_b":0}
+`
+	for _, path := range []string{"/iidy/v1/diff/lists/last_week?with=this_week", "/iidy/v2/lists/last_week/diff?with=this_week"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d want %d", path, rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("%s: got Content-Type %q", path, got)
+		}
+		if rr.Body.String() != want {
+			t.Errorf("%s: got body %q want %q", path, rr.Body.String(), want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/diff/lists/last_week", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %d want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	h = &Handler{Store: s}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/diff/lists/last_week?with=this_week", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %d want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestMergeHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Merger: s}
+	s.InsertBatchEntries(context.Background(), "all", []pgstore.ListEntry{{Item: "a.txt", Attempts: 1}})
+	s.InsertBatchEntries(context.Background(), "shard1", []pgstore.ListEntry{{Item: "a.txt", Attempts: 2}, {Item: "b.txt"}})
+	tests := []struct {
+		method   string
+		path     string
+		code     int
+		expected string
+	}{
+		{http.MethodPost, "/iidy/v1/lists/all?action=merge&from=shard1", http.StatusCreated, "ADDED 1\nUPDATED 1\n"},
+		{http.MethodPost, "/iidy/v2/lists/all/merge?from=shard1&conflict=sum", http.StatusOK, "{\"added\":0,\"updated\":1}\n"},
+		{http.MethodPost, "/iidy/v1/lists/all?action=merge&from=shard1&conflict=keep_dest", http.StatusOK, "ADDED 0\nUPDATED 0\n"},
+		{http.MethodPost, "/iidy/v1/lists/all?action=merge", http.StatusBadRequest, "Query arg from, the list to merge, is required\n"},
+		{http.MethodPost, "/iidy/v1/lists/all?action=merge&from=shard1&conflict=keep_src", http.StatusBadRequest, "Error trying to merge \"shard1\" into \"all\": invalid conflict \"keep_src\": must be one of \"keep_max\", \"keep_dest\", or \"sum\"\n"},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+	entries, _ := s.GetBatch(context.Background(), "all", "", 10)
+	want := []pgstore.ListEntry{{Item: "a.txt", Attempts: 4}, {Item: "b.txt"}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %v want %v", entries, want)
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Snapshots: s}
+	s.InsertBatchEntries(context.Background(), "work", []pgstore.ListEntry{{Item: "a.txt"}, {Item: "b.txt", Attempts: 1}})
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		// expected is the body, or, if it ends in "*", a prefix of it.
+		expected string
+		setup    func()
+	}{
+		{http.MethodPost, "/iidy/v1/snapshots/lists/work/before", http.StatusCreated, "before 2 *", nil},
+		{http.MethodPost, "/iidy/v1/snapshots/lists/work/before", http.StatusConflict, "Error trying to snapshot \"work\" as \"before\": snapshot already exists\n", nil},
+		{http.MethodGet, "/iidy/v1/snapshots/lists/work", http.StatusOK, "before 2 *", nil},
+		{http.MethodGet, "/iidy/v1/diff/lists/work?snapshot=before", http.StatusOK, "{\"item\":\"a.txt\",\"diff\":\"only_a\",\"attempts_a\":0}\n{\"item\":\"b.txt\",\"diff\":\"attempts\",\"attempts_a\":1,\"attempts_b\":2}\n{\"item\":\"c.txt\",\"diff\":\"only_b\",\"attempts_b\":0}\n", func() {
+			s.DeleteOne(context.Background(), "work", "a.txt")
+			s.InsertOne(context.Background(), "work", "c.txt")
+			s.IncrementBatch(context.Background(), "work", []string{"b.txt"})
+		}},
+		{http.MethodGet, "/iidy/v1/diff/lists/work?snapshot=after", http.StatusNotFound, "*", nil},
+		{http.MethodPost, "/iidy/v1/snapshots/lists/work/before?action=restore", http.StatusOK, "ADDED 1\nUPDATED 1\nDELETED 1\n", nil},
+		{http.MethodPost, "/iidy/v2/lists/work/snapshots/after/restore", http.StatusNotFound, "*", nil},
+		{http.MethodPut, "/iidy/v2/lists/work/snapshots/after", http.StatusCreated, "{\"label\":\"after\",\"items\":2,*", nil},
+		{http.MethodDelete, "/iidy/v1/snapshots/lists/work/before", http.StatusOK, "DELETED 1\n", nil},
+		{http.MethodDelete, "/iidy/v2/lists/work/snapshots/before", http.StatusOK, "{\"deleted\":0}\n", nil},
+	}
+	for _, tc := range tests {
+		if tc.setup != nil {
+			tc.setup()
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+		if prefix := strings.TrimSuffix(tc.expected, "*"); prefix != tc.expected {
+			if !strings.HasPrefix(rr.Body.String(), prefix) {
+				t.Errorf("%s %s: got body %q want prefix %q", tc.method, tc.path, rr.Body.String(), prefix)
+			}
+		} else if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+	entries, _ := s.GetBatch(context.Background(), "work", "", 10)
+	want := []pgstore.ListEntry{{Item: "a.txt"}, {Item: "b.txt", Attempts: 1}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %v want %v", entries, want)
+	}
+}
+
+func TestBatchPerItemHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s}
+	s.InsertOne(context.Background(), "downloads", "a")
+	tests := []struct {
+		method   string
+		path     string
+		mime     string
+		body     string
+		code     int
+		expected string
+	}{
+		{http.MethodPost, "/iidy/v1/batch/lists/downloads?detail=per_item", "text/plain", "a\nb\nc\nb", http.StatusCreated, "ADDED 2\na duplicate\nb ok\nc ok\nb duplicate\n"},
+		{http.MethodPost, "/iidy/v1/batch/lists/downloads?action=increment&detail=per_item", "application/json", `{"items": ["b", "d", "b"]}`, http.StatusOK, `{"incremented":1,"results":[{"item":"b","result":"ok"},{"item":"d","result":"not_found"},{"item":"b","result":"duplicate"}]}` + "\n"},
+		{http.MethodDelete, "/iidy/v1/batch/lists/downloads?detail=per_item", "text/plain", "c\nd", http.StatusOK, "DELETED 1\nc ok\nd not_found\n"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.mime)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestGetListsHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Lister: s}
+	s.InsertBatch(context.Background(), "uploads", []string{"a"})
+	s.InsertBatch(context.Background(), "downloads", []string{"a", "b"})
+	tests := []struct {
+		path     string
+		mime     string
+		expected string
+	}{
+		{"/iidy/v1/lists", "text/plain", "downloads 2\nuploads 1\n"},
+		{"/iidy/v2/lists", "application/json", `{"lists":[{"list":"downloads","items":2},{"list":"uploads","items":1}]}` + "\n"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept", tc.mime)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d want %d", tc.path, rr.Code, http.StatusOK)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s: got body %q want %q", tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestTransitionHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Transitioner: s}
+	s.InsertBatch(context.Background(), "downloads", []string{"a", "b"})
+	tests := []struct {
+		method   string
+		path     string
+		code     int
+		expected string
+	}{
+		{http.MethodPost, "/iidy/v1/lists/downloads/a?action=increment", http.StatusOK, "INCREMENTED 1\n"},
+		{http.MethodGet, "/iidy/v1/batch/lists/downloads?status=in_progress", http.StatusOK, "a 1\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/a?action=complete", http.StatusOK, "STATUS done\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/a?action=fail", http.StatusConflict, "Error trying to fail list item: invalid status transition: cannot fail an item that is done\n"},
+		{http.MethodPost, "/iidy/v2/lists/downloads/items/b?action=fail", http.StatusOK, "{\"status\":\"failed\"}\n"},
+		{http.MethodPost, "/iidy/v2/lists/downloads/items/c?action=fail", http.StatusNotFound, "{\"error\":\"Not found.\",\"code\":\"item_not_found\"}\n"},
+		{http.MethodPost, "/iidy/v2/lists/downloads/items/b?action=retry", http.StatusBadRequest, "{\"error\":\"Error trying to retry list item: invalid action \\\"retry\\\": must be one of \\\"complete\\\", \\\"fail\\\", or \\\"reset\\\"\",\"code\":\"invalid_action\"}\n"},
+		{http.MethodGet, "/iidy/v1/batch/lists/downloads?status=pending", http.StatusNoContent, ""},
+		{http.MethodPost, "/iidy/v1/lists/downloads/a?action=reset", http.StatusOK, "STATUS pending\n"},
+		{http.MethodGet, "/iidy/v1/batch/lists/downloads?status=pending", http.StatusOK, "a 1\n"},
+		{http.MethodGet, "/iidy/v1/batch/lists/downloads?status=stuck", http.StatusBadRequest, "invalid status \"stuck\": must be one of \"pending\", \"in_progress\", \"done\", or \"failed\"\n"},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestAdjustAttemptsHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Attempts: s}
+	s.InsertBatchEntries(context.Background(), "downloads", []pgstore.ListEntry{{Item: "a", Attempts: 2}, {Item: "b"}, {Item: "c", Attempts: 5}})
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		code     int
+		expected string
+	}{
+		{http.MethodPost, "/iidy/v1/lists/downloads/a?action=decrement", "", http.StatusOK, "DECREMENTED 1\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/b?action=decrement", "", http.StatusOK, "DECREMENTED 0\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/c?action=set&value=3", "", http.StatusOK, "SET 1\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/d?action=set&value=3", "", http.StatusOK, "SET 0\n"},
+		{http.MethodGet, "/iidy/v1/batch/lists/downloads", "", http.StatusOK, "a 1\nb 0\nc 3\n"},
+		{http.MethodPost, "/iidy/v1/batch/lists/downloads?action=decrement", "a\nb\nc\n", http.StatusOK, "DECREMENTED 2\n"},
+		{http.MethodPut, "/iidy/v2/lists/downloads/attempts?value=7", `{"items":[{"item":"a"},{"item":"b"}]}`, http.StatusOK, "{\"set\":2}\n"},
+		{http.MethodPost, "/iidy/v2/lists/downloads/attempts/decrement", `{"items":[{"item":"a"}]}`, http.StatusOK, "{\"decremented\":1}\n"},
+		{http.MethodGet, "/iidy/v1/batch/lists/downloads", "", http.StatusOK, "a 6\nb 7\nc 2\n"},
+		{http.MethodPost, "/iidy/v1/batch/lists/downloads?action=set", "a\n", http.StatusBadRequest, "Query arg value, the attempts to set, is required\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/a?action=set&value=-1", "", http.StatusBadRequest, "For query arg value, -1 is not a number of attempts\n"},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if strings.HasPrefix(tc.body, "{") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestGetCountHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Counter: s}
+	s.InsertBatchEntries(context.Background(), "downloads", []pgstore.ListEntry{{Item: "a"}, {Item: "b", Attempts: 2}, {Item: "c"}})
+	tests := []struct {
+		path     string
+		code     int
+		expected string
+	}{
+		{"/iidy/v1/stats/lists/downloads/count", http.StatusOK, "COUNT 3\n"},
+		{"/iidy/v1/stats/lists/downloads/count?by=attempts", http.StatusOK, "COUNT 3\n0 2\n2 1\n"},
+		{"/iidy/v1/stats/lists/uploads/count", http.StatusOK, "COUNT 0\n"},
+		{"/iidy/v2/lists/downloads/count", http.StatusOK, "{\"items\":3}\n"},
+		{"/iidy/v2/lists/downloads/count?by=attempts", http.StatusOK, "{\"items\":3,\"by_attempts\":[{\"attempts\":0,\"items\":2},{\"attempts\":2,\"items\":1}]}\n"},
+		{"/iidy/v1/stats/lists/downloads/count?by=status", http.StatusBadRequest, "For query arg by, \"status\" is not \"attempts\"\n"},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rr.Code != tc.code {
+			t.Errorf("%s: got status %d want %d", tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s: got body %q want %q", tc.path, rr.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestStreamBatchHandler(t *testing.T) {
+	s := memstore.NewMemStore()
+	s.InsertBatchEntries(context.Background(), "downloads", []pgstore.ListEntry{{Item: "a"}, {Item: "b", Attempts: 2}, {Item: "c"}})
+	streaming := &Handler{Store: s, Streamer: s, MaxCount: 2}
+	gathering := &Handler{Store: s, MaxCount: 2}
+	tests := []struct {
+		h        *Handler
+		path     string
+		code     int
+		expected string
+		last     string
+	}{
+		{streaming, "/iidy/v1/batch/lists/downloads", http.StatusOK, "{\"item\":\"a\",\"attempts\":0}\n{\"item\":\"b\",\"attempts\":2}\n{\"item\":\"c\",\"attempts\":0}\n", "c"},
+		{streaming, "/iidy/v1/batch/lists/downloads?after_id=a&count=1", http.StatusOK, "{\"item\":\"b\",\"attempts\":2}\n", "b"},
+		{streaming, "/iidy/v2/lists/downloads/items?min_attempts=1", http.StatusOK, "{\"item\":\"b\",\"attempts\":2}\n", "b"},
+		{streaming, "/iidy/v1/batch/lists/downloads?after_id=c", http.StatusNoContent, "", ""},
+		{streaming, "/iidy/v2/lists/uploads/items", http.StatusOK, "", ""},
+		{streaming, "/iidy/v1/batch/lists/downloads?count=-1", http.StatusBadRequest, "{\"error\":\"For query arg count, -1 is less than 0\",\"code\":\"bad_request\"}\n", ""},
+		{streaming, "/iidy/v1/batch/lists/downloads?remaining=exact", http.StatusBadRequest, "{\"error\":\"Query arg remaining cannot be used with Accept: application/x-ndjson\",\"code\":\"bad_request\"}\n", ""},
+		{gathering, "/iidy/v1/batch/lists/downloads", http.StatusOK, "{\"item\":\"a\",\"attempts\":0}\n{\"item\":\"b\",\"attempts\":2}\n", "b"},
+		{gathering, "/iidy/v1/batch/lists/downloads?count=3", http.StatusBadRequest, "{\"error\":\"For query arg count, 3 is not between 0 and the maximum of 2\",\"code\":\"bad_request\"}\n", ""},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		tc.h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s: got status %d want %d", tc.path, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s: got body %q want %q", tc.path, rr.Body.String(), tc.expected)
+		}
+		if last := rr.Result().Trailer.Get("X-IIDY-Last-Item"); last != tc.last {
+			t.Errorf("%s: got X-IIDY-Last-Item trailer %q want %q", tc.path, last, tc.last)
+		}
+	}
+}
+
+func TestPlainTextReader(t *testing.T) {
+	bodies := []string{
+		"a\nb\nc",
+		"a\nb\nc\n",
+		"\n\n  a \n b\n\nc  \n\n",
+		"a\n\n\nb",
+		"a\n  \nb\n \n",
+		"a\r\nb\r\n",
+		"only",
+	}
+	for _, body := range bodies {
+		var got []string
+		p := newPlainTextReader(strings.NewReader(body))
+		for {
+			item, err := p.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, item)
+		}
+		want := getItemsFromPlainText([]byte(body))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q want %q", body, got, want)
+		}
+	}
+}
+
+func TestInsertStreamHandler(t *testing.T) {
+	tests := map[string]struct {
+		body     string
+		hooked   bool
+		readOnly bool
+		code     int
+		expected string
+		items    []string
+	}{
+		"Streamed":   {body: "a\nb\nc\n", code: http.StatusCreated, expected: "ADDED 3\n", items: []string{"a", "b", "c"}},
+		"Empty":      {body: "", code: http.StatusOK, expected: "ADDED 0\n"},
+		"BadItem":    {body: "a\n\nb\n", code: http.StatusBadRequest, expected: "invalid item name \"\": must not be empty\n"},
+		"TooMany":    {body: "a\nb\nc\nd\n", code: http.StatusRequestEntityTooLarge, expected: "Error trying to parse list of items from request body: more than the 3 items allowed in one request: request body too large\n"},
+		"WithHooks":  {body: "a\nb\n", hooked: true, code: http.StatusCreated, expected: "ADDED 2\n", items: []string{"a", "b"}},
+		"Duplicates": {body: "a\na\n", code: http.StatusInternalServerError, expected: "Error trying to add list items: duplicate key value violates unique constraint \"list_pk\"\n"},
+		"ReadOnly":   {body: "a\nb\n", readOnly: true, code: http.StatusServiceUnavailable, expected: "Error trying to add list items: data store is read-only\n"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			s := memstore.NewMemStore()
+			h := &Handler{Store: s, Inserter: s, MaxBatchItems: 3}
+			if tt.readOnly {
+				r := pgstore.NewReadOnlyStore(s, nil)
+				r.Forced = true
+				h.Store, h.Inserter = r, r
+			}
+			var hooked []string
+			if tt.hooked {
+				h.OnMutation(func(e MutationEvent) { hooked = e.Items })
+			}
+			req := httptest.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/downloads", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/plain")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.code {
+				t.Errorf("got status %d want %d", rr.Code, tt.code)
+			}
+			if rr.Body.String() != tt.expected {
+				t.Errorf("got body %q want %q", rr.Body.String(), tt.expected)
+			}
+			entries, _ := s.GetBatch(context.Background(), "downloads", "", 10)
+			if items := entryItems(entries); len(items) != len(tt.items) || (len(items) > 0 && !reflect.DeepEqual(items, tt.items)) {
+				t.Errorf("got items %q want %q", items, tt.items)
+			}
+			if tt.hooked && !reflect.DeepEqual(hooked, tt.items) {
+				t.Errorf("got hooked items %q want %q", hooked, tt.items)
+			}
+		})
+	}
+}
+
+func TestBatchGetNextPage(t *testing.T) {
+	s := memstore.NewMemStore()
+	s.InsertBatch(context.Background(), "downloads", []string{"a", "b", "c", "d", "e"})
+	h := &Handler{Store: s}
+	tests := []struct {
+		url       string
+		want      ListEntryMessage
+		link      string
+		exhausted string
+	}{
+		{
+			url:  "/iidy/v1/batch/lists/downloads?count=2",
+			want: ListEntryMessage{ListEntries: []pgstore.ListEntry{{Item: "a"}, {Item: "b"}}, NextAfterID: "b"},
+			link: `</iidy/v1/batch/lists/downloads?after_id=b&count=2>; rel="next"`,
+		},
+		{
+			url:  "/iidy/v2/lists/downloads/items?count=2&from_id=c&status=pending",
+			want: ListEntryMessage{ListEntries: []pgstore.ListEntry{{Item: "c"}, {Item: "d"}}, NextAfterID: "d"},
+			link: `</iidy/v2/lists/downloads/items?after_id=d&count=2&status=pending>; rel="next"`,
+		},
+		{
+			url:       "/iidy/v1/batch/lists/downloads?count=2&after_id=c",
+			want:      ListEntryMessage{ListEntries: []pgstore.ListEntry{{Item: "d"}, {Item: "e"}}, Exhausted: true},
+			exhausted: "true",
+		},
+		{
+			url:  "/iidy/v1/batch/lists/downloads?count=2&order=oldest_first",
+			want: ListEntryMessage{ListEntries: []pgstore.ListEntry{{Item: "a"}, {Item: "b"}}},
+		},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d want %d", tc.url, rr.Code, http.StatusOK)
+		}
+		var got ListEntryMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v want %+v", tc.url, got, tc.want)
+		}
+		if link := rr.Header().Get("Link"); link != tc.link {
+			t.Errorf("%s: got Link %q want %q", tc.url, link, tc.link)
+		}
+		if exhausted := rr.Header().Get("X-IIDY-Exhausted"); exhausted != tc.exhausted {
+			t.Errorf("%s: got X-IIDY-Exhausted %q want %q", tc.url, exhausted, tc.exhausted)
+		}
+	}
+}
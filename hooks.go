@@ -0,0 +1,106 @@
+package iidy
+
+import (
+	"sync"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// The kinds of MutationEvent. Completing an item by deleting it is a
+// MutationDelete; completing, failing, or resetting it by moving it to a
+// new status (see Transitioner) is a MutationTransition.
+const (
+	MutationInsert      string = "insert"
+	MutationIncrement   string = "increment"
+	MutationDecrement   string = "decrement"
+	MutationSetAttempts string = "set_attempts"
+	MutationDelete      string = "delete"
+	MutationTransition  string = "transition"
+)
+
+// MutationEvent describes a change that a request made to a list.
+type MutationEvent struct {
+	// Op is MutationInsert, MutationIncrement, MutationDecrement,
+	// MutationSetAttempts, MutationDelete, or MutationTransition.
+	Op   string
+	List string
+	// Items are the items that the request asked to change, not all of
+	// which need have been changed (an insert of an item that is already
+	// in the list, say, or a delete of one that is not).
+	Items []string
+	// Count is how many items were changed.
+	Count int64
+	// Action and Status are only set for a MutationTransition: Action
+	// is the pgstore.ActionComplete, pgstore.ActionFail, or
+	// pgstore.ActionReset done to the item, and Status the status
+	// that it moved the item to.
+	Action string
+	Status string
+}
+
+// mutationHooks are the functions registered with Handler.OnMutation.
+type mutationHooks struct {
+	mu    sync.RWMutex
+	hooks []func(MutationEvent)
+}
+
+// OnMutation registers f to be called after every request that changes a
+// list (by inserting, incrementing, deleting, or transitioning items,
+// whether one at a time, in batches, or as actions), so that embedders can attach side
+// effects of their own, such as invalidating a cache. Requests that
+// change nothing do not call f.
+//
+// f is called with the change made, before the response is written,
+// in the goroutine handling the request, so it should be quick; a slow
+// f slows every change down. Functions are called in the order they were
+// registered. OnMutation can be called while h is serving requests.
+func (h *Handler) OnMutation(f func(MutationEvent)) {
+	h.hooks.mu.Lock()
+	defer h.hooks.mu.Unlock()
+	h.hooks.hooks = append(h.hooks.hooks, f)
+}
+
+// mutated tells the functions registered with OnMutation that the
+// request op on items in list changed count of them.
+func (h *Handler) mutated(op string, list string, items []string, count int64) {
+	if count == 0 {
+		return
+	}
+	h.hooks.mu.RLock()
+	hooks := h.hooks.hooks
+	h.hooks.mu.RUnlock()
+	for _, f := range hooks {
+		f(MutationEvent{Op: op, List: list, Items: items, Count: count})
+	}
+}
+
+// transitioned tells the functions registered with OnMutation that
+// action moved item in list to status.
+func (h *Handler) transitioned(list string, item string, action string, status string) {
+	h.hooks.mu.RLock()
+	hooks := h.hooks.hooks
+	h.hooks.mu.RUnlock()
+	for _, f := range hooks {
+		f(MutationEvent{Op: MutationTransition, List: list, Items: []string{item}, Count: 1, Action: action, Status: status})
+	}
+}
+
+// hasMutationHooks tells if any functions are registered with OnMutation.
+func (h *Handler) hasMutationHooks() bool {
+	h.hooks.mu.RLock()
+	defer h.hooks.mu.RUnlock()
+	return len(h.hooks.hooks) > 0
+}
+
+// mutatedByActions tells the functions registered with OnMutation what
+// a batch of actions on list changed, as counted by counts, with one
+// event for each kind of change made.
+func (h *Handler) mutatedByActions(list string, actions []pgstore.ItemAction, counts pgstore.ActionCounts) {
+	items := make(map[string][]string)
+	for _, a := range actions {
+		items[a.Action] = append(items[a.Action], a.Item)
+	}
+	h.mutated(MutationInsert, list, items[pgstore.ActionInsert], counts.Added)
+	h.mutated(MutationIncrement, list, items[pgstore.ActionIncrement], counts.Incremented)
+	h.mutated(MutationDelete, list, items[pgstore.ActionDelete], counts.Deleted)
+}
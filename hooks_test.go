@@ -0,0 +1,61 @@
+package iidy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+func TestOnMutation(t *testing.T) {
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, Transitioner: s, Activity: NewActivity()}
+	var events []MutationEvent
+	h.OnMutation(func(e MutationEvent) {
+		events = append(events, e)
+	})
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/iidy/v1/batch/lists/downloads", "a.txt\nb.txt\n"},
+		// Changes nothing, so there is no event.
+		{http.MethodPost, "/iidy/v1/lists/downloads/c.txt?action=increment", ""},
+		{http.MethodPost, "/iidy/v1/lists/downloads/a.txt?action=increment", ""},
+		{http.MethodDelete, "/iidy/v1/lists/downloads/b.txt", ""},
+		{http.MethodPost, "/iidy/v1/actions/lists/downloads", "insert c.txt\ndelete a.txt\ndelete d.txt\n"},
+		{http.MethodPost, "/iidy/v1/lists/downloads/c.txt?action=complete", ""},
+	}
+	for _, req := range requests {
+		var r *http.Request
+		if req.body == "" {
+			r = httptest.NewRequest(req.method, req.path, nil)
+		} else {
+			r = httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code >= 300 {
+			t.Fatalf("%s %s gave status %d: %s", req.method, req.path, rr.Code, rr.Body.String())
+		}
+	}
+	want := []MutationEvent{
+		{Op: MutationInsert, List: "downloads", Items: []string{"a.txt", "b.txt"}, Count: 2},
+		{Op: MutationIncrement, List: "downloads", Items: []string{"a.txt"}, Count: 1},
+		{Op: MutationDelete, List: "downloads", Items: []string{"b.txt"}, Count: 1},
+		{Op: MutationInsert, List: "downloads", Items: []string{"c.txt"}, Count: 1},
+		{Op: MutationDelete, List: "downloads", Items: []string{"a.txt", "d.txt"}, Count: 1},
+		{Op: MutationTransition, List: "downloads", Items: []string{"c.txt"}, Count: 1, Action: "complete", Status: "done"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %+v want %+v", events, want)
+	}
+	if n := h.Activity.Summarize("downloads", time.Hour).Transitioned; n != 1 {
+		t.Errorf("got %d transitioned in activity, want 1", n)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Package iidytest runs iidy in-process, for the integration tests of
+applications that use iidy.
+
+    func TestMyApp(t *testing.T) {
+        srv := iidytest.NewServer(t)
+        srv.Client.InsertBatch(context.Background(), "downloads", []string{"a.txt"})
+        app := myapp.New(srv.URL)
+        ...
+    }
+
+The server runs the full HTTP handler stack against an in-memory store,
+so there is no need for Docker, nor for a database that the tests are
+allowed to destroy. To run against PostgreSQL instead, use
+NewServerWithStore with a pgstore.PgStore.
+*/
+package iidytest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manniwood/iidy"
+	"github.com/manniwood/iidy/client"
+	"github.com/manniwood/iidy/memstore"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// Server is an iidy server running in-process.
+type Server struct {
+	// URL is the base URL of the server, such as "http://127.0.0.1:41234".
+	URL string
+	// Client is a client of the server.
+	Client *client.Client
+	// Store is the store that the server keeps its lists in, which tests
+	// can use to set up lists, or to check on them, directly.
+	Store pgstore.Store
+}
+
+// NewServer starts an iidy server backed by a new, empty, in-memory
+// store. The server is shut down when the test (and its subtests) finish.
+func NewServer(t testing.TB) *Server {
+	return NewServerWithStore(t, memstore.NewMemStore())
+}
+
+// NewServerWithStore starts an iidy server backed by s. The server is
+// shut down when the test (and its subtests) finish.
+func NewServerWithStore(t testing.TB, s pgstore.Store) *Server {
+	t.Helper()
+	ts := httptest.NewServer(iidy.NewHandler(s, nil))
+	t.Cleanup(ts.Close)
+	c := client.New(ts.URL)
+	c.HTTPClient = ts.Client()
+	return &Server{
+		URL:    ts.URL,
+		Client: c,
+		Store:  s,
+	}
+}
@@ -0,0 +1,97 @@
+package iidytest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/manniwood/iidy/client"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t)
+	c := srv.Client
+	ctx := context.Background()
+
+	_, ok, err := c.GetOne(ctx, "downloads", "kernel.tar.gz")
+	if err != nil || ok {
+		t.Errorf("Expected kernel.tar.gz to be missing; got %v, %v", ok, err)
+	}
+	count, err := c.InsertOne(ctx, "downloads", "kernel.tar.gz")
+	if err != nil || count != 1 {
+		t.Errorf("Expected to add 1 item; got %d, %v", count, err)
+	}
+	_, err = c.InsertOne(ctx, "downloads", "kernel.tar.gz")
+	var e *client.Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusInternalServerError || !errors.Is(err, client.ErrDuplicateItem) {
+		t.Errorf("Expected an error adding a duplicate; got %v", err)
+	}
+	count, err = c.IncrementOne(ctx, "downloads", "kernel.tar.gz")
+	if err != nil || count != 1 {
+		t.Errorf("Expected to increment 1 item; got %d, %v", count, err)
+	}
+	attempts, ok, err := c.GetOne(ctx, "downloads", "kernel.tar.gz")
+	if err != nil || !ok || attempts != 1 {
+		t.Errorf("Expected 1 attempt; got %d, %v, %v", attempts, ok, err)
+	}
+	count, err = c.DeleteOne(ctx, "downloads", "kernel.tar.gz")
+	if err != nil || count != 1 {
+		t.Errorf("Expected to delete 1 item; got %d, %v", count, err)
+	}
+
+	count, err = c.InsertBatch(ctx, "downloads", []string{"a", "b", "c", "d"})
+	if err != nil || count != 4 {
+		t.Errorf("Expected to add 4 items; got %d, %v", count, err)
+	}
+	count, skipped, err := c.InsertBatchIgnoreDuplicates(ctx, "downloads", []pgstore.ListEntry{{Item: "d"}, {Item: "f"}})
+	if err != nil || count != 1 || !reflect.DeepEqual(skipped, []string{"d"}) {
+		t.Errorf("Expected to add 1 item and skip d; got %d, %v, %v", count, skipped, err)
+	}
+	count, err = c.InsertBatchEntries(ctx, "downloads", []pgstore.ListEntry{{Item: "e", Attempts: 2}})
+	if err != nil || count != 1 {
+		t.Errorf("Expected to add 1 item; got %d, %v", count, err)
+	}
+	count, err = c.IncrementBatch(ctx, "downloads", []string{"a", "typo"})
+	if err != nil || count != 1 {
+		t.Errorf("Expected to increment 1 item; got %d, %v", count, err)
+	}
+	entries, err := c.GetBatch(ctx, "downloads", "b", 2)
+	want := []pgstore.ListEntry{{Item: "c", Attempts: 0}, {Item: "d", Attempts: 0}}
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected %v; got %v, %v", want, entries, err)
+	}
+	page, err := c.QueryPage(ctx, "downloads", pgstore.BatchQuery{StartID: "b", Count: 2})
+	if err != nil || page.NextAfterID != "d" || page.Exhausted {
+		t.Errorf("Expected a next page after d; got %+v, %v", page, err)
+	}
+	page, err = c.QueryPage(ctx, "downloads", pgstore.BatchQuery{StartID: page.NextAfterID, Count: 2})
+	want = []pgstore.ListEntry{{Item: "e", Attempts: 2}, {Item: "f", Attempts: 0}}
+	if err != nil || !reflect.DeepEqual(page.ListEntries, want) || page.NextAfterID != "" || !page.Exhausted {
+		t.Errorf("Expected a last page of %v; got %+v, %v", want, page, err)
+	}
+	var streamed []pgstore.ListEntry
+	count, err = c.StreamBatch(ctx, "downloads", pgstore.BatchQuery{StartID: "c"}, func(e pgstore.ListEntry) error {
+		streamed = append(streamed, e)
+		return nil
+	})
+	want = []pgstore.ListEntry{{Item: "d", Attempts: 0}, {Item: "e", Attempts: 2}, {Item: "f", Attempts: 0}}
+	if err != nil || count != 3 || !reflect.DeepEqual(streamed, want) {
+		t.Errorf("Expected to stream %v; got %d, %v, %v", want, count, streamed, err)
+	}
+	entries, err = c.GetMulti(ctx, "downloads", []string{"e", "a", "typo"})
+	want = []pgstore.ListEntry{{Item: "a", Attempts: 1}, {Item: "e", Attempts: 2}}
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected %v; got %v, %v", want, entries, err)
+	}
+	count, err = c.DeleteBatch(ctx, "downloads", []string{"a", "b", "c", "d", "e", "f"})
+	if err != nil || count != 6 {
+		t.Errorf("Expected to delete 6 items; got %d, %v", count, err)
+	}
+	entries, err = c.GetBatch(ctx, "downloads", "", 10)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty list; got %v, %v", entries, err)
+	}
+}
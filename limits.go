@@ -0,0 +1,87 @@
+package iidy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DefaultMaxBodyBytes is the most bytes a request body can hold (after
+// it is decompressed), unless Handler.MaxBodyBytes says otherwise.
+const DefaultMaxBodyBytes int64 = 64 << 20
+
+// DefaultMaxBatchItems is the most items that one request can add,
+// get, increment, or delete, unless Handler.MaxBatchItems says otherwise.
+const DefaultMaxBatchItems int = 100000
+
+// ErrBodyTooLarge is given when reading a request body that is bigger
+// than its Handler allows.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// maxBodyBytes gives the most bytes a request body can hold.
+func (h *Handler) maxBodyBytes() int64 {
+	if h.MaxBodyBytes == 0 {
+		return DefaultMaxBodyBytes
+	}
+	return h.MaxBodyBytes
+}
+
+// maxBatchItems gives the most items that one request can name.
+func (h *Handler) maxBatchItems() int {
+	if h.MaxBatchItems == 0 {
+		return DefaultMaxBatchItems
+	}
+	return h.MaxBatchItems
+}
+
+// limitBody makes r's body give ErrBodyTooLarge once more than h allows
+// has been read from it. If r says up front that its body is too large,
+// a status of 413 is given, and false is returned.
+func (h *Handler) limitBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > h.maxBodyBytes() {
+		errStr := fmt.Sprintf("A body of %d bytes is more than the %d allowed.", r.ContentLength, h.maxBodyBytes())
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = &limitedBody{ReadCloser: r.Body, left: h.maxBodyBytes()}
+	return true
+}
+
+// limitedBody reads from a request body until more than left bytes
+// have been read, when it gives ErrBodyTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.left {
+		n = int(b.left)
+		b.left = -1
+		return n, ErrBodyTooLarge
+	}
+	b.left -= int64(n)
+	return n, err
+}
+
+// printBodyError prints an error from reading the request body, or
+// parsing it, which gives a status of 413 if the body was too large,
+// and of 400 otherwise.
+func printBodyError(w http.ResponseWriter, r *http.Request, errStr string, err error) {
+	if errors.Is(err, ErrBodyTooLarge) {
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusRequestEntityTooLarge)
+		return
+	}
+	printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+}
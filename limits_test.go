@@ -0,0 +1,87 @@
+package iidy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+func TestBodyLimits(t *testing.T) {
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(strings.Repeat("a", 100) + "\n"))
+	gw.Close()
+
+	s := memstore.NewMemStore()
+	h := &Handler{Store: s, MaxBodyBytes: 64, MaxBatchItems: 3}
+	tests := map[string]struct {
+		body            string
+		contentType     string
+		contentEncoding string
+		chunked         bool
+		code            int
+		expected        string
+	}{
+		"UnderLimits": {
+			body:     "a\nb\nc\n",
+			code:     http.StatusCreated,
+			expected: "ADDED 3\n",
+		},
+		"TooManyItems": {
+			body:     "a\nb\nc\nd\n",
+			code:     http.StatusRequestEntityTooLarge,
+			expected: "4 items are more than the 3 allowed in one request.\n",
+		},
+		"ContentLength": {
+			body:     strings.Repeat("a", 65) + "\n",
+			code:     http.StatusRequestEntityTooLarge,
+			expected: "A body of 66 bytes is more than the 64 allowed.\n",
+		},
+		"Chunked": {
+			body:     strings.Repeat("a", 65) + "\n",
+			chunked:  true,
+			code:     http.StatusRequestEntityTooLarge,
+			expected: "Error reading body: request body too large\n",
+		},
+		"Decompressed": {
+			body:            gzipped.String(),
+			contentEncoding: "gzip",
+			code:            http.StatusRequestEntityTooLarge,
+			expected:        "Error reading body: request body too large\n",
+		},
+		"NDJSON": {
+			body:        strings.Repeat(`{"item":"a"}`+"\n", 6),
+			contentType: "application/x-ndjson",
+			chunked:     true,
+			code:        http.StatusRequestEntityTooLarge,
+			expected:    "{\"error\":\"Error trying to parse list of items from request body: line 6: request body too large\",\"code\":\"too_large\"}\n",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/iidy/v1/batch/lists/"+strings.ToLower(name), strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Errorf("got status %d want %d", rr.Code, tc.code)
+			}
+			if rr.Body.String() != tc.expected {
+				t.Errorf("got body %q want %q", rr.Body.String(), tc.expected)
+			}
+		})
+	}
+}
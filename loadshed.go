@@ -0,0 +1,122 @@
+package iidy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoadShedder is middleware that lets at most MaxInFlight requests through
+// to the handler it wraps at a time, so that they do not all pile up
+// waiting for a database connection. Up to MaxQueue more requests wait
+// their turn, for at most QueueTimeout; any others get a status of 503,
+// with an error code of "overloaded", and a Retry-After header of
+// RetryAfter, so that well-behaved clients back off.
+//
+// LoadShedder satisfies expvar.Var, so it can be published with
+// expvar.Publish.
+type LoadShedder struct {
+	Next         http.Handler
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
+	// RetryAfter is rounded up to whole seconds for the Retry-After header.
+	RetryAfter time.Duration
+
+	mu       sync.Mutex
+	slots    chan struct{}
+	queued   int
+	shed     int64
+	accepted int64
+}
+
+// NewLoadShedder constructs a new LoadShedder that lets maxInFlight
+// requests at a time through to next, with up to maxQueue more waiting
+// for up to queueTimeout. Shed requests are told to retry after a second.
+func NewLoadShedder(next http.Handler, maxInFlight int, maxQueue int, queueTimeout time.Duration) *LoadShedder {
+	return &LoadShedder{
+		Next:         next,
+		MaxInFlight:  maxInFlight,
+		MaxQueue:     maxQueue,
+		QueueTimeout: queueTimeout,
+		RetryAfter:   time.Second,
+		slots:        make(chan struct{}, maxInFlight),
+	}
+}
+
+func (l *LoadShedder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !l.acquire(r) {
+		l.mu.Lock()
+		l.shed++
+		l.mu.Unlock()
+		seconds := int((l.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		r = acceptHeaderToContext(contentTypeHeaderToContext(r))
+		printError(w, r, &ErrorMessage{Error: "Too many requests are waiting; try again later.", Code: CodeOverloaded}, http.StatusServiceUnavailable)
+		return
+	}
+	defer func() { <-l.slots }()
+	l.Next.ServeHTTP(w, r)
+}
+
+// acquire gets r a slot to run in, waiting in the queue if need be.
+// If the queue is full, or the wait is too long, false is returned.
+func (l *LoadShedder) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		l.countAccepted()
+		return true
+	default:
+	}
+	l.mu.Lock()
+	if l.queued >= l.MaxQueue {
+		l.mu.Unlock()
+		return false
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.countAccepted()
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// countAccepted counts a request that got a slot.
+func (l *LoadShedder) countAccepted() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepted++
+}
+
+// String satisfies expvar.Var, giving the number of requests in flight,
+// and waiting in the queue, now, and the number of requests accepted and
+// shed so far, as JSON.
+func (l *LoadShedder) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, err := json.Marshal(struct {
+		InFlight int   `json:"in_flight"`
+		Queued   int   `json:"queued"`
+		Accepted int64 `json:"accepted"`
+		Shed     int64 `json:"shed"`
+	}{len(l.slots), l.queued, l.accepted, l.shed})
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
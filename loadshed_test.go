@@ -0,0 +1,70 @@
+package iidy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadShedder(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	l := NewLoadShedder(slow, 1, 1, time.Hour)
+
+	// The first request takes the only slot,
+	// and the second waits in the queue.
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			l.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a.txt", nil))
+			done <- rr.Code
+		}()
+	}
+	<-started
+	for {
+		l.mu.Lock()
+		queued := l.queued
+		l.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so the third is shed.
+	rr := httptest.NewRecorder()
+	l.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a.txt", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q want %q", got, "1")
+	}
+	if want := `{"in_flight":1,"queued":1,"accepted":1,"shed":1}`; l.String() != want {
+		t.Errorf("got %s want %s", l.String(), want)
+	}
+
+	close(release)
+	<-started
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("got status %d want %d", code, http.StatusOK)
+		}
+	}
+}
+
+func TestLoadShedderTimeout(t *testing.T) {
+	l := NewLoadShedder(http.NotFoundHandler(), 1, 1, time.Millisecond)
+	l.slots <- struct{}{}
+	rr := httptest.NewRecorder()
+	l.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a.txt", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
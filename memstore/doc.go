@@ -0,0 +1,14 @@
+/*
+Package memstore is an in-memory checklist or "attempt list".
+
+MemStore implements the same pgstore.Store interface as pgstore.PgStore,
+and behaves the same way, but keeps its lists in memory. It is meant for
+tests (see package iidytest) and for trying iidy out without PostgreSQL;
+its lists do not survive the process.
+
+    s := memstore.NewMemStore()
+    s.InsertBatch(context.Background(), "downloads", []string{"a.txt", "b.txt"})
+    // gets "a.txt", "b.txt"
+    items, _ := s.GetBatch(context.Background(), "downloads", "", 10)
+*/
+package memstore
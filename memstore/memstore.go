@@ -0,0 +1,818 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// ErrDuplicate is returned when inserting an item that is already
+// in a list, just as PostgreSQL would complain. Like the PgStore's,
+// it matches pgstore.ErrDuplicate.
+var ErrDuplicate = &pgstore.DuplicateError{Message: `duplicate key value violates unique constraint "list_pk"`}
+
+// MemStore keeps lists in memory. It is safe for concurrent use.
+type MemStore struct {
+	// Clock tells the store the time, so that tests can
+	// simulate the passing of time with a clock.Fake.
+	Clock clock.Clock
+	// RetryDelays, if not nil, says how long, per list, an item whose
+	// attempts are incremented waits before it is ready again.
+	RetryDelays *pgstore.RetryDelays
+	mu          sync.Mutex
+	lists       map[string]map[string]entry
+	// snapshots are the snapshots of each list, by label.
+	snapshots map[string]map[string]snapshot
+}
+
+// snapshot is a copy of a list's attempts, by item.
+type snapshot struct {
+	attempts map[string]int
+	created  time.Time
+}
+
+// entry is what MemStore knows about an item.
+type entry struct {
+	attempts int
+	// updated is when the item was inserted, or its attempts
+	// last changed.
+	updated time.Time
+	// notBefore, if not zero, is when the item is ready again
+	// after its attempts were last incremented.
+	notBefore time.Time
+	// status is the item's status, or "" for pgstore.StatusPending.
+	status string
+}
+
+// NewMemStore returns a pointer to a new, empty, MemStore,
+// which uses the system clock.
+func NewMemStore() *MemStore {
+	return &MemStore{Clock: clock.System, lists: make(map[string]map[string]entry)}
+}
+
+// now gives the time according to m's Clock.
+func (m *MemStore) now() time.Time {
+	return clock.OrSystem(m.Clock).Now()
+}
+
+// increment increments the attempts of an item that is in l,
+// which is the named list.
+func (m *MemStore) increment(list string, l map[string]entry, item string) {
+	e := l[item]
+	e.attempts++
+	e.status = pgstore.StatusInProgress
+	e.updated = m.now()
+	e.notBefore = time.Time{}
+	if delay := m.RetryDelays.For(list).After(e.attempts); delay > 0 {
+		e.notBefore = e.updated.Add(delay)
+	}
+	l[item] = e
+}
+
+// String describes the store, like PgStore's String does.
+func (m *MemStore) String() string {
+	return "\nIn memory\n"
+}
+
+// list gets the named list, creating it if need be.
+// The caller must hold m.mu.
+func (m *MemStore) list(list string) map[string]entry {
+	l, ok := m.lists[list]
+	if !ok {
+		l = make(map[string]entry)
+		m.lists[list] = l
+	}
+	return l
+}
+
+// hasAttempts tells us if attempts holds n.
+func hasAttempts(attempts []int, n int) bool {
+	for _, a := range attempts {
+		if a == n {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertOne inserts an item into a list. If the list does not already
+// exist, it will be created. The number of attempts is set to 0.
+func (m *MemStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.list(list)
+	if _, ok := l[item]; ok {
+		return 0, ErrDuplicate
+	}
+	l[item] = entry{updated: m.now()}
+	return 1, nil
+}
+
+// GetOne returns the number of attempts that were made to complete an item
+// in a list. When a list or list item is missing, the second return value
+// is false.
+func (m *MemStore) GetOne(ctx context.Context, list string, item string) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lists[list][item]
+	return e.attempts, ok, nil
+}
+
+// DeleteOne removes an item from a list. The first return value is the
+// number of items deleted (1 or 0).
+func (m *MemStore) DeleteOne(ctx context.Context, list string, item string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lists[list][item]; !ok {
+		return 0, nil
+	}
+	delete(m.lists[list], item)
+	return 1, nil
+}
+
+// DeleteOneIfAttempts is like DeleteOne, but it only deletes the item
+// if its number of attempts is one of attempts.
+func (m *MemStore) DeleteOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lists[list][item]
+	if !ok || !hasAttempts(attempts, e.attempts) {
+		return 0, nil
+	}
+	delete(m.lists[list], item)
+	return 1, nil
+}
+
+// IncrementOne increments the number of attempts for an item in a list.
+// The first return value is the number of items incremented (1 or 0).
+func (m *MemStore) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lists[list][item]; !ok {
+		return 0, nil
+	}
+	m.increment(list, m.lists[list], item)
+	return 1, nil
+}
+
+// IncrementOneIfAttempts is like IncrementOne, but it only increments
+// the item if its number of attempts is one of attempts.
+func (m *MemStore) IncrementOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lists[list][item]
+	if !ok || !hasAttempts(attempts, e.attempts) {
+		return 0, nil
+	}
+	m.increment(list, m.lists[list], item)
+	return 1, nil
+}
+
+// InsertBatch adds a slice of items to the specified list, and sets
+// their attempts to 0. Like PgStore's COPY, it is all or nothing: if any
+// item is already in the list (or repeated), nothing is inserted.
+func (m *MemStore) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
+	entries := make([]pgstore.ListEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, pgstore.ListEntry{Item: item})
+	}
+	return m.InsertBatchEntries(ctx, list, entries)
+}
+
+// InsertBatchEntries adds a slice of ListEntries to the specified list,
+// with the attempts given in each entry. Like InsertBatch, it is all or
+// nothing.
+func (m *MemStore) InsertBatchEntries(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.list(list)
+	seen := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		if e.Attempts < 0 {
+			return 0, fmt.Errorf("attempts for item %q cannot be negative: %d", e.Item, e.Attempts)
+		}
+		if _, ok := l[e.Item]; ok {
+			return 0, ErrDuplicate
+		}
+		if _, ok := seen[e.Item]; ok {
+			return 0, ErrDuplicate
+		}
+		seen[e.Item] = struct{}{}
+	}
+	now := m.now()
+	for _, e := range entries {
+		l[e.Item] = entry{attempts: e.Attempts, updated: now}
+	}
+	return int64(len(entries)), nil
+}
+
+// InsertBatchIgnoreDuplicates is like InsertBatchEntries, except that
+// entries whose items are already in the list are skipped. The second
+// return value holds the skipped items, in the order they were given.
+func (m *MemStore) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []pgstore.ListEntry) (int64, []string, error) {
+	if len(entries) == 0 {
+		return 0, nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range entries {
+		if e.Attempts < 0 {
+			return 0, nil, fmt.Errorf("attempts for item %q cannot be negative: %d", e.Item, e.Attempts)
+		}
+	}
+	l := m.list(list)
+	now := m.now()
+	var count int64
+	skipped := make([]string, 0)
+	for _, e := range entries {
+		if _, ok := l[e.Item]; ok {
+			skipped = append(skipped, e.Item)
+			continue
+		}
+		l[e.Item] = entry{attempts: e.Attempts, updated: now}
+		count++
+	}
+	return count, skipped, nil
+}
+
+// GetBatch gets up to count ListEntries from the specified list
+// (alphabetically sorted), starting after startID, or from the beginning
+// of the list, if startID is an empty string.
+func (m *MemStore) GetBatch(ctx context.Context, list string, startID string, count int) ([]pgstore.ListEntry, error) {
+	return m.QueryBatch(ctx, list, pgstore.BatchQuery{StartID: startID, Count: count})
+}
+
+// QueryBatch is like GetBatch, with the options of a pgstore.BatchQuery.
+func (m *MemStore) QueryBatch(ctx context.Context, list string, q pgstore.BatchQuery) ([]pgstore.ListEntry, error) {
+	if err := pgstore.ValidateStatus(q.Status); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lists[list]
+	var items []string
+	for item, e := range l {
+		if matchesBatchQuery(item, e, q, m.now()) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if q.OldestFirst && !l[items[i]].updated.Equal(l[items[j]].updated) {
+			return l[items[i]].updated.Before(l[items[j]].updated)
+		}
+		if q.MostAttemptsFirst && !q.OldestFirst && l[items[i]].attempts != l[items[j]].attempts {
+			return l[items[i]].attempts > l[items[j]].attempts
+		}
+		return items[i] < items[j]
+	})
+	if len(items) > q.Count {
+		items = items[:q.Count]
+	}
+	result := make([]pgstore.ListEntry, 0, len(items))
+	for _, item := range items {
+		result = append(result, pgstore.ListEntry{Item: item, Attempts: l[item].attempts})
+	}
+	return result, nil
+}
+
+// StreamBatch is like QueryBatch, calling f with each ListEntry, where
+// a q.Count of 0 means no limit. Since the entries are all in memory
+// anyway, they are gathered first, so that f is not called with the
+// store locked.
+func (m *MemStore) StreamBatch(ctx context.Context, list string, q pgstore.BatchQuery, f func(pgstore.ListEntry) error) (int64, error) {
+	if q.Count == 0 {
+		q.Count = math.MaxInt32
+	}
+	entries, err := m.QueryBatch(ctx, list, q)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, e := range entries {
+		if err := f(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// InsertStream is like InsertBatch, getting its items by calling next
+// until it returns io.EOF. Since the list is all in memory anyway, the
+// items are gathered first, and then added all at once.
+func (m *MemStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	var items []string
+	for {
+		item, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		items = append(items, item)
+	}
+	return m.InsertBatch(ctx, list, items)
+}
+
+// CountBatch counts the ListEntries that QueryBatch would get if q.Count
+// were unlimited. The count is always exact, even if an estimate will do.
+func (m *MemStore) CountBatch(ctx context.Context, list string, q pgstore.BatchQuery, estimate bool) (int64, error) {
+	if err := pgstore.ValidateStatus(q.Status); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for item, e := range m.lists[list] {
+		if matchesBatchQuery(item, e, q, m.now()) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// matchesBatchQuery tells us if item, with entry e, is one of
+// the items that q describes (leaving aside q.Count) at time now.
+func matchesBatchQuery(item string, e entry, q pgstore.BatchQuery, now time.Time) bool {
+	if q.StartID != "" && (item < q.StartID || (item == q.StartID && !q.Inclusive)) {
+		return false
+	}
+	if !q.UpdatedBefore.IsZero() && !e.updated.Before(q.UpdatedBefore) {
+		return false
+	}
+	if !q.UpdatedAfter.IsZero() && !e.updated.After(q.UpdatedAfter) {
+		return false
+	}
+	if q.Ready && e.notBefore.After(now) {
+		return false
+	}
+	if q.Status != "" && q.Status != e.statusOrPending() {
+		return false
+	}
+	if e.attempts < q.MinAttempts || (q.MaxAttempts != nil && e.attempts > *q.MaxAttempts) {
+		return false
+	}
+	return true
+}
+
+// statusOrPending gives e's status, which is pgstore.StatusPending
+// if it has never been set.
+func (e entry) statusOrPending() string {
+	if e.status == "" {
+		return pgstore.StatusPending
+	}
+	return e.status
+}
+
+// Transition does action to the specified item, moving it to a new
+// status (see pgstore.PgStore.Transition).
+func (m *MemStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lists[list][item]
+	if !ok {
+		if _, err := pgstore.CanTransition(pgstore.StatusPending, action); errors.As(err, new(*pgstore.ValidationError)) {
+			return "", false, err
+		}
+		return "", false, nil
+	}
+	status, err := pgstore.CanTransition(e.statusOrPending(), action)
+	if err != nil {
+		return "", true, err
+	}
+	e.status = status
+	if status == pgstore.StatusPending {
+		e.notBefore = time.Time{}
+	}
+	m.lists[list][item] = e
+	return status, true, nil
+}
+
+// GetMulti gets the ListEntries for items from the specified list,
+// alphabetically sorted. Items that are not in the list are left out.
+func (m *MemStore) GetMulti(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lists[list]
+	seen := make(map[string]struct{}, len(items))
+	result := []pgstore.ListEntry{}
+	for _, item := range items {
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		if e, ok := l[item]; ok {
+			result = append(result, pgstore.ListEntry{Item: item, Attempts: e.attempts})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Item < result[j].Item })
+	return result, nil
+}
+
+// DeleteBatch deletes items from the specified list. The first return
+// value is the number of items deleted.
+func (m *MemStore) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
+	deleted, err := m.DeleteBatchReturning(ctx, list, items)
+	return int64(len(deleted)), err
+}
+
+// DeleteBatchReturning is like DeleteBatch, but it returns the items that
+// were deleted (alphabetically sorted) rather than how many there were.
+func (m *MemStore) DeleteBatchReturning(ctx context.Context, list string, items []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lists[list]
+	deleted := []string{}
+	for _, item := range items {
+		if _, ok := l[item]; ok {
+			delete(l, item)
+			deleted = append(deleted, item)
+		}
+	}
+	sort.Strings(deleted)
+	return deleted, nil
+}
+
+// IncrementBatch increments the attempts of items in the specified list.
+// The first return value is the number of items incremented.
+func (m *MemStore) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	entries, err := m.IncrementBatchReturning(ctx, list, items)
+	return int64(len(entries)), err
+}
+
+// IncrementBatchReturning is like IncrementBatch, but it returns the
+// incremented items' new ListEntries (alphabetically sorted) rather
+// than how many there were. An item named more than once is still
+// only incremented once, as in PgStore.
+func (m *MemStore) IncrementBatchReturning(ctx context.Context, list string, items []string) ([]pgstore.ListEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lists[list]
+	seen := make(map[string]struct{}, len(items))
+	entries := []pgstore.ListEntry{}
+	for _, item := range items {
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		if _, ok := l[item]; ok {
+			m.increment(list, l, item)
+			entries = append(entries, pgstore.ListEntry{Item: item, Attempts: l[item].attempts})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Item < entries[j].Item })
+	return entries, nil
+}
+
+// ApplyBatch does a batch of ItemActions to the specified list, in order.
+// Like PgStore's transaction, it is all or nothing: if any action fails,
+// none of them are done.
+func (m *MemStore) ApplyBatch(ctx context.Context, list string, actions []pgstore.ItemAction) (pgstore.ActionCounts, error) {
+	var counts pgstore.ActionCounts
+	if err := pgstore.ValidateActions(actions); err != nil {
+		return counts, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Work on a copy of the list, so that a failure leaves it untouched.
+	l := make(map[string]entry, len(m.lists[list]))
+	for item, e := range m.lists[list] {
+		l[item] = e
+	}
+	// As in PgStore, an item named more than once in a run of
+	// increments is only incremented once.
+	incremented := make(map[string]struct{})
+	for i, a := range actions {
+		if i > 0 && a.Action != actions[i-1].Action {
+			incremented = make(map[string]struct{})
+		}
+		_, ok := l[a.Item]
+		switch a.Action {
+		case pgstore.ActionInsert:
+			if ok {
+				return pgstore.ActionCounts{}, ErrDuplicate
+			}
+			l[a.Item] = entry{updated: m.now()}
+			counts.Added++
+		case pgstore.ActionIncrement:
+			if _, done := incremented[a.Item]; ok && !done {
+				m.increment(list, l, a.Item)
+				incremented[a.Item] = struct{}{}
+				counts.Incremented++
+			}
+		case pgstore.ActionDelete:
+			if ok {
+				delete(l, a.Item)
+				counts.Deleted++
+			}
+		}
+	}
+	m.lists[list] = l
+	return counts, nil
+}
+
+// Diff compares list a with list b, calling f, in order of item, for
+// each item that is only in a, only in b, or in both with different
+// attempts. f is called after the lists are compared, so it may use m.
+func (m *MemStore) Diff(ctx context.Context, a string, b string, f func(pgstore.DiffEntry) error) (int64, error) {
+	m.mu.Lock()
+	la := make(map[string]int, len(m.lists[a]))
+	for item, e := range m.lists[a] {
+		la[item] = e.attempts
+	}
+	lb := make(map[string]int, len(m.lists[b]))
+	for item, e := range m.lists[b] {
+		lb[item] = e.attempts
+	}
+	m.mu.Unlock()
+	return diff(la, lb, f)
+}
+
+// diff compares a and b, the attempts of two lists by item, as Diff does.
+func diff(a map[string]int, b map[string]int, f func(pgstore.DiffEntry) error) (int64, error) {
+	var diffs []pgstore.DiffEntry
+	for item, attemptsA := range a {
+		attemptsA := attemptsA
+		e := pgstore.DiffEntry{Item: item, Diff: pgstore.DiffOnlyA, AttemptsA: &attemptsA}
+		if attemptsB, ok := b[item]; ok {
+			if attemptsB == attemptsA {
+				continue
+			}
+			e.Diff, e.AttemptsB = pgstore.DiffAttempts, &attemptsB
+		}
+		diffs = append(diffs, e)
+	}
+	for item, attemptsB := range b {
+		if _, ok := a[item]; !ok {
+			attemptsB := attemptsB
+			diffs = append(diffs, pgstore.DiffEntry{Item: item, Diff: pgstore.DiffOnlyB, AttemptsB: &attemptsB})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Item < diffs[j].Item })
+	var count int64
+	for _, e := range diffs {
+		if err := f(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Merge copies every item in list src into list dest, with its
+// attempts, settling items that are in both as conflict says
+// (see pgstore.PgStore.Merge).
+func (m *MemStore) Merge(ctx context.Context, dest string, src string, conflict string) (pgstore.MergeCounts, error) {
+	if err := pgstore.ValidateMerge(dest, src, conflict); err != nil {
+		return pgstore.MergeCounts{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var counts pgstore.MergeCounts
+	ls := m.lists[src]
+	if len(ls) == 0 {
+		return counts, nil
+	}
+	ld := m.list(dest)
+	now := m.now()
+	for item, es := range ls {
+		ed, ok := ld[item]
+		if !ok {
+			ld[item] = entry{attempts: es.attempts, updated: now}
+			counts.Added++
+			continue
+		}
+		attempts := ed.attempts
+		switch conflict {
+		case pgstore.MergeKeepMax:
+			if es.attempts > attempts {
+				attempts = es.attempts
+			}
+		case pgstore.MergeSum:
+			attempts += es.attempts
+		}
+		if attempts != ed.attempts {
+			ed.attempts, ed.updated = attempts, now
+			ld[item] = ed
+			counts.Updated++
+		}
+	}
+	return counts, nil
+}
+
+// TakeSnapshot copies every item in the specified list, with its
+// attempts, into a snapshot under label (see pgstore.PgStore.TakeSnapshot).
+func (m *MemStore) TakeSnapshot(ctx context.Context, list string, label string) (*pgstore.Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.snapshots[list][label]; ok {
+		return nil, pgstore.ErrSnapshotExists
+	}
+	snap := snapshot{attempts: make(map[string]int), created: m.now()}
+	for item, e := range m.lists[list] {
+		snap.attempts[item] = e.attempts
+	}
+	if m.snapshots == nil {
+		m.snapshots = make(map[string]map[string]snapshot)
+	}
+	if m.snapshots[list] == nil {
+		m.snapshots[list] = make(map[string]snapshot)
+	}
+	m.snapshots[list][label] = snap
+	return &pgstore.Snapshot{Label: label, Items: int64(len(snap.attempts)), CreatedAt: snap.created}, nil
+}
+
+// Snapshots gets the snapshots of the specified list, oldest first.
+func (m *MemStore) Snapshots(ctx context.Context, list string) ([]pgstore.Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshots := []pgstore.Snapshot{}
+	for label, snap := range m.snapshots[list] {
+		snapshots = append(snapshots, pgstore.Snapshot{Label: label, Items: int64(len(snap.attempts)), CreatedAt: snap.created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if !snapshots[i].CreatedAt.Equal(snapshots[j].CreatedAt) {
+			return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+		}
+		return snapshots[i].Label < snapshots[j].Label
+	})
+	return snapshots, nil
+}
+
+// RestoreSnapshot puts the specified list back the way it was when the
+// snapshot under label was taken (see pgstore.PgStore.RestoreSnapshot).
+func (m *MemStore) RestoreSnapshot(ctx context.Context, list string, label string) (pgstore.RestoreCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap, ok := m.snapshots[list][label]
+	if !ok {
+		return pgstore.RestoreCounts{}, pgstore.ErrSnapshotNotFound
+	}
+	var counts pgstore.RestoreCounts
+	l := m.list(list)
+	for item := range l {
+		if _, ok := snap.attempts[item]; !ok {
+			delete(l, item)
+			counts.Deleted++
+		}
+	}
+	now := m.now()
+	for item, attempts := range snap.attempts {
+		e, ok := l[item]
+		switch {
+		case !ok:
+			l[item] = entry{attempts: attempts, updated: now}
+			counts.Added++
+		case e.attempts != attempts:
+			l[item] = entry{attempts: attempts, updated: now}
+			counts.Updated++
+		}
+	}
+	return counts, nil
+}
+
+// DeleteSnapshot deletes the snapshot of the specified list under label.
+// The first return value is the number of snapshots deleted.
+func (m *MemStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.snapshots[list][label]; !ok {
+		return 0, nil
+	}
+	delete(m.snapshots[list], label)
+	return 1, nil
+}
+
+// DiffSnapshot compares the snapshot of the specified list under label
+// with the list as it is now (see pgstore.PgStore.DiffSnapshot).
+func (m *MemStore) DiffSnapshot(ctx context.Context, list string, label string, f func(pgstore.DiffEntry) error) (int64, error) {
+	m.mu.Lock()
+	snap, ok := m.snapshots[list][label]
+	if !ok {
+		m.mu.Unlock()
+		return 0, pgstore.ErrSnapshotNotFound
+	}
+	now := make(map[string]int, len(m.lists[list]))
+	for item, e := range m.lists[list] {
+		now[item] = e.attempts
+	}
+	m.mu.Unlock()
+	return diff(snap.attempts, now, f)
+}
+
+// Lists gets the name of every list that has any items in it, along
+// with how many items it has, sorted by list.
+func (m *MemStore) Lists(ctx context.Context) ([]pgstore.ListCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lists := []pgstore.ListCount{}
+	for list, items := range m.lists {
+		if len(items) > 0 {
+			lists = append(lists, pgstore.ListCount{List: list, Items: int64(len(items))})
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].List < lists[j].List })
+	return lists, nil
+}
+
+// CountByAttempts counts the items of list by their attempts, sorted by
+// attempts.
+func (m *MemStore) CountByAttempts(ctx context.Context, list string) ([]pgstore.AttemptsCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byAttempts := map[int]int64{}
+	for _, e := range m.lists[list] {
+		byAttempts[e.attempts]++
+	}
+	counts := []pgstore.AttemptsCount{}
+	for attempts, n := range byAttempts {
+		counts = append(counts, pgstore.AttemptsCount{Attempts: attempts, Items: n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Attempts < counts[j].Attempts })
+	return counts, nil
+}
+
+// DecrementOne takes one off the attempts of an item in the
+// specified list, unless it has none.
+func (m *MemStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	return m.DecrementBatch(ctx, list, []string{item})
+}
+
+// DecrementBatch takes one off the attempts of each of the items in the
+// specified list, making them ready right away. Items with no attempts
+// are left alone.
+func (m *MemStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	return m.updateAttempts(list, items, func(attempts int) (int, bool) {
+		return attempts - 1, attempts > 0
+	})
+}
+
+// SetAttemptsOne sets the attempts of an item in the specified list.
+func (m *MemStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	return m.SetAttemptsBatch(ctx, list, []string{item}, attempts)
+}
+
+// SetAttemptsBatch sets the attempts of each of the items in the
+// specified list, making them ready right away.
+func (m *MemStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	if err := pgstore.ValidateAttempts(attempts); err != nil {
+		return 0, err
+	}
+	return m.updateAttempts(list, items, func(int) (int, bool) {
+		return attempts, true
+	})
+}
+
+// updateAttempts gives each of the items in list the attempts that f
+// gives it, unless f says to leave it alone, and returns the number
+// of items updated. An item given more than once is only updated once.
+func (m *MemStore) updateAttempts(list string, items []string, f func(attempts int) (int, bool)) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lists[list]
+	seen := make(map[string]struct{}, len(items))
+	var count int64
+	for _, item := range items {
+		e, ok := l[item]
+		if _, dup := seen[item]; !ok || dup {
+			continue
+		}
+		seen[item] = struct{}{}
+		attempts, ok := f(e.attempts)
+		if !ok {
+			continue
+		}
+		e.attempts = attempts
+		e.updated = m.now()
+		e.notBefore = time.Time{}
+		l[item] = e
+		count++
+	}
+	return count, nil
+}
+
+// MemStore must satisfy the same interfaces as PgStore.
+var (
+	_ pgstore.Store           = (*MemStore)(nil)
+	_ pgstore.Differ          = (*MemStore)(nil)
+	_ pgstore.Merger          = (*MemStore)(nil)
+	_ pgstore.Snapshotter     = (*MemStore)(nil)
+	_ pgstore.Lister          = (*MemStore)(nil)
+	_ pgstore.Transitioner    = (*MemStore)(nil)
+	_ pgstore.AttemptsSetter  = (*MemStore)(nil)
+	_ pgstore.AttemptsCounter = (*MemStore)(nil)
+	_ pgstore.Streamer        = (*MemStore)(nil)
+	_ pgstore.StreamInserter  = (*MemStore)(nil)
+)
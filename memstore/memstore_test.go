@@ -0,0 +1,318 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestMemStoreBatches(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStore()
+
+	t.Run("InsertBatchAllOrNothing", func(t *testing.T) {
+		count, err := s.InsertBatch(ctx, "downloads", []string{"a", "b"})
+		if err != nil || count != 2 {
+			t.Errorf("Expected to add 2 items; got %d, %v", count, err)
+		}
+		_, err = s.InsertBatch(ctx, "downloads", []string{"c", "a"})
+		if err != ErrDuplicate {
+			t.Errorf("Expected ErrDuplicate; got %v", err)
+		}
+		if _, ok, _ := s.GetOne(ctx, "downloads", "c"); ok {
+			t.Errorf("Failed batch should not have added c")
+		}
+	})
+
+	t.Run("InsertBatchIgnoreDuplicates", func(t *testing.T) {
+		count, skipped, err := s.InsertBatchIgnoreDuplicates(ctx, "downloads", []pgstore.ListEntry{{Item: "c"}, {Item: "a"}, {Item: "c"}})
+		if err != nil || count != 1 || !reflect.DeepEqual(skipped, []string{"a", "c"}) {
+			t.Errorf("Expected 1 added, a and c skipped; got %d, %v, %v", count, skipped, err)
+		}
+	})
+
+	t.Run("IfAttempts", func(t *testing.T) {
+		count, _ := s.IncrementOneIfAttempts(ctx, "downloads", "a", []int{1})
+		if count != 0 {
+			t.Errorf("Should not increment a with 0 attempts when 1 is expected")
+		}
+		count, _ = s.IncrementOneIfAttempts(ctx, "downloads", "a", []int{0, 1})
+		if count != 1 {
+			t.Errorf("Should increment a with 0 attempts when 0 or 1 is expected")
+		}
+		count, _ = s.DeleteOneIfAttempts(ctx, "downloads", "a", []int{1})
+		if count != 1 {
+			t.Errorf("Should delete a with 1 attempt when 1 is expected")
+		}
+	})
+
+	t.Run("Returning", func(t *testing.T) {
+		entries, err := s.IncrementBatchReturning(ctx, "downloads", []string{"c", "b", "b", "typo"})
+		want := []pgstore.ListEntry{{Item: "b", Attempts: 1}, {Item: "c", Attempts: 1}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		deleted, err := s.DeleteBatchReturning(ctx, "downloads", []string{"c", "typo", "b"})
+		if err != nil || !reflect.DeepEqual(deleted, []string{"b", "c"}) {
+			t.Errorf("Expected b and c deleted; got %v, %v", deleted, err)
+		}
+	})
+
+	t.Run("ApplyBatch", func(t *testing.T) {
+		s.InsertBatch(ctx, "actions", []string{"a", "b", "c"})
+		counts, err := s.ApplyBatch(ctx, "actions", []pgstore.ItemAction{
+			{Item: "a", Action: pgstore.ActionIncrement},
+			{Item: "a", Action: pgstore.ActionIncrement},
+			{Item: "b", Action: pgstore.ActionDelete},
+			{Item: "d", Action: pgstore.ActionInsert},
+			{Item: "typo", Action: pgstore.ActionDelete},
+		})
+		want := pgstore.ActionCounts{Added: 1, Incremented: 1, Deleted: 1}
+		if err != nil || counts != want {
+			t.Errorf("Expected %+v; got %+v, %v", want, counts, err)
+		}
+		_, err = s.ApplyBatch(ctx, "actions", []pgstore.ItemAction{
+			{Item: "c", Action: pgstore.ActionDelete},
+			{Item: "d", Action: pgstore.ActionInsert},
+		})
+		if err != ErrDuplicate {
+			t.Errorf("Expected ErrDuplicate; got %v", err)
+		}
+		entries, _ := s.GetBatch(ctx, "actions", "", 10)
+		wantEntries := []pgstore.ListEntry{{Item: "a", Attempts: 1}, {Item: "c"}, {Item: "d"}}
+		if !reflect.DeepEqual(entries, wantEntries) {
+			t.Errorf("Failed batch should have changed nothing; expected %v, got %v", wantEntries, entries)
+		}
+	})
+
+	t.Run("QueryBatch", func(t *testing.T) {
+		s.InsertBatch(ctx, "query", []string{"a", "b", "c"})
+		entries, err := s.QueryBatch(ctx, "query", pgstore.BatchQuery{StartID: "b", Inclusive: true, Count: 5})
+		want := []pgstore.ListEntry{{Item: "b"}, {Item: "c"}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		entries, err = s.QueryBatch(ctx, "query", pgstore.BatchQuery{StartID: "b", Count: 5})
+		want = []pgstore.ListEntry{{Item: "c"}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		n, err := s.CountBatch(ctx, "query", pgstore.BatchQuery{StartID: "b", Inclusive: true}, true)
+		if err != nil || n != 2 {
+			t.Errorf("Expected 2 items from b; got %d, %v", n, err)
+		}
+	})
+
+	t.Run("UpdatedAt", func(t *testing.T) {
+		start := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+		c := clock.NewFake(start)
+		s := NewMemStore()
+		s.Clock = c
+		s.InsertBatch(ctx, "downloads", []string{"a", "b"})
+		c.Advance(time.Hour)
+		s.InsertOne(ctx, "downloads", "c")
+		c.Advance(time.Hour)
+		s.IncrementOne(ctx, "downloads", "a")
+
+		entries, err := s.QueryBatch(ctx, "downloads", pgstore.BatchQuery{Count: 10, UpdatedBefore: start.Add(time.Minute)})
+		want := []pgstore.ListEntry{{Item: "b"}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		entries, err = s.QueryBatch(ctx, "downloads", pgstore.BatchQuery{Count: 10, UpdatedAfter: start})
+		want = []pgstore.ListEntry{{Item: "a", Attempts: 1}, {Item: "c"}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		entries, err = s.QueryBatch(ctx, "downloads", pgstore.BatchQuery{Count: 10, OldestFirst: true})
+		want = []pgstore.ListEntry{{Item: "b"}, {Item: "c"}, {Item: "a", Attempts: 1}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		entries, err = s.QueryBatch(ctx, "downloads", pgstore.BatchQuery{Count: 2, MostAttemptsFirst: true})
+		want = []pgstore.ListEntry{{Item: "a", Attempts: 1}, {Item: "b"}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		s := NewMemStore()
+		s.InsertBatch(ctx, "a", []string{"a", "b", "c"})
+		s.InsertBatchEntries(ctx, "b", []pgstore.ListEntry{{Item: "b"}, {Item: "c", Attempts: 2}, {Item: "d", Attempts: 1}})
+		var diffs []pgstore.DiffEntry
+		count, err := s.Diff(ctx, "a", "b", func(e pgstore.DiffEntry) error {
+			diffs = append(diffs, e)
+			return nil
+		})
+		zero, one, two := 0, 1, 2
+		want := []pgstore.DiffEntry{
+			{Item: "a", Diff: pgstore.DiffOnlyA, AttemptsA: &zero},
+			{Item: "c", Diff: pgstore.DiffAttempts, AttemptsA: &zero, AttemptsB: &two},
+			{Item: "d", Diff: pgstore.DiffOnlyB, AttemptsB: &one},
+		}
+		if err != nil || count != 3 || !reflect.DeepEqual(diffs, want) {
+			t.Errorf("Expected %v; got %v, %d, %v", want, diffs, count, err)
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		for conflict, want := range map[string][]pgstore.ListEntry{
+			pgstore.MergeKeepMax:  {{Item: "a", Attempts: 3}, {Item: "b", Attempts: 2}, {Item: "c", Attempts: 1}},
+			pgstore.MergeKeepDest: {{Item: "a", Attempts: 1}, {Item: "b", Attempts: 2}, {Item: "c", Attempts: 1}},
+			pgstore.MergeSum:      {{Item: "a", Attempts: 4}, {Item: "b", Attempts: 3}, {Item: "c", Attempts: 1}},
+		} {
+			s := NewMemStore()
+			s.InsertBatchEntries(ctx, "dest", []pgstore.ListEntry{{Item: "a", Attempts: 1}, {Item: "b", Attempts: 2}})
+			s.InsertBatchEntries(ctx, "src", []pgstore.ListEntry{{Item: "a", Attempts: 3}, {Item: "b", Attempts: 1}, {Item: "c", Attempts: 1}})
+			if _, err := s.Merge(ctx, "dest", "src", conflict); err != nil {
+				t.Errorf("%s: %v", conflict, err)
+			}
+			entries, err := s.GetBatch(ctx, "dest", "", 10)
+			if err != nil || !reflect.DeepEqual(entries, want) {
+				t.Errorf("%s: expected %v; got %v, %v", conflict, want, entries, err)
+			}
+		}
+		s := NewMemStore()
+		if _, err := s.Merge(ctx, "dest", "src", "keep_src"); !errors.As(err, new(*pgstore.ValidationError)) {
+			t.Errorf("Expected a *pgstore.ValidationError; got %v", err)
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		s := NewMemStore()
+		s.InsertBatchEntries(ctx, "downloads", []pgstore.ListEntry{{Item: "a"}, {Item: "b", Attempts: 1}})
+		if snap, err := s.TakeSnapshot(ctx, "downloads", "before"); err != nil || snap.Items != 2 {
+			t.Errorf("Expected a snapshot of 2 items; got %+v, %v", snap, err)
+		}
+		if _, err := s.TakeSnapshot(ctx, "downloads", "before"); !errors.Is(err, pgstore.ErrSnapshotExists) {
+			t.Errorf("Expected pgstore.ErrSnapshotExists; got %v", err)
+		}
+		s.DeleteOne(ctx, "downloads", "a")
+		s.IncrementOne(ctx, "downloads", "b")
+		s.InsertOne(ctx, "downloads", "c")
+		counts, err := s.RestoreSnapshot(ctx, "downloads", "before")
+		if want := (pgstore.RestoreCounts{Added: 1, Updated: 1, Deleted: 1}); err != nil || counts != want {
+			t.Errorf("Expected %+v; got %+v, %v", want, counts, err)
+		}
+		entries, err := s.GetBatch(ctx, "downloads", "", 10)
+		want := []pgstore.ListEntry{{Item: "a"}, {Item: "b", Attempts: 1}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		if _, err := s.RestoreSnapshot(ctx, "downloads", "after"); !errors.Is(err, pgstore.ErrSnapshotNotFound) {
+			t.Errorf("Expected pgstore.ErrSnapshotNotFound; got %v", err)
+		}
+		if n, err := s.DeleteSnapshot(ctx, "downloads", "before"); err != nil || n != 1 {
+			t.Errorf("Expected 1 snapshot deleted; got %d, %v", n, err)
+		}
+		if snapshots, err := s.Snapshots(ctx, "downloads"); err != nil || len(snapshots) != 0 {
+			t.Errorf("Expected no snapshots; got %v, %v", snapshots, err)
+		}
+	})
+
+	t.Run("Transition", func(t *testing.T) {
+		s := NewMemStore()
+		s.InsertBatch(ctx, "downloads", []string{"a", "b"})
+		s.IncrementOne(ctx, "downloads", "a")
+		entries, err := s.QueryBatch(ctx, "downloads", pgstore.BatchQuery{Count: 10, Status: pgstore.StatusInProgress})
+		want := []pgstore.ListEntry{{Item: "a", Attempts: 1}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		if status, ok, err := s.Transition(ctx, "downloads", "a", pgstore.ActionComplete); err != nil || !ok || status != pgstore.StatusDone {
+			t.Errorf("Expected done; got %q, %v, %v", status, ok, err)
+		}
+		if _, ok, err := s.Transition(ctx, "downloads", "a", pgstore.ActionFail); !ok || !errors.Is(err, pgstore.ErrInvalidTransition) {
+			t.Errorf("Expected pgstore.ErrInvalidTransition; got %v, %v", ok, err)
+		}
+		if _, ok, err := s.Transition(ctx, "downloads", "c", pgstore.ActionReset); ok || err != nil {
+			t.Errorf("Expected item not found; got %v, %v", ok, err)
+		}
+		if _, err := s.CountBatch(ctx, "downloads", pgstore.BatchQuery{Status: "stuck"}, false); !errors.As(err, new(*pgstore.ValidationError)) {
+			t.Errorf("Expected a *pgstore.ValidationError; got %v", err)
+		}
+	})
+
+	t.Run("Attempts", func(t *testing.T) {
+		s := NewMemStore()
+		s.InsertBatchEntries(ctx, "downloads", []pgstore.ListEntry{{Item: "a"}, {Item: "b", Attempts: 1}, {Item: "c", Attempts: 3}})
+		zero, one := 0, 1
+		for _, tc := range []struct {
+			q    pgstore.BatchQuery
+			want []pgstore.ListEntry
+		}{
+			{pgstore.BatchQuery{Count: 10, MinAttempts: 1}, []pgstore.ListEntry{{Item: "b", Attempts: 1}, {Item: "c", Attempts: 3}}},
+			{pgstore.BatchQuery{Count: 10, MaxAttempts: &zero}, []pgstore.ListEntry{{Item: "a"}}},
+			{pgstore.BatchQuery{Count: 10, MinAttempts: 1, MaxAttempts: &one}, []pgstore.ListEntry{{Item: "b", Attempts: 1}}},
+		} {
+			entries, err := s.QueryBatch(ctx, "downloads", tc.q)
+			if err != nil || !reflect.DeepEqual(entries, tc.want) {
+				t.Errorf("%+v: expected %v; got %v, %v", tc.q, tc.want, entries, err)
+			}
+		}
+	})
+
+	t.Run("SetAttempts", func(t *testing.T) {
+		s := NewMemStore()
+		s.InsertBatchEntries(ctx, "downloads", []pgstore.ListEntry{{Item: "a", Attempts: 2}, {Item: "b"}})
+		if n, err := s.DecrementBatch(ctx, "downloads", []string{"a", "b", "c"}); n != 1 || err != nil {
+			t.Errorf("Expected 1 decremented; got %d, %v", n, err)
+		}
+		if n, err := s.SetAttemptsOne(ctx, "downloads", "b", 4); n != 1 || err != nil {
+			t.Errorf("Expected 1 set; got %d, %v", n, err)
+		}
+		if _, err := s.SetAttemptsBatch(ctx, "downloads", []string{"a"}, -1); !errors.As(err, new(*pgstore.ValidationError)) {
+			t.Errorf("Expected a *pgstore.ValidationError; got %v", err)
+		}
+		entries, err := s.GetBatch(ctx, "downloads", "", 10)
+		want := []pgstore.ListEntry{{Item: "a", Attempts: 1}, {Item: "b", Attempts: 4}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+	})
+
+	t.Run("StreamBatch", func(t *testing.T) {
+		s := NewMemStore()
+		s.InsertBatch(ctx, "downloads", []string{"c", "a", "b"})
+		var entries []pgstore.ListEntry
+		n, err := s.StreamBatch(ctx, "downloads", pgstore.BatchQuery{StartID: "a"}, func(e pgstore.ListEntry) error {
+			entries = append(entries, e)
+			return nil
+		})
+		want := []pgstore.ListEntry{{Item: "b"}, {Item: "c"}}
+		if n != 2 || err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %d, %v, %v", want, n, entries, err)
+		}
+	})
+
+	t.Run("RetryDelay", func(t *testing.T) {
+		start := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+		c := clock.NewFake(start)
+		s := NewMemStore()
+		s.Clock = c
+		s.RetryDelays = &pgstore.RetryDelays{Default: pgstore.RetryDelay{Base: time.Minute, Cap: 3 * time.Minute}}
+		s.InsertBatch(ctx, "downloads", []string{"a", "b"})
+		s.IncrementBatch(ctx, "downloads", []string{"a"})
+		s.IncrementBatch(ctx, "downloads", []string{"a", "b"})
+
+		ready := pgstore.BatchQuery{Count: 10, Ready: true}
+		c.Advance(time.Minute)
+		// a has waited 1m of 2m; b has waited its 1m.
+		entries, err := s.QueryBatch(ctx, "downloads", ready)
+		want := []pgstore.ListEntry{{Item: "b", Attempts: 1}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("Expected %v; got %v, %v", want, entries, err)
+		}
+		c.Advance(time.Minute)
+		n, err := s.CountBatch(ctx, "downloads", ready, false)
+		if err != nil || n != 2 {
+			t.Errorf("Expected 2 ready items; got %d, %v", n, err)
+		}
+	})
+}
@@ -0,0 +1,404 @@
+package iidy
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OtherList is the list name that metrics are recorded under for every
+// list that does not get metrics of its own.
+const OtherList string = "_other"
+
+// Metrics counts requests and list rows affected, per list and per
+// operation (such as "insert_batch" or "get_one"). Because list names are
+// chosen by clients, and so are unbounded, only the lists in the allowlist,
+// plus the first limit other lists seen, get metrics of their own; all other
+// lists are lumped together under OtherList. This keeps the number of
+// metric labels under control.
+//
+// Metrics satisfies expvar.Var, so it can be published with expvar.Publish
+// and read from /debug/vars. A nil *Metrics records nothing, so handlers
+// do not need to check whether metrics are turned on.
+type Metrics struct {
+	mu    sync.Mutex
+	allow map[string]struct{}
+	limit int
+	seen  map[string]struct{}
+	lists expvar.Map
+}
+
+// NewMetrics returns Metrics that give a label of their own to each of
+// the lists in allow, and to at most limit other lists.
+func NewMetrics(allow []string, limit int) *Metrics {
+	m := &Metrics{
+		allow: make(map[string]struct{}, len(allow)),
+		limit: limit,
+		seen:  make(map[string]struct{}),
+	}
+	for _, list := range allow {
+		m.allow[list] = struct{}{}
+	}
+	m.lists.Init()
+	return m
+}
+
+// label gives the name that metrics for list are recorded under.
+func (m *Metrics) label(list string) string {
+	if _, ok := m.allow[list]; ok {
+		return list
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.seen[list]; ok {
+		return list
+	}
+	if len(m.seen) < m.limit {
+		m.seen[list] = struct{}{}
+		return list
+	}
+	return OtherList
+}
+
+// listVars gives the metrics for list, creating them if need be.
+func (m *Metrics) listVars(list string) *expvar.Map {
+	label := m.label(list)
+	if v, ok := m.lists.Get(label).(*expvar.Map); ok {
+		return v
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Check again, in case another request just created them.
+	if v, ok := m.lists.Get(label).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	m.lists.Set(label, v)
+	return v
+}
+
+// CountRequest counts a request to perform op on list.
+func (m *Metrics) CountRequest(list string, op string) {
+	if m == nil {
+		return
+	}
+	m.listVars(list).Add(op+"_requests", 1)
+}
+
+// CountRows counts the n rows of list that op affected (or fetched).
+func (m *Metrics) CountRows(list string, op string, n int64) {
+	if m == nil {
+		return
+	}
+	m.listVars(list).Add(op+"_rows", n)
+}
+
+// CountSlowQuery counts a data store call for op on list that
+// took too long.
+func (m *Metrics) CountSlowQuery(list string, op string) {
+	if m == nil {
+		return
+	}
+	m.listVars(list).Add(op+"_slow_queries", 1)
+}
+
+// String satisfies expvar.Var, giving the metrics as a JSON object
+// with a member for each list.
+func (m *Metrics) String() string {
+	return m.lists.String()
+}
+
+// LatencyBuckets are the upper bounds of the latency buckets that
+// SLOMetrics counts requests in.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// SLOMetrics counts requests, successes, and latencies per endpoint
+// class: single_read, single_write, batch_read, and batch_write. These are
+// the numbers needed for alerting on service level objective burn rates.
+// A request succeeds unless it fails with a 5xx status; 4xx statuses are
+// the client's fault, not the service's.
+//
+// Latency buckets are cumulative, like Prometheus histograms:
+// latency_le_100ms counts every request that took 100ms or less.
+//
+// SLOMetrics satisfies expvar.Var, so it can be published with
+// expvar.Publish. A nil *SLOMetrics records nothing.
+type SLOMetrics struct {
+	mu      sync.Mutex
+	classes expvar.Map
+}
+
+// NewSLOMetrics constructs new SLOMetrics.
+func NewSLOMetrics() *SLOMetrics {
+	m := &SLOMetrics{}
+	m.classes.Init()
+	return m
+}
+
+// classVars gives the metrics for class, creating them if need be.
+func (m *SLOMetrics) classVars(class string) *expvar.Map {
+	if v, ok := m.classes.Get(class).(*expvar.Map); ok {
+		return v
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.classes.Get(class).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	requests := new(expvar.Int)
+	successes := new(expvar.Int)
+	v.Set("requests", requests)
+	v.Set("successes", successes)
+	v.Set("success_ratio", expvar.Func(func() interface{} {
+		n := requests.Value()
+		if n == 0 {
+			return 1.0
+		}
+		return float64(successes.Value()) / float64(n)
+	}))
+	m.classes.Set(class, v)
+	return v
+}
+
+// Observe records a request of the given class that ended with
+// status code after taking d.
+func (m *SLOMetrics) Observe(class string, code int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	v := m.classVars(class)
+	v.Add("requests", 1)
+	if code < http.StatusInternalServerError {
+		v.Add("successes", 1)
+	}
+	for _, bucket := range LatencyBuckets {
+		if d <= bucket {
+			v.Add("latency_le_"+bucket.String(), 1)
+		}
+	}
+	v.Add("latency_le_inf", 1)
+}
+
+// String satisfies expvar.Var, giving the metrics as a JSON object
+// with a member for each endpoint class.
+func (m *SLOMetrics) String() string {
+	return m.classes.String()
+}
+
+// endpointClass gives the SLO endpoint class of a request: whether it is
+// for a single item or a batch of them, and whether it reads or writes.
+func endpointClass(r *http.Request) string {
+	if apiVersion(r) >= 2 {
+		size := "batch"
+		if strings.HasSuffix(routeName(r), "_one") {
+			size = "single"
+		}
+		kind := "write"
+		if r.Method == http.MethodGet {
+			kind = "read"
+		}
+		return size + "_" + kind
+	}
+	urlParts := strings.Split(r.URL.Path, "/")
+	size := "single"
+	if len(urlParts) > 3 && urlParts[3] != "lists" {
+		size = "batch"
+	}
+	kind := "write"
+	if r.Method == http.MethodGet || (len(urlParts) > 3 && urlParts[3] == "multiget") {
+		kind = "read"
+	}
+	return size + "_" + kind
+}
+
+// Exemplar is a concrete request that landed in a latency bucket, so
+// that a slow bucket can be traced back to a request (by its request ID,
+// in the access log, say).
+type Exemplar struct {
+	RequestID string  `json:"request_id"`
+	Duration  float64 `json:"duration_ms"`
+}
+
+// latencyBucket counts the requests that took no longer than its
+// upper bound, and keeps the most recent of them as an exemplar.
+type latencyBucket struct {
+	Count    int64     `json:"count"`
+	Exemplar *Exemplar `json:"exemplar,omitempty"`
+}
+
+// routeLatency is the latency histogram of one route.
+type routeLatency struct {
+	Count   int64                     `json:"count"`
+	Sum     float64                   `json:"sum_ms"`
+	Buckets map[string]*latencyBucket `json:"buckets"`
+}
+
+// RouteLatency keeps a latency histogram per route (such as
+// "insert_batch" or "get_one"), using LatencyBuckets. Unlike
+// SLOMetrics' buckets, these are not cumulative: each request is counted
+// in the smallest bucket it fits in, whose exemplar it then becomes.
+// That way, the exemplar of a slow bucket is a request that really was
+// that slow, which mean latencies would hide.
+//
+// RouteLatency satisfies expvar.Var, so it can be published with
+// expvar.Publish. A nil *RouteLatency records nothing.
+type RouteLatency struct {
+	mu     sync.Mutex
+	routes map[string]*routeLatency
+}
+
+// NewRouteLatency constructs a new RouteLatency.
+func NewRouteLatency() *RouteLatency {
+	return &RouteLatency{routes: make(map[string]*routeLatency)}
+}
+
+// Observe records that a request to route, with the given request ID,
+// took d.
+func (m *RouteLatency) Observe(route string, requestID string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	name := "le_inf"
+	for _, bucket := range LatencyBuckets {
+		if d <= bucket {
+			name = "le_" + bucket.String()
+			break
+		}
+	}
+	ms := float64(d.Microseconds()) / 1000
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rl, ok := m.routes[route]
+	if !ok {
+		rl = &routeLatency{Buckets: make(map[string]*latencyBucket)}
+		m.routes[route] = rl
+	}
+	rl.Count++
+	rl.Sum += ms
+	b, ok := rl.Buckets[name]
+	if !ok {
+		b = &latencyBucket{}
+		rl.Buckets[name] = b
+	}
+	b.Count++
+	if requestID != "" {
+		b.Exemplar = &Exemplar{RequestID: requestID, Duration: ms}
+	}
+}
+
+// String satisfies expvar.Var, giving the histograms as a JSON object
+// with a member for each route.
+func (m *RouteLatency) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := json.Marshal(m.routes)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// routeName gives the name of the route a request is for, using the same
+// operation names as Metrics, or "unknown" for a request that does
+// not match any route.
+func routeName(r *http.Request) string {
+	if apiVersion(r) >= 2 {
+		if route, _, _, _ := matchV2(r); route != nil {
+			return route.name
+		}
+		return "unknown"
+	}
+	urlParts := strings.Split(r.URL.Path, "/")
+	if len(urlParts) == 5 && urlParts[3] == "lists" && r.Method == http.MethodPost && r.URL.Query().Get("action") == "merge" {
+		return "merge"
+	}
+	if len(urlParts) == 4 && urlParts[3] == "lists" && r.Method == http.MethodGet {
+		return "get_lists"
+	}
+	if len(urlParts) < 6 {
+		return "unknown"
+	}
+	switch {
+	case urlParts[3] == "lists":
+		switch r.Method {
+		case http.MethodGet:
+			return "get_one"
+		case http.MethodDelete:
+			return "delete_one"
+		case http.MethodPut:
+			return "put_one"
+		case http.MethodPost:
+			switch r.URL.Query().Get("action") {
+			case "increment":
+				return "increment_one"
+			case "decrement":
+				return "decrement_one"
+			case "set":
+				return "set_attempts_one"
+			case "complete", "fail", "reset":
+				return "transition"
+			}
+			return "insert_one"
+		}
+	case urlParts[3] == "batch" && urlParts[4] == "lists":
+		switch r.Method {
+		case http.MethodGet:
+			return "get_batch"
+		case http.MethodDelete:
+			return "delete_batch"
+		case http.MethodPost:
+			switch r.URL.Query().Get("action") {
+			case "increment":
+				return "increment_batch"
+			case "decrement":
+				return "decrement_batch"
+			case "set":
+				return "set_attempts_batch"
+			}
+			return "insert_batch"
+		}
+	case urlParts[3] == "multiget" && urlParts[4] == "lists" && r.Method == http.MethodPost:
+		return "get_multi"
+	case urlParts[3] == "actions" && urlParts[4] == "lists" && r.Method == http.MethodPost:
+		return "apply_batch"
+	case urlParts[3] == "activity" && urlParts[4] == "lists" && r.Method == http.MethodGet:
+		return "get_activity"
+	case urlParts[3] == "diff" && urlParts[4] == "lists" && r.Method == http.MethodGet:
+		return "get_diff"
+	case urlParts[3] == "snapshots" && urlParts[4] == "lists":
+		switch {
+		case len(urlParts) == 6 && r.Method == http.MethodGet:
+			return "get_snapshots"
+		case len(urlParts) == 7 && r.Method == http.MethodDelete:
+			return "delete_snapshot"
+		case len(urlParts) == 7 && r.Method == http.MethodPost && r.URL.Query().Get("action") == "restore":
+			return "restore_snapshot"
+		case len(urlParts) == 7 && r.Method == http.MethodPost:
+			return "create_snapshot"
+		}
+	case len(urlParts) == 6 && urlParts[3] == "settings" && urlParts[4] == "lists" && r.Method == http.MethodGet:
+		return "get_settings"
+	case len(urlParts) == 6 && urlParts[3] == "settings" && urlParts[4] == "lists" && r.Method == http.MethodPut:
+		return "set_settings"
+	case len(urlParts) == 7 && urlParts[3] == "stats" && urlParts[4] == "lists" && urlParts[6] == "top" && r.Method == http.MethodGet:
+		return "get_top"
+	case len(urlParts) == 7 && urlParts[3] == "stats" && urlParts[4] == "lists" && urlParts[6] == "count" && r.Method == http.MethodGet:
+		return "get_count"
+	}
+	return "unknown"
+}
@@ -0,0 +1,230 @@
+package iidy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestMetricsCardinality(t *testing.T) {
+	m := NewMetrics([]string{"downloads"}, 1)
+	m.CountRequest("uploads", "get_one")
+	m.CountRequest("downloads", "get_one")
+	m.CountRequest("deletes", "get_one")
+	m.CountRequest("renames", "get_one")
+	m.CountRows("uploads", "get_one", 1)
+	m.CountRows("renames", "get_one", 1)
+
+	var got map[string]map[string]int64
+	if err := json.Unmarshal([]byte(m.String()), &got); err != nil {
+		t.Fatalf("metrics are not JSON: %v: %s", err, m.String())
+	}
+	want := map[string]map[string]int64{
+		"downloads": {"get_one_requests": 1},
+		"uploads":   {"get_one_requests": 1, "get_one_rows": 1},
+		OtherList:   {"get_one_requests": 2, "get_one_rows": 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	mockStore := StoreTestingStub{
+		deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return 2, nil
+		},
+	}
+	m := NewMetrics(nil, 10)
+	h := &Handler{Store: mockStore, Metrics: m}
+	req, err := http.NewRequest(http.MethodDelete, "/iidy/v1/batch/lists/downloads?items=a,b,c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var got map[string]map[string]int64
+	if err := json.Unmarshal([]byte(m.String()), &got); err != nil {
+		t.Fatalf("metrics are not JSON: %v: %s", err, m.String())
+	}
+	want := map[string]map[string]int64{
+		"downloads": {"delete_batch_requests": 1, "delete_batch_rows": 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestSlowQueryMetrics(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	mockStore := StoreTestingStub{
+		deleteBatch: func(ctx context.Context, list string, items []string) (int64, error) {
+			return 2, nil
+		},
+	}
+	m := NewMetrics(nil, 10)
+	// With a threshold of 0, every call is slow.
+	store := pgstore.NewSlowLogStore(mockStore, 0, func(q pgstore.SlowQuery) {
+		m.CountSlowQuery(q.List, q.Op)
+	})
+	h := &Handler{Store: store, Metrics: m}
+	req, err := http.NewRequest(http.MethodDelete, "/iidy/v1/batch/lists/downloads?items=secret-a,secret-b,secret-c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var got map[string]map[string]int64
+	if err := json.Unmarshal([]byte(m.String()), &got); err != nil {
+		t.Fatalf("metrics are not JSON: %v: %s", err, m.String())
+	}
+	if n := got["downloads"]["delete_batch_slow_queries"]; n != 1 {
+		t.Errorf("got %d slow queries want 1: %v", n, got)
+	}
+	if !strings.Contains(logged.String(), `op=delete_batch list="downloads" batch_id=`) || !strings.Contains(logged.String(), " items=3 ") {
+		t.Errorf("slow query not logged as expected: %s", logged.String())
+	}
+	if strings.Contains(logged.String(), "secret") {
+		t.Errorf("slow query log gives away item names: %s", logged.String())
+	}
+}
+
+func TestEndpointClass(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		url    string
+		want   string
+	}{
+		"GetOne":         {method: http.MethodGet, url: "/iidy/v1/lists/downloads/a", want: "single_read"},
+		"InsertOne":      {method: http.MethodPost, url: "/iidy/v1/lists/downloads/a", want: "single_write"},
+		"DeleteOne":      {method: http.MethodDelete, url: "/iidy/v1/lists/downloads/a", want: "single_write"},
+		"GetBatch":       {method: http.MethodGet, url: "/iidy/v1/batch/lists/downloads", want: "batch_read"},
+		"MultiGet":       {method: http.MethodPost, url: "/iidy/v1/multiget/lists/downloads", want: "batch_read"},
+		"IncrementBatch": {method: http.MethodPost, url: "/iidy/v1/batch/lists/downloads?action=increment", want: "batch_write"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if got := endpointClass(req); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerSLOMetrics(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 0, false, nil
+		},
+		deleteOne: func(ctx context.Context, list string, item string) (int64, error) {
+			return 0, fmt.Errorf("connection refused")
+		},
+	}
+	slo := NewSLOMetrics()
+	h := &Handler{Store: mockStore, SLO: slo}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/iidy/v1/lists/downloads/a", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/iidy/v1/lists/downloads", nil))
+
+	var got map[string]map[string]float64
+	if err := json.Unmarshal([]byte(slo.String()), &got); err != nil {
+		t.Fatalf("metrics are not JSON: %v: %s", err, slo.String())
+	}
+	// The 404 is the client's fault, so it is a success as far as the
+	// SLO is concerned.
+	if r := got["single_read"]; r["requests"] != 1 || r["successes"] != 1 || r["success_ratio"] != 1 || r["latency_le_inf"] != 1 {
+		t.Errorf("unexpected single_read metrics: %v", r)
+	}
+	if w := got["single_write"]; w["requests"] != 2 || w["successes"] != 1 || w["success_ratio"] != 0.5 {
+		t.Errorf("unexpected single_write metrics: %v", w)
+	}
+}
+
+func TestRouteName(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		url    string
+		want   string
+	}{
+		"GetOne":         {method: http.MethodGet, url: "/iidy/v1/lists/downloads/a", want: "get_one"},
+		"IncrementOne":   {method: http.MethodPost, url: "/iidy/v1/lists/downloads/a?action=increment", want: "increment_one"},
+		"InsertBatch":    {method: http.MethodPost, url: "/iidy/v1/batch/lists/downloads", want: "insert_batch"},
+		"DecrementBatch": {method: http.MethodPost, url: "/iidy/v1/batch/lists/downloads?action=decrement", want: "decrement_batch"},
+		"SetAttemptsOne": {method: http.MethodPost, url: "/iidy/v1/lists/downloads/a?action=set&value=0", want: "set_attempts_one"},
+		"DeleteBatch":    {method: http.MethodDelete, url: "/iidy/v1/batch/lists/downloads", want: "delete_batch"},
+		"MultiGet":       {method: http.MethodPost, url: "/iidy/v1/multiget/lists/downloads", want: "get_multi"},
+		"ApplyBatch":     {method: http.MethodPost, url: "/iidy/v1/actions/lists/downloads", want: "apply_batch"},
+		"GetActivity":    {method: http.MethodGet, url: "/iidy/v1/activity/lists/downloads?since=1h", want: "get_activity"},
+		"GetLists":       {method: http.MethodGet, url: "/iidy/v1/lists", want: "get_lists"},
+		"GetCount":       {method: http.MethodGet, url: "/iidy/v1/stats/lists/downloads/count", want: "get_count"},
+		"TooShort":       {method: http.MethodGet, url: "/iidy/v1/lists/downloads", want: "unknown"},
+		"UnknownMulti":   {method: http.MethodGet, url: "/iidy/v1/multiget/lists/downloads", want: "unknown"},
+		"UnknownSection": {method: http.MethodGet, url: "/iidy/v1/nope/lists/downloads", want: "unknown"},
+	}
+	for ttName, tt := range tests {
+		t.Run(ttName, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if got := routeName(req); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteLatencyExemplars(t *testing.T) {
+	m := NewRouteLatency()
+	m.Observe("insert_batch", "fast", time.Millisecond)
+	m.Observe("insert_batch", "slow", 3*time.Second)
+	m.Observe("insert_batch", "slower", 4*time.Second)
+	m.Observe("insert_batch", "slowest", time.Minute)
+
+	var got map[string]routeLatency
+	if err := json.Unmarshal([]byte(m.String()), &got); err != nil {
+		t.Fatalf("metrics are not JSON: %v: %s", err, m.String())
+	}
+	rl := got["insert_batch"]
+	if rl.Count != 4 {
+		t.Errorf("got count %d want 4", rl.Count)
+	}
+	if b := rl.Buckets["le_5ms"]; b == nil || b.Count != 1 || b.Exemplar.RequestID != "fast" {
+		t.Errorf("unexpected le_5ms bucket: %+v", b)
+	}
+	if b := rl.Buckets["le_5s"]; b == nil || b.Count != 2 || b.Exemplar.RequestID != "slower" || b.Exemplar.Duration != 4000 {
+		t.Errorf("unexpected le_5s bucket: %+v", b)
+	}
+	if b := rl.Buckets["le_inf"]; b == nil || b.Count != 1 || b.Exemplar.RequestID != "slowest" {
+		t.Errorf("unexpected le_inf bucket: %+v", b)
+	}
+}
+
+func TestHandlerRouteLatency(t *testing.T) {
+	mockStore := StoreTestingStub{
+		getOne: func(ctx context.Context, list string, item string) (int, bool, error) {
+			return 1, true, nil
+		},
+	}
+	latency := NewRouteLatency()
+	var out bytes.Buffer
+	a := NewAccessLog(&Handler{Store: mockStore, Latency: latency}, &out)
+	req := httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	a.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(latency.String(), `"get_one":{"count":1`) || !strings.Contains(latency.String(), `"request_id":"req-42"`) {
+		t.Errorf("request not recorded as expected: %s", latency.String())
+	}
+}
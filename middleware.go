@@ -0,0 +1,55 @@
+package iidy
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Middleware wraps a handler in another, which does something for every
+// request (such as logging it, or turning it away) around what next does.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps h in each of mws, so that the first of them sees each
+// request first, and the last of them hands it to h. Nil middleware are
+// skipped, so that middleware that is turned off can be left in place.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
+
+// Recover is middleware that turns a panic in next into a 500, and logs
+// it with its stack trace, rather than letting it cut the connection with
+// no word of what went wrong. A panic with http.ErrAbortHandler, which is
+// how a handler cuts a connection on purpose, is passed on.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+			// If the response has already been started, this does nothing
+			// but add a superfluous WriteHeader to the log.
+			printError(w, r, &ErrorMessage{Error: "Internal server error."}, http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NoSniff is middleware that tells clients to take the Content-Type
+// header of every response seriously, rather than guessing at it.
+func NoSniff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,2 @@
+-- The batch that inserted each item, if it was inserted in a batch.
+alter table iidy.lists add column batch_id text;
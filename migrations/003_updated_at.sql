@@ -0,0 +1,18 @@
+-- When each item was inserted, or last had its attempts changed,
+-- so that items untouched for a while can be found (and retried).
+alter table iidy.lists add column updated_at timestamptz not null default now();
+
+create index list_updated_at on iidy.lists (list, updated_at, item);
+
+create function iidy.touch_updated_at() returns trigger as $$
+begin
+	new.updated_at := now();
+	return new;
+end;
+$$ language plpgsql;
+
+create trigger lists_touch_updated_at
+	before update of attempts on iidy.lists
+	for each row
+	execute function iidy.touch_updated_at();
+
@@ -0,0 +1,11 @@
+-- When each item is ready to be worked on again, after its attempts were
+-- last incremented, if it has to wait; see iidy.retry_not_before.
+alter table iidy.lists add column not_before timestamptz;
+
+-- When an item whose attempts have just been incremented from attempts is
+-- ready to be worked on again: base, doubled for each earlier attempt, but
+-- no more than cap (if cap is not null). With a null base, it is ready
+-- right away, so not_before is null.
+create function iidy.retry_not_before(attempts integer, base interval, cap interval) returns timestamptz as $$
+	select now() + least(cap, base * power(2, least(attempts, 30)));
+$$ language sql stable;
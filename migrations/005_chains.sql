@@ -0,0 +1,30 @@
+-- Workflow chains: once an item is deleted from (that is, done in) list,
+-- it is inserted into next_list, in the same transaction.
+create table iidy.chains (
+	list      text not null,
+	next_list text not null,
+	-- What the item is called in next_list, with {item} standing for
+	-- its name in list. If null, it keeps its name.
+	template  text,
+	constraint chains_pk primary key (list),
+	constraint chains_not_self check (list <> next_list));
+
+create function iidy.chain_deleted() returns trigger as $$
+begin
+	insert into iidy.lists
+	       (list, item, batch_id)
+	select c.next_list,
+	       coalesce(replace(c.template, '{item}', d.item), d.item),
+	       d.batch_id
+	  from deleted d
+	  join iidy.chains c on c.list = d.list
+	    on conflict (list, item) do nothing;
+	return null;
+end;
+$$ language plpgsql;
+
+create trigger lists_chain_deleted
+	after delete on iidy.lists
+	referencing old table as deleted
+	for each statement
+	execute function iidy.chain_deleted();
@@ -0,0 +1,3 @@
+-- So that the most-attempted items in a list, which are the ones an
+-- operator most often wants to look at, can be found without a sort.
+create index list_attempts on iidy.lists (list, attempts desc, item);
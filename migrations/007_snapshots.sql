@@ -0,0 +1,38 @@
+-- Point-in-time copies of lists, under labels, so that operators can
+-- take a cheap "undo point" before a risky bulk operation, and later
+-- restore the list to it, or see what has changed since.
+create table iidy.snapshots (
+	list       text        not null,
+	label      text        not null,
+	items      bigint      not null,
+	created_at timestamptz not null default now(),
+	constraint snapshots_pk primary key (list, label));
+
+create table iidy.snapshot_items (
+	list     text    not null,
+	label    text    not null,
+	item     text    not null,
+	attempts integer not null,
+	constraint snapshot_items_pk primary key (list, label, item),
+	constraint snapshot_items_snapshot_fk foreign key (list, label)
+		references iidy.snapshots (list, label) on delete cascade);
+
+-- Restoring a snapshot deletes the items that were not in it, which is
+-- not the same as their being done, so it turns chaining off, for its
+-- own transaction, with the iidy.no_chain setting.
+create or replace function iidy.chain_deleted() returns trigger as $$
+begin
+	if current_setting('iidy.no_chain', true) = 'on' then
+		return null;
+	end if;
+	insert into iidy.lists
+	       (list, item, batch_id)
+	select c.next_list,
+	       coalesce(replace(c.template, '{item}', d.item), d.item),
+	       d.batch_id
+	  from deleted d
+	  join iidy.chains c on c.list = d.list
+	    on conflict (list, item) do nothing;
+	return null;
+end;
+$$ language plpgsql;
@@ -0,0 +1,7 @@
+-- Where each item is in its life, since attempts alone cannot tell an
+-- item that is being worked on from one that has never been tried, or
+-- one that is done, or has failed for good.
+alter table iidy.lists add column status text not null default 'pending';
+
+alter table iidy.lists add constraint lists_status_check
+	check (status in ('pending', 'in_progress', 'done', 'failed'));
@@ -0,0 +1,34 @@
+-- Settings for each list, which override iidy's defaults for that list.
+-- A list with no row, or with a null setting, gets the default.
+create table iidy.list_settings (
+	list         text not null,
+	-- Once an item's attempts are incremented to max_attempts, it has
+	-- failed for good; see iidy.incremented_status.
+	max_attempts integer,
+	-- The list's retry delay (see iidy.retry_not_before), in place of
+	-- the one iidy was started with.
+	retry_base   interval,
+	retry_cap    interval,
+	constraint list_settings_pk primary key (list),
+	constraint list_settings_max_attempts_check check (max_attempts > 0),
+	constraint list_settings_retry_check check (retry_base > interval '0' and retry_cap > interval '0'));
+
+-- The status of an item in list whose attempts have just been incremented
+-- to attempts: failed, if that is the list's max_attempts, or more, and
+-- otherwise in_progress.
+create function iidy.incremented_status(list text, attempts integer) returns text as $$
+	select case when $2 >= (select s.max_attempts
+	                          from iidy.list_settings s
+	                         where s.list = $1)
+	            then 'failed'
+	            else 'in_progress'
+	       end;
+$$ language sql stable;
+
+-- iidy.retry_not_before, with the retry delay of list's settings, where
+-- it has one, in place of base and cap.
+create function iidy.list_retry_not_before(list text, attempts integer, base interval, cap interval) returns timestamptz as $$
+	select iidy.retry_not_before($2, coalesce(s.retry_base, $3), coalesce(s.retry_cap, $4))
+	  from (select) one
+	  left join iidy.list_settings s on s.list = $1;
+$$ language sql stable;
@@ -0,0 +1,31 @@
+-- When each item expires, and is deleted (or archived) by the janitor;
+-- see iidy.set_expires_at. Items of lists with no ttl never expire, so
+-- theirs is null.
+alter table iidy.lists add column expires_at timestamptz;
+
+create index list_expires_at on iidy.lists (expires_at) where expires_at is not null;
+
+-- How long a list's items live after they were inserted, or last had
+-- their attempts changed, and the list, if any, that they are moved to,
+-- rather than deleted, when they expire.
+alter table iidy.list_settings add column ttl interval;
+alter table iidy.list_settings add column archive_list text;
+
+alter table iidy.list_settings add constraint list_settings_ttl_check
+	check (ttl > interval '0');
+alter table iidy.list_settings add constraint list_settings_archive_list_check
+	check (archive_list <> list);
+
+create function iidy.set_expires_at() returns trigger as $$
+begin
+	new.expires_at := now() + (select s.ttl
+	                             from iidy.list_settings s
+	                            where s.list = new.list);
+	return new;
+end;
+$$ language plpgsql;
+
+create trigger lists_set_expires_at
+	before insert or update of attempts on iidy.lists
+	for each row
+	execute function iidy.set_expires_at();
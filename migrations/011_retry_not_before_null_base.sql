@@ -0,0 +1,9 @@
+-- least ignores nulls, so iidy.retry_not_before, as migration 004 made
+-- it, gave now() + cap, rather than null, for a null base with a cap,
+-- and items that should have been ready right away waited out the cap.
+create or replace function iidy.retry_not_before(attempts integer, base interval, cap interval) returns timestamptz as $$
+	select case when base is null
+	            then null
+	            else now() + least(cap, base * power(2, least(attempts, 30)))
+	       end;
+$$ language sql stable;
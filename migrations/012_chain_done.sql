@@ -0,0 +1,44 @@
+-- Completing an item through its status (see migration 008) is as much
+-- the item being done as deleting it is, so it chains the item, too.
+-- Since an item that is done may later be deleted, deleting it no longer
+-- chains it a second time.
+create or replace function iidy.chain_deleted() returns trigger as $$
+begin
+	if current_setting('iidy.no_chain', true) = 'on' then
+		return null;
+	end if;
+	insert into iidy.lists
+	       (list, item, batch_id)
+	select c.next_list,
+	       coalesce(replace(c.template, '{item}', d.item), d.item),
+	       d.batch_id
+	  from deleted d
+	  join iidy.chains c on c.list = d.list
+	 where d.status <> 'done'
+	    on conflict (list, item) do nothing;
+	return null;
+end;
+$$ language plpgsql;
+
+create function iidy.chain_done() returns trigger as $$
+begin
+	if current_setting('iidy.no_chain', true) = 'on' then
+		return null;
+	end if;
+	insert into iidy.lists
+	       (list, item, batch_id)
+	select c.next_list,
+	       coalesce(replace(c.template, '{item}', new.item), new.item),
+	       new.batch_id
+	  from iidy.chains c
+	 where c.list = new.list
+	    on conflict (list, item) do nothing;
+	return null;
+end;
+$$ language plpgsql;
+
+create trigger lists_chain_done
+	after update of status on iidy.lists
+	for each row
+	when (new.status = 'done' and old.status <> 'done')
+	execute function iidy.chain_done();
@@ -0,0 +1,91 @@
+// Package migrations holds iidy's database migrations, which are
+// tern migrations (https://github.com/jackc/tern), and can run them.
+// The SQL files are embedded, so the migrations can be run from
+// anywhere, not just from a checkout of iidy.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/tern/migrate"
+)
+
+// VersionTable is the table that tern keeps track of migrations in.
+const VersionTable string = "public.schema_version"
+
+// FS holds the migrations' SQL files.
+//go:embed *.sql
+var FS embed.FS
+
+// names gives the names of the migrations' SQL files, in the order
+// they are run in.
+func names() ([]string, error) {
+	names, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	// Migrations are named 001_..., 002_..., so sorting by name sorts
+	// them by sequence.
+	sort.Strings(names)
+	return names, nil
+}
+
+// Latest gives the version of the latest migration, which is the
+// version that Migrate brings a database up to.
+func Latest() (int32, error) {
+	names, err := names()
+	if err != nil {
+		return 0, err
+	}
+	return int32(len(names)), nil
+}
+
+// Version gives the version of the latest migration that has been run
+// against the database that conn is connected to. Unlike tern, it does
+// not create the version table if it is missing, so it can be run
+// against a read-only database.
+func Version(ctx context.Context, conn *pgx.Conn) (int32, error) {
+	var version int32
+	err := conn.QueryRow(ctx, "select version from "+VersionTable).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("could not get migration version: %v", err)
+	}
+	return version, nil
+}
+
+// Migrate brings the database that conn is connected to up to
+// the latest migration.
+func Migrate(ctx context.Context, conn *pgx.Conn) error {
+	migrator, err := migrate.NewMigrator(ctx, conn, VersionTable)
+	if err != nil {
+		return fmt.Errorf("could not create tern migrator: %v", err)
+	}
+	names, err := names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		sql, err := FS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		// tern puts any down migration after this separator.
+		parts := strings.SplitN(string(sql), "---- create above / drop below ----", 2)
+		down := ""
+		if len(parts) == 2 {
+			down = parts[1]
+		}
+		migrator.AppendMigration(name, parts[0], down)
+	}
+	err = migrator.Migrate(ctx)
+	if err != nil {
+		return fmt.Errorf("could not run tern migration: %v", err)
+	}
+	return nil
+}
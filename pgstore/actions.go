@@ -0,0 +1,145 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// The actions that an ItemAction can take.
+const (
+	ActionInsert    string = "insert"
+	ActionIncrement string = "increment"
+	ActionDelete    string = "delete"
+)
+
+// ItemAction is something to do to an item: insert it, increment
+// its attempts, or delete it.
+type ItemAction struct {
+	Item   string `json:"item"`
+	Action string `json:"action"`
+}
+
+// ActionCounts counts the items that a batch of ItemActions
+// inserted, incremented, and deleted.
+type ActionCounts struct {
+	Added       int64 `json:"added"`
+	Incremented int64 `json:"incremented"`
+	Deleted     int64 `json:"deleted"`
+	// Transitioned counts items moved to a new status (see
+	// Transitioner). A batch of ItemActions never does that, so
+	// ApplyBatch leaves it 0.
+	Transitioned int64 `json:"transitioned,omitempty"`
+}
+
+// ValidateActions checks that every action is one of ActionInsert,
+// ActionIncrement, or ActionDelete, returning a *ValidationError
+// for the first that is not.
+func ValidateActions(actions []ItemAction) error {
+	for _, a := range actions {
+		switch a.Action {
+		case ActionInsert, ActionIncrement, ActionDelete:
+		default:
+			return &ValidationError{Field: "action", Value: a.Action, Reason: fmt.Sprintf(`for item %q, must be one of "insert", "increment", or "delete"`, a.Item)}
+		}
+	}
+	return nil
+}
+
+// actionRuns splits actions into runs of the same action, in order,
+// so that each run can be done with one statement.
+func actionRuns(actions []ItemAction) [][]ItemAction {
+	var runs [][]ItemAction
+	start := 0
+	for i := 1; i <= len(actions); i++ {
+		if i == len(actions) || actions[i].Action != actions[start].Action {
+			runs = append(runs, actions[start:i])
+			start = i
+		}
+	}
+	return runs
+}
+
+// actionItems gives just the items of the actions.
+func actionItems(actions []ItemAction) []string {
+	items := make([]string, 0, len(actions))
+	for _, a := range actions {
+		items = append(items, a.Item)
+	}
+	return items
+}
+
+// ApplyBatch does a batch of ItemActions to the specified list, in order,
+// in one transaction, so that, say, a worker that has finished a page of
+// items can increment the failures and delete the successes all at once.
+// If any action fails (such as inserting an item that is already in the
+// list), none of them are done. Incrementing or deleting an item that is
+// not in the list is not a failure; it is just not counted.
+//
+// The transaction is serializable, so that concurrent batches touching
+// the same items behave as though run one after the other; one that
+// loses a race with another is run again (see PgStore.TxRetries).
+func (p *PgStore) ApplyBatch(ctx context.Context, list string, actions []ItemAction) (ActionCounts, error) {
+	var counts ActionCounts
+	if err := p.validator().Validate(list, actionItems(actions)...); err != nil {
+		return counts, err
+	}
+	if err := ValidateActions(actions); err != nil {
+		return counts, err
+	}
+	if len(actions) == 0 {
+		return counts, nil
+	}
+	batchID := nullIfEmpty(BatchIDFromContext(ctx))
+	base, limit := p.RetryDelays.For(list).args()
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return ActionCounts{}, err
+	}
+	defer conn.Release()
+	// See DeleteBatch for why we unnest the arrays.
+	err = p.inTx(ctx, conn, pgx.Serializable, func(tx pgx.Tx) error {
+		counts = ActionCounts{}
+		for _, run := range actionRuns(actions) {
+			items := actionItems(run)
+			switch run[0].Action {
+			case ActionInsert:
+				commandTag, err := tx.Exec(ctx, `
+					insert into iidy.lists
+					(list, item, batch_id)
+					select $1, unnest($2::text[]), $3`, list, items, batchID)
+				if err != nil {
+					return err
+				}
+				counts.Added += commandTag.RowsAffected()
+			case ActionIncrement:
+				commandTag, err := tx.Exec(ctx, `
+					update iidy.lists
+					   set attempts = attempts + 1,
+					       status = iidy.incremented_status(list, attempts + 1),
+					       not_before = iidy.list_retry_not_before(list, attempts, $3, $4)
+					 where list = $1
+					   and item in (select unnest($2::text[]))`, list, items, base, limit)
+				if err != nil {
+					return err
+				}
+				counts.Incremented += commandTag.RowsAffected()
+			case ActionDelete:
+				commandTag, err := tx.Exec(ctx, `
+					delete from iidy.lists
+					      where list = $1
+					        and item in (select unnest($2::text[]))`, list, items)
+				if err != nil {
+					return err
+				}
+				counts.Deleted += commandTag.RowsAffected()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ActionCounts{}, insertError(err)
+	}
+	return counts, nil
+}
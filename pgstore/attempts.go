@@ -0,0 +1,93 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// AttemptsSetter is implemented by stores that can take attempts back,
+// as well as increment them.
+type AttemptsSetter interface {
+	DecrementOne(ctx context.Context, list string, item string) (int64, error)
+	DecrementBatch(ctx context.Context, list string, items []string) (int64, error)
+	SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error)
+	SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error)
+}
+
+// ValidateAttempts checks a number of attempts to set items to,
+// returning a *ValidationError if it is negative.
+func ValidateAttempts(attempts int) error {
+	if attempts < 0 {
+		return &ValidationError{Field: "attempts", Value: fmt.Sprint(attempts), Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// DecrementOne takes one off the attempts of an item in the specified
+// list. The first return value is the number of items found and
+// decremented (1 or 0); an item with no attempts is left alone, since
+// attempts are never negative.
+func (p *PgStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	return p.DecrementBatch(ctx, list, []string{item})
+}
+
+// DecrementBatch takes one off the attempts of each of the items in the
+// specified list, making them ready right away (see BatchQuery.Ready).
+// Items with no attempts are left alone. The first return value is the
+// number of items decremented.
+func (p *PgStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	// See IncrementBatch for why we unnest the array.
+	return p.updateAttempts(ctx, list, items, `
+		update iidy.lists
+		   set attempts = attempts - 1,
+		       not_before = null
+		 where list = $1
+		   and item in (select unnest($2::text[]))
+		   and attempts > 0`)
+}
+
+// SetAttemptsOne sets the attempts of an item in the specified list.
+// The first return value is the number of items found and set (1 or 0).
+func (p *PgStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	return p.SetAttemptsBatch(ctx, list, []string{item}, attempts)
+}
+
+// SetAttemptsBatch sets the attempts of each of the items in the
+// specified list, making them ready right away (see BatchQuery.Ready),
+// which is handy for a wave of retries: set them to 0, rather than
+// deleting and adding them again. Their statuses are left alone. The
+// first return value is the number of items found and set.
+func (p *PgStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	if err := ValidateAttempts(attempts); err != nil {
+		return 0, err
+	}
+	// See IncrementBatch for why we unnest the array.
+	return p.updateAttempts(ctx, list, items, `
+		update iidy.lists
+		   set attempts = $3,
+		       not_before = null
+		 where list = $1
+		   and item in (select unnest($2::text[]))`, attempts)
+}
+
+// updateAttempts validates the names, and runs sql, an update of
+// the attempts of items in list, with list, items, and args as its
+// parameters, returning the number of items updated.
+func (p *PgStore) updateAttempts(ctx context.Context, list string, items []string, sql string, args ...interface{}) (int64, error) {
+	if err := p.validator().Validate(list, items...); err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	commandTag, err := conn.Exec(ctx, sql, append([]interface{}{list, items}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return commandTag.RowsAffected(), nil
+}
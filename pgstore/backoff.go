@@ -0,0 +1,58 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// Backoff retries something that can fail for a while, such as connecting
+// to a database that is still starting up, waiting Initial after the first
+// failure, and twice as long after each failure after that, but never more
+// than Max, until Limit has passed.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Limit   time.Duration
+	// Clock does the waiting. If nil, clock.System is used.
+	Clock clock.Clock
+}
+
+// Retry calls f until it succeeds, Limit has passed, or ctx is done,
+// in which case the last error from f is given. After each failure that
+// will be retried, onRetry (unless it is nil) is told how many
+// attempts have failed, the error, and how long it will be until
+// the next attempt.
+func (b Backoff) Retry(ctx context.Context, f func(ctx context.Context) error, onRetry func(attempt int, err error, wait time.Duration)) error {
+	c := clock.OrSystem(b.Clock)
+	start := c.Now()
+	wait := b.Initial
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+		left := b.Limit - c.Since(start)
+		if left <= 0 {
+			return err
+		}
+		if wait > left {
+			wait = left
+		}
+		next := c.After(wait)
+		if onRetry != nil {
+			onRetry(attempt, err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v (gave up: %v)", err, ctx.Err())
+		case <-next:
+		}
+		wait *= 2
+		if wait > b.Max {
+			wait = b.Max
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+func TestBackoff(t *testing.T) {
+	fake := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := Backoff{Initial: time.Second, Max: 4 * time.Second, Limit: 10 * time.Second, Clock: fake}
+	var waits []time.Duration
+	// The fake clock never moves on its own, so move it along
+	// by however long the backoff says it will wait.
+	onRetry := func(attempt int, err error, wait time.Duration) {
+		waits = append(waits, wait)
+		fake.Advance(wait)
+	}
+
+	calls := 0
+	err := b.Retry(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, onRetry)
+	if err != nil {
+		t.Errorf("Retry gave error: %v", err)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("got waits %v want %v", waits, want)
+	}
+
+	waits = nil
+	err = b.Retry(context.Background(), func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, onRetry)
+	if err == nil {
+		t.Errorf("Retry did not give up")
+	}
+	// 1 + 2 + 4 + 3 (what is left of the limit) seconds.
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 3 * time.Second}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("got waits %v want %v", waits, want)
+	}
+}
@@ -0,0 +1,233 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BatchQuery says which ListEntries QueryBatch gets from a list.
+// The zero BatchQuery gets nothing; set Count.
+type BatchQuery struct {
+	// StartID is where to start in the (alphabetically sorted) list.
+	// If it is an empty string, the list is read from the beginning.
+	StartID string
+	// Inclusive, if true, includes the item named by StartID
+	// (if it is in the list); otherwise, the list is read from
+	// just after StartID.
+	Inclusive bool
+	// Count is the most ListEntries to get.
+	Count int
+	// UpdatedBefore, if not zero, leaves out items that were inserted,
+	// or had their attempts changed, at or after UpdatedBefore.
+	UpdatedBefore time.Time
+	// UpdatedAfter, if not zero, leaves out items that were inserted,
+	// or had their attempts changed, at or before UpdatedAfter.
+	UpdatedAfter time.Time
+	// OldestFirst, if true, sorts the items by when they were last
+	// updated, rather than alphabetically. Since the items' order changes
+	// as they are worked on, StartID makes little sense with it: instead,
+	// work on the oldest items (which makes them newest) and ask again.
+	OldestFirst bool
+	// MostAttemptsFirst, if true, sorts the items by their attempts,
+	// highest first (and then alphabetically), rather than just
+	// alphabetically. Like OldestFirst, it makes little sense with
+	// StartID. If both are true, OldestFirst wins.
+	MostAttemptsFirst bool
+	// Ready, if true, leaves out items that are still waiting out the
+	// delay after their attempts were last incremented (see RetryDelay).
+	Ready bool
+	// Status, if not empty, leaves out items that do not have that
+	// status (see StatusPending).
+	Status string
+	// MinAttempts leaves out items with fewer attempts than it.
+	MinAttempts int
+	// MaxAttempts, if not nil, leaves out items with more attempts
+	// than it. It is a pointer since 0 is a useful maximum: it picks
+	// out the items that have never been tried.
+	MaxAttempts *int
+}
+
+// GetBatch gets a slice of ListEntries from the specified list
+// (alphabetically sorted), starting after the startID, or from the beginning
+// of the list, if startID is an empty string. If there is nothing to be found,
+// an empty slice is returned.
+func (p *PgStore) GetBatch(ctx context.Context, list string, startID string, count int) ([]ListEntry, error) {
+	return p.QueryBatch(ctx, list, BatchQuery{StartID: startID, Count: count})
+}
+
+// QueryBatch is like GetBatch, with the options of a BatchQuery.
+//
+// The general pattern being followed here is explained very well at
+// http://use-the-index-luke.com/sql/partial-results/fetch-next-page
+func (p *PgStore) QueryBatch(ctx context.Context, list string, q BatchQuery) ([]ListEntry, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return nil, err
+	}
+	if err := ValidateStatus(q.Status); err != nil {
+		return nil, err
+	}
+	if q.StartID != "" {
+		if err := p.validator().ValidateItem(q.StartID); err != nil {
+			return nil, err
+		}
+	}
+	if q.Count == 0 {
+		return []ListEntry{}, nil
+	}
+	where, args := batchWhere(q, list, q.Count)
+	sql := `
+      select item,
+             attempts
+        from iidy.lists
+       where list = $1
+         ` + where + `
+    order by ` + batchOrder(q) + `
+       limit $2`
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	// Up front, may as well allocate as much memory
+	// as we need for the entire list.
+	items := make([]ListEntry, 0, q.Count)
+	var item string
+	var attempts int
+	for rows.Next() {
+		err = rows.Scan(&item, &attempts)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		items = append(items, ListEntry{Item: item, Attempts: attempts})
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("%v", rows.Err())
+	}
+	return items, nil
+}
+
+// CountBatch counts the ListEntries that QueryBatch would get from the
+// specified list if q.Count were unlimited: that is, how many are left,
+// starting from q.StartID. The count is exact, which means reading every
+// one of them, unless estimate is true, in which case PostgreSQL's query
+// planner guesses it, which is cheap, but can be well off.
+func (p *PgStore) CountBatch(ctx context.Context, list string, q BatchQuery, estimate bool) (int64, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return 0, err
+	}
+	if err := ValidateStatus(q.Status); err != nil {
+		return 0, err
+	}
+	if q.StartID != "" {
+		if err := p.validator().ValidateItem(q.StartID); err != nil {
+			return 0, err
+		}
+	}
+	where, args := batchWhere(q, list)
+	sql := `
+      select count(*)
+        from iidy.lists
+       where list = $1
+         ` + where
+	if estimate {
+		return p.estimateRows(ctx, sql, args...)
+	}
+	var count int64
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	err = conn.QueryRow(ctx, sql, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return count, nil
+}
+
+// estimateRows gives the query planner's estimate of how many rows
+// sql, a select count(*), would count.
+func (p *PgStore) estimateRows(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+	var plan string
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	err = conn.QueryRow(ctx, "explain (format json) "+strings.Replace(sql, "count(*)", "1", 1), args...).Scan(&plan)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		}
+	}
+	if err := json.Unmarshal([]byte(plan), &explained); err != nil || len(explained) == 0 {
+		return 0, fmt.Errorf("could not read query plan %q: %v", plan, err)
+	}
+	return int64(explained[0].Plan.Rows), nil
+}
+
+// batchWhere gives the conditions (after "where list = $1") that pick
+// out the ListEntries described by q, and the args to go with them,
+// which start with args.
+func batchWhere(q BatchQuery, args ...interface{}) (string, []interface{}) {
+	var where []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.StartID != "" {
+		op := ">"
+		if q.Inclusive {
+			op = ">="
+		}
+		where = append(where, fmt.Sprintf("and item %s %s", op, arg(q.StartID)))
+	}
+	if !q.UpdatedBefore.IsZero() {
+		where = append(where, fmt.Sprintf("and updated_at < %s", arg(q.UpdatedBefore)))
+	}
+	if !q.UpdatedAfter.IsZero() {
+		where = append(where, fmt.Sprintf("and updated_at > %s", arg(q.UpdatedAfter)))
+	}
+	if q.Ready {
+		where = append(where, "and (not_before is null or not_before <= now())")
+	}
+	if q.Status != "" {
+		where = append(where, fmt.Sprintf("and status = %s", arg(q.Status)))
+	}
+	if q.MinAttempts > 0 {
+		where = append(where, fmt.Sprintf("and attempts >= %s", arg(q.MinAttempts)))
+	}
+	if q.MaxAttempts != nil {
+		where = append(where, fmt.Sprintf("and attempts <= %s", arg(*q.MaxAttempts)))
+	}
+	return strings.Join(where, "\n         "), args
+}
+
+// batchOrder gives the order by clause for q, which uses the primary
+// key, the list_updated_at index, or the list_attempts index.
+func batchOrder(q BatchQuery) string {
+	if q.OldestFirst {
+		return `list,
+             updated_at,
+             item`
+	}
+	if q.MostAttemptsFirst {
+		return `list,
+             attempts desc,
+             item`
+	}
+	return `list,
+             item`
+}
@@ -0,0 +1,656 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// ErrUnavailable is the error that a BreakerStore gives, without calling
+// the store it wraps, while its circuit is open.
+var ErrUnavailable = errors.New("data store is unavailable")
+
+// Circuit states of a BreakerStore.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// BreakerStore is a Store that wraps another Store, and stops calling it
+// while the database behind it is down, so that requests fail fast with
+// ErrUnavailable instead of each one waiting out connection timeouts.
+//
+// A failed call does not, on its own, mean that the database is down (it
+// could be a duplicate item, say), so after each failed call, Probe is
+// called to find out. After Threshold failed calls in a row whose probes
+// also failed, the circuit opens. Once it has been open for Cooldown, the
+// next call probes again: if the probe succeeds, the circuit closes, and
+// the call goes through; otherwise, the circuit stays open for another
+// Cooldown. Calls to the side interfaces of the wrapped store, such as
+// StreamInserter, count, and are turned away, the same as any other
+// (see ErrNotImplemented).
+//
+// BreakerStore satisfies expvar.Var, so its state can be published with
+// expvar.Publish.
+type BreakerStore struct {
+	Store Store
+	// Probe checks that the database is up, such as PgStore.Ping.
+	Probe        func(ctx context.Context) error
+	Threshold    int
+	Cooldown     time.Duration
+	ProbeTimeout time.Duration
+	// Clock times the cooldown. If nil, clock.System is used.
+	Clock clock.Clock
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trips    int64
+	rejected int64
+}
+
+// NewBreakerStore constructs a new BreakerStore that wraps s, and checks
+// the database with probe. By default, the circuit opens after 5 failures,
+// and probes again every 5 seconds, giving each probe 2 seconds.
+func NewBreakerStore(s Store, probe func(ctx context.Context) error) *BreakerStore {
+	return &BreakerStore{
+		Store:        s,
+		Probe:        probe,
+		Threshold:    5,
+		Cooldown:     5 * time.Second,
+		ProbeTimeout: 2 * time.Second,
+		state:        CircuitClosed,
+	}
+}
+
+// State gives the state of the circuit: CircuitClosed, CircuitOpen,
+// or CircuitHalfOpen (while a probe is checking whether to close it).
+func (b *BreakerStore) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// String satisfies expvar.Var, giving the state of the circuit, the
+// failures counted towards opening it, how many times it has opened,
+// and how many calls it has turned away, as JSON.
+func (b *BreakerStore) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v := struct {
+		State    string `json:"state"`
+		Failures int    `json:"failures"`
+		Trips    int64  `json:"trips"`
+		Rejected int64  `json:"rejected"`
+	}{b.state, b.failures, b.trips, b.rejected}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(j)
+}
+
+// probe checks the database, giving up after ProbeTimeout.
+func (b *BreakerStore) probe(ctx context.Context) error {
+	if b.ProbeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.ProbeTimeout)
+		defer cancel()
+	}
+	return b.Probe(ctx)
+}
+
+// before is called before each call to the wrapped store, and gives
+// ErrUnavailable if the call should not be made.
+func (b *BreakerStore) before(ctx context.Context) error {
+	b.mu.Lock()
+	switch b.state {
+	case CircuitClosed:
+		b.mu.Unlock()
+		return nil
+	case CircuitOpen:
+		if clock.OrSystem(b.Clock).Since(b.openedAt) >= b.Cooldown {
+			break
+		}
+		fallthrough
+	default:
+		// Either the cooldown is not over, or another
+		// call is already probing.
+		b.rejected++
+		b.mu.Unlock()
+		return ErrUnavailable
+	}
+	b.state = CircuitHalfOpen
+	b.mu.Unlock()
+
+	err := b.probe(ctx)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.state = CircuitOpen
+		b.openedAt = clock.OrSystem(b.Clock).Now()
+		b.rejected++
+		return ErrUnavailable
+	}
+	log.Printf("Data store is back; closing circuit\n")
+	b.state = CircuitClosed
+	b.failures = 0
+	return nil
+}
+
+// after is called after each call to the wrapped store, with the
+// error (if any) that it gave.
+func (b *BreakerStore) after(ctx context.Context, err error) {
+	if err == nil {
+		b.mu.Lock()
+		b.failures = 0
+		b.mu.Unlock()
+		return
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return
+	}
+	// Probe without ctx's deadline, which may be what the call
+	// ran out of, but with its values.
+	probeErr := b.probe(detach(ctx))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probeErr == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == CircuitClosed && b.failures >= b.Threshold {
+		log.Printf("Data store is down (%v); opening circuit for %v\n", probeErr, b.Cooldown)
+		b.state = CircuitOpen
+		b.openedAt = clock.OrSystem(b.Clock).Now()
+		b.trips++
+	}
+}
+
+// detachedContext carries the values of a context,
+// but not its deadline or cancelation.
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+// detach gives a context with the values of ctx, which
+// is never done.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{Context: context.Background(), values: ctx}
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.values.Value(key)
+}
+
+func (b *BreakerStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.InsertOne(ctx, list, item)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) GetOne(ctx context.Context, list string, item string) (int, bool, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, false, err
+	}
+	attempts, ok, err := b.Store.GetOne(ctx, list, item)
+	b.after(ctx, err)
+	return attempts, ok, err
+}
+
+func (b *BreakerStore) DeleteOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.DeleteOne(ctx, list, item)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) DeleteOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.DeleteOneIfAttempts(ctx, list, item, attempts)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.IncrementOne(ctx, list, item)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) IncrementOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.IncrementOneIfAttempts(ctx, list, item, attempts)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.InsertBatch(ctx, list, items)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) InsertBatchEntries(ctx context.Context, list string, entries []ListEntry) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.InsertBatchEntries(ctx, list, entries)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []ListEntry) (int64, []string, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, nil, err
+	}
+	n, skipped, err := b.Store.InsertBatchIgnoreDuplicates(ctx, list, entries)
+	b.after(ctx, err)
+	return n, skipped, err
+}
+
+func (b *BreakerStore) GetBatch(ctx context.Context, list string, startID string, count int) ([]ListEntry, error) {
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := b.Store.GetBatch(ctx, list, startID, count)
+	b.after(ctx, err)
+	return entries, err
+}
+
+func (b *BreakerStore) QueryBatch(ctx context.Context, list string, q BatchQuery) ([]ListEntry, error) {
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := b.Store.QueryBatch(ctx, list, q)
+	b.after(ctx, err)
+	return entries, err
+}
+
+func (b *BreakerStore) CountBatch(ctx context.Context, list string, q BatchQuery, estimate bool) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.CountBatch(ctx, list, q, estimate)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) GetMulti(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := b.Store.GetMulti(ctx, list, items)
+	b.after(ctx, err)
+	return entries, err
+}
+
+func (b *BreakerStore) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.DeleteBatch(ctx, list, items)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) DeleteBatchReturning(ctx context.Context, list string, items []string) ([]string, error) {
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	deleted, err := b.Store.DeleteBatchReturning(ctx, list, items)
+	b.after(ctx, err)
+	return deleted, err
+}
+
+func (b *BreakerStore) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := b.Store.IncrementBatch(ctx, list, items)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) IncrementBatchReturning(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := b.Store.IncrementBatchReturning(ctx, list, items)
+	b.after(ctx, err)
+	return entries, err
+}
+
+func (b *BreakerStore) ApplyBatch(ctx context.Context, list string, actions []ItemAction) (ActionCounts, error) {
+	if err := b.before(ctx); err != nil {
+		return ActionCounts{}, err
+	}
+	counts, err := b.Store.ApplyBatch(ctx, list, actions)
+	b.after(ctx, err)
+	return counts, err
+}
+
+func (b *BreakerStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	inner, ok := b.Store.(StreamInserter)
+	if !ok {
+		return 0, notImplemented(b.Store, "StreamInserter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.InsertStream(ctx, list, next)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) SetChain(ctx context.Context, chain Chain) error {
+	inner, ok := b.Store.(Chainer)
+	if !ok {
+		return notImplemented(b.Store, "Chainer")
+	}
+	if err := b.before(ctx); err != nil {
+		return err
+	}
+	err := inner.SetChain(ctx, chain)
+	b.after(ctx, err)
+	return err
+}
+
+func (b *BreakerStore) GetChain(ctx context.Context, list string) (*Chain, error) {
+	inner, ok := b.Store.(Chainer)
+	if !ok {
+		return nil, notImplemented(b.Store, "Chainer")
+	}
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	chain, err := inner.GetChain(ctx, list)
+	b.after(ctx, err)
+	return chain, err
+}
+
+func (b *BreakerStore) DeleteChain(ctx context.Context, list string) (int64, error) {
+	inner, ok := b.Store.(Chainer)
+	if !ok {
+		return 0, notImplemented(b.Store, "Chainer")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DeleteChain(ctx, list)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error) {
+	inner, ok := b.Store.(Merger)
+	if !ok {
+		return MergeCounts{}, notImplemented(b.Store, "Merger")
+	}
+	if err := b.before(ctx); err != nil {
+		return MergeCounts{}, err
+	}
+	counts, err := inner.Merge(ctx, dest, src, conflict)
+	b.after(ctx, err)
+	return counts, err
+}
+
+func (b *BreakerStore) TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error) {
+	inner, ok := b.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(b.Store, "Snapshotter")
+	}
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	snapshot, err := inner.TakeSnapshot(ctx, list, label)
+	b.after(ctx, err)
+	return snapshot, err
+}
+
+func (b *BreakerStore) Snapshots(ctx context.Context, list string) ([]Snapshot, error) {
+	inner, ok := b.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(b.Store, "Snapshotter")
+	}
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	snapshots, err := inner.Snapshots(ctx, list)
+	b.after(ctx, err)
+	return snapshots, err
+}
+
+func (b *BreakerStore) RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error) {
+	inner, ok := b.Store.(Snapshotter)
+	if !ok {
+		return RestoreCounts{}, notImplemented(b.Store, "Snapshotter")
+	}
+	if err := b.before(ctx); err != nil {
+		return RestoreCounts{}, err
+	}
+	counts, err := inner.RestoreSnapshot(ctx, list, label)
+	b.after(ctx, err)
+	return counts, err
+}
+
+func (b *BreakerStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	inner, ok := b.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(b.Store, "Snapshotter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DeleteSnapshot(ctx, list, label)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := b.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(b.Store, "Snapshotter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DiffSnapshot(ctx, list, label, f)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	inner, ok := b.Store.(Transitioner)
+	if !ok {
+		return "", false, notImplemented(b.Store, "Transitioner")
+	}
+	if err := b.before(ctx); err != nil {
+		return "", false, err
+	}
+	status, found, err := inner.Transition(ctx, list, item, action)
+	b.after(ctx, err)
+	return status, found, err
+}
+
+func (b *BreakerStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	inner, ok := b.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(b.Store, "AttemptsSetter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DecrementOne(ctx, list, item)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	inner, ok := b.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(b.Store, "AttemptsSetter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DecrementBatch(ctx, list, items)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	inner, ok := b.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(b.Store, "AttemptsSetter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.SetAttemptsOne(ctx, list, item, attempts)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	inner, ok := b.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(b.Store, "AttemptsSetter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.SetAttemptsBatch(ctx, list, items, attempts)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) GetListSettings(ctx context.Context, list string) (ListSettings, error) {
+	inner, ok := b.Store.(Configurer)
+	if !ok {
+		return ListSettings{}, notImplemented(b.Store, "Configurer")
+	}
+	if err := b.before(ctx); err != nil {
+		return ListSettings{}, err
+	}
+	settings, err := inner.GetListSettings(ctx, list)
+	b.after(ctx, err)
+	return settings, err
+}
+
+func (b *BreakerStore) SetListSettings(ctx context.Context, settings ListSettings) error {
+	inner, ok := b.Store.(Configurer)
+	if !ok {
+		return notImplemented(b.Store, "Configurer")
+	}
+	if err := b.before(ctx); err != nil {
+		return err
+	}
+	err := inner.SetListSettings(ctx, settings)
+	b.after(ctx, err)
+	return err
+}
+
+func (b *BreakerStore) StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error) {
+	inner, ok := b.Store.(Streamer)
+	if !ok {
+		return 0, notImplemented(b.Store, "Streamer")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.StreamBatch(ctx, list, q, f)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) Lists(ctx context.Context) ([]ListCount, error) {
+	inner, ok := b.Store.(Lister)
+	if !ok {
+		return nil, notImplemented(b.Store, "Lister")
+	}
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	lists, err := inner.Lists(ctx)
+	b.after(ctx, err)
+	return lists, err
+}
+
+func (b *BreakerStore) CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error) {
+	inner, ok := b.Store.(AttemptsCounter)
+	if !ok {
+		return nil, notImplemented(b.Store, "AttemptsCounter")
+	}
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	counts, err := inner.CountByAttempts(ctx, list)
+	b.after(ctx, err)
+	return counts, err
+}
+
+func (b *BreakerStore) Diff(ctx context.Context, list string, other string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := b.Store.(Differ)
+	if !ok {
+		return 0, notImplemented(b.Store, "Differ")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.Diff(ctx, list, other, f)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	inner, ok := b.Store.(Exporter)
+	if !ok {
+		return 0, notImplemented(b.Store, "Exporter")
+	}
+	if err := b.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.Export(ctx, w, list)
+	b.after(ctx, err)
+	return n, err
+}
+
+func (b *BreakerStore) DBStats(ctx context.Context) (*DBStats, error) {
+	inner, ok := b.Store.(DBStatter)
+	if !ok {
+		return nil, notImplemented(b.Store, "DBStatter")
+	}
+	if err := b.before(ctx); err != nil {
+		return nil, err
+	}
+	stats, err := inner.DBStats(ctx)
+	b.after(ctx, err)
+	return stats, err
+}
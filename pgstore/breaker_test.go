@@ -0,0 +1,91 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// failingStore is a Store whose GetOne fails with err,
+// counting its calls.
+type failingStore struct {
+	Store
+	err   error
+	calls int
+}
+
+func (s *failingStore) GetOne(ctx context.Context, list string, item string) (int, bool, error) {
+	s.calls++
+	return 0, false, s.err
+}
+
+func TestBreakerStore(t *testing.T) {
+	fake := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	inner := &failingStore{err: errors.New("connection refused")}
+	dbDown := true
+	b := NewBreakerStore(inner, func(ctx context.Context) error {
+		if dbDown {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	b.Clock = fake
+	b.Threshold = 2
+	b.Cooldown = time.Minute
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := b.GetOne(ctx, "downloads", "a.txt"); err != inner.err {
+			t.Errorf("call %d: got error %v want %v", i, err, inner.err)
+		}
+	}
+	if b.State() != CircuitOpen {
+		t.Fatalf("circuit is %s after %d failures, want %s", b.State(), b.Threshold, CircuitOpen)
+	}
+	if _, _, err := b.GetOne(ctx, "downloads", "a.txt"); err != ErrUnavailable {
+		t.Errorf("got error %v want %v", err, ErrUnavailable)
+	}
+	if inner.calls != 2 {
+		t.Errorf("open circuit let call through: %d calls", inner.calls)
+	}
+
+	// The probe still fails after the cooldown, so the circuit stays open.
+	fake.Advance(time.Minute)
+	if _, _, err := b.GetOne(ctx, "downloads", "a.txt"); err != ErrUnavailable {
+		t.Errorf("got error %v want %v", err, ErrUnavailable)
+	}
+	if b.State() != CircuitOpen {
+		t.Errorf("circuit is %s after failed probe, want %s", b.State(), CircuitOpen)
+	}
+
+	dbDown = false
+	inner.err = nil
+	fake.Advance(time.Minute)
+	if _, _, err := b.GetOne(ctx, "downloads", "a.txt"); err != nil {
+		t.Errorf("recovered store gave error: %v", err)
+	}
+	if b.State() != CircuitClosed {
+		t.Errorf("circuit is %s after recovery, want %s", b.State(), CircuitClosed)
+	}
+	want := `{"state":"closed","failures":0,"trips":1,"rejected":2}`
+	if got := b.String(); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestBreakerStoreDatabaseUp(t *testing.T) {
+	// Failures while the database is up (such as duplicate items)
+	// never open the circuit.
+	inner := &failingStore{err: errors.New(`duplicate key value violates unique constraint "list_pk"`)}
+	b := NewBreakerStore(inner, func(ctx context.Context) error { return nil })
+	b.Threshold = 1
+	for i := 0; i < 3; i++ {
+		b.GetOne(context.Background(), "downloads", "a.txt")
+	}
+	if b.State() != CircuitClosed {
+		t.Errorf("circuit is %s, want %s", b.State(), CircuitClosed)
+	}
+}
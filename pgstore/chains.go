@@ -0,0 +1,130 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ChainItemPlaceholder stands for an item's name in a Chain's Template.
+const ChainItemPlaceholder string = "{item}"
+
+// Chain says that once an item is deleted from List, or completed (see
+// Transition), because a worker has finished with it, it is inserted
+// into Next, in the same
+// transaction, so that the next stage of a pipeline (download, then
+// verify, then ingest, say) can pick it up without anything in between.
+type Chain struct {
+	List string `json:"list"`
+	Next string `json:"next"`
+	// Template, if not empty, is what the item is called in Next, with
+	// ChainItemPlaceholder standing for its name in List, such as
+	// "s3://verified/{item}". If empty, the item keeps its name.
+	Template string `json:"template,omitempty"`
+}
+
+// Chainer is implemented by stores that can chain lists together.
+type Chainer interface {
+	SetChain(ctx context.Context, c Chain) error
+	GetChain(ctx context.Context, list string) (*Chain, error)
+	DeleteChain(ctx context.Context, list string) (int64, error)
+}
+
+// validateChain checks the names in c.
+func (p *PgStore) validateChain(c Chain) error {
+	if err := p.validator().ValidateList(c.List); err != nil {
+		return err
+	}
+	if err := p.validator().ValidateList(c.Next); err != nil {
+		return err
+	}
+	if c.Next == c.List {
+		return &ValidationError{Field: "list", Value: c.Next, Reason: "cannot be chained to itself"}
+	}
+	if c.Template != "" && !strings.Contains(c.Template, ChainItemPlaceholder) {
+		return &ValidationError{Field: "template", Value: c.Template, Reason: "must contain " + ChainItemPlaceholder}
+	}
+	return nil
+}
+
+// SetChain chains c.List to c.Next, replacing any chain c.List already
+// had. Every item deleted from c.List after that, by any delete (one item,
+// a batch, or an action), or completed by Transition, is inserted into
+// c.Next by a trigger, in the same transaction as the delete or the
+// transition, with its attempts reset to 0. An item that is done is not
+// inserted again when it is later deleted, and an item that is already
+// in c.Next is left as it is.
+func (p *PgStore) SetChain(ctx context.Context, c Chain) error {
+	if err := p.validateChain(c); err != nil {
+		return err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	_, err = conn.Exec(ctx, `
+		insert into iidy.chains
+		       (list, next_list, template)
+		values ($1, $2, $3)
+		    on conflict (list) do update
+		   set next_list = excluded.next_list,
+		       template = excluded.template`, c.List, c.Next, nullIfEmpty(c.Template))
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// GetChain gets the chain from the specified list, or nil if
+// it is not chained to another list.
+func (p *PgStore) GetChain(ctx context.Context, list string) (*Chain, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return nil, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	c := Chain{List: list}
+	var template *string
+	err = conn.QueryRow(ctx, `
+		select next_list,
+		       template
+		  from iidy.chains
+		 where list = $1`, list).Scan(&c.Next, &template)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	if template != nil {
+		c.Template = *template
+	}
+	return &c, nil
+}
+
+// DeleteChain unchains the specified list from the list it was
+// chained to. The first return value is the number of chains
+// deleted (1 or 0).
+func (p *PgStore) DeleteChain(ctx context.Context, list string) (int64, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return 0, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	commandTag, err := conn.Exec(ctx, `
+		delete from iidy.chains
+		      where list = $1`, list)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return commandTag.RowsAffected(), nil
+}
@@ -0,0 +1,754 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// ErrInjected is the error that a ChaosStore injects.
+var ErrInjected = errors.New("injected fault")
+
+// ChaosStore is a Store that wraps another Store, and misbehaves on
+// purpose, so that clients' retries and idempotency can be tested against
+// a misbehaving iidy before production does it for real.
+//
+// Each call is delayed by Latency, LatencyRate of the time; it then fails
+// with ErrInjected, without reaching the wrapped store, ErrorRate of the
+// time. Otherwise, the call goes through, but still fails with ErrInjected
+// ErrorAfterRate of the time, as if the response had been lost after the
+// change was made. Rates run from 0 (never) to 1 (always). Calls to the
+// side interfaces of the wrapped store, such as StreamInserter, misbehave
+// too (see ErrNotImplemented).
+type ChaosStore struct {
+	Store          Store
+	Latency        time.Duration
+	LatencyRate    float64
+	ErrorRate      float64
+	ErrorAfterRate float64
+	// Clock does the waiting. If nil, clock.System is used.
+	Clock clock.Clock
+	// Rand gives random numbers from 0 to 1. If nil, math/rand is used.
+	Rand func() float64
+}
+
+// NewChaosStore constructs a new ChaosStore that wraps s,
+// but does not misbehave until its rates are set.
+func NewChaosStore(s Store) *ChaosStore {
+	return &ChaosStore{Store: s}
+}
+
+// String describes how c misbehaves.
+func (c *ChaosStore) String() string {
+	return fmt.Sprintf("latency=%v latency_rate=%v error_rate=%v error_after_rate=%v",
+		c.Latency, c.LatencyRate, c.ErrorRate, c.ErrorAfterRate)
+}
+
+// happens tells us whether something that happens
+// rate of the time is happening now.
+func (c *ChaosStore) happens(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	r := rand.Float64
+	if c.Rand != nil {
+		r = c.Rand
+	}
+	return r() < rate
+}
+
+// before is called before each call to the wrapped store,
+// to delay it, or fail it, or both.
+func (c *ChaosStore) before(ctx context.Context) error {
+	if c.Latency > 0 && c.happens(c.LatencyRate) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v", ctx.Err())
+		case <-clock.OrSystem(c.Clock).After(c.Latency):
+		}
+	}
+	if c.happens(c.ErrorRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+// after is called after each successful call to the wrapped store,
+// to fail it anyway.
+func (c *ChaosStore) after() error {
+	if c.happens(c.ErrorAfterRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+func (c *ChaosStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.InsertOne(ctx, list, item)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) GetOne(ctx context.Context, list string, item string) (int, bool, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, false, err
+	}
+	attempts, ok, err := c.Store.GetOne(ctx, list, item)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := c.after(); err != nil {
+		return 0, false, err
+	}
+	return attempts, ok, nil
+}
+
+func (c *ChaosStore) DeleteOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.DeleteOne(ctx, list, item)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) DeleteOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.DeleteOneIfAttempts(ctx, list, item, attempts)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.IncrementOne(ctx, list, item)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) IncrementOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.IncrementOneIfAttempts(ctx, list, item, attempts)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.InsertBatch(ctx, list, items)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) InsertBatchEntries(ctx context.Context, list string, entries []ListEntry) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.InsertBatchEntries(ctx, list, entries)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []ListEntry) (int64, []string, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, nil, err
+	}
+	n, skipped, err := c.Store.InsertBatchIgnoreDuplicates(ctx, list, entries)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := c.after(); err != nil {
+		return 0, nil, err
+	}
+	return n, skipped, nil
+}
+
+func (c *ChaosStore) GetBatch(ctx context.Context, list string, startID string, count int) ([]ListEntry, error) {
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := c.Store.GetBatch(ctx, list, startID, count)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *ChaosStore) QueryBatch(ctx context.Context, list string, q BatchQuery) ([]ListEntry, error) {
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := c.Store.QueryBatch(ctx, list, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *ChaosStore) CountBatch(ctx context.Context, list string, q BatchQuery, estimate bool) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.CountBatch(ctx, list, q, estimate)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) GetMulti(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := c.Store.GetMulti(ctx, list, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *ChaosStore) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.DeleteBatch(ctx, list, items)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) DeleteBatchReturning(ctx context.Context, list string, items []string) ([]string, error) {
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	deleted, err := c.Store.DeleteBatchReturning(ctx, list, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (c *ChaosStore) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.Store.IncrementBatch(ctx, list, items)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) IncrementBatchReturning(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := c.Store.IncrementBatchReturning(ctx, list, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *ChaosStore) ApplyBatch(ctx context.Context, list string, actions []ItemAction) (ActionCounts, error) {
+	if err := c.before(ctx); err != nil {
+		return ActionCounts{}, err
+	}
+	counts, err := c.Store.ApplyBatch(ctx, list, actions)
+	if err != nil {
+		return ActionCounts{}, err
+	}
+	if err := c.after(); err != nil {
+		return ActionCounts{}, err
+	}
+	return counts, nil
+}
+
+func (c *ChaosStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	inner, ok := c.Store.(StreamInserter)
+	if !ok {
+		return 0, notImplemented(c.Store, "StreamInserter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.InsertStream(ctx, list, next)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) SetChain(ctx context.Context, chain Chain) error {
+	inner, ok := c.Store.(Chainer)
+	if !ok {
+		return notImplemented(c.Store, "Chainer")
+	}
+	if err := c.before(ctx); err != nil {
+		return err
+	}
+	err := inner.SetChain(ctx, chain)
+	if err != nil {
+		return err
+	}
+	if err := c.after(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ChaosStore) GetChain(ctx context.Context, list string) (*Chain, error) {
+	inner, ok := c.Store.(Chainer)
+	if !ok {
+		return nil, notImplemented(c.Store, "Chainer")
+	}
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	chain, err := inner.GetChain(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+func (c *ChaosStore) DeleteChain(ctx context.Context, list string) (int64, error) {
+	inner, ok := c.Store.(Chainer)
+	if !ok {
+		return 0, notImplemented(c.Store, "Chainer")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DeleteChain(ctx, list)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error) {
+	inner, ok := c.Store.(Merger)
+	if !ok {
+		return MergeCounts{}, notImplemented(c.Store, "Merger")
+	}
+	if err := c.before(ctx); err != nil {
+		return MergeCounts{}, err
+	}
+	counts, err := inner.Merge(ctx, dest, src, conflict)
+	if err != nil {
+		return MergeCounts{}, err
+	}
+	if err := c.after(); err != nil {
+		return MergeCounts{}, err
+	}
+	return counts, nil
+}
+
+func (c *ChaosStore) TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error) {
+	inner, ok := c.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(c.Store, "Snapshotter")
+	}
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	snapshot, err := inner.TakeSnapshot(ctx, list, label)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (c *ChaosStore) Snapshots(ctx context.Context, list string) ([]Snapshot, error) {
+	inner, ok := c.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(c.Store, "Snapshotter")
+	}
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	snapshots, err := inner.Snapshots(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (c *ChaosStore) RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error) {
+	inner, ok := c.Store.(Snapshotter)
+	if !ok {
+		return RestoreCounts{}, notImplemented(c.Store, "Snapshotter")
+	}
+	if err := c.before(ctx); err != nil {
+		return RestoreCounts{}, err
+	}
+	counts, err := inner.RestoreSnapshot(ctx, list, label)
+	if err != nil {
+		return RestoreCounts{}, err
+	}
+	if err := c.after(); err != nil {
+		return RestoreCounts{}, err
+	}
+	return counts, nil
+}
+
+func (c *ChaosStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	inner, ok := c.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(c.Store, "Snapshotter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DeleteSnapshot(ctx, list, label)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := c.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(c.Store, "Snapshotter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DiffSnapshot(ctx, list, label, f)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	inner, ok := c.Store.(Transitioner)
+	if !ok {
+		return "", false, notImplemented(c.Store, "Transitioner")
+	}
+	if err := c.before(ctx); err != nil {
+		return "", false, err
+	}
+	status, found, err := inner.Transition(ctx, list, item, action)
+	if err != nil {
+		return "", false, err
+	}
+	if err := c.after(); err != nil {
+		return "", false, err
+	}
+	return status, found, nil
+}
+
+func (c *ChaosStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	inner, ok := c.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(c.Store, "AttemptsSetter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DecrementOne(ctx, list, item)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	inner, ok := c.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(c.Store, "AttemptsSetter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.DecrementBatch(ctx, list, items)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	inner, ok := c.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(c.Store, "AttemptsSetter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.SetAttemptsOne(ctx, list, item, attempts)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	inner, ok := c.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(c.Store, "AttemptsSetter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.SetAttemptsBatch(ctx, list, items, attempts)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) GetListSettings(ctx context.Context, list string) (ListSettings, error) {
+	inner, ok := c.Store.(Configurer)
+	if !ok {
+		return ListSettings{}, notImplemented(c.Store, "Configurer")
+	}
+	if err := c.before(ctx); err != nil {
+		return ListSettings{}, err
+	}
+	settings, err := inner.GetListSettings(ctx, list)
+	if err != nil {
+		return ListSettings{}, err
+	}
+	if err := c.after(); err != nil {
+		return ListSettings{}, err
+	}
+	return settings, nil
+}
+
+func (c *ChaosStore) SetListSettings(ctx context.Context, settings ListSettings) error {
+	inner, ok := c.Store.(Configurer)
+	if !ok {
+		return notImplemented(c.Store, "Configurer")
+	}
+	if err := c.before(ctx); err != nil {
+		return err
+	}
+	err := inner.SetListSettings(ctx, settings)
+	if err != nil {
+		return err
+	}
+	if err := c.after(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ChaosStore) StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error) {
+	inner, ok := c.Store.(Streamer)
+	if !ok {
+		return 0, notImplemented(c.Store, "Streamer")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.StreamBatch(ctx, list, q, f)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) Lists(ctx context.Context) ([]ListCount, error) {
+	inner, ok := c.Store.(Lister)
+	if !ok {
+		return nil, notImplemented(c.Store, "Lister")
+	}
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	lists, err := inner.Lists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func (c *ChaosStore) CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error) {
+	inner, ok := c.Store.(AttemptsCounter)
+	if !ok {
+		return nil, notImplemented(c.Store, "AttemptsCounter")
+	}
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	counts, err := inner.CountByAttempts(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (c *ChaosStore) Diff(ctx context.Context, list string, other string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := c.Store.(Differ)
+	if !ok {
+		return 0, notImplemented(c.Store, "Differ")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.Diff(ctx, list, other, f)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	inner, ok := c.Store.(Exporter)
+	if !ok {
+		return 0, notImplemented(c.Store, "Exporter")
+	}
+	if err := c.before(ctx); err != nil {
+		return 0, err
+	}
+	n, err := inner.Export(ctx, w, list)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.after(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *ChaosStore) DBStats(ctx context.Context) (*DBStats, error) {
+	inner, ok := c.Store.(DBStatter)
+	if !ok {
+		return nil, notImplemented(c.Store, "DBStatter")
+	}
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	stats, err := inner.DBStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.after(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,85 @@
+package pgstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// countingStore is a Store that counts its calls to InsertOne.
+type countingStore struct {
+	Store
+	inserts int
+}
+
+func (s *countingStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	s.inserts++
+	return 1, nil
+}
+
+func TestChaosStore(t *testing.T) {
+	inner := &countingStore{}
+	c := NewChaosStore(inner)
+	roll := 0.5
+	c.Rand = func() float64 { return roll }
+
+	if _, err := c.InsertOne(context.Background(), "downloads", "a.txt"); err != nil {
+		t.Errorf("well-behaved ChaosStore gave error: %v", err)
+	}
+
+	c.ErrorRate = 0.6
+	if _, err := c.InsertOne(context.Background(), "downloads", "a.txt"); err != ErrInjected {
+		t.Errorf("got error %v want %v", err, ErrInjected)
+	}
+	if inner.inserts != 1 {
+		t.Errorf("failed call reached the wrapped store: %d inserts", inner.inserts)
+	}
+
+	c.ErrorRate = 0.4
+	c.ErrorAfterRate = 0.6
+	if _, err := c.InsertOne(context.Background(), "downloads", "a.txt"); err != ErrInjected {
+		t.Errorf("got error %v want %v", err, ErrInjected)
+	}
+	if inner.inserts != 2 {
+		t.Errorf("lost-response call did not reach the wrapped store: %d inserts", inner.inserts)
+	}
+}
+
+func TestChaosStoreLatency(t *testing.T) {
+	fake := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := NewChaosStore(&countingStore{})
+	c.Clock = fake
+	c.Latency = time.Second
+	c.LatencyRate = 1
+
+	done := make(chan error)
+	go func() {
+		_, err := c.InsertOne(context.Background(), "downloads", "a.txt")
+		done <- err
+	}()
+	for {
+		fake.Advance(time.Second)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("delayed call gave error: %v", err)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestChaosStoreLatencyCanceled(t *testing.T) {
+	c := NewChaosStore(&countingStore{})
+	c.Clock = clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Latency = time.Second
+	c.LatencyRate = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.InsertOne(ctx, "downloads", "a.txt"); err == nil {
+		t.Errorf("call with canceled context did not fail")
+	}
+}
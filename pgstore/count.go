@@ -0,0 +1,58 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// AttemptsCount tells how many items of a list have been attempted
+// a given number of times.
+type AttemptsCount struct {
+	Attempts int   `json:"attempts"`
+	Items    int64 `json:"items"`
+}
+
+// AttemptsCounter is implemented by stores that can count a list's items
+// by their attempts.
+type AttemptsCounter interface {
+	CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error)
+}
+
+// CountByAttempts counts the items of list by their attempts, with one
+// AttemptsCount for each number of attempts that any item has, sorted
+// by attempts. A list with no items gives none.
+func (p *PgStore) CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return nil, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, `
+      select attempts,
+             count(*)
+        from iidy.lists
+       where list = $1
+    group by attempts
+    order by attempts`, list)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	counts := []AttemptsCount{}
+	for rows.Next() {
+		var c AttemptsCount
+		err = rows.Scan(&c.Attempts, &c.Items)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		counts = append(counts, c)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("%v", rows.Err())
+	}
+	return counts, nil
+}
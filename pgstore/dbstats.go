@@ -0,0 +1,79 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DBStats describes the state of a PgStore's connection pool and of the
+// database server it is connected to, for operational triage.
+type DBStats struct {
+	Host                 string `json:"host"`
+	Port                 uint16 `json:"port"`
+	Database             string `json:"database"`
+	User                 string `json:"user"`
+	MaxConns             int32  `json:"max_conns"`
+	TotalConns           int32  `json:"total_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	ConstructingConns    int32  `json:"constructing_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+	AcquireDurationMs    int64  `json:"acquire_duration_ms"`
+	ServerVersion        string `json:"server_version"`
+	// IsReplica is true if the server is a streaming replica (that is,
+	// it is in recovery), in which case ReplicationLagMs is how far behind
+	// its primary it is.
+	IsReplica        bool   `json:"is_replica"`
+	ReplicationLagMs *int64 `json:"replication_lag_ms,omitempty"`
+}
+
+// DBStatter is implemented by stores that can describe their database.
+type DBStatter interface {
+	DBStats(ctx context.Context) (*DBStats, error)
+}
+
+// DBStats gets live connection pool stats, the connection config (less
+// the password), the server version, and, if the server is a replica,
+// its replication lag.
+func (p *PgStore) DBStats(ctx context.Context) (*DBStats, error) {
+	config := p.pool.Config()
+	stat := p.pool.Stat()
+	s := &DBStats{
+		Host:                 config.ConnConfig.Host,
+		Port:                 config.ConnConfig.Port,
+		Database:             config.ConnConfig.Database,
+		User:                 config.ConnConfig.User,
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		IdleConns:            stat.IdleConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDurationMs:    stat.AcquireDuration().Milliseconds(),
+	}
+	// pg_last_xact_replay_timestamp is null on a primary, and on a replica
+	// that has not replayed anything yet.
+	var lag *float64
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	err = conn.QueryRow(ctx, `
+		select current_setting('server_version'),
+		       pg_is_in_recovery(),
+		       extract(epoch from now() - pg_last_xact_replay_timestamp())::float8`).Scan(&s.ServerVersion, &s.IsReplica, &lag)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	if s.IsReplica && lag != nil {
+		ms := int64(*lag * float64(time.Second/time.Millisecond))
+		s.ReplicationLagMs = &ms
+	}
+	return s, nil
+}
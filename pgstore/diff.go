@@ -0,0 +1,109 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// The kinds of DiffEntry.
+const (
+	// DiffOnlyA is for an item that is only in the first list.
+	DiffOnlyA string = "only_a"
+	// DiffOnlyB is for an item that is only in the second list.
+	DiffOnlyB string = "only_b"
+	// DiffAttempts is for an item that is in both lists, with
+	// different attempts.
+	DiffAttempts string = "attempts"
+)
+
+// DiffEntry is one way in which two lists differ. AttemptsA and
+// AttemptsB are the item's attempts in each list, and are nil for
+// the list that the item is not in.
+type DiffEntry struct {
+	Item      string `json:"item"`
+	Diff      string `json:"diff"`
+	AttemptsA *int   `json:"attempts_a,omitempty"`
+	AttemptsB *int   `json:"attempts_b,omitempty"`
+}
+
+// Differ is implemented by stores that can compare two lists.
+type Differ interface {
+	Diff(ctx context.Context, a string, b string, f func(DiffEntry) error) (int64, error)
+}
+
+// Diff compares list a with list b, calling f, in order of item, for
+// each item that is only in a, only in b, or in both with different
+// attempts. Items that are in both with the same attempts are left out,
+// so that two copies of a list have no diff at all. If f returns an
+// error, Diff stops, and returns it. Diff returns the number of
+// DiffEntries that f was called with.
+//
+// The lists are compared by the database, in one query, and the
+// differences streamed to f as they are found, so that comparing two
+// big lists does not mean holding either of them in memory.
+func (p *PgStore) Diff(ctx context.Context, a string, b string, f func(DiffEntry) error) (int64, error) {
+	if err := p.validator().ValidateList(a); err != nil {
+		return 0, err
+	}
+	if err := p.validator().ValidateList(b); err != nil {
+		return 0, err
+	}
+	return p.diff(ctx, `select item, attempts from iidy.lists where list = $1`,
+		`select item, attempts from iidy.lists where list = $2`, f, a, b)
+}
+
+// diff compares the items and attempts that queries a and b select, as
+// Diff does; args are the arguments of both.
+func (p *PgStore) diff(ctx context.Context, a string, b string, f func(DiffEntry) error, args ...interface{}) (int64, error) {
+	sql := `
+      select coalesce(a.item, b.item),
+             a.attempts,
+             b.attempts
+        from (` + a + `) a
+   full join (` + b + `) b
+          on a.item = b.item
+       where a.item is null
+          or b.item is null
+          or a.attempts <> b.attempts
+    order by 1`
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var count int64
+	for rows.Next() {
+		var e DiffEntry
+		err = rows.Scan(&e.Item, &e.AttemptsA, &e.AttemptsB)
+		if err != nil {
+			return count, fmt.Errorf("%v", err)
+		}
+		e.Diff = diffKind(e.AttemptsA, e.AttemptsB)
+		if err := f(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if rows.Err() != nil {
+		return count, fmt.Errorf("%v", rows.Err())
+	}
+	return count, nil
+}
+
+// diffKind gives the kind of DiffEntry for an item whose attempts in
+// each list are attemptsA and attemptsB (nil if it is not in that list).
+func diffKind(attemptsA *int, attemptsB *int) string {
+	switch {
+	case attemptsB == nil:
+		return DiffOnlyA
+	case attemptsA == nil:
+		return DiffOnlyB
+	}
+	return DiffAttempts
+}
@@ -0,0 +1,42 @@
+package pgstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+)
+
+// sqlStateUniqueViolation is the SQLSTATE of inserting a row whose key
+// is already taken: for iidy.lists, an item that is already in its list.
+const sqlStateUniqueViolation = "23505"
+
+// ErrDuplicate is matched, with errors.Is, by the error that a store
+// gives when asked to insert an item that is already in its list.
+var ErrDuplicate = errors.New("duplicate item")
+
+// DuplicateError is the error that a store gives when asked to insert an
+// item that is already in its list. Its message is the data store's own.
+type DuplicateError struct {
+	Message string
+}
+
+func (e *DuplicateError) Error() string {
+	return e.Message
+}
+
+// Is makes errors.Is(err, ErrDuplicate) true for any *DuplicateError.
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// insertError repackages err, from inserting items, as errors from the
+// database always are, except that an item that is already in its list
+// gives a *DuplicateError, so that callers can tell.
+func insertError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == sqlStateUniqueViolation {
+		return &DuplicateError{Message: err.Error()}
+	}
+	return fmt.Errorf("%v", err)
+}
@@ -0,0 +1,158 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/manniwood/iidy/clock"
+)
+
+// DefaultExpireBatchSize is how many expired items the Janitor deletes
+// at a time, unless its BatchSize says otherwise.
+const DefaultExpireBatchSize int = 1000
+
+// ExpireCounts counts what Expire did with the expired items it found.
+type ExpireCounts struct {
+	// Expired is how many expired items were taken out of their lists.
+	Expired int64 `json:"expired"`
+	// Archived is how many of those were put in their lists'
+	// ArchiveList; the rest were deleted. An item that is already in
+	// the archive list is not put there again.
+	Archived int64 `json:"archived"`
+}
+
+// Expire removes up to limit items whose TTL (see ListSettings) has run
+// out from their lists, putting them in their lists' ArchiveList, if
+// they have one, and otherwise just deleting them. Expiry is not an item
+// being done, so expired items are not chained to the next list (see
+// Chain), and items that another transaction has locked are left for
+// the next call.
+func (p *PgStore) Expire(ctx context.Context, limit int) (ExpireCounts, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return ExpireCounts{}, err
+	}
+	defer conn.Release()
+	var counts ExpireCounts
+	err = p.inTx(ctx, conn, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		// See migration 007.
+		if _, err := tx.Exec(ctx, `set local iidy.no_chain = 'on'`); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+      with expired as (
+           delete from iidy.lists
+            where (list, item) in (select list,
+                                          item
+                                     from iidy.lists
+                                    where expires_at <= now()
+                                 order by expires_at
+                                    limit $1
+                                      for update skip locked)
+        returning list,
+                  item,
+                  attempts,
+                  batch_id,
+                  status),
+      archived as (
+           insert into iidy.lists
+                  (list, item, attempts, batch_id, status)
+           select s.archive_list,
+                  e.item,
+                  e.attempts,
+                  e.batch_id,
+                  e.status
+             from expired e
+             join iidy.list_settings s on s.list = e.list
+            where s.archive_list is not null
+               on conflict (list, item) do nothing
+        returning 1)
+      select (select count(*) from expired),
+             (select count(*) from archived)`, limit).Scan(&counts.Expired, &counts.Archived)
+	})
+	if err != nil {
+		return ExpireCounts{}, fmt.Errorf("%v", err)
+	}
+	return counts, nil
+}
+
+// Janitor expires items (see PgStore.Expire) on an interval, and keeps
+// count of how many it has expired, and archived, since it started.
+//
+// Janitor satisfies expvar.Var, so it can be published with
+// expvar.Publish.
+type Janitor struct {
+	// Clock decides when Run sweeps. If nil, clock.System is used.
+	Clock clock.Clock
+	// BatchSize is how many items each call to expire is given to
+	// expire. If 0, DefaultExpireBatchSize is used.
+	BatchSize int
+	expire    func(ctx context.Context, limit int) (ExpireCounts, error)
+	mu        sync.Mutex
+	total     ExpireCounts
+}
+
+// NewJanitor constructs a new Janitor that
+// expires items with expire (such as PgStore.Expire).
+func NewJanitor(expire func(ctx context.Context, limit int) (ExpireCounts, error)) *Janitor {
+	return &Janitor{expire: expire}
+}
+
+// Run sweeps right away, and then every interval, until ctx is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	c := clock.OrSystem(j.Clock)
+	for {
+		j.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(interval):
+		}
+	}
+}
+
+// Sweep expires items a batch at a time, until a batch comes up short,
+// so that a backlog of expired items is cleared in one sweep without
+// any one transaction holding many locks. Failures are logged, and end
+// the sweep, for the next one to try again.
+func (j *Janitor) Sweep(ctx context.Context) {
+	batchSize := j.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultExpireBatchSize
+	}
+	for ctx.Err() == nil {
+		counts, err := j.expire(ctx, batchSize)
+		if err != nil {
+			log.Printf("Could not expire items: %v\n", err)
+			return
+		}
+		j.mu.Lock()
+		j.total.Expired += counts.Expired
+		j.total.Archived += counts.Archived
+		j.mu.Unlock()
+		if counts.Expired < int64(batchSize) {
+			return
+		}
+	}
+}
+
+// Total gives how many items have been expired, and archived, so far.
+func (j *Janitor) Total() ExpireCounts {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.total
+}
+
+// String satisfies expvar.Var, giving the totals as JSON.
+func (j *Janitor) String() string {
+	b, err := json.Marshal(j.Total())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
@@ -0,0 +1,45 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestJanitorSweep(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// 5 expired items, 2 at a time, take 3 batches; the last comes up
+	// short, and ends the sweep.
+	left := 5
+	var calls int
+	j := NewJanitor(func(ctx context.Context, limit int) (ExpireCounts, error) {
+		calls++
+		if left < 0 {
+			return ExpireCounts{}, errors.New("connection refused")
+		}
+		n := limit
+		if left < n {
+			n = left
+		}
+		left -= n
+		return ExpireCounts{Expired: int64(n), Archived: 1}, nil
+	})
+	j.BatchSize = 2
+	j.Sweep(context.Background())
+	if calls != 3 {
+		t.Errorf("got %d batches want 3", calls)
+	}
+	if got, want := j.Total(), (ExpireCounts{Expired: 5, Archived: 3}); got != want {
+		t.Errorf("got total %+v want %+v", got, want)
+	}
+	left = -1
+	j.Sweep(context.Background())
+	if got, want := j.String(), `{"expired":5,"archived":3}`; got != want {
+		t.Errorf("failed sweep changed total: got %s want %s", got, want)
+	}
+}
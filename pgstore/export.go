@@ -0,0 +1,77 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ExportColumns are the columns of an export, in order, as named
+// in its header line.
+var ExportColumns = []string{"list", "item", "attempts", "batch_id", "updated_at"}
+
+// Exporter is implemented by stores that can export a consistent
+// snapshot of their lists.
+type Exporter interface {
+	Export(ctx context.Context, w io.Writer, list string) (int64, error)
+}
+
+// Export writes every item in the specified list (or, if list is "", in
+// every list) to w as CSV, with a header line of ExportColumns, sorted by
+// list and item. It returns the number of items written.
+//
+// The export is read in one repeatable-read, read-only transaction, and
+// streamed out with COPY TO, so it is a consistent snapshot of the lists,
+// however long it takes to write, and however many changes are made while
+// it is being written, without locking anyone out. That makes it good for
+// backups, and for seeding another database (see the seed package).
+func (p *PgStore) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	where := ""
+	if list != "" {
+		if err := p.validator().ValidateList(list); err != nil {
+			return 0, err
+		}
+		// COPY does not take query parameters.
+		where = "where list = " + quoteLiteral(list)
+	}
+	sql := fmt.Sprintf(`
+		copy (select %s
+		        from iidy.lists
+		        %s
+		       order by list, item)
+		  to stdout with (format csv, header)`, strings.Join(ExportColumns, ", "), where)
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	var count int64
+	opts := pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+	err = conn.BeginTxFunc(ctx, opts, func(tx pgx.Tx) error {
+		commandTag, err := tx.Conn().PgConn().CopyTo(ctx, w, sql)
+		if err != nil {
+			return err
+		}
+		count = commandTag.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return count, nil
+}
+
+// quoteLiteral quotes s as a string literal, for the rare statement,
+// such as COPY, that cannot take it as a query parameter. Backslashes
+// are escaped too, so that the literal means the same thing whatever
+// standard_conforming_strings is set to.
+func quoteLiteral(s string) string {
+	s = strings.ReplaceAll(s, `'`, `''`)
+	if strings.Contains(s, `\`) {
+		return `E'` + strings.ReplaceAll(s, `\`, `\\`) + `'`
+	}
+	return `'` + s + `'`
+}
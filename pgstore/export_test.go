@@ -0,0 +1,17 @@
+package pgstore
+
+import "testing"
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := map[string]string{
+		"downloads":  `'downloads'`,
+		"it's":       `'it''s'`,
+		`C:\temp`:    `E'C:\\temp'`,
+		`it's C:\t'`: `E'it''s C:\\t'''`,
+	}
+	for s, want := range tests {
+		if got := quoteLiteral(s); got != want {
+			t.Errorf("quoteLiteral(%q): got %s want %s", s, got, want)
+		}
+	}
+}
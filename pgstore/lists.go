@@ -0,0 +1,55 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// ListCount names a list, and tells how many items are in it.
+type ListCount struct {
+	List  string `json:"list"`
+	Items int64  `json:"items"`
+}
+
+// Lister is implemented by stores that can enumerate their lists.
+type Lister interface {
+	Lists(ctx context.Context) ([]ListCount, error)
+}
+
+// Lists gets the name of every list that has any items in it, along
+// with how many items it has, sorted by list. A list with no items does
+// not exist, as far as iidy is concerned, so it is not among them.
+//
+// Every item is counted, though only from the lists table's primary key
+// index, so this is best not called often on a big store.
+func (p *PgStore) Lists(ctx context.Context) ([]ListCount, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, `
+      select list,
+             count(*)
+        from iidy.lists
+    group by list
+    order by list`)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	lists := []ListCount{}
+	for rows.Next() {
+		var l ListCount
+		err = rows.Scan(&l.List, &l.Items)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		lists = append(lists, l)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("%v", rows.Err())
+	}
+	return lists, nil
+}
@@ -0,0 +1,112 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// The ways that Merge can settle an item that is in both lists.
+const (
+	// MergeKeepMax keeps the higher of the two attempts.
+	MergeKeepMax string = "keep_max"
+	// MergeKeepDest keeps the destination's attempts.
+	MergeKeepDest string = "keep_dest"
+	// MergeSum adds the source's attempts to the destination's.
+	MergeSum string = "sum"
+)
+
+// mergeConflicts are the "on conflict" clauses of each way of settling
+// an item that is in both lists. An item whose attempts would not change
+// is not updated, so that it is not counted, and keeps its updated_at.
+var mergeConflicts = map[string]string{
+	MergeKeepMax: `do update set attempts = excluded.attempts
+                 where iidy.lists.attempts < excluded.attempts`,
+	MergeKeepDest: `do nothing`,
+	MergeSum: `do update set attempts = iidy.lists.attempts + excluded.attempts
+                 where excluded.attempts <> 0`,
+}
+
+// MergeCounts reports on a merge of one list into another.
+type MergeCounts struct {
+	// Added counts the items that were only in the source list.
+	Added int64 `json:"added"`
+	// Updated counts the items that were in both lists, and whose
+	// attempts in the destination list were changed.
+	Updated int64 `json:"updated"`
+}
+
+// Merger is implemented by stores that can merge one list into another.
+type Merger interface {
+	Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error)
+}
+
+// Merge copies every item in list src into list dest, with its attempts.
+// An item that is already in dest is settled as conflict says: one of
+// MergeKeepMax, MergeKeepDest, or MergeSum. src is left as it was.
+//
+// The merge is one INSERT ... SELECT, so it is all or nothing, and a
+// worker reading dest sees either none of src or all of it. Items added
+// to dest are tagged with the batch ID carried by ctx, if any.
+func (p *PgStore) Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error) {
+	if err := p.validator().ValidateList(dest); err != nil {
+		return MergeCounts{}, err
+	}
+	if err := p.validator().ValidateList(src); err != nil {
+		return MergeCounts{}, err
+	}
+	if err := ValidateMerge(dest, src, conflict); err != nil {
+		return MergeCounts{}, err
+	}
+	// xmax is 0 for a row that the insert added, and not 0
+	// for one that it updated.
+	sql := `
+      insert into iidy.lists
+             (list, item, attempts, batch_id)
+      select $1, item, attempts, $3
+        from iidy.lists
+       where list = $2
+ on conflict (list, item)
+             ` + mergeConflicts[conflict] + `
+   returning xmax = 0`
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return MergeCounts{}, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, sql, dest, src, nullIfEmpty(BatchIDFromContext(ctx)))
+	if err != nil {
+		return MergeCounts{}, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var counts MergeCounts
+	var inserted bool
+	for rows.Next() {
+		err = rows.Scan(&inserted)
+		if err != nil {
+			return MergeCounts{}, fmt.Errorf("%v", err)
+		}
+		if inserted {
+			counts.Added++
+		} else {
+			counts.Updated++
+		}
+	}
+	if rows.Err() != nil {
+		return MergeCounts{}, fmt.Errorf("%v", rows.Err())
+	}
+	return counts, nil
+}
+
+// ValidateMerge checks that a merge of list src into list dest is not of
+// a list into itself, and that conflict is one of MergeKeepMax,
+// MergeKeepDest, or MergeSum, returning a *ValidationError if not.
+func ValidateMerge(dest string, src string, conflict string) error {
+	if src == dest {
+		return &ValidationError{Field: "list", Value: src, Reason: "cannot be merged into itself"}
+	}
+	if _, ok := mergeConflicts[conflict]; !ok {
+		return &ValidationError{Field: "conflict", Value: conflict, Reason: `must be one of "keep_max", "keep_dest", or "sum"`}
+	}
+	return nil
+}
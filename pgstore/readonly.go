@@ -0,0 +1,507 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// ErrReadOnly is the error that a ReadOnlyStore gives, without calling
+// the store it wraps, for a change made while the store is read-only.
+var ErrReadOnly = errors.New("data store is read-only")
+
+// ReadOnlyStore is a Store that wraps another Store, and turns away
+// changes (inserts, increments, and deletes) with ErrReadOnly while the
+// database is read-only, such as after a failover to a standby, or while
+// Forced is true. Reads go through as usual.
+//
+// Changes made through the side interfaces of the wrapped store, such as
+// StreamInserter, are turned away in the same way (see ErrNotImplemented).
+//
+// Whether the database is read-only is found out with Check, every time
+// Run's interval passes, and again whenever a change fails, so that the
+// first change to fail after a failover gives ErrReadOnly, rather than
+// whatever PostgreSQL had to say.
+//
+// ReadOnlyStore satisfies expvar.Var, so its state can be published with
+// expvar.Publish.
+type ReadOnlyStore struct {
+	Store
+	// Check tells whether the database is read-only,
+	// such as PgStore.IsReadOnly. If nil, only Forced counts.
+	Check func(ctx context.Context) (bool, error)
+	// Forced, when true, makes the store read-only
+	// whatever Check says.
+	Forced bool
+	// OnChange, if not nil, is called whenever the store
+	// becomes read-only, or stops being read-only.
+	OnChange func(readOnly bool)
+	// Clock decides when Run checks. If nil, clock.System is used.
+	Clock clock.Clock
+
+	mu       sync.Mutex
+	dbRO     bool
+	rejected int64
+}
+
+// NewReadOnlyStore constructs a new ReadOnlyStore that wraps s,
+// and finds out whether the database is read-only with check.
+func NewReadOnlyStore(s Store, check func(ctx context.Context) (bool, error)) *ReadOnlyStore {
+	return &ReadOnlyStore{Store: s, Check: check}
+}
+
+// ReadOnly tells whether the store is read-only.
+func (r *ReadOnlyStore) ReadOnly() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Forced || r.dbRO
+}
+
+// Reason describes why the store is read-only, or is ""
+// if it is not.
+func (r *ReadOnlyStore) Reason() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.Forced:
+		return "read-only mode is turned on"
+	case r.dbRO:
+		return "database is read-only"
+	}
+	return ""
+}
+
+// String satisfies expvar.Var, giving whether the store is read-only,
+// whether that is forced, and how many changes have been turned away,
+// as JSON.
+func (r *ReadOnlyStore) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.Marshal(struct {
+		ReadOnly bool  `json:"read_only"`
+		Forced   bool  `json:"forced"`
+		Rejected int64 `json:"rejected"`
+	}{r.Forced || r.dbRO, r.Forced, r.rejected})
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+// Run checks whether the database is read-only right away,
+// and then every interval, until ctx is done.
+func (r *ReadOnlyStore) Run(ctx context.Context, interval time.Duration) {
+	c := clock.OrSystem(r.Clock)
+	for {
+		r.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(interval):
+		}
+	}
+}
+
+// Refresh checks whether the database is read-only now. Failures are
+// logged, and the previous answer is kept.
+func (r *ReadOnlyStore) Refresh(ctx context.Context) {
+	if r.Check == nil {
+		return
+	}
+	readOnly, err := r.Check(ctx)
+	if err != nil {
+		log.Printf("Could not check whether the database is read-only: %v\n", err)
+		return
+	}
+	r.mu.Lock()
+	before := r.Forced || r.dbRO
+	r.dbRO = readOnly
+	after := r.Forced || r.dbRO
+	r.mu.Unlock()
+	if before == after {
+		return
+	}
+	if after {
+		log.Printf("Database is read-only; turning away changes\n")
+	} else {
+		log.Printf("Database is writable again\n")
+	}
+	if r.OnChange != nil {
+		r.OnChange(after)
+	}
+}
+
+// before is called before each change, and gives ErrReadOnly
+// if the change should not be made.
+func (r *ReadOnlyStore) before() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Forced || r.dbRO {
+		r.rejected++
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// after is called after each change, with the error (if any) that it
+// gave, and gives the error to return: ErrReadOnly, if the change failed
+// because the database has become read-only.
+func (r *ReadOnlyStore) after(ctx context.Context, err error) error {
+	if err == nil || r.Check == nil {
+		return err
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return err
+	}
+	r.Refresh(ctx)
+	if r.ReadOnly() {
+		return fmt.Errorf("%w: %v", ErrReadOnly, err)
+	}
+	return err
+}
+
+func (r *ReadOnlyStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.InsertOne(ctx, list, item)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DeleteOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.DeleteOne(ctx, list, item)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DeleteOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.DeleteOneIfAttempts(ctx, list, item, attempts)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.IncrementOne(ctx, list, item)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) IncrementOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.IncrementOneIfAttempts(ctx, list, item, attempts)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.InsertBatch(ctx, list, items)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) InsertBatchEntries(ctx context.Context, list string, entries []ListEntry) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.InsertBatchEntries(ctx, list, entries)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []ListEntry) (int64, []string, error) {
+	if err := r.before(); err != nil {
+		return 0, nil, err
+	}
+	n, skipped, err := r.Store.InsertBatchIgnoreDuplicates(ctx, list, entries)
+	return n, skipped, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.DeleteBatch(ctx, list, items)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DeleteBatchReturning(ctx context.Context, list string, items []string) ([]string, error) {
+	if err := r.before(); err != nil {
+		return nil, err
+	}
+	deleted, err := r.Store.DeleteBatchReturning(ctx, list, items)
+	return deleted, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := r.Store.IncrementBatch(ctx, list, items)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) IncrementBatchReturning(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	if err := r.before(); err != nil {
+		return nil, err
+	}
+	entries, err := r.Store.IncrementBatchReturning(ctx, list, items)
+	return entries, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) ApplyBatch(ctx context.Context, list string, actions []ItemAction) (ActionCounts, error) {
+	if err := r.before(); err != nil {
+		return ActionCounts{}, err
+	}
+	counts, err := r.Store.ApplyBatch(ctx, list, actions)
+	return counts, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	inner, ok := r.Store.(StreamInserter)
+	if !ok {
+		return 0, notImplemented(r.Store, "StreamInserter")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.InsertStream(ctx, list, next)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) SetChain(ctx context.Context, chain Chain) error {
+	inner, ok := r.Store.(Chainer)
+	if !ok {
+		return notImplemented(r.Store, "Chainer")
+	}
+	if err := r.before(); err != nil {
+		return err
+	}
+	return r.after(ctx, inner.SetChain(ctx, chain))
+}
+
+func (r *ReadOnlyStore) GetChain(ctx context.Context, list string) (*Chain, error) {
+	inner, ok := r.Store.(Chainer)
+	if !ok {
+		return nil, notImplemented(r.Store, "Chainer")
+	}
+	return inner.GetChain(ctx, list)
+}
+
+func (r *ReadOnlyStore) DeleteChain(ctx context.Context, list string) (int64, error) {
+	inner, ok := r.Store.(Chainer)
+	if !ok {
+		return 0, notImplemented(r.Store, "Chainer")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.DeleteChain(ctx, list)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error) {
+	inner, ok := r.Store.(Merger)
+	if !ok {
+		return MergeCounts{}, notImplemented(r.Store, "Merger")
+	}
+	if err := r.before(); err != nil {
+		return MergeCounts{}, err
+	}
+	counts, err := inner.Merge(ctx, dest, src, conflict)
+	return counts, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error) {
+	inner, ok := r.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(r.Store, "Snapshotter")
+	}
+	if err := r.before(); err != nil {
+		return nil, err
+	}
+	snapshot, err := inner.TakeSnapshot(ctx, list, label)
+	return snapshot, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) Snapshots(ctx context.Context, list string) ([]Snapshot, error) {
+	inner, ok := r.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(r.Store, "Snapshotter")
+	}
+	return inner.Snapshots(ctx, list)
+}
+
+func (r *ReadOnlyStore) RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error) {
+	inner, ok := r.Store.(Snapshotter)
+	if !ok {
+		return RestoreCounts{}, notImplemented(r.Store, "Snapshotter")
+	}
+	if err := r.before(); err != nil {
+		return RestoreCounts{}, err
+	}
+	counts, err := inner.RestoreSnapshot(ctx, list, label)
+	return counts, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	inner, ok := r.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(r.Store, "Snapshotter")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.DeleteSnapshot(ctx, list, label)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := r.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(r.Store, "Snapshotter")
+	}
+	return inner.DiffSnapshot(ctx, list, label, f)
+}
+
+func (r *ReadOnlyStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	inner, ok := r.Store.(Transitioner)
+	if !ok {
+		return "", false, notImplemented(r.Store, "Transitioner")
+	}
+	if err := r.before(); err != nil {
+		return "", false, err
+	}
+	status, found, err := inner.Transition(ctx, list, item, action)
+	return status, found, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	inner, ok := r.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(r.Store, "AttemptsSetter")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.DecrementOne(ctx, list, item)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	inner, ok := r.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(r.Store, "AttemptsSetter")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.DecrementBatch(ctx, list, items)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	inner, ok := r.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(r.Store, "AttemptsSetter")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.SetAttemptsOne(ctx, list, item, attempts)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	inner, ok := r.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(r.Store, "AttemptsSetter")
+	}
+	if err := r.before(); err != nil {
+		return 0, err
+	}
+	n, err := inner.SetAttemptsBatch(ctx, list, items, attempts)
+	return n, r.after(ctx, err)
+}
+
+func (r *ReadOnlyStore) GetListSettings(ctx context.Context, list string) (ListSettings, error) {
+	inner, ok := r.Store.(Configurer)
+	if !ok {
+		return ListSettings{}, notImplemented(r.Store, "Configurer")
+	}
+	return inner.GetListSettings(ctx, list)
+}
+
+func (r *ReadOnlyStore) SetListSettings(ctx context.Context, settings ListSettings) error {
+	inner, ok := r.Store.(Configurer)
+	if !ok {
+		return notImplemented(r.Store, "Configurer")
+	}
+	if err := r.before(); err != nil {
+		return err
+	}
+	return r.after(ctx, inner.SetListSettings(ctx, settings))
+}
+
+func (r *ReadOnlyStore) StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error) {
+	inner, ok := r.Store.(Streamer)
+	if !ok {
+		return 0, notImplemented(r.Store, "Streamer")
+	}
+	return inner.StreamBatch(ctx, list, q, f)
+}
+
+func (r *ReadOnlyStore) Lists(ctx context.Context) ([]ListCount, error) {
+	inner, ok := r.Store.(Lister)
+	if !ok {
+		return nil, notImplemented(r.Store, "Lister")
+	}
+	return inner.Lists(ctx)
+}
+
+func (r *ReadOnlyStore) CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error) {
+	inner, ok := r.Store.(AttemptsCounter)
+	if !ok {
+		return nil, notImplemented(r.Store, "AttemptsCounter")
+	}
+	return inner.CountByAttempts(ctx, list)
+}
+
+func (r *ReadOnlyStore) Diff(ctx context.Context, list string, other string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := r.Store.(Differ)
+	if !ok {
+		return 0, notImplemented(r.Store, "Differ")
+	}
+	return inner.Diff(ctx, list, other, f)
+}
+
+func (r *ReadOnlyStore) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	inner, ok := r.Store.(Exporter)
+	if !ok {
+		return 0, notImplemented(r.Store, "Exporter")
+	}
+	return inner.Export(ctx, w, list)
+}
+
+func (r *ReadOnlyStore) DBStats(ctx context.Context) (*DBStats, error) {
+	inner, ok := r.Store.(DBStatter)
+	if !ok {
+		return nil, notImplemented(r.Store, "DBStatter")
+	}
+	return inner.DBStats(ctx)
+}
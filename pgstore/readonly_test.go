@@ -0,0 +1,71 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	inner := &countingStore{}
+	dbRO := false
+	r := NewReadOnlyStore(inner, func(ctx context.Context) (bool, error) {
+		return dbRO, nil
+	})
+	var changes []bool
+	r.OnChange = func(readOnly bool) { changes = append(changes, readOnly) }
+	ctx := context.Background()
+
+	if _, err := r.InsertOne(ctx, "downloads", "a.txt"); err != nil {
+		t.Errorf("writable store gave error: %v", err)
+	}
+
+	dbRO = true
+	r.Refresh(ctx)
+	if _, err := r.InsertOne(ctx, "downloads", "a.txt"); err != ErrReadOnly {
+		t.Errorf("got error %v want %v", err, ErrReadOnly)
+	}
+	if inner.inserts != 1 {
+		t.Errorf("change reached read-only store: %d inserts", inner.inserts)
+	}
+
+	dbRO = false
+	r.Refresh(ctx)
+	r.Forced = true
+	if _, err := r.InsertOne(ctx, "downloads", "a.txt"); err != ErrReadOnly {
+		t.Errorf("got error %v want %v", err, ErrReadOnly)
+	}
+	if want := `{"read_only":true,"forced":true,"rejected":2}`; r.String() != want {
+		t.Errorf("got %s want %s", r.String(), want)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("got changes %v want [true false]", changes)
+	}
+}
+
+func TestReadOnlyStoreFailover(t *testing.T) {
+	// The database fails over between checks: the change fails,
+	// and is reported as ErrReadOnly.
+	inner := &failingStore{err: errors.New("cannot execute INSERT in a read-only transaction")}
+	r := NewReadOnlyStore(&insertFailingStore{inner}, func(ctx context.Context) (bool, error) {
+		return true, nil
+	})
+	_, err := r.InsertOne(context.Background(), "downloads", "a.txt")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("got error %v want %v", err, ErrReadOnly)
+	}
+	// Reads still go through.
+	if _, _, err := r.GetOne(context.Background(), "downloads", "a.txt"); errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only store turned away a read")
+	}
+}
+
+// insertFailingStore is a Store whose InsertOne fails
+// like the failingStore's GetOne does.
+type insertFailingStore struct {
+	*failingStore
+}
+
+func (s *insertFailingStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	return 0, s.err
+}
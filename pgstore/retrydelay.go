@@ -0,0 +1,67 @@
+package pgstore
+
+import (
+	"math"
+	"time"
+)
+
+// RetryDelay says how long an item whose attempts have been incremented
+// (because working on it failed) waits before it is ready to be worked on
+// again: Base after the first attempt, doubling with each attempt after
+// that, but never more than Cap. That way, an item that keeps failing
+// backs off, rather than being worked on again straight away. The zero
+// RetryDelay makes items ready again right away.
+type RetryDelay struct {
+	Base time.Duration
+	// Cap, if more than 0, is the longest delay.
+	Cap time.Duration
+}
+
+// After gives the delay for an item that has now had attempts attempts.
+func (d RetryDelay) After(attempts int) time.Duration {
+	if d.Base <= 0 || attempts < 1 {
+		return 0
+	}
+	delay := d.Base
+	// Stop doubling at 30, as iidy.retry_not_before does
+	// (or sooner, rather than overflow).
+	for i := 1; i < attempts && i <= 30 && delay <= math.MaxInt64/2; i++ {
+		delay *= 2
+	}
+	if d.Cap > 0 && delay > d.Cap {
+		return d.Cap
+	}
+	return delay
+}
+
+// args gives d's Base and Cap as query parameters for
+// iidy.retry_not_before, which takes null for none.
+func (d RetryDelay) args() (interface{}, interface{}) {
+	var base, limit interface{}
+	if d.Base > 0 {
+		base = d.Base
+	}
+	if d.Cap > 0 {
+		limit = d.Cap
+	}
+	return base, limit
+}
+
+// RetryDelays gives the RetryDelay of each list.
+type RetryDelays struct {
+	// Default is the RetryDelay of any list not in Lists.
+	Default RetryDelay
+	Lists   map[string]RetryDelay
+}
+
+// For gives the RetryDelay of list. A nil RetryDelays gives the zero
+// RetryDelay, for every list.
+func (r *RetryDelays) For(list string) RetryDelay {
+	if r == nil {
+		return RetryDelay{}
+	}
+	if d, ok := r.Lists[list]; ok {
+		return d
+	}
+	return r.Default
+}
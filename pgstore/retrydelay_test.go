@@ -0,0 +1,50 @@
+package pgstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	d := RetryDelay{Base: time.Second, Cap: time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{7, time.Minute},
+		{1000, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := d.After(tt.attempts); got != tt.want {
+			t.Errorf("After(%d): got %v want %v", tt.attempts, got, tt.want)
+		}
+	}
+	// A cap alone is no delay at all, not a delay of the cap.
+	if got := (RetryDelay{Cap: time.Minute}).After(3); got != 0 {
+		t.Errorf("After(3) with only a cap: got %v want 0", got)
+	}
+	uncapped := RetryDelay{Base: time.Hour}
+	// Doubling stops short of overflowing.
+	if got := uncapped.After(1000); got < time.Hour<<20 {
+		t.Errorf("After(1000) with no cap: got %v", got)
+	}
+
+	delays := &RetryDelays{
+		Default: d,
+		Lists:   map[string]RetryDelay{"thumbnails": {}},
+	}
+	if got := delays.For("downloads"); got != d {
+		t.Errorf("For(downloads): got %v want %v", got, d)
+	}
+	if got := delays.For("thumbnails"); got != (RetryDelay{}) {
+		t.Errorf("For(thumbnails): got %v want no delay", got)
+	}
+	var none *RetryDelays
+	if got := none.For("downloads"); got != (RetryDelay{}) {
+		t.Errorf("nil RetryDelays gave %v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// PrefixRewrite reports on a rewrite of the prefix of item names in a list.
+type PrefixRewrite struct {
+	// Matched counts the items whose names start with the old prefix.
+	Matched int64 `json:"matched"`
+	// Conflicts counts the matched items whose new names are already
+	// taken by other items in the list.
+	Conflicts int64 `json:"conflicts"`
+	// TooLong counts the matched items whose new names would be
+	// longer than the Validator allows.
+	TooLong int64 `json:"too_long"`
+	// Rewritten counts the items that were renamed; it is 0 for a dry run,
+	// or if there are any conflicts, or names that would be too long.
+	Rewritten int64 `json:"rewritten"`
+	DryRun    bool  `json:"dry_run"`
+}
+
+// OK tells us whether the rewrite can be (or was) done: that is,
+// whether no new names conflict, or are too long.
+func (r *PrefixRewrite) OK() bool {
+	return r.Conflicts == 0 && r.TooLong == 0
+}
+
+// ErrRewriteBlocked is returned when a prefix rewrite is not done because
+// some new item names conflict with existing ones, or would be too long.
+var ErrRewriteBlocked = errors.New("prefix rewrite blocked by conflicting or too-long item names")
+
+// RewritePrefix renames every item in the specified list whose name starts
+// with from, so that it starts with to instead (such as from
+// "s3://old-bucket/" to "s3://new-bucket/"), keeping its attempts.
+// It is done with a single UPDATE, in a transaction that first checks that
+// no new name conflicts with an existing item, or is too long; if any do,
+// nothing is renamed, and ErrRewriteBlocked is returned, along with the
+// report. With dryRun, the checks are done, but nothing is renamed.
+func (p *PgStore) RewritePrefix(ctx context.Context, list string, from string, to string, dryRun bool) (*PrefixRewrite, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return nil, err
+	}
+	if from == "" {
+		return nil, &ValidationError{Field: "prefix", Value: from, Reason: "must not be empty"}
+	}
+	if to != "" {
+		if err := p.validator().ValidateItem(to); err != nil {
+			return nil, err
+		}
+	}
+	r := &PrefixRewrite{DryRun: dryRun}
+	err := p.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		// Keep other rewrites of the list (and new items) out of the way
+		// between the checks and the update.
+		if _, err := tx.Exec(ctx, `lock table iidy.lists in share row exclusive mode`); err != nil {
+			return err
+		}
+		err := tx.QueryRow(ctx, `
+      select count(*),
+             count(*) filter (where exists (select 1
+                                              from iidy.lists o
+                                             where o.list = l.list
+                                               and o.item = $3 || substr(l.item, char_length($2) + 1))),
+             count(*) filter (where octet_length($3 || substr(l.item, char_length($2) + 1)) > $4)
+        from iidy.lists l
+       where l.list = $1
+         and starts_with(l.item, $2)`, list, from, to, p.validator().MaxItemLength).Scan(&r.Matched, &r.Conflicts, &r.TooLong)
+		if err != nil {
+			return err
+		}
+		if dryRun || !r.OK() || r.Matched == 0 {
+			return nil
+		}
+		ct, err := tx.Exec(ctx, `
+      update iidy.lists
+         set item = $3 || substr(item, char_length($2) + 1)
+       where list = $1
+         and starts_with(item, $2)`, list, from, to)
+		if err != nil {
+			return err
+		}
+		r.Rewritten = ct.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	if !dryRun && !r.OK() {
+		return r, ErrRewriteBlocked
+	}
+	return r, nil
+}
@@ -0,0 +1,176 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ListSettings override, for one list, how iidy treats its items. The
+// zero value of each setting means iidy's default, so the zero
+// ListSettings (but for List) is what every list has to start with.
+type ListSettings struct {
+	List string
+	// MaxAttempts, if more than 0, is how many attempts an item gets:
+	// once its attempts are incremented to MaxAttempts, its status is
+	// StatusFailed, rather than StatusInProgress.
+	MaxAttempts int
+	// RetryBase and RetryCap, if more than 0, are the list's RetryDelay,
+	// in place of the Base and Cap that PgStore.RetryDelays gives it. Each
+	// stands in for its own: a RetryCap alone caps the Base that
+	// PgStore.RetryDelays gives, and, if that gives none, is no delay.
+	RetryBase time.Duration
+	RetryCap  time.Duration
+	// TTL, if more than 0, is how long an item lives after it was
+	// inserted, or last had its attempts changed, before the Janitor
+	// deletes it.
+	TTL time.Duration
+	// ArchiveList, if not empty, is the list that the Janitor moves
+	// expired items to, rather than deleting them.
+	ArchiveList string
+}
+
+// Configurer is implemented by stores that keep settings per list.
+type Configurer interface {
+	GetListSettings(ctx context.Context, list string) (ListSettings, error)
+	SetListSettings(ctx context.Context, s ListSettings) error
+}
+
+// validateListSettings checks the list name and settings in s.
+func (p *PgStore) validateListSettings(s ListSettings) error {
+	if err := p.validator().ValidateList(s.List); err != nil {
+		return err
+	}
+	if s.MaxAttempts < 0 {
+		return &ValidationError{Field: "max_attempts", Value: fmt.Sprint(s.MaxAttempts), Reason: "cannot be negative"}
+	}
+	if s.RetryBase < 0 {
+		return &ValidationError{Field: "retry_base", Value: s.RetryBase.String(), Reason: "cannot be negative"}
+	}
+	if s.RetryCap < 0 {
+		return &ValidationError{Field: "retry_cap", Value: s.RetryCap.String(), Reason: "cannot be negative"}
+	}
+	if s.TTL < 0 {
+		return &ValidationError{Field: "ttl", Value: s.TTL.String(), Reason: "cannot be negative"}
+	}
+	if s.ArchiveList == "" {
+		return nil
+	}
+	if s.ArchiveList == s.List {
+		return &ValidationError{Field: "archive_list", Value: s.ArchiveList, Reason: "cannot be the list itself"}
+	}
+	v := p.validator()
+	return v.validate("archive_list", s.ArchiveList, v.MaxListLength)
+}
+
+// GetListSettings gets the settings of the specified list. A list that
+// has never had any set gets the zero ListSettings, for the defaults.
+func (p *PgStore) GetListSettings(ctx context.Context, list string) (ListSettings, error) {
+	s := ListSettings{List: list}
+	if err := p.validator().ValidateList(list); err != nil {
+		return s, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return s, err
+	}
+	defer conn.Release()
+	var maxAttempts *int
+	var base, limit, ttl *time.Duration
+	var archive *string
+	err = conn.QueryRow(ctx, `
+		select max_attempts,
+		       retry_base,
+		       retry_cap,
+		       ttl,
+		       archive_list
+		  from iidy.list_settings
+		 where list = $1`, list).Scan(&maxAttempts, &base, &limit, &ttl, &archive)
+	if err == pgx.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("%v", err)
+	}
+	if maxAttempts != nil {
+		s.MaxAttempts = *maxAttempts
+	}
+	if base != nil {
+		s.RetryBase = *base
+	}
+	if limit != nil {
+		s.RetryCap = *limit
+	}
+	if ttl != nil {
+		s.TTL = *ttl
+	}
+	if archive != nil {
+		s.ArchiveList = *archive
+	}
+	return s, nil
+}
+
+// SetListSettings replaces the settings of s.List with s, so that a
+// setting left at its zero value goes back to the default. The
+// settings are consulted by the database itself, whenever an item's
+// attempts are incremented, by any increment (one item, a batch, or an
+// action), so they apply to every one after this returns. A new TTL
+// applies to the items already in the list, too, which means updating
+// each of them.
+func (p *PgStore) SetListSettings(ctx context.Context, s ListSettings) error {
+	if err := p.validateListSettings(s); err != nil {
+		return err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	var maxAttempts, ttl, archive interface{}
+	if s.MaxAttempts > 0 {
+		maxAttempts = s.MaxAttempts
+	}
+	if s.TTL > 0 {
+		ttl = s.TTL
+	}
+	if s.ArchiveList != "" {
+		archive = s.ArchiveList
+	}
+	base, limit := RetryDelay{Base: s.RetryBase, Cap: s.RetryCap}.args()
+	err = p.inTx(ctx, conn, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var err error
+		if s == (ListSettings{List: s.List}) {
+			_, err = tx.Exec(ctx, `
+				delete from iidy.list_settings
+				      where list = $1`, s.List)
+		} else {
+			_, err = tx.Exec(ctx, `
+				insert into iidy.list_settings
+				       (list, max_attempts, retry_base, retry_cap, ttl, archive_list)
+				values ($1, $2, $3, $4, $5, $6)
+				    on conflict (list) do update
+				   set max_attempts = excluded.max_attempts,
+				       retry_base = excluded.retry_base,
+				       retry_cap = excluded.retry_cap,
+				       ttl = excluded.ttl,
+				       archive_list = excluded.archive_list`, s.List, maxAttempts, base, limit, ttl, archive)
+		}
+		if err != nil {
+			return err
+		}
+		// The items already in the list expire by the new TTL, counted
+		// from when they were last touched, as if they had always had it.
+		_, err = tx.Exec(ctx, `
+			update iidy.lists
+			   set expires_at = updated_at + $2::interval
+			 where list = $1
+			   and expires_at is distinct from updated_at + $2::interval`, s.List, ttl)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
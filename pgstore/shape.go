@@ -0,0 +1,130 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// Shape describes how much data the store holds, for capacity planning.
+type Shape struct {
+	SampledAt  time.Time        `json:"sampled_at"`
+	TableBytes int64            `json:"table_bytes"`
+	IndexBytes int64            `json:"index_bytes"`
+	ListItems  map[string]int64 `json:"list_items"`
+	// RetriedItems counts, per list, the items that have had at least
+	// one failed attempt made on them.
+	RetriedItems map[string]int64 `json:"retried_items"`
+}
+
+// Shape gets the size of the lists table and its indexes, and the number
+// of items (and of retried items) in each list. It counts every row of
+// the lists table, so it should not be called often.
+func (p *PgStore) Shape(ctx context.Context) (*Shape, error) {
+	s := &Shape{
+		SampledAt:    clock.OrSystem(p.Clock).Now().UTC(),
+		ListItems:    make(map[string]int64),
+		RetriedItems: make(map[string]int64),
+	}
+	sql := `
+      select pg_table_size('iidy.lists'),
+             pg_indexes_size('iidy.lists')`
+	err := p.pool.QueryRow(ctx, sql).Scan(&s.TableBytes, &s.IndexBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+
+	sql = `
+      select list,
+             count(*),
+             count(*) filter (where attempts > 0)
+        from iidy.lists
+    group by list`
+	rows, err := p.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+	var list string
+	var items, retried int64
+	for rows.Next() {
+		err = rows.Scan(&list, &items, &retried)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		s.ListItems[list] = items
+		s.RetriedItems[list] = retried
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("%v", rows.Err())
+	}
+	return s, nil
+}
+
+// ShapeSampler samples a store's Shape on an interval, so that its
+// latest sample can be read as often as need be without hitting
+// the database each time.
+//
+// ShapeSampler satisfies expvar.Var, so it can be published with
+// expvar.Publish.
+type ShapeSampler struct {
+	// Clock decides when Run takes samples. If nil, clock.System is used.
+	Clock  clock.Clock
+	sample func(ctx context.Context) (*Shape, error)
+	mu     sync.Mutex
+	latest *Shape
+}
+
+// NewShapeSampler constructs a new ShapeSampler that
+// takes its samples with sample (such as PgStore.Shape).
+func NewShapeSampler(sample func(ctx context.Context) (*Shape, error)) *ShapeSampler {
+	return &ShapeSampler{sample: sample}
+}
+
+// Run takes a sample right away, and then every interval,
+// until ctx is done.
+func (s *ShapeSampler) Run(ctx context.Context, interval time.Duration) {
+	c := clock.OrSystem(s.Clock)
+	for {
+		s.Sample(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(interval):
+		}
+	}
+}
+
+// Sample takes a sample now. Failures are logged, and the
+// previous sample is kept.
+func (s *ShapeSampler) Sample(ctx context.Context) {
+	shape, err := s.sample(ctx)
+	if err != nil {
+		log.Printf("Could not sample data shape: %v\n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = shape
+}
+
+// Latest gives the latest sample, or nil if there is none yet.
+func (s *ShapeSampler) Latest() *Shape {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+// String satisfies expvar.Var, giving the latest sample as JSON.
+func (s *ShapeSampler) String() string {
+	b, err := json.Marshal(s.Latest())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
@@ -0,0 +1,67 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+func TestShapeSampler(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	fail := false
+	s := NewShapeSampler(func(ctx context.Context) (*Shape, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &Shape{TableBytes: 8192, ListItems: map[string]int64{"downloads": 3}}, nil
+	})
+	if s.String() != "null" {
+		t.Errorf("got %s before first sample want null", s.String())
+	}
+	s.Sample(context.Background())
+	fail = true
+	s.Sample(context.Background())
+	if got := s.Latest(); got == nil || got.TableBytes != 8192 || got.ListItems["downloads"] != 3 {
+		t.Errorf("failed sample did not keep previous sample: %v", got)
+	}
+}
+
+func TestShapeSamplerRun(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	samples := make(chan struct{})
+	s := NewShapeSampler(func(ctx context.Context) (*Shape, error) {
+		samples <- struct{}{}
+		return &Shape{SampledAt: c.Now()}, nil
+	})
+	s.Clock = c
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, time.Minute)
+		close(done)
+	}()
+	<-samples
+	// Run may not be waiting on the clock yet, so keep nudging it
+	// forward until the next sample is taken.
+	for taken := false; !taken; {
+		c.Advance(time.Minute)
+		select {
+		case <-samples:
+			taken = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+	if got := s.Latest(); got == nil || got.SampledAt.Before(time.Date(2021, 1, 1, 0, 1, 0, 0, time.UTC)) {
+		t.Errorf("second sample was not taken a minute later: %v", got)
+	}
+}
@@ -0,0 +1,362 @@
+package pgstore
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// SlowQuery describes a Store method call that took longer than it should
+// have. The item names themselves are left out, because they could be
+// sensitive, and because there could be millions of them.
+type SlowQuery struct {
+	Op       string
+	List     string
+	BatchID  string
+	Items    int
+	Duration time.Duration
+}
+
+// SlowLogStore is a Store that wraps another Store, logging every call
+// that takes Threshold or longer. If OnSlow is not nil, it is also called
+// for every such call (to count slow queries in metrics, say). Calls to
+// the side interfaces of the wrapped store, such as StreamInserter, are
+// logged too (see ErrNotImplemented).
+type SlowLogStore struct {
+	Store     Store
+	Threshold time.Duration
+	OnSlow    func(SlowQuery)
+	// Clock times the calls. If nil, clock.System is used.
+	Clock clock.Clock
+}
+
+// NewSlowLogStore constructs a new SlowLogStore that wraps s.
+func NewSlowLogStore(s Store, threshold time.Duration, onSlow func(SlowQuery)) *SlowLogStore {
+	return &SlowLogStore{
+		Store:     s,
+		Threshold: threshold,
+		OnSlow:    onSlow,
+	}
+}
+
+// observe logs the call to op if it began long enough ago
+// to count as slow.
+func (s *SlowLogStore) observe(ctx context.Context, op string, list string, items int, start time.Time) {
+	d := clock.OrSystem(s.Clock).Since(start)
+	if d < s.Threshold {
+		return
+	}
+	q := SlowQuery{Op: op, List: list, BatchID: BatchIDFromContext(ctx), Items: items, Duration: d}
+	log.Printf("Slow query: op=%s list=%q batch_id=%q items=%d duration=%v\n", q.Op, q.List, q.BatchID, q.Items, q.Duration)
+	if s.OnSlow != nil {
+		s.OnSlow(q)
+	}
+}
+
+// now gives the time by s's clock.
+func (s *SlowLogStore) now() time.Time {
+	return clock.OrSystem(s.Clock).Now()
+}
+
+func (s *SlowLogStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	defer s.observe(ctx, "insert_one", list, 1, s.now())
+	return s.Store.InsertOne(ctx, list, item)
+}
+
+func (s *SlowLogStore) GetOne(ctx context.Context, list string, item string) (int, bool, error) {
+	defer s.observe(ctx, "get_one", list, 1, s.now())
+	return s.Store.GetOne(ctx, list, item)
+}
+
+func (s *SlowLogStore) DeleteOne(ctx context.Context, list string, item string) (int64, error) {
+	defer s.observe(ctx, "delete_one", list, 1, s.now())
+	return s.Store.DeleteOne(ctx, list, item)
+}
+
+func (s *SlowLogStore) DeleteOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	defer s.observe(ctx, "delete_one", list, 1, s.now())
+	return s.Store.DeleteOneIfAttempts(ctx, list, item, attempts)
+}
+
+func (s *SlowLogStore) IncrementOne(ctx context.Context, list string, item string) (int64, error) {
+	defer s.observe(ctx, "increment_one", list, 1, s.now())
+	return s.Store.IncrementOne(ctx, list, item)
+}
+
+func (s *SlowLogStore) IncrementOneIfAttempts(ctx context.Context, list string, item string, attempts []int) (int64, error) {
+	defer s.observe(ctx, "increment_one", list, 1, s.now())
+	return s.Store.IncrementOneIfAttempts(ctx, list, item, attempts)
+}
+
+func (s *SlowLogStore) InsertBatch(ctx context.Context, list string, items []string) (int64, error) {
+	defer s.observe(ctx, "insert_batch", list, len(items), s.now())
+	return s.Store.InsertBatch(ctx, list, items)
+}
+
+func (s *SlowLogStore) InsertBatchEntries(ctx context.Context, list string, entries []ListEntry) (int64, error) {
+	defer s.observe(ctx, "insert_batch", list, len(entries), s.now())
+	return s.Store.InsertBatchEntries(ctx, list, entries)
+}
+
+func (s *SlowLogStore) InsertBatchIgnoreDuplicates(ctx context.Context, list string, entries []ListEntry) (int64, []string, error) {
+	defer s.observe(ctx, "insert_batch", list, len(entries), s.now())
+	return s.Store.InsertBatchIgnoreDuplicates(ctx, list, entries)
+}
+
+func (s *SlowLogStore) GetBatch(ctx context.Context, list string, startID string, count int) ([]ListEntry, error) {
+	defer s.observe(ctx, "get_batch", list, count, s.now())
+	return s.Store.GetBatch(ctx, list, startID, count)
+}
+
+func (s *SlowLogStore) QueryBatch(ctx context.Context, list string, q BatchQuery) ([]ListEntry, error) {
+	defer s.observe(ctx, "get_batch", list, q.Count, s.now())
+	return s.Store.QueryBatch(ctx, list, q)
+}
+
+func (s *SlowLogStore) CountBatch(ctx context.Context, list string, q BatchQuery, estimate bool) (int64, error) {
+	defer s.observe(ctx, "count_batch", list, 0, s.now())
+	return s.Store.CountBatch(ctx, list, q, estimate)
+}
+
+func (s *SlowLogStore) GetMulti(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	defer s.observe(ctx, "get_multi", list, len(items), s.now())
+	return s.Store.GetMulti(ctx, list, items)
+}
+
+func (s *SlowLogStore) DeleteBatch(ctx context.Context, list string, items []string) (int64, error) {
+	defer s.observe(ctx, "delete_batch", list, len(items), s.now())
+	return s.Store.DeleteBatch(ctx, list, items)
+}
+
+func (s *SlowLogStore) DeleteBatchReturning(ctx context.Context, list string, items []string) ([]string, error) {
+	defer s.observe(ctx, "delete_batch", list, len(items), s.now())
+	return s.Store.DeleteBatchReturning(ctx, list, items)
+}
+
+func (s *SlowLogStore) IncrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	defer s.observe(ctx, "increment_batch", list, len(items), s.now())
+	return s.Store.IncrementBatch(ctx, list, items)
+}
+
+func (s *SlowLogStore) IncrementBatchReturning(ctx context.Context, list string, items []string) ([]ListEntry, error) {
+	defer s.observe(ctx, "increment_batch", list, len(items), s.now())
+	return s.Store.IncrementBatchReturning(ctx, list, items)
+}
+
+func (s *SlowLogStore) ApplyBatch(ctx context.Context, list string, actions []ItemAction) (ActionCounts, error) {
+	defer s.observe(ctx, "apply_batch", list, len(actions), s.now())
+	return s.Store.ApplyBatch(ctx, list, actions)
+}
+
+func (s *SlowLogStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	inner, ok := s.Store.(StreamInserter)
+	if !ok {
+		return 0, notImplemented(s.Store, "StreamInserter")
+	}
+	// How many items there were is only known at the end.
+	start := s.now()
+	n, err := inner.InsertStream(ctx, list, next)
+	s.observe(ctx, "insert_batch", list, int(n), start)
+	return n, err
+}
+
+func (s *SlowLogStore) SetChain(ctx context.Context, chain Chain) error {
+	inner, ok := s.Store.(Chainer)
+	if !ok {
+		return notImplemented(s.Store, "Chainer")
+	}
+	defer s.observe(ctx, "set_chain", chain.List, 0, s.now())
+	return inner.SetChain(ctx, chain)
+}
+
+func (s *SlowLogStore) GetChain(ctx context.Context, list string) (*Chain, error) {
+	inner, ok := s.Store.(Chainer)
+	if !ok {
+		return nil, notImplemented(s.Store, "Chainer")
+	}
+	defer s.observe(ctx, "get_chain", list, 0, s.now())
+	return inner.GetChain(ctx, list)
+}
+
+func (s *SlowLogStore) DeleteChain(ctx context.Context, list string) (int64, error) {
+	inner, ok := s.Store.(Chainer)
+	if !ok {
+		return 0, notImplemented(s.Store, "Chainer")
+	}
+	defer s.observe(ctx, "delete_chain", list, 0, s.now())
+	return inner.DeleteChain(ctx, list)
+}
+
+func (s *SlowLogStore) Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error) {
+	inner, ok := s.Store.(Merger)
+	if !ok {
+		return MergeCounts{}, notImplemented(s.Store, "Merger")
+	}
+	defer s.observe(ctx, "merge", dest, 0, s.now())
+	return inner.Merge(ctx, dest, src, conflict)
+}
+
+func (s *SlowLogStore) TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error) {
+	inner, ok := s.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(s.Store, "Snapshotter")
+	}
+	defer s.observe(ctx, "take_snapshot", list, 0, s.now())
+	return inner.TakeSnapshot(ctx, list, label)
+}
+
+func (s *SlowLogStore) Snapshots(ctx context.Context, list string) ([]Snapshot, error) {
+	inner, ok := s.Store.(Snapshotter)
+	if !ok {
+		return nil, notImplemented(s.Store, "Snapshotter")
+	}
+	defer s.observe(ctx, "get_snapshots", list, 0, s.now())
+	return inner.Snapshots(ctx, list)
+}
+
+func (s *SlowLogStore) RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error) {
+	inner, ok := s.Store.(Snapshotter)
+	if !ok {
+		return RestoreCounts{}, notImplemented(s.Store, "Snapshotter")
+	}
+	defer s.observe(ctx, "restore_snapshot", list, 0, s.now())
+	return inner.RestoreSnapshot(ctx, list, label)
+}
+
+func (s *SlowLogStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	inner, ok := s.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(s.Store, "Snapshotter")
+	}
+	defer s.observe(ctx, "delete_snapshot", list, 0, s.now())
+	return inner.DeleteSnapshot(ctx, list, label)
+}
+
+func (s *SlowLogStore) DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := s.Store.(Snapshotter)
+	if !ok {
+		return 0, notImplemented(s.Store, "Snapshotter")
+	}
+	defer s.observe(ctx, "diff_snapshot", list, 0, s.now())
+	return inner.DiffSnapshot(ctx, list, label, f)
+}
+
+func (s *SlowLogStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	inner, ok := s.Store.(Transitioner)
+	if !ok {
+		return "", false, notImplemented(s.Store, "Transitioner")
+	}
+	defer s.observe(ctx, "transition", list, 1, s.now())
+	return inner.Transition(ctx, list, item, action)
+}
+
+func (s *SlowLogStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	inner, ok := s.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(s.Store, "AttemptsSetter")
+	}
+	defer s.observe(ctx, "decrement_one", list, 1, s.now())
+	return inner.DecrementOne(ctx, list, item)
+}
+
+func (s *SlowLogStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	inner, ok := s.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(s.Store, "AttemptsSetter")
+	}
+	defer s.observe(ctx, "decrement_batch", list, len(items), s.now())
+	return inner.DecrementBatch(ctx, list, items)
+}
+
+func (s *SlowLogStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	inner, ok := s.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(s.Store, "AttemptsSetter")
+	}
+	defer s.observe(ctx, "set_attempts_one", list, 1, s.now())
+	return inner.SetAttemptsOne(ctx, list, item, attempts)
+}
+
+func (s *SlowLogStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	inner, ok := s.Store.(AttemptsSetter)
+	if !ok {
+		return 0, notImplemented(s.Store, "AttemptsSetter")
+	}
+	defer s.observe(ctx, "set_attempts_batch", list, len(items), s.now())
+	return inner.SetAttemptsBatch(ctx, list, items, attempts)
+}
+
+func (s *SlowLogStore) GetListSettings(ctx context.Context, list string) (ListSettings, error) {
+	inner, ok := s.Store.(Configurer)
+	if !ok {
+		return ListSettings{}, notImplemented(s.Store, "Configurer")
+	}
+	defer s.observe(ctx, "get_settings", list, 0, s.now())
+	return inner.GetListSettings(ctx, list)
+}
+
+func (s *SlowLogStore) SetListSettings(ctx context.Context, settings ListSettings) error {
+	inner, ok := s.Store.(Configurer)
+	if !ok {
+		return notImplemented(s.Store, "Configurer")
+	}
+	defer s.observe(ctx, "set_settings", settings.List, 0, s.now())
+	return inner.SetListSettings(ctx, settings)
+}
+
+func (s *SlowLogStore) StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error) {
+	inner, ok := s.Store.(Streamer)
+	if !ok {
+		return 0, notImplemented(s.Store, "Streamer")
+	}
+	defer s.observe(ctx, "stream_batch", list, q.Count, s.now())
+	return inner.StreamBatch(ctx, list, q, f)
+}
+
+func (s *SlowLogStore) Lists(ctx context.Context) ([]ListCount, error) {
+	inner, ok := s.Store.(Lister)
+	if !ok {
+		return nil, notImplemented(s.Store, "Lister")
+	}
+	defer s.observe(ctx, "get_lists", "", 0, s.now())
+	return inner.Lists(ctx)
+}
+
+func (s *SlowLogStore) CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error) {
+	inner, ok := s.Store.(AttemptsCounter)
+	if !ok {
+		return nil, notImplemented(s.Store, "AttemptsCounter")
+	}
+	defer s.observe(ctx, "count_by_attempts", list, 0, s.now())
+	return inner.CountByAttempts(ctx, list)
+}
+
+func (s *SlowLogStore) Diff(ctx context.Context, list string, other string, f func(DiffEntry) error) (int64, error) {
+	inner, ok := s.Store.(Differ)
+	if !ok {
+		return 0, notImplemented(s.Store, "Differ")
+	}
+	defer s.observe(ctx, "diff", list, 0, s.now())
+	return inner.Diff(ctx, list, other, f)
+}
+
+func (s *SlowLogStore) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	inner, ok := s.Store.(Exporter)
+	if !ok {
+		return 0, notImplemented(s.Store, "Exporter")
+	}
+	defer s.observe(ctx, "export", list, 0, s.now())
+	return inner.Export(ctx, w, list)
+}
+
+func (s *SlowLogStore) DBStats(ctx context.Context) (*DBStats, error) {
+	inner, ok := s.Store.(DBStatter)
+	if !ok {
+		return nil, notImplemented(s.Store, "DBStatter")
+	}
+	defer s.observe(ctx, "db_stats", "", 0, s.now())
+	return inner.DBStats(ctx)
+}
@@ -0,0 +1,50 @@
+package pgstore
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// sleepyStore is a Store whose InsertOne takes as long as it is told to,
+// by the fake clock.
+type sleepyStore struct {
+	Store
+	c     *clock.Fake
+	sleep time.Duration
+}
+
+func (s *sleepyStore) InsertOne(ctx context.Context, list string, item string) (int64, error) {
+	s.c.Advance(s.sleep)
+	return 1, nil
+}
+
+func TestSlowLogStore(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	inner := &sleepyStore{c: c}
+	var slow []SlowQuery
+	s := NewSlowLogStore(inner, time.Second, func(q SlowQuery) {
+		slow = append(slow, q)
+	})
+	s.Clock = c
+
+	inner.sleep = 999 * time.Millisecond
+	s.InsertOne(context.Background(), "downloads", "a.txt")
+	if len(slow) != 0 {
+		t.Errorf("got slow queries %v for a call under the threshold", slow)
+	}
+	inner.sleep = 3 * time.Second
+	s.InsertOne(WithBatchID(context.Background(), "b1"), "downloads", "a.txt")
+	want := SlowQuery{Op: "insert_one", List: "downloads", BatchID: "b1", Items: 1, Duration: 3 * time.Second}
+	if len(slow) != 1 || slow[0] != want {
+		t.Errorf("got slow queries %v want [%v]", slow, want)
+	}
+}
@@ -0,0 +1,291 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrSnapshotNotFound is returned when a list has no snapshot
+// under the label asked for.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// ErrSnapshotExists is returned when taking a snapshot of a list under
+// a label that it already has a snapshot under.
+var ErrSnapshotExists = errors.New("snapshot already exists")
+
+// Snapshot describes a point-in-time copy of a list, taken
+// under a label.
+type Snapshot struct {
+	Label     string    `json:"label"`
+	Items     int64     `json:"items"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RestoreCounts reports on a restore of a list from a snapshot.
+type RestoreCounts struct {
+	// Added counts the items that were put back.
+	Added int64 `json:"added"`
+	// Updated counts the items whose attempts were put back.
+	Updated int64 `json:"updated"`
+	// Deleted counts the items that were not in the snapshot.
+	Deleted int64 `json:"deleted"`
+}
+
+// Snapshotter is implemented by stores that can take snapshots of lists,
+// and later restore the lists from them, or compare the lists with them.
+type Snapshotter interface {
+	TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error)
+	Snapshots(ctx context.Context, list string) ([]Snapshot, error)
+	RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error)
+	DeleteSnapshot(ctx context.Context, list string, label string) (int64, error)
+	DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error)
+}
+
+// validateSnapshot checks the names of a snapshot.
+func (p *PgStore) validateSnapshot(list string, label string) error {
+	if err := p.validator().ValidateList(list); err != nil {
+		return err
+	}
+	return p.validator().ValidateLabel(label)
+}
+
+// TakeSnapshot copies every item in the specified list, with its
+// attempts, into a snapshot under label, and describes the snapshot.
+// If the list already has a snapshot under label,
+// ErrSnapshotExists is returned. An empty list can be snapshotted;
+// restoring the snapshot empties the list.
+//
+// The snapshot is taken in one repeatable-read transaction, so it is
+// of the list as it was at one moment, however busy the list is.
+func (p *PgStore) TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error) {
+	if err := p.validateSnapshot(list, label); err != nil {
+		return nil, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	s := &Snapshot{Label: label}
+	err = p.inTx(ctx, conn, pgx.RepeatableRead, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+      insert into iidy.snapshots
+             (list, label, items)
+      values ($1, $2, 0)`, list, label)
+		if err != nil {
+			return err
+		}
+		ct, err := tx.Exec(ctx, `
+      insert into iidy.snapshot_items
+             (list, label, item, attempts)
+      select list, $2, item, attempts
+        from iidy.lists
+       where list = $1`, list, label)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+      update iidy.snapshots
+         set items = $3
+       where list = $1
+         and label = $2
+   returning items,
+             created_at`, list, label, ct.RowsAffected()).Scan(&s.Items, &s.CreatedAt)
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == sqlStateUniqueViolation {
+		return nil, ErrSnapshotExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return s, nil
+}
+
+// Snapshots gets the snapshots of the specified list,
+// oldest first.
+func (p *PgStore) Snapshots(ctx context.Context, list string) ([]Snapshot, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return nil, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, `
+      select label,
+             items,
+             created_at
+        from iidy.snapshots
+       where list = $1
+    order by created_at,
+             label`, list)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		var s Snapshot
+		err = rows.Scan(&s.Label, &s.Items, &s.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("%v", rows.Err())
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot puts the specified list back the way it was when the
+// snapshot under label was taken: items that were not in it are
+// deleted, items that were are put back, and every item's attempts are
+// set to what they were. Items whose attempts are put back are ready
+// right away (see BatchQuery.Ready). Items put back are tagged with the
+// batch ID carried by ctx, if any. The snapshot is kept, so that it can
+// be restored again. If there is no snapshot under label,
+// ErrSnapshotNotFound is returned.
+//
+// The restore is one transaction, so workers see the list either as it
+// was, or as it was restored. Items it deletes are not passed along to
+// the list's chain, if it has one, since they are not done.
+func (p *PgStore) RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error) {
+	if err := p.validateSnapshot(list, label); err != nil {
+		return RestoreCounts{}, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return RestoreCounts{}, err
+	}
+	defer conn.Release()
+	var counts RestoreCounts
+	err = p.inTx(ctx, conn, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		counts = RestoreCounts{}
+		var found bool
+		err := tx.QueryRow(ctx, `
+      select exists (select 1
+                       from iidy.snapshots
+                      where list = $1
+                        and label = $2
+                        for share)`, list, label).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrSnapshotNotFound
+		}
+		// See migration 007.
+		if _, err := tx.Exec(ctx, `set local iidy.no_chain = 'on'`); err != nil {
+			return err
+		}
+		ct, err := tx.Exec(ctx, `
+      delete from iidy.lists l
+       where l.list = $1
+         and not exists (select 1
+                           from iidy.snapshot_items s
+                          where s.list = $1
+                            and s.label = $2
+                            and s.item = l.item)`, list, label)
+		if err != nil {
+			return err
+		}
+		counts.Deleted = ct.RowsAffected()
+		// xmax is 0 for a row that the insert added, and not 0
+		// for one that it updated.
+		rows, err := tx.Query(ctx, `
+      insert into iidy.lists
+             (list, item, attempts, batch_id)
+      select list, item, attempts, $3
+        from iidy.snapshot_items
+       where list = $1
+         and label = $2
+ on conflict (list, item)
+             do update set attempts = excluded.attempts,
+                           not_before = null
+                     where iidy.lists.attempts <> excluded.attempts
+   returning xmax = 0`, list, label, nullIfEmpty(BatchIDFromContext(ctx)))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var inserted bool
+		for rows.Next() {
+			if err := rows.Scan(&inserted); err != nil {
+				return err
+			}
+			if inserted {
+				counts.Added++
+			} else {
+				counts.Updated++
+			}
+		}
+		return rows.Err()
+	})
+	if errors.Is(err, ErrSnapshotNotFound) {
+		return RestoreCounts{}, err
+	}
+	if err != nil {
+		return RestoreCounts{}, fmt.Errorf("%v", err)
+	}
+	return counts, nil
+}
+
+// DeleteSnapshot deletes the snapshot of the specified list under label.
+// The first return value is the number of snapshots deleted: 0 if there
+// was none under label, or 1.
+func (p *PgStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	if err := p.validateSnapshot(list, label); err != nil {
+		return 0, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	ct, err := conn.Exec(ctx, `
+      delete from iidy.snapshots
+       where list = $1
+         and label = $2`, list, label)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return ct.RowsAffected(), nil
+}
+
+// DiffSnapshot compares the snapshot of the specified list under label
+// (as list a) with the list as it is now (as list b), as Diff does, so
+// that DiffOnlyB is for an item added since the snapshot was taken. If
+// there is no snapshot under label, ErrSnapshotNotFound is returned.
+func (p *PgStore) DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error) {
+	if err := p.validateSnapshot(list, label); err != nil {
+		return 0, err
+	}
+	snapshots, err := p.Snapshots(ctx, list)
+	if err != nil {
+		return 0, err
+	}
+	if !hasSnapshot(snapshots, label) {
+		return 0, ErrSnapshotNotFound
+	}
+	return p.diff(ctx, `select item, attempts from iidy.snapshot_items where list = $1 and label = $2`,
+		`select item, attempts from iidy.lists where list = $1`, f, list, label)
+}
+
+// hasSnapshot tells whether snapshots has one under label.
+func hasSnapshot(snapshots []Snapshot, label string) bool {
+	for _, s := range snapshots {
+		if s.Label == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// The statuses that an item can have. An item is StatusPending when it is
+// inserted, StatusInProgress once its attempts are incremented, and
+// StatusDone or StatusFailed once a worker says so (see Transition), or,
+// for StatusFailed, once it has had its list's ListSettings.MaxAttempts.
+const (
+	StatusPending    string = "pending"
+	StatusInProgress string = "in_progress"
+	StatusDone       string = "done"
+	StatusFailed     string = "failed"
+)
+
+// The actions that Transition can take.
+const (
+	// ActionComplete marks a pending or in-progress item done.
+	ActionComplete string = "complete"
+	// ActionFail marks a pending or in-progress item failed.
+	ActionFail string = "fail"
+	// ActionReset makes an item pending again, whatever its status.
+	ActionReset string = "reset"
+)
+
+// ErrInvalidTransition is returned when an item's status does not allow
+// the action asked for, such as completing an item that has failed.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions gives, for each action, the status it moves an item to,
+// and the statuses it can move an item from (nil for any).
+var transitions = map[string]struct {
+	to   string
+	from []string
+}{
+	ActionComplete: {StatusDone, []string{StatusPending, StatusInProgress}},
+	ActionFail:     {StatusFailed, []string{StatusPending, StatusInProgress}},
+	ActionReset:    {StatusPending, nil},
+}
+
+// Transitioner is implemented by stores that keep a status
+// for each item.
+type Transitioner interface {
+	Transition(ctx context.Context, list string, item string, action string) (string, bool, error)
+}
+
+// ValidateStatus checks that status, if not empty, is one of the
+// statuses that an item can have, returning a *ValidationError if not.
+func ValidateStatus(status string) error {
+	switch status {
+	case "", StatusPending, StatusInProgress, StatusDone, StatusFailed:
+		return nil
+	}
+	return &ValidationError{Field: "status", Value: status, Reason: `must be one of "pending", "in_progress", "done", or "failed"`}
+}
+
+// CanTransition tells whether an item with status from can take
+// action, and, if so, what its status becomes. It returns a
+// *ValidationError if action is not one of ActionComplete, ActionFail,
+// or ActionReset, and ErrInvalidTransition if from does not allow it.
+func CanTransition(from string, action string) (string, error) {
+	t, ok := transitions[action]
+	if !ok {
+		return "", &ValidationError{Field: "action", Value: action, Reason: `must be one of "complete", "fail", or "reset"`}
+	}
+	if t.from == nil {
+		return t.to, nil
+	}
+	for _, f := range t.from {
+		if f == from {
+			return t.to, nil
+		}
+	}
+	return "", fmt.Errorf("%w: cannot %s an item that is %s", ErrInvalidTransition, action, from)
+}
+
+// Transition does action to the specified item, moving it to a new
+// status, which it returns. The second return value is false if the item
+// is not in the list. Resetting an item also makes it ready right away
+// (see BatchQuery.Ready); its attempts are kept, either way. Completing
+// an item chains it to the next list, if its list has a Chain, just as
+// deleting it would.
+func (p *PgStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	if err := p.validator().Validate(list, item); err != nil {
+		return "", false, err
+	}
+	if _, err := CanTransition(StatusPending, action); errors.As(err, new(*ValidationError)) {
+		return "", false, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	defer conn.Release()
+	var status string
+	var found bool
+	err = p.inTx(ctx, conn, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var from string
+		err := tx.QueryRow(ctx, `
+      select status
+        from iidy.lists
+       where list = $1
+         and item = $2
+         for update`, list, item).Scan(&from)
+		if errors.Is(err, pgx.ErrNoRows) {
+			found = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		status, err = CanTransition(from, action)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+      update iidy.lists
+         set status = $3,
+             not_before = case when $3 = 'pending' then null else not_before end
+       where list = $1
+         and item = $2`, list, item, status)
+		return err
+	})
+	if errors.Is(err, ErrInvalidTransition) {
+		return "", true, err
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("%v", err)
+	}
+	return status, found, nil
+}
@@ -0,0 +1,174 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Streamer is implemented by stores that can hand over the ListEntries
+// of a batch query one at a time, as they are read, rather than
+// gathering them all into a slice first.
+type Streamer interface {
+	StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error)
+}
+
+// StreamBatch is like QueryBatch, but calls f with each ListEntry as it
+// is read from the database, so that however many there are, they are
+// never all in memory at once; and a q.Count of 0 means no limit, rather
+// than nothing. If f returns an error, StreamBatch stops, and returns it.
+// StreamBatch returns the number of ListEntries that f was called with.
+//
+// The connection is held until the last row is read, so f should not
+// dawdle: a slow reader of a huge list ties up a connection all the while.
+func (p *PgStore) StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return 0, err
+	}
+	if err := ValidateStatus(q.Status); err != nil {
+		return 0, err
+	}
+	if q.StartID != "" {
+		if err := p.validator().ValidateItem(q.StartID); err != nil {
+			return 0, err
+		}
+	}
+	where, args := batchWhere(q, list)
+	limit := ""
+	if q.Count > 0 {
+		where, args = batchWhere(q, list, q.Count)
+		limit = `
+       limit $2`
+	}
+	sql := `
+      select item,
+             attempts
+        from iidy.lists
+       where list = $1
+         ` + where + `
+    order by ` + batchOrder(q) + limit
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var count int64
+	for rows.Next() {
+		var e ListEntry
+		err = rows.Scan(&e.Item, &e.Attempts)
+		if err != nil {
+			return count, fmt.Errorf("%v", err)
+		}
+		if err := f(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if rows.Err() != nil {
+		return count, fmt.Errorf("%v", rows.Err())
+	}
+	return count, nil
+}
+
+// StreamInserter is implemented by stores that can add items to a list
+// as they are handed over, one at a time, rather than from a slice that
+// holds them all.
+type StreamInserter interface {
+	InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error)
+}
+
+// InsertStream is like InsertBatch, but gets its items by calling next
+// until it returns io.EOF, and copies each into the list as it comes,
+// so that however many there are, they are never all in memory at once.
+// Like InsertBatch, it is all or nothing: if next returns any other
+// error, or gives an item that is not valid, nothing is added, and the
+// error is returned as it is.
+func (p *PgStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	if err := p.validator().ValidateList(list); err != nil {
+		return 0, err
+	}
+	// Nothing to add should not need a connection.
+	first, err := next()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	cp := &streamCopier{
+		List:      list,
+		Items:     next,
+		BatchID:   nullIfEmpty(BatchIDFromContext(ctx)),
+		Validator: p.validator(),
+		item:      first,
+		held:      true,
+	}
+	copyCount, err := conn.CopyFrom(
+		ctx,
+		pgx.Identifier{"iidy", "lists"},
+		[]string{"list", "item", "batch_id"},
+		cp)
+	if cp.err != nil {
+		return 0, cp.err
+	}
+	if err != nil {
+		return 0, insertError(err)
+	}
+	return copyCount, nil
+}
+
+// streamCopier implements pgx.CopyFromSource. It is like itemCopier,
+// but it gets each item by calling Items, validating it on the way.
+type streamCopier struct {
+	List      string
+	Items     func() (string, error)
+	BatchID   interface{}
+	Validator *Validator
+	item      string
+	held      bool
+	err       error
+}
+
+// Next tells pgx if there is another row of input left to
+// copy into the destination table.
+func (cp *streamCopier) Next() bool {
+	if cp.held {
+		cp.held = false
+	} else {
+		cp.item, cp.err = cp.Items()
+		if cp.err == io.EOF {
+			cp.err = nil
+			return false
+		}
+		if cp.err != nil {
+			return false
+		}
+	}
+	cp.err = cp.Validator.ValidateItem(cp.item)
+	return cp.err == nil
+}
+
+// Values is called by a pgx copy command when it is ready
+// for the next row of input.
+func (cp *streamCopier) Values() ([]interface{}, error) {
+	return []interface{}{cp.List, cp.item, cp.BatchID}, nil
+}
+
+// Err can be called if there were any errors encountered
+// while copying.
+func (cp *streamCopier) Err() error {
+	return cp.err
+}
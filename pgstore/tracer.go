@@ -0,0 +1,165 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryEvent describes one query that pgx ran against PostgreSQL. Query
+// arguments are left out, because they hold list and item names.
+type QueryEvent struct {
+	// Op is how pgx ran the query: "Query", "Exec", or "CopyFrom".
+	Op       string
+	SQL      string
+	Duration time.Duration
+	Rows     int64
+	Err      error
+}
+
+// QueryTracer is handed to pgx as its logger, so that it sees every query
+// that pgx runs. When enabled, it logs each query, calls OnQuery (if not nil)
+// with it, and counts it, along with its rows, errors, and time taken.
+// It can be turned on and off while the service runs, either with
+// SetEnabled, or over HTTP (see ServeHTTP).
+//
+// Apart from that, if Spans is not nil, every query run on behalf of a
+// traced request (one whose context carries an OpenTelemetry span) is
+// recorded as a span of its own, whether tracing is on or not.
+//
+// QueryTracer satisfies expvar.Var, so its counts can be published
+// with expvar.Publish.
+type QueryTracer struct {
+	enabled int32
+	OnQuery func(QueryEvent)
+	Spans   trace.Tracer
+	stats   expvar.Map
+}
+
+// NewQueryTracer constructs a new, enabled, QueryTracer.
+func NewQueryTracer(onQuery func(QueryEvent)) *QueryTracer {
+	t := &QueryTracer{enabled: 1, OnQuery: onQuery}
+	t.stats.Init()
+	return t
+}
+
+// SetEnabled turns tracing on or off.
+func (t *QueryTracer) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&t.enabled, v)
+}
+
+// Enabled tells us if tracing is on.
+func (t *QueryTracer) Enabled() bool {
+	return atomic.LoadInt32(&t.enabled) == 1
+}
+
+// Log satisfies the pgx.Logger interface. pgx logs every query it runs
+// with a message of "Query", "Exec", or "CopyFrom"; everything else
+// pgx logs is ignored.
+func (t *QueryTracer) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	enabled := t.Enabled()
+	if !enabled && t.Spans == nil {
+		return
+	}
+	if msg != "Query" && msg != "Exec" && msg != "CopyFrom" {
+		return
+	}
+	e := QueryEvent{Op: msg}
+	e.SQL, _ = data["sql"].(string)
+	if msg == "CopyFrom" {
+		e.SQL = fmt.Sprintf("copy %v %v", data["tableName"], data["columnNames"])
+	}
+	e.Duration, _ = data["time"].(time.Duration)
+	if rows, ok := data["rowCount"].(int64); ok {
+		e.Rows = rows
+	} else if rows, ok := data["rowCount"].(int); ok {
+		e.Rows = int64(rows)
+	} else if tag, ok := data["commandTag"].(pgconn.CommandTag); ok {
+		e.Rows = tag.RowsAffected()
+	}
+	if err, ok := data["err"].(error); ok {
+		e.Err = err
+	}
+	if t.Spans != nil {
+		t.span(ctx, e)
+	}
+	if enabled {
+		t.record(e)
+	}
+}
+
+// span records e, which has just finished, as a span of the trace
+// in ctx, if there is one.
+func (t *QueryTracer) span(ctx context.Context, e QueryEvent) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	end := time.Now()
+	_, span := t.Spans.Start(ctx, e.Op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-e.Duration)),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBStatementKey.String(e.SQL),
+			attribute.Int64("db.rows", e.Rows),
+		))
+	if e.Err != nil {
+		span.RecordError(e.Err)
+		span.SetStatus(codes.Error, e.Err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// record logs and counts e.
+func (t *QueryTracer) record(e QueryEvent) {
+	if e.Err != nil {
+		log.Printf("Query: op=%s duration=%v rows=%d err=%q sql=%q\n", e.Op, e.Duration, e.Rows, e.Err.Error(), e.SQL)
+		t.stats.Add(e.Op+"_errors", 1)
+	} else {
+		log.Printf("Query: op=%s duration=%v rows=%d sql=%q\n", e.Op, e.Duration, e.Rows, e.SQL)
+	}
+	t.stats.Add(e.Op+"_count", 1)
+	t.stats.Add(e.Op+"_rows", e.Rows)
+	t.stats.Add(e.Op+"_microseconds", e.Duration.Microseconds())
+	if t.OnQuery != nil {
+		t.OnQuery(e)
+	}
+}
+
+// String satisfies expvar.Var, giving the query counts as a JSON object.
+func (t *QueryTracer) String() string {
+	return t.stats.String()
+}
+
+// ServeHTTP reports whether tracing is on, as {"enabled": true|false}.
+// A POST with a query arg of enabled=true or enabled=false
+// turns tracing on or off first.
+func (t *QueryTracer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("For query arg enabled: %v", err), http.StatusBadRequest)
+			return
+		}
+		t.SetEnabled(enabled)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": t.Enabled()})
+}
@@ -0,0 +1,127 @@
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQueryTracer(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var events []QueryEvent
+	tracer := NewQueryTracer(func(e QueryEvent) {
+		events = append(events, e)
+	})
+	ctx := context.Background()
+	tracer.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{
+		"sql":      "select 1",
+		"args":     []interface{}{"secret"},
+		"time":     2 * time.Millisecond,
+		"rowCount": 1,
+	})
+	tracer.Log(ctx, pgx.LogLevelInfo, "Exec", map[string]interface{}{
+		"sql":        "delete from lists",
+		"time":       3 * time.Millisecond,
+		"commandTag": pgconn.CommandTag("DELETE 4"),
+	})
+	tracer.Log(ctx, pgx.LogLevelError, "Exec", map[string]interface{}{
+		"sql": "delete from nope",
+		"err": errors.New("relation does not exist"),
+	})
+	tracer.Log(ctx, pgx.LogLevelInfo, "Dialing PostgreSQL server", nil)
+	tracer.SetEnabled(false)
+	tracer.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "select 2"})
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events want 3: %v", len(events), events)
+	}
+	if events[0].Rows != 1 || events[1].Rows != 4 || events[2].Err == nil {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	var got map[string]int64
+	if err := json.Unmarshal([]byte(tracer.String()), &got); err != nil {
+		t.Fatalf("stats are not JSON: %v: %s", err, tracer.String())
+	}
+	want := map[string]int64{
+		"Query_count":        1,
+		"Query_rows":         1,
+		"Query_microseconds": 2000,
+		"Exec_count":         2,
+		"Exec_rows":          4,
+		"Exec_errors":        1,
+		"Exec_microseconds":  3000,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestQueryTracerSpans(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	tracer := NewQueryTracer(nil)
+	tracer.SetEnabled(false)
+	tracer.Spans = provider.Tracer("pgstore")
+
+	// A query outside of any trace gets no span.
+	tracer.Log(context.Background(), pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "select 1"})
+	ctx, request := provider.Tracer("test").Start(context.Background(), "get_one")
+	tracer.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{
+		"sql":      "select attempts from iidy.lists where list = $1 and item = $2",
+		"time":     2 * time.Millisecond,
+		"rowCount": 1,
+	})
+	tracer.Log(ctx, pgx.LogLevelError, "Exec", map[string]interface{}{
+		"sql": "delete from nope",
+		"err": errors.New("relation does not exist"),
+	})
+	request.End()
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("got %d spans want 3", len(ended))
+	}
+	query, exec := ended[0], ended[1]
+	if query.Name() != "Query" || query.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("query span is %q with parent %v", query.Name(), query.Parent().SpanID())
+	}
+	if d := query.EndTime().Sub(query.StartTime()); d != 2*time.Millisecond {
+		t.Errorf("query span took %v want 2ms", d)
+	}
+	if exec.Status().Code != codes.Error || exec.Status().Description != "relation does not exist" {
+		t.Errorf("exec span has status %v", exec.Status())
+	}
+}
+
+func TestQueryTracerToggle(t *testing.T) {
+	tracer := NewQueryTracer(nil)
+	req := httptest.NewRequest(http.MethodPost, "/debug/iidy/tracing?enabled=false", nil)
+	rr := httptest.NewRecorder()
+	tracer.ServeHTTP(rr, req)
+	if rr.Body.String() != "{\"enabled\":false}\n" || tracer.Enabled() {
+		t.Errorf("tracing not turned off: %s", rr.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodPost, "/debug/iidy/tracing?enabled=bogus", nil)
+	rr = httptest.NewRecorder()
+	tracer.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %d want %d", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,62 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// DefaultTxRetries is how many times a transaction that loses a race
+// with another transaction is run again, unless PgStore.TxRetries
+// says otherwise.
+const DefaultTxRetries int = 3
+
+// The SQLSTATEs of failures that mean a transaction lost a race with
+// another transaction, and can simply be run again.
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// retryable tells whether err means that a transaction lost a race with
+// another transaction (a serialization failure or a deadlock), and so
+// can be run again from the start.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == sqlStateSerializationFailure || pgErr.Code == sqlStateDeadlockDetected
+}
+
+// txRetries gives p.TxRetries, or DefaultTxRetries if it is 0.
+// A negative p.TxRetries turns retrying off.
+func (p *PgStore) txRetries() int {
+	switch {
+	case p.TxRetries == 0:
+		return DefaultTxRetries
+	case p.TxRetries < 0:
+		return 0
+	}
+	return p.TxRetries
+}
+
+// inTx runs f in a transaction on conn at isolation level iso, committing
+// if f returns nil and rolling back otherwise. If the transaction fails
+// with a serialization failure or a deadlock, it is run again, up to
+// p.TxRetries more times, so f must not carry anything over from one run
+// to the next: anything it accumulates must be reset at its start.
+//
+// Errors are returned as they are, for the caller to repackage.
+func (p *PgStore) inTx(ctx context.Context, conn *pgxpool.Conn, iso pgx.TxIsoLevel, f func(tx pgx.Tx) error) error {
+	retries := p.txRetries()
+	for attempt := 0; ; attempt++ {
+		err := conn.BeginTxFunc(ctx, pgx.TxOptions{IsoLevel: iso}, f)
+		if err == nil || attempt >= retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+}
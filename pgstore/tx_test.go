@@ -0,0 +1,45 @@
+package pgstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "40P01"}, true},
+		{fmt.Errorf("commit: %w", &pgconn.PgError{Code: "40001"}), true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{errors.New("could not serialize access"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v): got %v want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTxRetries(t *testing.T) {
+	tests := []struct {
+		retries int
+		want    int
+	}{
+		{0, DefaultTxRetries},
+		{-1, 0},
+		{7, 7},
+	}
+	for _, tt := range tests {
+		p := PgStore{TxRetries: tt.retries}
+		if got := p.txRetries(); got != tt.want {
+			t.Errorf("TxRetries %d: got %d want %d", tt.retries, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package pgstore
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ValidationError is returned when a list or item name
+// (or an action to take on an item) is not allowed.
+type ValidationError struct {
+	// Field is "list", "item", "action", "conflict", "status", or
+	// "attempts", or, for the stores' less common operations, "prefix",
+	// "template", or "label", or, for list settings, the setting.
+	Field string
+	// Value is the offending name, action, conflict, status, attempts, or
+	// setting.
+	Value string
+	// Reason says what is wrong with Value.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	switch e.Field {
+	case "action", "conflict", "status", "attempts", "max_attempts", "retry_base", "retry_cap", "ttl":
+		return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s name %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// Validator checks list and item names before they go anywhere near
+// the database (or a response body).
+//
+// Names must be valid, non-empty, UTF-8, without control characters,
+// and no longer than the maximum lengths (in bytes). If a deployment wants
+// to be stricter, Charset, if not nil, must match every name.
+type Validator struct {
+	MaxListLength int
+	MaxItemLength int
+	Charset       *regexp.Regexp
+}
+
+// DefaultValidator is used when no other Validator is given.
+var DefaultValidator = &Validator{
+	MaxListLength: 255,
+	MaxItemLength: 1024,
+}
+
+// MaxKeyLength is the most bytes that the maximum lengths of a Validator
+// can add up to. A list name and an item name together are the primary key
+// of iidy.lists, and PostgreSQL cannot index a key much longer than
+// a third of a page (about 2.7kB), so this leaves room to spare.
+const MaxKeyLength int = 2600
+
+// Check says whether v's rules can be kept: its maximum lengths must be
+// positive, and together no more than MaxKeyLength.
+func (v *Validator) Check() error {
+	if v.MaxListLength <= 0 || v.MaxItemLength <= 0 {
+		return fmt.Errorf("maximum list and item name lengths must be positive, not %d and %d", v.MaxListLength, v.MaxItemLength)
+	}
+	if v.MaxListLength+v.MaxItemLength > MaxKeyLength {
+		return fmt.Errorf("maximum list and item name lengths of %d and %d add up to more than %d bytes", v.MaxListLength, v.MaxItemLength, MaxKeyLength)
+	}
+	return nil
+}
+
+// validate checks a name against the rules for field.
+func (v *Validator) validate(field string, name string, maxLength int) error {
+	if name == "" {
+		return &ValidationError{Field: field, Value: name, Reason: "must not be empty"}
+	}
+	if len(name) > maxLength {
+		return &ValidationError{Field: field, Value: name, Reason: fmt.Sprintf("longer than %d bytes", maxLength)}
+	}
+	if !utf8.ValidString(name) {
+		return &ValidationError{Field: field, Value: name, Reason: "not valid UTF-8"}
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return &ValidationError{Field: field, Value: name, Reason: fmt.Sprintf("contains control character %U", r)}
+		}
+	}
+	if v.Charset != nil && !v.Charset.MatchString(name) {
+		return &ValidationError{Field: field, Value: name, Reason: fmt.Sprintf("does not match %s", v.Charset)}
+	}
+	return nil
+}
+
+// ValidateList checks a list name.
+func (v *Validator) ValidateList(list string) error {
+	return v.validate("list", list, v.MaxListLength)
+}
+
+// ValidateItem checks an item name.
+func (v *Validator) ValidateItem(item string) error {
+	return v.validate("item", item, v.MaxItemLength)
+}
+
+// ValidateLabel checks a snapshot label, which follows the
+// same rules as a list name.
+func (v *Validator) ValidateLabel(label string) error {
+	return v.validate("label", label, v.MaxListLength)
+}
+
+// Validate checks a list name and any number of item names,
+// returning the first problem found.
+func (v *Validator) Validate(list string, items ...string) error {
+	if err := v.ValidateList(list); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := v.ValidateItem(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateEntries is like Validate, for ListEntries.
+func (v *Validator) ValidateEntries(list string, entries []ListEntry) error {
+	if err := v.ValidateList(list); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := v.ValidateItem(e.Item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
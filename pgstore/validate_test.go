@@ -0,0 +1,67 @@
+package pgstore
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	v := &Validator{MaxListLength: 10, MaxItemLength: 20}
+	charset := &Validator{MaxListLength: 10, MaxItemLength: 20, Charset: regexp.MustCompile(`^[a-z0-9_.-]+$`)}
+	tests := []struct {
+		v     *Validator
+		list  string
+		items []string
+		field string
+	}{
+		{v: v, list: "downloads", items: []string{"kernel.tar.gz", "ünïcödé"}},
+		{v: v, list: "", field: "list"},
+		{v: v, list: "downloads!!", field: "list"},
+		{v: v, list: "downloads", items: []string{"a", ""}, field: "item"},
+		{v: v, list: "downloads", items: []string{strings.Repeat("a", 21)}, field: "item"},
+		{v: v, list: "downloads", items: []string{"a\nb"}, field: "item"},
+		{v: v, list: "down\x00", field: "list"},
+		{v: v, list: "downloads", items: []string{"\xff\xfe"}, field: "item"},
+		{v: charset, list: "downloads", items: []string{"kernel.tar.gz"}},
+		{v: charset, list: "downloads", items: []string{"ünïcödé"}, field: "item"},
+		{v: charset, list: "Downloads", field: "list"},
+	}
+	for _, test := range tests {
+		err := test.v.Validate(test.list, test.items...)
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("Validate(%q, %q) gave unexpected error: %v", test.list, test.items, err)
+			}
+			continue
+		}
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("Validate(%q, %q) gave %v, want a *ValidationError", test.list, test.items, err)
+			continue
+		}
+		if ve.Field != test.field {
+			t.Errorf("Validate(%q, %q) complained about %s, want %s: %v", test.list, test.items, ve.Field, test.field, err)
+		}
+	}
+}
+
+func TestValidatorCheck(t *testing.T) {
+	tests := []struct {
+		v  *Validator
+		ok bool
+	}{
+		{v: DefaultValidator, ok: true},
+		{v: &Validator{MaxListLength: 100, MaxItemLength: MaxKeyLength - 100}, ok: true},
+		{v: &Validator{MaxListLength: 100, MaxItemLength: MaxKeyLength - 99}},
+		{v: &Validator{MaxListLength: 0, MaxItemLength: 1024}},
+		{v: &Validator{MaxListLength: 255, MaxItemLength: -1}},
+	}
+	for _, test := range tests {
+		err := test.v.Check()
+		if (err == nil) != test.ok {
+			t.Errorf("Check() of lengths %d and %d gave %v, want ok %v", test.v.MaxListLength, test.v.MaxItemLength, err, test.ok)
+		}
+	}
+}
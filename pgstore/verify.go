@@ -0,0 +1,164 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/manniwood/iidy/clock"
+)
+
+// maxVerifyExamples is how many offending list items a Check names.
+const maxVerifyExamples int = 10
+
+// Check is the result of checking one invariant of the data store.
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Detail says what was found, and, if the check failed,
+	// names some of the offending list items.
+	Detail string `json:"detail"`
+}
+
+// VerifyReport is the result of checking every invariant
+// of the data store.
+type VerifyReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Check   `json:"checks"`
+}
+
+// OK tells us whether every check passed.
+func (r *VerifyReport) OK() bool {
+	for _, c := range r.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks the invariants that iidy relies on, which could be broken
+// by a crash, or by manual surgery on the database:
+//
+//     negative_attempts: no item has been attempted fewer than 0 times.
+//     valid_names: every list and item name would pass p's Validator
+//                  (less its Charset, which is a matter of policy).
+//     index_matches_heap: the lists table and its primary key index
+//                  have the same number of rows.
+//
+// Verify reads every row of the lists table, more than once,
+// so it should not be run often.
+func (p *PgStore) Verify(ctx context.Context) (*VerifyReport, error) {
+	r := &VerifyReport{CheckedAt: clock.OrSystem(p.Clock).Now().UTC()}
+	checks := []func(context.Context) (Check, error){
+		p.checkNegativeAttempts,
+		p.checkValidNames,
+		p.checkIndexMatchesHeap,
+	}
+	for _, check := range checks {
+		c, err := check(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.Checks = append(r.Checks, c)
+	}
+	return r, nil
+}
+
+// checkOffenders runs a check whose query (which must select list and item,
+// and count(*) over ()) finds offending list items.
+func (p *PgStore) checkOffenders(ctx context.Context, name string, what string, sql string, args ...interface{}) (Check, error) {
+	rows, err := p.pool.Query(ctx, sql+fmt.Sprintf(" limit %d", maxVerifyExamples), args...)
+	if err != nil {
+		return Check{}, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+	var total int64
+	var examples []string
+	for rows.Next() {
+		var list, item string
+		err = rows.Scan(&list, &item, &total)
+		if err != nil {
+			return Check{}, fmt.Errorf("%v", err)
+		}
+		examples = append(examples, fmt.Sprintf("%q/%q", list, item))
+	}
+	if rows.Err() != nil {
+		return Check{}, fmt.Errorf("%v", rows.Err())
+	}
+	if total == 0 {
+		return Check{Name: name, OK: true, Detail: fmt.Sprintf("no items %s", what)}, nil
+	}
+	return Check{
+		Name:   name,
+		OK:     false,
+		Detail: fmt.Sprintf("%d items %s, such as %s", total, what, strings.Join(examples, ", ")),
+	}, nil
+}
+
+func (p *PgStore) checkNegativeAttempts(ctx context.Context) (Check, error) {
+	return p.checkOffenders(ctx, "negative_attempts", "have negative attempts", `
+      select list,
+             item,
+             count(*) over ()
+        from iidy.lists
+       where attempts < 0
+    order by list, item`)
+}
+
+func (p *PgStore) checkValidNames(ctx context.Context) (Check, error) {
+	v := p.validator()
+	return p.checkOffenders(ctx, "valid_names", "have invalid list or item names", `
+      select list,
+             item,
+             count(*) over ()
+        from iidy.lists
+       where list = ''
+          or item = ''
+          or octet_length(list) > $1
+          or octet_length(item) > $2
+          or list ~ '[[:cntrl:]]'
+          or item ~ '[[:cntrl:]]'
+    order by list, item`, v.MaxListLength, v.MaxItemLength)
+}
+
+// checkIndexMatchesHeap counts the rows of the lists table twice, once
+// with index scans turned off, and once with sequential scans turned off,
+// to find a corrupt (or out of date) primary key index.
+func (p *PgStore) checkIndexMatchesHeap(ctx context.Context) (Check, error) {
+	var heap, index int64
+	err := p.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		for _, sql := range []string{
+			`set local enable_indexscan = off`,
+			`set local enable_indexonlyscan = off`,
+			`set local enable_bitmapscan = off`,
+		} {
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return err
+			}
+		}
+		if err := tx.QueryRow(ctx, `select count(*) from iidy.lists`).Scan(&heap); err != nil {
+			return err
+		}
+		for _, sql := range []string{
+			`set local enable_seqscan = off`,
+			`set local enable_indexonlyscan = on`,
+		} {
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return err
+			}
+		}
+		return tx.QueryRow(ctx, `select count(*) from iidy.lists where list >= ''`).Scan(&index)
+	})
+	if err != nil {
+		return Check{}, fmt.Errorf("%v", err)
+	}
+	c := Check{
+		Name:   "index_matches_heap",
+		OK:     heap == index,
+		Detail: fmt.Sprintf("lists table has %d rows, list_pk index has %d", heap, index),
+	}
+	return c, nil
+}
@@ -0,0 +1,91 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/manniwood/iidy/clock"
+)
+
+// idleConns remembers when each of the pool's connections was last
+// released, so that a connection that has sat idle for a while can be
+// checked before it is handed out again.
+type idleConns struct {
+	mu       sync.Mutex
+	released map[*pgx.Conn]time.Time
+}
+
+// Warm opens the pool's minimum number of connections (pool_min_conns in
+// the connection URL) all at once, and pings each of them, so that the
+// first requests after startup neither wait for connections to be opened,
+// nor find that they do not work. It returns the number of connections it
+// warmed, which is 0 if the pool has no minimum.
+func (p *PgStore) Warm(ctx context.Context) (int, error) {
+	n := int(p.pool.Config().MinConns)
+	conns := make([]*pgxpool.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Release()
+		}
+	}()
+	// Hold on to each connection until all are acquired, so
+	// that the pool has to open every one of them.
+	for i := 0; i < n; i++ {
+		c, err := p.acquire(ctx)
+		if err != nil {
+			return len(conns), err
+		}
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		if err := c.Conn().Ping(ctx); err != nil {
+			return 0, fmt.Errorf("%v", err)
+		}
+	}
+	return len(conns), nil
+}
+
+// afterRelease is the pool's AfterRelease hook. It notes when conn was
+// released, if p.PrePingIdle calls for it.
+func (p *PgStore) afterRelease(conn *pgx.Conn) bool {
+	if p.PrePingIdle <= 0 {
+		return true
+	}
+	p.idle.mu.Lock()
+	defer p.idle.mu.Unlock()
+	if p.idle.released == nil {
+		p.idle.released = make(map[*pgx.Conn]time.Time)
+	}
+	// Forget connections that the pool has since closed.
+	for c := range p.idle.released {
+		if c.IsClosed() {
+			delete(p.idle.released, c)
+		}
+	}
+	p.idle.released[conn] = clock.OrSystem(p.Clock).Now()
+	return true
+}
+
+// beforeAcquire is the pool's BeforeAcquire hook. It pings conn if it has
+// been idle for longer than p.PrePingIdle, so that a connection that died
+// while idle (such as one that a firewall or PgBouncer dropped) is thrown
+// away, and another one tried, instead of failing the request it was
+// handed out for.
+func (p *PgStore) beforeAcquire(ctx context.Context, conn *pgx.Conn) bool {
+	if p.PrePingIdle <= 0 {
+		return true
+	}
+	p.idle.mu.Lock()
+	released, ok := p.idle.released[conn]
+	delete(p.idle.released, conn)
+	p.idle.mu.Unlock()
+	if !ok || clock.OrSystem(p.Clock).Since(released) < p.PrePingIdle {
+		return true
+	}
+	return conn.Ping(ctx) == nil
+}
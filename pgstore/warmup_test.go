@@ -0,0 +1,50 @@
+package pgstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/clock"
+	"github.com/manniwood/iidy/pgstoretest"
+)
+
+func TestWarmAndPrePing(t *testing.T) {
+	db := pgstoretest.NewDB(t)
+	s, err := NewPgStore(db.URL + "&pool_min_conns=2&pool_max_conns=2")
+	if err != nil {
+		t.Fatalf("Error instantiating PgStore: %v", err)
+	}
+	ctx := context.Background()
+
+	n, err := s.Warm(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 warmed connections; got %d, %v", n, err)
+	}
+
+	fake := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.Clock = fake
+	s.PrePingIdle = time.Minute
+	// Note the idle connections' release times, and kill them
+	// behind the pool's back.
+	conns := s.pool.AcquireAllIdle(ctx)
+	if len(conns) != 2 {
+		t.Fatalf("Expected 2 idle connections; got %d", len(conns))
+	}
+	victim := conns[0].Conn().PgConn().PID()
+	if _, err := conns[1].Exec(ctx, `select pg_terminate_backend($1)`, victim); err != nil {
+		t.Fatalf("Error terminating backend: %v", err)
+	}
+	for _, c := range conns {
+		c.Release()
+	}
+	fake.Advance(2 * time.Minute)
+
+	// Whichever connection these get, the dead one is found
+	// before it is used.
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.GetOne(ctx, "downloads", "a.txt"); err != nil {
+			t.Errorf("Expected pre-ping to skip the dead connection; got %v", err)
+		}
+	}
+}
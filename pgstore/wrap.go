@@ -0,0 +1,20 @@
+package pgstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotImplemented is the error that a Store that wraps another, such
+// as a ReadOnlyStore, gives for a call to a side interface (such as
+// StreamInserter) that the store it wraps does not implement. The
+// wrappers forward the side interfaces with the same checks as the
+// Store methods, so that no change gets around them, but can only
+// forward them to a store that has them.
+var ErrNotImplemented = errors.New("not implemented by data store")
+
+// notImplemented gives ErrNotImplemented, for s, which does not
+// implement iface.
+func notImplemented(s Store, iface string) error {
+	return fmt.Errorf("%w: %T is not a %s", ErrNotImplemented, s, iface)
+}
@@ -0,0 +1,258 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// sideStore is a Store that also implements the side interfaces,
+// counting the calls made to them.
+type sideStore struct {
+	countingStore
+	calls int
+}
+
+func (s *sideStore) InsertStream(ctx context.Context, list string, next func() (string, error)) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) SetChain(ctx context.Context, c Chain) error {
+	s.calls++
+	return nil
+}
+
+func (s *sideStore) GetChain(ctx context.Context, list string) (*Chain, error) {
+	s.calls++
+	return nil, nil
+}
+
+func (s *sideStore) DeleteChain(ctx context.Context, list string) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) Merge(ctx context.Context, dest string, src string, conflict string) (MergeCounts, error) {
+	s.calls++
+	return MergeCounts{}, nil
+}
+
+func (s *sideStore) TakeSnapshot(ctx context.Context, list string, label string) (*Snapshot, error) {
+	s.calls++
+	return nil, nil
+}
+
+func (s *sideStore) Snapshots(ctx context.Context, list string) ([]Snapshot, error) {
+	s.calls++
+	return nil, nil
+}
+
+func (s *sideStore) RestoreSnapshot(ctx context.Context, list string, label string) (RestoreCounts, error) {
+	s.calls++
+	return RestoreCounts{}, nil
+}
+
+func (s *sideStore) DeleteSnapshot(ctx context.Context, list string, label string) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) DiffSnapshot(ctx context.Context, list string, label string, f func(DiffEntry) error) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) Transition(ctx context.Context, list string, item string, action string) (string, bool, error) {
+	s.calls++
+	return StatusDone, true, nil
+}
+
+func (s *sideStore) DecrementOne(ctx context.Context, list string, item string) (int64, error) {
+	s.calls++
+	return 1, nil
+}
+
+func (s *sideStore) DecrementBatch(ctx context.Context, list string, items []string) (int64, error) {
+	s.calls++
+	return int64(len(items)), nil
+}
+
+func (s *sideStore) SetAttemptsOne(ctx context.Context, list string, item string, attempts int) (int64, error) {
+	s.calls++
+	return 1, nil
+}
+
+func (s *sideStore) SetAttemptsBatch(ctx context.Context, list string, items []string, attempts int) (int64, error) {
+	s.calls++
+	return int64(len(items)), nil
+}
+
+func (s *sideStore) GetListSettings(ctx context.Context, list string) (ListSettings, error) {
+	s.calls++
+	return ListSettings{List: list}, nil
+}
+
+func (s *sideStore) SetListSettings(ctx context.Context, settings ListSettings) error {
+	s.calls++
+	return nil
+}
+
+func (s *sideStore) StreamBatch(ctx context.Context, list string, q BatchQuery, f func(ListEntry) error) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) Lists(ctx context.Context) ([]ListCount, error) {
+	s.calls++
+	return nil, nil
+}
+
+func (s *sideStore) CountByAttempts(ctx context.Context, list string) ([]AttemptsCount, error) {
+	s.calls++
+	return nil, nil
+}
+
+func (s *sideStore) Diff(ctx context.Context, a string, b string, f func(DiffEntry) error) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) Export(ctx context.Context, w io.Writer, list string) (int64, error) {
+	s.calls++
+	return 0, nil
+}
+
+func (s *sideStore) DBStats(ctx context.Context) (*DBStats, error) {
+	s.calls++
+	return nil, nil
+}
+
+// wrapped gives s in each of the wrappers, the way cmd/iidy wraps its
+// store, with the ReadOnlyStore on the outside.
+func wrapped(s Store) *ReadOnlyStore {
+	chaos := NewChaosStore(s)
+	slow := NewSlowLogStore(chaos, time.Hour, nil)
+	breaker := NewBreakerStore(slow, func(ctx context.Context) error { return nil })
+	return NewReadOnlyStore(breaker, nil)
+}
+
+func TestWrappedSideInterfaces(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		write bool
+		call  func(r *ReadOnlyStore) error
+	}{
+		{"InsertStream", true, func(r *ReadOnlyStore) error {
+			_, err := r.InsertStream(ctx, "downloads", func() (string, error) { return "", io.EOF })
+			return err
+		}},
+		{"SetChain", true, func(r *ReadOnlyStore) error {
+			return r.SetChain(ctx, Chain{List: "downloads", Next: "uploads"})
+		}},
+		{"GetChain", false, func(r *ReadOnlyStore) error {
+			_, err := r.GetChain(ctx, "downloads")
+			return err
+		}},
+		{"DeleteChain", true, func(r *ReadOnlyStore) error {
+			_, err := r.DeleteChain(ctx, "downloads")
+			return err
+		}},
+		{"Merge", true, func(r *ReadOnlyStore) error {
+			_, err := r.Merge(ctx, "downloads", "uploads", MergeKeepMax)
+			return err
+		}},
+		{"TakeSnapshot", true, func(r *ReadOnlyStore) error {
+			_, err := r.TakeSnapshot(ctx, "downloads", "before")
+			return err
+		}},
+		{"Snapshots", false, func(r *ReadOnlyStore) error {
+			_, err := r.Snapshots(ctx, "downloads")
+			return err
+		}},
+		{"RestoreSnapshot", true, func(r *ReadOnlyStore) error {
+			_, err := r.RestoreSnapshot(ctx, "downloads", "before")
+			return err
+		}},
+		{"DeleteSnapshot", true, func(r *ReadOnlyStore) error {
+			_, err := r.DeleteSnapshot(ctx, "downloads", "before")
+			return err
+		}},
+		{"DiffSnapshot", false, func(r *ReadOnlyStore) error {
+			_, err := r.DiffSnapshot(ctx, "downloads", "before", func(DiffEntry) error { return nil })
+			return err
+		}},
+		{"Transition", true, func(r *ReadOnlyStore) error {
+			_, _, err := r.Transition(ctx, "downloads", "a.txt", ActionComplete)
+			return err
+		}},
+		{"DecrementOne", true, func(r *ReadOnlyStore) error {
+			_, err := r.DecrementOne(ctx, "downloads", "a.txt")
+			return err
+		}},
+		{"DecrementBatch", true, func(r *ReadOnlyStore) error {
+			_, err := r.DecrementBatch(ctx, "downloads", []string{"a.txt"})
+			return err
+		}},
+		{"SetAttemptsOne", true, func(r *ReadOnlyStore) error {
+			_, err := r.SetAttemptsOne(ctx, "downloads", "a.txt", 0)
+			return err
+		}},
+		{"SetAttemptsBatch", true, func(r *ReadOnlyStore) error {
+			_, err := r.SetAttemptsBatch(ctx, "downloads", []string{"a.txt"}, 0)
+			return err
+		}},
+		{"GetListSettings", false, func(r *ReadOnlyStore) error {
+			_, err := r.GetListSettings(ctx, "downloads")
+			return err
+		}},
+		{"SetListSettings", true, func(r *ReadOnlyStore) error {
+			return r.SetListSettings(ctx, ListSettings{List: "downloads", MaxAttempts: 3})
+		}},
+		{"StreamBatch", false, func(r *ReadOnlyStore) error {
+			_, err := r.StreamBatch(ctx, "downloads", BatchQuery{Count: 10}, func(ListEntry) error { return nil })
+			return err
+		}},
+		{"Lists", false, func(r *ReadOnlyStore) error {
+			_, err := r.Lists(ctx)
+			return err
+		}},
+		{"CountByAttempts", false, func(r *ReadOnlyStore) error {
+			_, err := r.CountByAttempts(ctx, "downloads")
+			return err
+		}},
+		{"Diff", false, func(r *ReadOnlyStore) error {
+			_, err := r.Diff(ctx, "downloads", "uploads", func(DiffEntry) error { return nil })
+			return err
+		}},
+		{"Export", false, func(r *ReadOnlyStore) error {
+			_, err := r.Export(ctx, io.Discard, "downloads")
+			return err
+		}},
+		{"DBStats", false, func(r *ReadOnlyStore) error {
+			_, err := r.DBStats(ctx)
+			return err
+		}},
+	}
+	for _, tc := range tests {
+		inner := &sideStore{}
+		r := wrapped(inner)
+		if err := tc.call(r); err != nil || inner.calls != 1 {
+			t.Errorf("%s: got %d calls, %v, want 1 call through the wrappers", tc.name, inner.calls, err)
+		}
+		r.Forced = true
+		err := tc.call(r)
+		switch {
+		case tc.write && (!errors.Is(err, ErrReadOnly) || inner.calls != 1):
+			t.Errorf("%s: got %d calls, %v, want the change turned away with %v", tc.name, inner.calls, err, ErrReadOnly)
+		case !tc.write && (err != nil || inner.calls != 2):
+			t.Errorf("%s: got %d calls, %v, want the read to go through", tc.name, inner.calls, err)
+		}
+		if err := tc.call(wrapped(&countingStore{})); !errors.Is(err, ErrNotImplemented) {
+			t.Errorf("%s: got %v over a plain Store want %v", tc.name, err, ErrNotImplemented)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package iidy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CheckTimeout bounds how long a Readiness check can take.
+const CheckTimeout time.Duration = 2 * time.Second
+
+// Readiness tells orchestrators (such as a Kubernetes readiness probe)
+// whether the server is ready to serve lists, and if not, why not.
+// It starts out not ready. A server can also be ready but degraded, such
+// as when it can serve reads, but not changes.
+//
+// Once the server is ready, each readiness request can also run a
+// check (see SetCheck), such as that the database is still reachable,
+// so that the server is not ready while the check fails.
+//
+// Readiness satisfies http.Handler, giving a status of 200 when ready,
+// or else 503, and expvar.Var, so it can be published with expvar.Publish.
+type Readiness struct {
+	mu       sync.Mutex
+	ready    bool
+	reason   string
+	degraded string
+	check    func(ctx context.Context) error
+}
+
+// NewReadiness returns a Readiness that is not ready yet,
+// because the server is starting.
+func NewReadiness() *Readiness {
+	return &Readiness{reason: "starting"}
+}
+
+// SetReady marks the server as ready.
+func (r *Readiness) SetReady() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ready = true
+	r.reason = ""
+}
+
+// SetNotReady marks the server as not ready, for the given reason.
+func (r *Readiness) SetNotReady(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ready = false
+	r.reason = reason
+}
+
+// SetDegraded marks the server as degraded, for the given reason,
+// or, if reason is "", as no longer degraded. Being degraded does
+// not make the server any less ready.
+func (r *Readiness) SetDegraded(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.degraded = reason
+}
+
+// SetCheck sets the check that each readiness request runs once the
+// server is ready. If it fails, the server is not ready, for the reason
+// that its error gives. The check is given CheckTimeout to run.
+func (r *Readiness) SetCheck(check func(ctx context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.check = check
+}
+
+// Degraded tells why the server is degraded, or is "" if it is not.
+func (r *Readiness) Degraded() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.degraded
+}
+
+// Ready tells whether the server is ready, and if not, why not.
+func (r *Readiness) Ready() (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ready, r.reason
+}
+
+// ServeHTTP satisfies http.Handler.
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ready, reason := r.Ready()
+	r.mu.Lock()
+	check := r.check
+	r.mu.Unlock()
+	if ready && check != nil {
+		ctx, cancel := context.WithTimeout(req.Context(), CheckTimeout)
+		defer cancel()
+		if err := check(ctx); err != nil {
+			ready, reason = false, err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "NOT READY %s\n", reason)
+		return
+	}
+	if degraded := r.Degraded(); degraded != "" {
+		fmt.Fprintf(w, "READY DEGRADED %s\n", degraded)
+		return
+	}
+	fmt.Fprintf(w, "READY\n")
+}
+
+// Healthz tells orchestrators (such as a Kubernetes liveness probe) that
+// the server is up, with a status of 200, whether or not it is ready.
+func Healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "OK\n")
+}
+
+// String satisfies expvar.Var, giving the readiness as JSON. Checks are
+// not run for it.
+func (r *Readiness) String() string {
+	ready, reason := r.Ready()
+	b, err := json.Marshal(struct {
+		Ready    bool   `json:"ready"`
+		Reason   string `json:"reason,omitempty"`
+		Degraded string `json:"degraded,omitempty"`
+	}{ready, reason, r.Degraded()})
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
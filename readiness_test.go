@@ -0,0 +1,85 @@
+package iidy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	r := NewReadiness()
+	tests := []struct {
+		change   func()
+		expected int
+		body     string
+		expvar   string
+	}{
+		{func() {}, http.StatusServiceUnavailable, "NOT READY starting\n", `{"ready":false,"reason":"starting"}`},
+		{func() { r.SetNotReady("waiting for database") }, http.StatusServiceUnavailable, "NOT READY waiting for database\n", `{"ready":false,"reason":"waiting for database"}`},
+		{r.SetReady, http.StatusOK, "READY\n", `{"ready":true}`},
+		{func() { r.SetDegraded("read_only") }, http.StatusOK, "READY DEGRADED read_only\n", `{"ready":true,"degraded":"read_only"}`},
+		{func() { r.SetDegraded("") }, http.StatusOK, "READY\n", `{"ready":true}`},
+	}
+	for _, test := range tests {
+		test.change()
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rr.Code != test.expected {
+			t.Errorf("got status %d want %d", rr.Code, test.expected)
+		}
+		if rr.Body.String() != test.body {
+			t.Errorf("got body %q want %q", rr.Body.String(), test.body)
+		}
+		if got := r.String(); got != test.expvar {
+			t.Errorf("got %s want %s", got, test.expvar)
+		}
+	}
+}
+
+func TestReadinessCheck(t *testing.T) {
+	r := NewReadiness()
+	var checkErr error
+	checks := 0
+	r.SetCheck(func(ctx context.Context) error {
+		checks++
+		return checkErr
+	})
+	tests := []struct {
+		change   func()
+		expected int
+		body     string
+	}{
+		// The check is not run until the server is ready.
+		{func() {}, http.StatusServiceUnavailable, "NOT READY starting\n"},
+		{r.SetReady, http.StatusOK, "READY\n"},
+		{func() { checkErr = errors.New("database is at migration 7 of 8") }, http.StatusServiceUnavailable, "NOT READY database is at migration 7 of 8\n"},
+		{func() { checkErr = nil }, http.StatusOK, "READY\n"},
+	}
+	for _, test := range tests {
+		test.change()
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rr.Code != test.expected {
+			t.Errorf("got status %d want %d", rr.Code, test.expected)
+		}
+		if rr.Body.String() != test.body {
+			t.Errorf("got body %q want %q", rr.Body.String(), test.body)
+		}
+	}
+	if checks != 3 {
+		t.Errorf("check was run %d times want 3", checks)
+	}
+	if got := r.String(); got != `{"ready":true}` {
+		t.Errorf("got %s want {\"ready\":true}", got)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Healthz(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "OK\n" {
+		t.Errorf("got %d %q want 200 \"OK\\n\"", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Package seed generates synthetic list items, for demos, benchmarks, and
+migration rehearsals. The same Config always generates the same items,
+with the same attempts, so that runs can be compared.
+
+    cfg := seed.Config{
+        Count:    1000000,
+        Pattern:  "s3://bucket/%08d.tar.gz",
+        Attempts: map[int]int{0: 90, 1: 8, 5: 2},
+        Seed:     42,
+    }
+    added, err := seed.Load(ctx, store, "downloads", cfg, nil)
+
+Load inserts the items in batches with InsertBatchEntries, which, for a
+pgstore.PgStore, uses PostgreSQL's COPY.
+*/
+package seed
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// DefaultPattern is the item name pattern used when Config.Pattern is empty.
+const DefaultPattern string = "item-%08d"
+
+// DefaultBatchSize is the batch size used when Config.BatchSize is 0.
+const DefaultBatchSize int = 10000
+
+// Config describes the items to generate.
+type Config struct {
+	// Count is how many items to generate.
+	Count int
+	// Pattern is a fmt pattern that is given each item's number,
+	// from 0 to Count-1, such as "item-%08d".
+	Pattern string
+	// Attempts weights the number of attempts that items get: with
+	// {0: 90, 3: 10}, 90% of items have 0 attempts, and 10% have 3.
+	// When empty, every item has 0 attempts.
+	Attempts map[int]int
+	// Seed seeds the random choice of attempts.
+	Seed int64
+	// BatchSize is how many items Load inserts at a time.
+	BatchSize int
+}
+
+// ParseAttempts parses attempt weights written as "0=90,1=8,5=2",
+// for Config.Attempts.
+func ParseAttempts(s string) (map[int]int, error) {
+	weights := make(map[int]int)
+	if s == "" {
+		return weights, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf(`"%s" is not of the form attempts=weight`, part)
+		}
+		attempts, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || attempts < 0 {
+			return nil, fmt.Errorf(`"%s": attempts must be a number, 0 or more`, part)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf(`"%s": weight must be a number, 0 or more`, part)
+		}
+		weights[attempts] = weight
+	}
+	return weights, nil
+}
+
+// Generator generates the items described by a Config, one at a time.
+type Generator struct {
+	cfg      Config
+	rnd      *rand.Rand
+	attempts []int
+	weights  []int
+	total    int
+	next     int
+}
+
+// NewGenerator constructs a new Generator of the items described by cfg.
+func NewGenerator(cfg Config) *Generator {
+	if cfg.Pattern == "" {
+		cfg.Pattern = DefaultPattern
+	}
+	g := &Generator{cfg: cfg, rnd: rand.New(rand.NewSource(cfg.Seed))}
+	// Map iteration order is random, so sort the attempts,
+	// for the same choices every time.
+	for attempts := range cfg.Attempts {
+		g.attempts = append(g.attempts, attempts)
+	}
+	sort.Ints(g.attempts)
+	for _, attempts := range g.attempts {
+		g.total += cfg.Attempts[attempts]
+		g.weights = append(g.weights, g.total)
+	}
+	return g
+}
+
+// Next gives the next item, and false once Count items have been given.
+func (g *Generator) Next() (pgstore.ListEntry, bool) {
+	if g.next >= g.cfg.Count {
+		return pgstore.ListEntry{}, false
+	}
+	e := pgstore.ListEntry{Item: fmt.Sprintf(g.cfg.Pattern, g.next)}
+	g.next++
+	if g.total > 0 {
+		n := g.rnd.Intn(g.total)
+		i := sort.Search(len(g.weights), func(i int) bool { return g.weights[i] > n })
+		e.Attempts = g.attempts[i]
+	}
+	return e, true
+}
+
+// Entries gives all of the items described by cfg.
+func Entries(cfg Config) []pgstore.ListEntry {
+	g := NewGenerator(cfg)
+	entries := make([]pgstore.ListEntry, 0, cfg.Count)
+	for e, ok := g.Next(); ok; e, ok = g.Next() {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Load adds the items described by cfg to list in s, a batch at a time,
+// and gives the number of items added. progress, if not nil, is called
+// after each batch with the number added so far.
+func Load(ctx context.Context, s pgstore.Store, list string, cfg Config, progress func(added int64)) (int64, error) {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	g := NewGenerator(cfg)
+	var added int64
+	batch := make([]pgstore.ListEntry, 0, batchSize)
+	for {
+		e, ok := g.Next()
+		if ok {
+			batch = append(batch, e)
+		}
+		if len(batch) == batchSize || (!ok && len(batch) > 0) {
+			n, err := s.InsertBatchEntries(ctx, list, batch)
+			if err != nil {
+				return added, err
+			}
+			added += n
+			batch = batch[:0]
+			if progress != nil {
+				progress(added)
+			}
+		}
+		if !ok {
+			return added, nil
+		}
+	}
+}
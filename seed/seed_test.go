@@ -0,0 +1,71 @@
+package seed
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+func TestParseAttempts(t *testing.T) {
+	got, err := ParseAttempts("0=90, 1=8,5=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int{0: 90, 1: 8, 5: 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	for _, bad := range []string{"0", "a=1", "1=b", "-1=5", "1=-5"} {
+		if _, err := ParseAttempts(bad); err == nil {
+			t.Errorf("ParseAttempts(%q) gave no error", bad)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	cfg := Config{Count: 10000, Pattern: "f-%05d.txt", Attempts: map[int]int{0: 90, 3: 10}, Seed: 42}
+	entries := Entries(cfg)
+	if len(entries) != cfg.Count {
+		t.Fatalf("got %d entries want %d", len(entries), cfg.Count)
+	}
+	if entries[0].Item != "f-00000.txt" || entries[9999].Item != "f-09999.txt" {
+		t.Errorf("got items %q ... %q", entries[0].Item, entries[9999].Item)
+	}
+	counts := make(map[int]int)
+	for _, e := range entries {
+		counts[e.Attempts]++
+	}
+	if len(counts) != 2 || counts[3] < 800 || counts[3] > 1200 {
+		t.Errorf("got attempt counts %v, want about 9000 at 0, 1000 at 3", counts)
+	}
+	if again := Entries(cfg); !reflect.DeepEqual(entries, again) {
+		t.Errorf("the same config gave different entries")
+	}
+	cfg.Seed = 43
+	if other := Entries(cfg); reflect.DeepEqual(entries, other) {
+		t.Errorf("a different seed gave the same entries")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s := memstore.NewMemStore()
+	cfg := Config{Count: 25, BatchSize: 10, Attempts: map[int]int{0: 1, 1: 1}}
+	var progress []int64
+	added, err := Load(context.Background(), s, "seeded", cfg, func(n int64) {
+		progress = append(progress, n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 25 || !reflect.DeepEqual(progress, []int64{10, 20, 25}) {
+		t.Errorf("got %d added, progress %v", added, progress)
+	}
+	got, err := s.GetBatch(context.Background(), "seeded", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Entries(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
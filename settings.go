@@ -0,0 +1,182 @@
+package iidy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// ListSettingsMessage is the settings of a list (see pgstore.ListSettings)
+// that we serialize/deserialize to/from JSON or MessagePack, with the retry
+// delays and TTL as durations such as "90s". A setting that is left out has
+// its default.
+type ListSettingsMessage struct {
+	List        string  `json:"list"`
+	MaxAttempts *int    `json:"max_attempts,omitempty"`
+	RetryBase   *string `json:"retry_base,omitempty"`
+	RetryCap    *string `json:"retry_cap,omitempty"`
+	TTL         *string `json:"ttl,omitempty"`
+	ArchiveList *string `json:"archive_list,omitempty"`
+}
+
+// newListSettingsMessage gives the message for s.
+func newListSettingsMessage(s pgstore.ListSettings) *ListSettingsMessage {
+	m := &ListSettingsMessage{List: s.List}
+	if s.MaxAttempts > 0 {
+		m.MaxAttempts = &s.MaxAttempts
+	}
+	if s.ArchiveList != "" {
+		m.ArchiveList = &s.ArchiveList
+	}
+	for _, d := range []struct {
+		d time.Duration
+		s **string
+	}{
+		{s.RetryBase, &m.RetryBase},
+		{s.RetryCap, &m.RetryCap},
+		{s.TTL, &m.TTL},
+	} {
+		if d.d > 0 {
+			str := d.d.String()
+			*d.s = &str
+		}
+	}
+	return m
+}
+
+// listSettings gives the pgstore.ListSettings of list that m holds.
+func (m *ListSettingsMessage) listSettings(list string) (pgstore.ListSettings, error) {
+	s := pgstore.ListSettings{List: list}
+	if m.MaxAttempts != nil {
+		s.MaxAttempts = *m.MaxAttempts
+	}
+	if m.ArchiveList != nil {
+		s.ArchiveList = *m.ArchiveList
+	}
+	for _, d := range []struct {
+		name string
+		s    *string
+		d    *time.Duration
+	}{
+		{"retry_base", m.RetryBase, &s.RetryBase},
+		{"retry_cap", m.RetryCap, &s.RetryCap},
+		{"ttl", m.TTL, &s.TTL},
+	} {
+		if d.s == nil {
+			continue
+		}
+		var err error
+		*d.d, err = time.ParseDuration(*d.s)
+		if err != nil {
+			return s, fmt.Errorf("%s %q is not a duration", d.name, *d.s)
+		}
+	}
+	return s, nil
+}
+
+// getSettings gets the settings of a list (see pgstore.ListSettings).
+// Only the settings that the list has are given; the rest have their
+// defaults. A list that has none set is not an error: it gets them all.
+func (h *Handler) getSettings(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Settings == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) {
+		return
+	}
+	s, err := h.Settings.GetListSettings(r.Context(), list)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get settings of list %s: %v", list, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	printSuccess(w, r, newListSettingsMessage(s), http.StatusOK)
+}
+
+// setSettings replaces the settings of a list (see pgstore.ListSettings):
+// any setting left out goes back to its default, so an empty body puts
+// them all back. The body is {"max_attempts": 5, "retry_base": "1m",
+// "retry_cap": "1h", "ttl": "168h", "archive_list": "expired"} in JSON or
+// MessagePack, or, in plain text, one "name value" line per setting, with
+// the same names.
+func (h *Handler) setSettings(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Settings == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) {
+		return
+	}
+	m, err := getSettingsFromRequest(r)
+	if err != nil {
+		errStr := fmt.Sprintf("Error parsing settings from request body: %v", err)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	s, err := m.listSettings(list)
+	if err != nil {
+		errStr := fmt.Sprintf("Error parsing settings from request body: %v", err)
+		printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+		return
+	}
+	// Expired items are moved to the archive list, so the API key
+	// has to allow that list, too.
+	if s.ArchiveList != "" && !h.validate(w, r, s.ArchiveList) {
+		return
+	}
+	err = h.Settings.SetListSettings(r.Context(), s)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to set settings of list %s: %v", list, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	printSuccess(w, r, newListSettingsMessage(s), http.StatusOK)
+}
+
+// getSettingsFromRequest gets a ListSettingsMessage (less its List) from
+// the request body, regardless of the format it is in.
+func getSettingsFromRequest(r *http.Request) (*ListSettingsMessage, error) {
+	m := &ListSettingsMessage{}
+	bodyBytes, _ := r.Context().Value(BodyBytesKey).([]byte)
+	if len(strings.TrimSpace(string(bodyBytes))) == 0 {
+		return m, nil
+	}
+	contentType := requestContentType(r)
+	if isStructured(contentType) {
+		if err := decodeBody(contentType, bodyBytes, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	for _, line := range getItemsFromPlainText(bodyBytes) {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected a name and a value; got %q", line)
+		}
+		value := parts[1]
+		switch parts[0] {
+		case "max_attempts":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("max_attempts %q is not a number", value)
+			}
+			m.MaxAttempts = &n
+		case "retry_base":
+			m.RetryBase = &value
+		case "retry_cap":
+			m.RetryCap = &value
+		case "ttl":
+			m.TTL = &value
+		case "archive_list":
+			m.ArchiveList = &value
+		default:
+			return nil, fmt.Errorf("%q is not one of max_attempts, retry_base, retry_cap, ttl, or archive_list", parts[0])
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,133 @@
+package iidy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+type configurerStub struct {
+	settings map[string]pgstore.ListSettings
+}
+
+func (c configurerStub) GetListSettings(ctx context.Context, list string) (pgstore.ListSettings, error) {
+	s, ok := c.settings[list]
+	if !ok {
+		return pgstore.ListSettings{List: list}, nil
+	}
+	return s, nil
+}
+
+func (c configurerStub) SetListSettings(ctx context.Context, s pgstore.ListSettings) error {
+	c.settings[s.List] = s
+	return nil
+}
+
+func TestSettingsHandler(t *testing.T) {
+	settings := configurerStub{settings: make(map[string]pgstore.ListSettings)}
+	h := &Handler{Store: StoreTestingStub{}, Settings: settings}
+	tests := []struct {
+		method      string
+		url         string
+		contentType string
+		body        string
+		code        int
+		expected    string
+		want        *pgstore.ListSettings
+	}{
+		{
+			method: http.MethodGet, url: "/iidy/v1/settings/lists/downloads",
+			code: http.StatusOK, expected: "list downloads\n",
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v1/settings/lists/downloads", contentType: "text/plain",
+			body: "max_attempts 2\nretry_base 5m\n",
+			code: http.StatusOK, expected: "list downloads\nmax_attempts 2\nretry_base 5m0s\n",
+			want: &pgstore.ListSettings{List: "downloads", MaxAttempts: 2, RetryBase: 5 * time.Minute},
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v1/settings/lists/downloads", contentType: "application/json",
+			body: `{"max_attempts": 5, "retry_base": "30s", "retry_cap": "1h"}`,
+			code: http.StatusOK, expected: `{"list":"downloads","max_attempts":5,"retry_base":"30s","retry_cap":"1h0m0s"}` + "\n",
+			want: &pgstore.ListSettings{List: "downloads", MaxAttempts: 5, RetryBase: 30 * time.Second, RetryCap: time.Hour},
+		},
+		{
+			method: http.MethodGet, url: "/iidy/v2/lists/downloads/settings",
+			code: http.StatusOK, expected: `{"list":"downloads","max_attempts":5,"retry_base":"30s","retry_cap":"1h0m0s"}` + "\n",
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v1/settings/lists/downloads", contentType: "text/plain",
+			body: "max_attempts 3\n",
+			code: http.StatusOK, expected: "list downloads\nmax_attempts 3\n",
+			want: &pgstore.ListSettings{List: "downloads", MaxAttempts: 3},
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v2/lists/downloads/settings", contentType: "text/plain",
+			body: "ttl 168h\narchive_list expired\n",
+			code: http.StatusOK, expected: "list downloads\nttl 168h0m0s\narchive_list expired\n",
+			want: &pgstore.ListSettings{List: "downloads", TTL: 168 * time.Hour, ArchiveList: "expired"},
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v1/settings/lists/downloads", contentType: "text/plain",
+			code: http.StatusOK, expected: "list downloads\n",
+			want: &pgstore.ListSettings{List: "downloads"},
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v1/settings/lists/downloads", contentType: "text/plain",
+			body: "retry_base soon\n",
+			code: http.StatusBadRequest, expected: "Error parsing settings from request body: retry_base \"soon\" is not a duration\n",
+		},
+		{
+			method: http.MethodPut, url: "/iidy/v1/settings/lists/downloads", contentType: "text/plain",
+			body: "lease 5m\n",
+			code: http.StatusBadRequest, expected: "Error parsing settings from request body: \"lease\" is not one of max_attempts, retry_base, retry_cap, ttl, or archive_list\n",
+		},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("%s %s: got status %d want %d", tc.method, tc.url, rr.Code, tc.code)
+		}
+		if rr.Body.String() != tc.expected {
+			t.Errorf("%s %s: got body %q want %q", tc.method, tc.url, rr.Body.String(), tc.expected)
+		}
+		if tc.want != nil {
+			if got := settings.settings["downloads"]; got != *tc.want {
+				t.Errorf("%s %s: got settings %+v want %+v", tc.method, tc.url, got, *tc.want)
+			}
+		}
+	}
+
+	// An API key that does not allow the archive list cannot have
+	// items moved into it.
+	keys := []APIKey{{Key: "team", Prefixes: []string{"team-a-"}}}
+	authed := Chain(&Handler{Store: StoreTestingStub{}, Settings: settings}, RequireAPIKey(keys))
+	req := httptest.NewRequest(http.MethodPut, "/iidy/v2/lists/team-a-jobs/settings", strings.NewReader("ttl 1h\narchive_list team-b-jobs\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", "Bearer team")
+	rr := httptest.NewRecorder()
+	authed.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || rr.Body.String() != "This API key does not allow list \"team-b-jobs\".\n" {
+		t.Errorf("got status %d, body %q, want the archive list forbidden", rr.Code, rr.Body.String())
+	}
+	if _, ok := settings.settings["team-a-jobs"]; ok {
+		t.Errorf("settings were set with a forbidden archive list")
+	}
+
+	h = &Handler{Store: StoreTestingStub{}}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/iidy/v1/settings/lists/downloads", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %d want %d without a Configurer", rr.Code, http.StatusNotFound)
+	}
+}
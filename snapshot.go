@@ -0,0 +1,118 @@
+package iidy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// SnapshotsMessage lists the snapshots of a list, oldest first.
+// The message can be formatted either as plain text or JSON.
+type SnapshotsMessage struct {
+	Snapshots []pgstore.Snapshot `json:"snapshots"`
+}
+
+// RestoredMessage informs the user how many items a restore of a list
+// from a snapshot added, updated the attempts of, and deleted.
+// The message can be formatted either as plain text or JSON.
+type RestoredMessage struct {
+	Added   int64 `json:"added"`
+	Updated int64 `json:"updated"`
+	Deleted int64 `json:"deleted"`
+}
+
+// getSnapshots lists the snapshots of list.
+func (h *Handler) getSnapshots(w http.ResponseWriter, r *http.Request, list string) {
+	if h.Snapshots == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) {
+		return
+	}
+	h.Metrics.CountRequest(list, "get_snapshots")
+	snapshots, err := h.Snapshots.Snapshots(r.Context(), list)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to get snapshots of %q: %v", list, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	printSuccess(w, r, &SnapshotsMessage{Snapshots: snapshots}, http.StatusOK)
+}
+
+// takeSnapshot snapshots list under label. If list already has a
+// snapshot under label, a status of 409 is given.
+func (h *Handler) takeSnapshot(w http.ResponseWriter, r *http.Request, list string, label string) {
+	if h.Snapshots == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) || !h.validateLabel(w, r, label) {
+		return
+	}
+	h.Metrics.CountRequest(list, "create_snapshot")
+	snap, err := h.Snapshots.TakeSnapshot(r.Context(), list, label)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to snapshot %q as %q: %v", list, label, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	printSuccess(w, r, snap, http.StatusCreated)
+}
+
+// restoreSnapshot puts list back the way it was when its snapshot under
+// label was taken. If there is no such snapshot, a status of 404 is
+// given.
+func (h *Handler) restoreSnapshot(w http.ResponseWriter, r *http.Request, list string, label string) {
+	if h.Snapshots == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	r = startBatch(w, r)
+	if !h.validate(w, r, list) || !h.validateLabel(w, r, label) {
+		return
+	}
+	h.Metrics.CountRequest(list, "restore_snapshot")
+	counts, err := h.Snapshots.RestoreSnapshot(r.Context(), list, label)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to restore %q from %q: %v", list, label, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	h.Metrics.CountRows(list, "restore_snapshot", counts.Added+counts.Updated+counts.Deleted)
+	h.Activity.Record(list, pgstore.ActionCounts{Added: counts.Added, Deleted: counts.Deleted})
+	h.mutated(MutationInsert, list, nil, counts.Added)
+	h.mutated(MutationDelete, list, nil, counts.Deleted)
+	printSuccess(w, r, (*RestoredMessage)(&counts), http.StatusOK)
+}
+
+// deleteSnapshot deletes the snapshot of list under label.
+func (h *Handler) deleteSnapshot(w http.ResponseWriter, r *http.Request, list string, label string) {
+	if h.Snapshots == nil {
+		printError(w, r, &ErrorMessage{Error: "Not found."}, http.StatusNotFound)
+		return
+	}
+	if !h.validate(w, r, list) || !h.validateLabel(w, r, label) {
+		return
+	}
+	h.Metrics.CountRequest(list, "delete_snapshot")
+	count, err := h.Snapshots.DeleteSnapshot(r.Context(), list, label)
+	if err != nil {
+		errStr := fmt.Sprintf("Error trying to delete snapshot %q of %q: %v", label, list, err)
+		printStoreError(w, r, errStr, err)
+		return
+	}
+	printSuccess(w, r, &DeletedMessage{Deleted: count}, http.StatusOK)
+}
+
+// validateLabel checks a snapshot label, and, if it is no good, gives
+// a status of 400, and returns false.
+func (h *Handler) validateLabel(w http.ResponseWriter, r *http.Request, label string) bool {
+	err := h.validator().ValidateLabel(label)
+	if err == nil {
+		return true
+	}
+	printStoreError(w, r, err.Error(), err)
+	return false
+}
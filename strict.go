@@ -0,0 +1,243 @@
+package iidy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/manniwood/iidy/pgstore"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// StrictKey is the key to find, in the request's context, whether
+// the request is being handled in strict mode (see Handler.Strict).
+const StrictKey string = "strict"
+
+// routeQueryArgs are the query args that each route (as named by
+// routeName) takes, and, for those that only take certain values, what
+// those values are. In strict mode, any other query arg is an error.
+var routeQueryArgs = map[string]map[string][]string{
+	"insert_one":         {"action": {"increment"}, "on_conflict": nil},
+	"increment_one":      {"action": {"increment"}},
+	"transition":         {"action": {"complete", "fail", "reset"}},
+	"decrement_one":      {"action": {"decrement"}},
+	"set_attempts_one":   {"action": {"set"}, "value": nil},
+	"decrement_batch":    {"action": {"decrement"}, "items": nil},
+	"set_attempts_batch": {"action": {"set"}, "value": nil, "items": nil},
+	"insert_batch":       {"action": {"increment"}, "on_conflict": nil, "detail": {"full", "per_item"}},
+	"increment_batch":    {"action": {"increment"}, "detail": {"full", "per_item"}, "items": nil},
+	"delete_batch":       {"detail": {"full", "per_item"}, "items": nil},
+	"get_batch": {
+		"after_id": nil, "from_id": nil, "updated_before": nil, "updated_after": nil,
+		"order": {"item", "oldest_first", "most_attempts"}, "ready": nil, "count": nil, "remaining": nil,
+		"status": {"pending", "in_progress", "done", "failed"}, "min_attempts": nil, "max_attempts": nil,
+	},
+	"get_multi":        {"item": nil},
+	"get_activity":     {"since": nil},
+	"get_top":          {"n": nil},
+	"get_count":        {"by": {"attempts"}},
+	"get_diff":         {"with": nil, "snapshot": nil},
+	"restore_snapshot": {"action": {"restore"}},
+	"merge":            {"action": {"merge"}, "from": nil, "conflict": {"keep_max", "keep_dest", "sum"}},
+	"get_export":       {"list": nil},
+}
+
+// routeBodies are the types that the structured (JSON or MessagePack)
+// bodies of each route (as named by routeName) are decoded into. In
+// strict mode, a field that the type does not have is an error.
+var routeBodies = map[string]reflect.Type{
+	"insert_batch":       reflect.TypeOf(BatchItemListMessage{}),
+	"increment_batch":    reflect.TypeOf(BatchItemListMessage{}),
+	"decrement_batch":    reflect.TypeOf(BatchItemListMessage{}),
+	"set_attempts_batch": reflect.TypeOf(BatchItemListMessage{}),
+	"delete_batch":       reflect.TypeOf(BatchItemListMessage{}),
+	"get_multi":          reflect.TypeOf(BatchItemListMessage{}),
+	"apply_batch":        reflect.TypeOf(ActionListMessage{}),
+	"set_chain":          reflect.TypeOf(pgstore.Chain{}),
+	"set_settings":       reflect.TypeOf(ListSettingsMessage{}),
+}
+
+// isStrict tells whether r is being handled in strict mode.
+func isStrict(r *http.Request) bool {
+	strict, _ := r.Context().Value(StrictKey).(bool)
+	return strict
+}
+
+// strictMediaType gives the media type of a Content-Type header,
+// without its parameters (such as "; charset=utf-8").
+func strictMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// checkStrict checks r as strict mode calls for: that its Content-Type,
+// if it has one, is one that is handled, that its query args are all
+// ones that its route takes, and that its structured body has no fields
+// that its route does not know about. If not, it gives a status of 415
+// or 400, and returns false.
+func (h *Handler) checkStrict(w http.ResponseWriter, r *http.Request) bool {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		if _, ok := HandledContentTypes[strictMediaType(contentType)]; !ok {
+			errStr := fmt.Sprintf("Content-Type %q is not one of %s", contentType, strings.Join(handledContentTypes(), ", "))
+			printError(w, r, &ErrorMessage{Error: errStr, Code: CodeUnsupportedMediaType}, http.StatusUnsupportedMediaType)
+			return false
+		}
+	}
+	route := routeName(r)
+	if route == "unknown" {
+		// The request will fail to be routed, which says more
+		// than anything strict mode could.
+		return true
+	}
+	query := r.Context().Value(QueryKey).(url.Values)
+	args := routeQueryArgs[route]
+	for _, name := range sortedKeys(query) {
+		values, ok := args[name]
+		if !ok {
+			errStr := fmt.Sprintf("Query arg %s is not one that %s %s takes", name, r.Method, r.URL.Path)
+			printError(w, r, &ErrorMessage{Error: errStr, Code: CodeUnknownQueryArg}, http.StatusBadRequest)
+			return false
+		}
+		if values != nil && !contains(values, query.Get(name)) {
+			errStr := fmt.Sprintf("For query arg %s, %q is not one of %s", name, query.Get(name), strings.Join(values, ", "))
+			printError(w, r, &ErrorMessage{Error: errStr}, http.StatusBadRequest)
+			return false
+		}
+	}
+	t, ok := routeBodies[route]
+	bodyBytes, _ := r.Context().Value(BodyBytesKey).([]byte)
+	contentType := requestContentType(r)
+	if !ok || len(bytes.TrimSpace(bodyBytes)) == 0 || !isStructured(contentType) {
+		return true
+	}
+	var v interface{}
+	var err error
+	if contentType == "application/msgpack" {
+		err = msgpack.Unmarshal(bodyBytes, &v)
+	} else {
+		err = json.Unmarshal(bodyBytes, &v)
+	}
+	if err != nil {
+		// Left for the route to complain about.
+		return true
+	}
+	if field := unknownField(v, t, ""); field != "" {
+		errStr := fmt.Sprintf("Request body field %s is not one that %s %s takes", field, r.Method, r.URL.Path)
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeUnknownField}, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// unknownField gives the path (such as "items[2].atempts") of the first
+// field in v, a generically decoded body, that t does not have, or "" if
+// there is none. Values that t takes in some other form (such as a bare
+// item name, for a BatchItem, or a bare array, for a message whose
+// only field is its items) are not checked.
+func unknownField(v interface{}, t reflect.Type, path string) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		switch t.Kind() {
+		case reflect.Slice:
+			for i, e := range v {
+				if field := unknownField(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); field != "" {
+					return field
+				}
+			}
+		case reflect.Struct:
+			if f, ok := jsonField(t, "items"); ok {
+				return unknownField(v, f.Type, path)
+			}
+		}
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return ""
+		}
+		for _, name := range sortedKeys(v) {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			f, ok := jsonField(t, name)
+			if !ok {
+				return fieldPath
+			}
+			if field := unknownField(v[name], f.Type, fieldPath); field != "" {
+				return field
+			}
+		}
+	}
+	return ""
+}
+
+// jsonField finds the field of struct type t whose JSON name is name.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == name || (tag == "" && strings.EqualFold(f.Name, name)) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// checkStrictNDJSON checks that line, one line of a newline-delimited
+// JSON body, has no fields that t does not have.
+func checkStrictNDJSON(line json.RawMessage, t reflect.Type) error {
+	var v interface{}
+	if err := json.Unmarshal(line, &v); err != nil {
+		return err
+	}
+	if field := unknownField(v, t, ""); field != "" {
+		return fmt.Errorf("field %s is not one that is taken", field)
+	}
+	return nil
+}
+
+// handledContentTypes gives HandledContentTypes, sorted.
+func handledContentTypes() []string {
+	types := make([]string, 0, len(HandledContentTypes))
+	for t := range HandledContentTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// sortedKeys gives the keys of m, sorted, so that errors
+// about them are the same from one request to the next.
+func sortedKeys(m interface{}) []string {
+	rv := reflect.ValueOf(m)
+	keys := make([]string, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains tells whether values contains v.
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
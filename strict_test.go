@@ -0,0 +1,116 @@
+package iidy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+func TestStrict(t *testing.T) {
+	tests := map[string]struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		code        int
+		errCode     ErrorCode
+		errContains string
+	}{
+		"UnknownQueryArg": {
+			method:      http.MethodPost,
+			path:        "/iidy/v1/lists/downloads/a.txt?acton=increment",
+			code:        http.StatusBadRequest,
+			errCode:     CodeUnknownQueryArg,
+			errContains: "acton",
+		},
+		"UnknownQueryArgValue": {
+			method:      http.MethodPost,
+			path:        "/iidy/v1/lists/downloads/a.txt?action=incremnt",
+			code:        http.StatusBadRequest,
+			errCode:     CodeBadRequest,
+			errContains: "incremnt",
+		},
+		"UnknownContentType": {
+			method:      http.MethodPost,
+			path:        "/iidy/v1/batch/lists/downloads",
+			contentType: "application/xml",
+			body:        "<items/>",
+			code:        http.StatusUnsupportedMediaType,
+			errCode:     CodeUnsupportedMediaType,
+			errContains: "application/xml",
+		},
+		"UnknownField": {
+			method:      http.MethodPost,
+			path:        "/iidy/v1/batch/lists/downloads",
+			contentType: "application/json",
+			body:        `{"items":[{"item":"a.txt","atempts":1}]}`,
+			code:        http.StatusBadRequest,
+			errCode:     CodeUnknownField,
+			errContains: "items[0].atempts",
+		},
+		"UnknownNDJSONField": {
+			method:      http.MethodPost,
+			path:        "/iidy/v1/batch/lists/downloads",
+			contentType: "application/x-ndjson",
+			body:        "{\"item\":\"a.txt\"}\n{\"item\":\"b.txt\",\"atempts\":1}\n",
+			code:        http.StatusBadRequest,
+			errCode:     CodeBadRequest,
+			errContains: "atempts",
+		},
+		"KnownArgs": {
+			method: http.MethodPost,
+			path:   "/iidy/v1/batch/lists/downloads?on_conflict=ignore",
+			body:   "a.txt\n",
+			code:   http.StatusCreated,
+		},
+		"ContentTypeParameters": {
+			method:      http.MethodPost,
+			path:        "/iidy/v1/batch/lists/downloads",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"items":[{"item":"a.txt"}]}`,
+			code:        http.StatusCreated,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, strict := range []bool{false, true} {
+				h := &Handler{Store: memstore.NewMemStore(), Strict: strict}
+				r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+				if tc.contentType != "" {
+					r.Header.Set("Content-Type", tc.contentType)
+				}
+				r.Header.Set("Accept", "application/json")
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, r)
+				if !strict {
+					// Without strict mode, what strict mode turns away
+					// is made what sense of it can be.
+					if rr.Code >= 300 {
+						t.Errorf("Not strict: got status %d: %s", rr.Code, rr.Body.String())
+					}
+					continue
+				}
+				if rr.Code != tc.code {
+					t.Fatalf("Got status %d want %d: %s", rr.Code, tc.code, rr.Body.String())
+				}
+				if tc.errCode == "" {
+					continue
+				}
+				var m ErrorMessage
+				if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+					t.Fatalf("Could not decode %q: %v", rr.Body.String(), err)
+				}
+				if m.Code != tc.errCode {
+					t.Errorf("Got code %q want %q", m.Code, tc.errCode)
+				}
+				if !strings.Contains(m.Error, tc.errContains) {
+					t.Errorf("Got error %q, which does not mention %q", m.Error, tc.errContains)
+				}
+			}
+		})
+	}
+}
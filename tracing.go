@@ -0,0 +1,42 @@
+package iidy
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the span of r, named for its route, as a child of
+// the trace that r's headers carry, if any. It gives w and r to handle
+// the request with, r carrying the span in its context, so that the
+// queries the request runs are traced too, and a func to end the span
+// with once the request has been handled.
+//
+// Item names are left out of the span, as they are left out of the
+// slow query log.
+func (h *Handler) startSpan(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	route := routeName(r)
+	ctx, span := h.Tracer.Start(ctx, route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			attribute.String("iidy.route", route),
+		))
+	if id := RequestID(r); id != "" {
+		span.SetAttributes(attribute.String("iidy.request_id", id))
+	}
+	sw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	return sw, r.WithContext(ctx), func() {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,51 @@
+package iidy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHandlerTracing(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	s := memstore.NewMemStore()
+	s.InsertBatch(context.Background(), "downloads", []string{"a"})
+	h := &Handler{Store: s, Tracer: provider.Tracer("iidy")}
+	req := httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/secret-a", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans want 1", len(ended))
+	}
+	span := ended[0]
+	if span.Name() != "get_one" {
+		t.Errorf("got span %q want get_one", span.Name())
+	}
+	if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span is not part of the caller's trace: %s", got)
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+		if kv.Value.Type() == attribute.STRING && kv.Value.AsString() == "secret-a" {
+			t.Errorf("span gives away item names: %v", kv)
+		}
+	}
+	if code := attrs["http.status_code"]; code.AsInt64() != http.StatusNotFound {
+		t.Errorf("got status code %v want %d", code.Emit(), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,245 @@
+package iidy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/manniwood/iidy/pgstore"
+)
+
+// V2Prefix is the path that every route of the v2 API starts with.
+const V2Prefix string = "/iidy/v2/"
+
+// apiVersion gives the version of the API that r was made to:
+// 2 for paths under V2Prefix, and 1 for anything else.
+func apiVersion(r *http.Request) int {
+	if strings.HasPrefix(r.URL.Path, V2Prefix) {
+		return 2
+	}
+	return 1
+}
+
+// v2Route is one route of the v2 API.
+type v2Route struct {
+	method string
+	// path is the route's path after V2Prefix, where "{list}" matches
+	// a list name, and "{item}", which comes last, matches an item name,
+	// slashes and all.
+	path string
+	// query, if set, is a query arg that the request must have
+	// for the route to match.
+	query string
+	// name names the route, as routeName does.
+	name  string
+	serve func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string)
+}
+
+// v2Routes are the routes of the v2 API. Each is served by the same
+// handler as its v1 counterpart; only the paths differ, along with the
+// few things that apiVersion changes (such as a 200 with an empty list
+// rather than a 204). Routes with a query arg come before the same
+// method and path without.
+var v2Routes = []v2Route{
+	{http.MethodGet, "lists/{list}/items", "item", "get_multi", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		if !h.validate(w, r, list) {
+			return
+		}
+		h.Metrics.CountRequest(list, "get_multi")
+		h.getEntries(w, r, list, r.URL.Query()["item"])
+	}},
+	{http.MethodGet, "lists/{list}/items", "", "get_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getBatch(w, r, list)
+	}},
+	{http.MethodPost, "lists/{list}/items", "", "insert_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.insertBatch(w, r, list)
+	}},
+	{http.MethodDelete, "lists/{list}/items", "", "delete_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.deleteBatch(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/items/{item}", "", "get_one", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getOne(w, r, list, item)
+	}},
+	{http.MethodPut, "lists/{list}/items/{item}", "", "put_one", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.putOne(w, r, list, item)
+	}},
+	{http.MethodPost, "lists/{list}/items/{item}", "action", "transition", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.transitionOne(w, r, list, item, r.URL.Query().Get("action"))
+	}},
+	{http.MethodDelete, "lists/{list}/items/{item}", "", "delete_one", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.deleteOne(w, r, list, item)
+	}},
+	{http.MethodPost, "lists/{list}/attempts", "", "increment_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.incrementBatch(w, r, list)
+	}},
+	{http.MethodPost, "lists/{list}/attempts/decrement", "", "decrement_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.decrementBatch(w, r, list)
+	}},
+	{http.MethodPut, "lists/{list}/attempts", "", "set_attempts_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.setAttemptsBatch(w, r, list)
+	}},
+	{http.MethodPost, "lists/{list}/actions", "", "apply_batch", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.applyBatch(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/stats", "", "get_activity", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getActivity(w, r, list)
+	}},
+	{http.MethodPost, "lists/{list}/merge", "", "merge", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.mergeList(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/snapshots", "", "get_snapshots", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getSnapshots(w, r, list)
+	}},
+	{http.MethodPost, "lists/{list}/snapshots/{item}/restore", "", "restore_snapshot", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, label string) {
+		h.restoreSnapshot(w, r, list, label)
+	}},
+	{http.MethodPut, "lists/{list}/snapshots/{item}", "", "create_snapshot", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, label string) {
+		h.takeSnapshot(w, r, list, label)
+	}},
+	{http.MethodDelete, "lists/{list}/snapshots/{item}", "", "delete_snapshot", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, label string) {
+		h.deleteSnapshot(w, r, list, label)
+	}},
+	{http.MethodGet, "lists/{list}/diff", "", "get_diff", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getDiff(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/top", "", "get_top", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getTop(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/count", "", "get_count", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getCount(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/chain", "", "get_chain", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getChain(w, r, list)
+	}},
+	{http.MethodPut, "lists/{list}/chain", "", "set_chain", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.setChain(w, r, list)
+	}},
+	{http.MethodDelete, "lists/{list}/chain", "", "delete_chain", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.deleteChain(w, r, list)
+	}},
+	{http.MethodGet, "lists/{list}/settings", "", "get_settings", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getSettings(w, r, list)
+	}},
+	{http.MethodPut, "lists/{list}/settings", "", "set_settings", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.setSettings(w, r, list)
+	}},
+	{http.MethodGet, "lists", "", "get_lists", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getLists(w, r)
+	}},
+	{http.MethodGet, "stats/db", "", "get_db_stats", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getDBStats(w, r)
+	}},
+	{http.MethodGet, "exports", "", "get_export", func(h *Handler, w http.ResponseWriter, r *http.Request, list string, item string) {
+		h.getExport(w, r)
+	}},
+}
+
+// matchPath matches path (less V2Prefix) against the route's path,
+// giving the list and item names it holds.
+func (rt *v2Route) matchPath(path string) (list string, item string, ok bool) {
+	want := strings.Split(rt.path, "/")
+	got := strings.SplitN(path, "/", len(want))
+	if len(got) != len(want) {
+		return "", "", false
+	}
+	for i, w := range want {
+		switch w {
+		case "{list}":
+			list = got[i]
+		case "{item}":
+			item = got[i]
+		default:
+			if got[i] != w {
+				return "", "", false
+			}
+		}
+	}
+	return list, item, true
+}
+
+// matchV2 finds the v2 route for r, and the list and item names in its
+// path. If there is none, it gives the methods that the path does allow,
+// if any.
+func matchV2(r *http.Request) (*v2Route, string, string, []string) {
+	path := strings.TrimPrefix(r.URL.Path, V2Prefix)
+	var allowed []string
+	for i := range v2Routes {
+		rt := &v2Routes[i]
+		list, item, ok := rt.matchPath(path)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			if len(allowed) == 0 || allowed[len(allowed)-1] != rt.method {
+				allowed = append(allowed, rt.method)
+			}
+			continue
+		}
+		if rt.query != "" && !r.URL.Query().Has(rt.query) {
+			continue
+		}
+		return rt, list, item, nil
+	}
+	return nil, "", "", allowed
+}
+
+// v2 handles requests to the v2 API, whose routes are named for the
+// resources they act on, rather than for what they do to them:
+//     GET    /iidy/v2/lists
+//     GET    /iidy/v2/lists/<listname>/items?after_id=it&count=ct
+//     GET    /iidy/v2/lists/<listname>/items?item=it1&item=it2
+//     POST   /iidy/v2/lists/<listname>/items [itemnames in body]
+//     DELETE /iidy/v2/lists/<listname>/items [itemnames in body]
+//     GET    /iidy/v2/lists/<listname>/items/<itemname>
+//     PUT    /iidy/v2/lists/<listname>/items/<itemname>
+//     POST   /iidy/v2/lists/<listname>/items/<itemname>?action=complete|fail|reset
+//     DELETE /iidy/v2/lists/<listname>/items/<itemname>
+//     POST   /iidy/v2/lists/<listname>/attempts [itemnames in body]
+//     POST   /iidy/v2/lists/<listname>/attempts/decrement [itemnames in body]
+//     PUT    /iidy/v2/lists/<listname>/attempts?value=<n> [itemnames in body]
+//     POST   /iidy/v2/lists/<listname>/actions [items and actions in body]
+//     GET    /iidy/v2/lists/<listname>/stats?since=1h
+//     GET    /iidy/v2/lists/<listname>/top?n=50
+//     GET    /iidy/v2/lists/<listname>/count?by=attempts
+//     GET    /iidy/v2/lists/<listname>/diff?with=<listname>
+//     GET    /iidy/v2/lists/<listname>/diff?snapshot=<label>
+//     GET    /iidy/v2/lists/<listname>/snapshots
+//     PUT    /iidy/v2/lists/<listname>/snapshots/<label>
+//     DELETE /iidy/v2/lists/<listname>/snapshots/<label>
+//     POST   /iidy/v2/lists/<listname>/snapshots/<label>/restore
+//     POST   /iidy/v2/lists/<listname>/merge?from=<listname>&conflict=keep_max
+//     GET    /iidy/v2/lists/<listname>/chain
+//     PUT    /iidy/v2/lists/<listname>/chain [next list and template in body]
+//     DELETE /iidy/v2/lists/<listname>/chain
+//     GET    /iidy/v2/lists/<listname>/settings
+//     PUT    /iidy/v2/lists/<listname>/settings [settings in body]
+//     GET    /iidy/v2/stats/db
+//     GET    /iidy/v2/exports?list=<listname>
+// A path that is not one of these gives a status of 404, and a method
+// that its path does not allow gives a status of 405.
+func (h *Handler) v2(w http.ResponseWriter, r *http.Request) {
+	route, list, item, allowed := matchV2(r)
+	if route != nil {
+		route.serve(h, w, r, list, item)
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		errStr := fmt.Sprintf(`%s is not allowed on "%s"`, r.Method, r.URL.Path)
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeMethodNotAllowed}, http.StatusMethodNotAllowed)
+		return
+	}
+	errStr := fmt.Sprintf(`"%s" is not a valid url`, r.URL.Path)
+	printError(w, r, &ErrorMessage{Error: errStr, Code: CodeNotFound}, http.StatusNotFound)
+}
+
+// printNoEntries responds to a read that found no list entries: with
+// a status of 204 and no body in v1, or, in v2, with a status of 200
+// and an empty list, as for any other successful read.
+func printNoEntries(w http.ResponseWriter, r *http.Request) {
+	if apiVersion(r) < 2 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	printListEntries(w, r, []pgstore.ListEntry{})
+}
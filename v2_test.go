@@ -0,0 +1,118 @@
+package iidy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/manniwood/iidy/memstore"
+	"github.com/manniwood/iidy/pgstore"
+)
+
+func TestV2(t *testing.T) {
+	h := &Handler{Store: memstore.NewMemStore()}
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if body == "" {
+			req = httptest.NewRequest(method, path, nil)
+		} else {
+			req = httptest.NewRequest(method, path, strings.NewReader(body))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Bodies are JSON unless said otherwise.
+	rr := do(http.MethodPost, "/iidy/v2/lists/downloads/items", `{"items": ["a.txt", "b.txt", "c.txt"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("insert gave status %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("insert gave Content-Type %q", ct)
+	}
+	rr = do(http.MethodPost, "/iidy/v2/lists/downloads/items", `{"items": ["a.txt"]}`)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), `"code":"duplicate_item"`) {
+		t.Errorf("duplicate insert gave status %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = do(http.MethodPost, "/iidy/v2/lists/downloads/attempts", `{"items": ["b.txt"]}`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("increment gave status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var m ListEntryMessage
+	rr = do(http.MethodGet, "/iidy/v2/lists/downloads/items?count=2", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("could not decode batch: %v: %s", err, rr.Body.String())
+	}
+	want := []pgstore.ListEntry{{Item: "a.txt"}, {Item: "b.txt", Attempts: 1}}
+	if !reflect.DeepEqual(m.ListEntries, want) {
+		t.Errorf("got %v want %v", m.ListEntries, want)
+	}
+	rr = do(http.MethodGet, "/iidy/v2/lists/downloads/items?item=c.txt&item=d.txt", "")
+	m = ListEntryMessage{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("could not decode entries: %v: %s", err, rr.Body.String())
+	}
+	want = []pgstore.ListEntry{{Item: "c.txt"}}
+	if !reflect.DeepEqual(m.ListEntries, want) {
+		t.Errorf("got %v want %v", m.ListEntries, want)
+	}
+
+	// An empty page is still a 200, with an empty list.
+	rr = do(http.MethodGet, "/iidy/v2/lists/downloads/items?after_id=c.txt", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"listentries\":[]}\n" {
+		t.Errorf("empty page gave status %d: %q", rr.Code, rr.Body.String())
+	}
+
+	rr = do(http.MethodGet, "/iidy/v2/lists/downloads/items/b.txt", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"item\":\"b.txt\",\"attempts\":1}\n" {
+		t.Errorf("get one gave status %d: %q", rr.Code, rr.Body.String())
+	}
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		if rr = do(http.MethodDelete, "/iidy/v2/lists/downloads/items/b.txt", ""); rr.Code != want {
+			t.Errorf("delete one gave status %d want %d", rr.Code, want)
+		}
+	}
+
+	rr = do(http.MethodPost, "/iidy/v2/lists/downloads/items/a.txt", "")
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "GET, PUT, DELETE" {
+		t.Errorf("POST to an item gave status %d, Allow %q", rr.Code, rr.Header().Get("Allow"))
+	}
+	rr = do(http.MethodGet, "/iidy/v2/bulk/lists/downloads", "")
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), `"code":"not_found"`) {
+		t.Errorf("unknown path gave status %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestV2RouteName(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		name   string
+		class  string
+	}{
+		{http.MethodGet, "/iidy/v2/lists/downloads/items", "get_batch", "batch_read"},
+		{http.MethodGet, "/iidy/v2/lists/downloads/items?item=a", "get_multi", "batch_read"},
+		{http.MethodGet, "/iidy/v2/lists/downloads/items/s3://bucket/a", "get_one", "single_read"},
+		{http.MethodDelete, "/iidy/v2/lists/downloads/items/a", "delete_one", "single_write"},
+		{http.MethodPost, "/iidy/v2/lists/downloads/attempts", "increment_batch", "batch_write"},
+		{http.MethodPost, "/iidy/v2/lists/downloads", "unknown", "batch_write"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := routeName(r); got != tt.name {
+			t.Errorf("%s %s: got route %s want %s", tt.method, tt.path, got, tt.name)
+		}
+		if got := endpointClass(r); got != tt.class {
+			t.Errorf("%s %s: got class %s want %s", tt.method, tt.path, got, tt.class)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/iidy/v2/lists/downloads/items/s3://bucket/a", nil)
+	if _, list, item, _ := matchV2(r); list != "downloads" || item != "s3://bucket/a" {
+		t.Errorf("got list %q, item %q", list, item)
+	}
+}
@@ -0,0 +1,134 @@
+package iidy
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// V1Prefix is the path that every route of the v1 API starts with.
+const V1Prefix string = "/iidy/v1/"
+
+// Deprecation says when a version of the API was deprecated, and when,
+// if ever, it will stop working.
+type Deprecation struct {
+	// Since is when the version was deprecated.
+	Since time.Time
+	// Sunset, if not zero, is when the version will stop working.
+	Sunset time.Time
+}
+
+// APIVersion describes one version of the API, for GET /iidy.
+type APIVersion struct {
+	Version    string     `json:"version"`
+	Prefix     string     `json:"prefix"`
+	Deprecated *time.Time `json:"deprecated,omitempty"`
+	Sunset     *time.Time `json:"sunset,omitempty"`
+}
+
+// VersionsMessage lists the versions of the API that a server speaks,
+// oldest first, and which of them new clients should use.
+// The message can be formatted either as plain text or JSON.
+type VersionsMessage struct {
+	Current  string       `json:"current"`
+	Versions []APIVersion `json:"versions"`
+}
+
+// isDiscovery says whether r is for the version discovery endpoint.
+func isDiscovery(r *http.Request) bool {
+	return r.URL.Path == "/iidy" || r.URL.Path == "/iidy/"
+}
+
+// getVersions handles GET /iidy, which tells clients what versions of
+// the API there are, and which of them are deprecated.
+func (h *Handler) getVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		errStr := fmt.Sprintf(`%s is not allowed on "%s"`, r.Method, r.URL.Path)
+		printError(w, r, &ErrorMessage{Error: errStr, Code: CodeMethodNotAllowed}, http.StatusMethodNotAllowed)
+		return
+	}
+	v1 := APIVersion{Version: "v1", Prefix: h.prefix() + "/v1/"}
+	if d := h.V1Deprecation; d != nil {
+		since := d.Since.UTC()
+		v1.Deprecated = &since
+		if !d.Sunset.IsZero() {
+			sunset := d.Sunset.UTC()
+			v1.Sunset = &sunset
+		}
+	}
+	m := &VersionsMessage{
+		Current:  "v2",
+		Versions: []APIVersion{v1, {Version: "v2", Prefix: h.prefix() + "/v2/"}},
+	}
+	printSuccess(w, r, m, http.StatusOK)
+}
+
+// setDeprecationHeaders tells the client, on every response from a
+// deprecated version of the API, that the version is deprecated (as the
+// Deprecation header of RFC 9745 does), when it will stop working (as
+// the Sunset header of RFC 8594 does), and where to find out more: at
+// prefix, the path that the API is served under.
+func setDeprecationHeaders(w http.ResponseWriter, d *Deprecation, prefix string) {
+	if d == nil {
+		return
+	}
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Link", "<"+prefix+`>; rel="deprecation"`)
+}
+
+// VersionMetrics counts requests per version of the API, and per route
+// within each version, so that it is plain when nobody is using a
+// deprecated version any more, and which of its routes are holding out.
+//
+// VersionMetrics satisfies expvar.Var, so it can be published with
+// expvar.Publish. A nil *VersionMetrics records nothing.
+type VersionMetrics struct {
+	mu       sync.Mutex
+	versions expvar.Map
+}
+
+// NewVersionMetrics constructs new VersionMetrics.
+func NewVersionMetrics() *VersionMetrics {
+	m := &VersionMetrics{}
+	m.versions.Init()
+	return m
+}
+
+// versionVars gives the metrics for version, creating them if need be.
+func (m *VersionMetrics) versionVars(version string) *expvar.Map {
+	if v, ok := m.versions.Get(version).(*expvar.Map); ok {
+		return v
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.versions.Get(version).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	m.versions.Set(version, v)
+	return v
+}
+
+// CountRequest counts a request to route of the given version
+// of the API.
+func (m *VersionMetrics) CountRequest(version string, route string) {
+	if m == nil {
+		return
+	}
+	v := m.versionVars(version)
+	v.Add("requests", 1)
+	v.Add(route+"_requests", 1)
+}
+
+// String satisfies expvar.Var, giving the metrics as a JSON object
+// with a member for each version.
+func (m *VersionMetrics) String() string {
+	return m.versions.String()
+}
@@ -0,0 +1,65 @@
+package iidy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/manniwood/iidy/memstore"
+)
+
+func TestVersions(t *testing.T) {
+	since := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &Handler{
+		Store:         memstore.NewMemStore(),
+		V1Deprecation: &Deprecation{Since: since, Sunset: sunset},
+		Versions:      NewVersionMetrics(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/iidy", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	var m VersionsMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("could not decode versions: %v: %s", err, rr.Body.String())
+	}
+	if m.Current != "v2" || len(m.Versions) != 2 {
+		t.Fatalf("unexpected versions: %+v", m)
+	}
+	v1 := m.Versions[0]
+	if v1.Prefix != V1Prefix || v1.Deprecated == nil || !v1.Deprecated.Equal(since) || v1.Sunset == nil || !v1.Sunset.Equal(sunset) {
+		t.Errorf("unexpected v1: %+v", v1)
+	}
+	if v2 := m.Versions[1]; v2.Prefix != V2Prefix || v2.Deprecated != nil || v2.Sunset != nil {
+		t.Errorf("unexpected v2: %+v", v2)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/iidy/v1/lists/downloads/a.txt", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Deprecation"); got != "@1780272000" {
+		t.Errorf("got Deprecation %q", got)
+	}
+	if got := rr.Header().Get("Sunset"); got != "Fri, 01 Jan 2027 00:00:00 GMT" {
+		t.Errorf("got Sunset %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/iidy/v2/lists/downloads/items", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Deprecation"); got != "" {
+		t.Errorf("v2 got Deprecation %q", got)
+	}
+
+	var counts map[string]map[string]int64
+	if err := json.Unmarshal([]byte(h.Versions.String()), &counts); err != nil {
+		t.Fatalf("could not decode version metrics: %v", err)
+	}
+	if counts["v1"]["requests"] != 1 || counts["v1"]["get_one_requests"] != 1 || counts["v2"]["get_batch_requests"] != 1 {
+		t.Errorf("unexpected version metrics: %v", counts)
+	}
+}